@@ -6,17 +6,24 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/seborama/pcloud-sdk/sdk"
+	"github.com/seborama/pcloud-sdk/tracker/archos"
 )
 
 const PCloudPrefix = "r:"
 
 type sdkClient interface {
 	GetFileLink(ctx context.Context, file sdk.T3PathOrFileID, forceDownloadOpt bool, contentTypeOpt string, maxSpeedOpt uint64, skipFilenameOpt bool, opts ...sdk.ClientOption) (*sdk.FileLink, error)
+	CreateFolderIfNotExists(ctx context.Context, folder sdk.T2PathOrFolderIDName, opts ...sdk.ClientOption) (*sdk.FSList, error)
+	BuildChecksumIndex(ctx context.Context, folder sdk.T1PathOrFolderID, opts ...sdk.ClientOption) (*sdk.ChecksumIndex, error)
+	UploadFileDedup(ctx context.Context, index *sdk.ChecksumIndex, folderID uint64, name string, f *os.File, noOverOpt bool, mTimeOpt, cTimeOpt time.Time, opts ...sdk.ClientOption) (*sdk.DedupUpload, error)
 }
 
 type CLI struct {
@@ -87,3 +94,92 @@ func (cli *CLI) copyFromPCloudToLocal(ctx context.Context, from, to string) erro
 
 	return errors.WithStack(err)
 }
+
+// DedupUpload uploads the local file or folder from to the pCloud location to (which must use
+// the prefix 'r:'). Folders are uploaded recursively.
+// Files whose content already exists under the pCloud folder indexRoot are materialised
+// server-side instead of being transferred. Their modification and creation times are preserved.
+// When noOver is true, the files that exist at the destination are not replaced: the upload
+// stops with an error instead.
+// It returns the number of bytes that did not need transferring.
+func (cli *CLI) DedupUpload(ctx context.Context, from, to, indexRoot string, noOver bool) (uint64, error) {
+	if strings.HasPrefix(from, PCloudPrefix) || !strings.HasPrefix(to, PCloudPrefix) {
+		return 0, errors.New("dedup upload requires a local source and a pCloud destination")
+	}
+
+	index, err := cli.pCloudClient.BuildChecksumIndex(ctx, sdk.T1FolderByPath(indexRoot))
+	if err != nil {
+		return 0, errors.WithMessage(err, "building the checksum index of pCloud files")
+	}
+
+	fi, err := os.Stat(from)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	toPath := to[len(PCloudPrefix):]
+
+	if !fi.IsDir() {
+		if strings.HasSuffix(toPath, "/") {
+			toPath = path.Join(toPath, fi.Name())
+		}
+		return cli.dedupUploadFile(ctx, index, from, toPath, noOver)
+	}
+
+	bytesSaved := uint64(0)
+
+	err = filepath.Walk(from, func(localPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		relPath, err := filepath.Rel(from, localPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		remotePath := path.Join(toPath, filepath.ToSlash(relPath))
+
+		if info.IsDir() {
+			_, err = cli.pCloudClient.CreateFolderIfNotExists(ctx, sdk.T2FolderByPath(remotePath))
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		n, err := cli.dedupUploadFile(ctx, index, localPath, remotePath, noOver)
+		bytesSaved += n
+
+		return err
+	})
+
+	return bytesSaved, err
+}
+
+func (cli *CLI) dedupUploadFile(ctx context.Context, index *sdk.ChecksumIndex, localPath, remotePath string, noOver bool) (uint64, error) {
+	// nolint: gosec
+	f, err := os.Open(localPath)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	lf, err := cli.pCloudClient.CreateFolderIfNotExists(ctx, sdk.T2FolderByPath(path.Dir(remotePath)))
+	if err != nil {
+		return 0, err
+	}
+
+	du, err := cli.pCloudClient.UploadFileDedup(ctx, index, lf.Metadata.FolderID, path.Base(remotePath), f, noOver, fi.ModTime(), archos.CreatedTime(fi))
+	if err != nil {
+		return 0, errors.WithMessagef(err, "uploading '%s'", localPath)
+	}
+
+	return du.BytesSaved, nil
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 	"go.uber.org/zap"
 
@@ -42,7 +43,7 @@ func analyse(c *cli.Context) error {
 		return err
 	}
 
-	store, err := db.NewSQLite3(ctx, c.String("db-path"))
+	store, err := openStore(ctx, c)
 	if err != nil {
 		return err
 	}
@@ -51,15 +52,30 @@ func analyse(c *cli.Context) error {
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
 
-	pCloudFS := filesystem.NewPCloud(pCloudClient)
+	err = store.RegisterFileSystem(ctx, "pcloud", db.FSDriverPCloud, "/")
+	if err != nil {
+		return err
+	}
+
+	err = store.SetSnapshotRetention(ctx, "pcloud", c.Int("snapshots"))
+	if err != nil {
+		return err
+	}
+
+	pCloudFS := filesystem.NewPCloud(pCloudClient, filesystem.WithContentHashCache(store))
 
 	track, err := tracker.NewTracker(ctx, logger, store, pCloudFS, "pcloud")
 	if err != nil {
 		return err
 	}
 
+	refreshOpts := []tracker.RefreshOption{}
+	if c.Bool("incremental") {
+		refreshOpts = append(refreshOpts, tracker.WithIncrementalRefresh())
+	}
+
 	fmt.Println("RefreshFSContents...")
-	err = track.RefreshFSContents(ctx)
+	err = track.RefreshFSContents(ctx, refreshOpts...)
 	if err != nil {
 		return err
 	}
@@ -108,3 +124,16 @@ func analyse(c *cli.Context) error {
 
 	return nil
 }
+
+// openStore opens the PostgreSQL database when its DSN is set or the SQLite3 database otherwise.
+func openStore(ctx context.Context, c *cli.Context) (db.Store, error) {
+	if dsn := c.String("postgres-dsn"); dsn != "" {
+		return db.NewPostgres(ctx, dsn)
+	}
+
+	if c.String("db-path") == "" {
+		return nil, errors.New("either db-path or postgres-dsn must be set")
+	}
+
+	return db.NewSQLite3(ctx, c.String("db-path"))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -56,3 +57,41 @@ func pCLI(c *ucli.Context) error {
 
 	return nil
 }
+
+func dedupUpload(c *ucli.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sdkHTTPClient := &http.Client{
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost:   1,
+			MaxConnsPerHost:       1,
+			ResponseHeaderTimeout: 20 * time.Second,
+			Proxy:                 http.ProxyFromEnvironment,
+		},
+		Timeout: 0,
+	}
+
+	pCloudClient := sdk.NewClient(sdkHTTPClient)
+
+	err := pCloudClient.Login(
+		ctx,
+		c.String("pcloud-otp-code"),
+		sdk.WithGlobalOptionUsername(c.String("pcloud-username")),
+		sdk.WithGlobalOptionPassword(c.String("pcloud-password")),
+	)
+	if err != nil {
+		return err
+	}
+
+	pCli := pcli.NewCLI(pCloudClient, nil)
+
+	bytesSaved, err := pCli.DedupUpload(ctx, c.String("from"), c.String("to"), c.String("index-root"), c.Bool("no-overwrite"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("bytes saved by server-side copies: %d\n", bytesSaved)
+
+	return nil
+}
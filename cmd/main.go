@@ -37,11 +37,113 @@ func main() {
 				Action:  analyse,
 				Flags: []cli.Flag{
 					&cli.StringFlag{
-						Name:     "db-path",
-						EnvVars:  []string{"DB_PATH"},
-						Usage:    "Location of the database (it will be created if inexistent)",
+						Name:    "db-path",
+						EnvVars: []string{"DB_PATH"},
+						Usage:   "Location of the SQLite3 database (it will be created if inexistent)",
+					},
+					&cli.StringFlag{
+						Name:    "postgres-dsn",
+						EnvVars: []string{"POSTGRES_DSN"},
+						Usage:   "DSN of the PostgreSQL database to use instead of SQLite3 (its schema will be created if inexistent)",
+					},
+					&cli.BoolFlag{
+						Name:  "incremental",
+						Usage: "Refresh pCloud from its changes since the last refresh rather than list it entirely",
+					},
+					&cli.IntFlag{
+						Name:  "snapshots",
+						Usage: "Number of snapshots of pCloud to keep in the database, taken at each refresh (0 keeps no history)",
+					},
+				},
+			},
+			{
+				Name:    "report",
+				Aliases: []string{"r"},
+				Usage:   "report on a tracked filesystem: largest files and folders, duplicates, stale files, extensions and growth",
+				Action:  reportFS,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "db-path",
+						EnvVars: []string{"DB_PATH"},
+						Usage:   "Location of the SQLite3 database",
+					},
+					&cli.StringFlag{
+						Name:    "postgres-dsn",
+						EnvVars: []string{"POSTGRES_DSN"},
+						Usage:   "DSN of the PostgreSQL database to use instead of SQLite3",
+					},
+					&cli.StringFlag{
+						Name:  "fs-name",
+						Usage: "Name of the tracked filesystem",
+						Value: "pcloud",
+					},
+					&cli.StringFlag{
+						Name:     "query",
+						Usage:    "One of: largest-files, largest-folders, duplicates, stale, extensions, growth",
 						Required: true,
 					},
+					&cli.IntFlag{
+						Name:  "top",
+						Usage: "Number of entries reported by the largest-files, largest-folders and growth queries (0 reports all)",
+						Value: 20,
+					},
+					&cli.StringFlag{
+						Name:  "since",
+						Usage: "Date (YYYY-MM-DD) before which files were last modified to be reported by the stale query",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "Output format: table, csv or json",
+						Value: "table",
+					},
+				},
+			},
+			{
+				Name:    "migrate",
+				Aliases: []string{"m"},
+				Usage:   "migrate the schema of the database up or down",
+				Action:  migrate,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "db-path",
+						EnvVars: []string{"DB_PATH"},
+						Usage:   "Location of the SQLite3 database",
+					},
+					&cli.StringFlag{
+						Name:    "postgres-dsn",
+						EnvVars: []string{"POSTGRES_DSN"},
+						Usage:   "DSN of the PostgreSQL database to use instead of SQLite3",
+					},
+					&cli.IntFlag{
+						Name:  "to",
+						Usage: "Version to migrate the schema to (defaults to the latest version, 0 reverts all migrations)",
+						Value: -1,
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Print the statements of the migrations rather than execute them",
+					},
+					&cli.StringFlag{
+						Name:  "recover",
+						Usage: "Resolve the migration that was interrupted: 'committed' if its statements were committed, 'rolled-back' otherwise",
+					},
+				},
+			},
+			{
+				Name:   "recover",
+				Usage:  "discard the refreshes that did not complete and require the syncs that were interrupted to run again",
+				Action: recoverStore,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "db-path",
+						EnvVars: []string{"DB_PATH"},
+						Usage:   "Location of the SQLite3 database",
+					},
+					&cli.StringFlag{
+						Name:    "postgres-dsn",
+						EnvVars: []string{"POSTGRES_DSN"},
+						Usage:   "DSN of the PostgreSQL database to use instead of SQLite3",
+					},
 				},
 			},
 			{
@@ -62,6 +164,33 @@ func main() {
 					},
 				},
 			},
+			{
+				Name:    "upload",
+				Aliases: []string{"u"},
+				Usage:   "upload to pCloud, copying server-side the files whose content already exists",
+				Action:  dedupUpload,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "from",
+						Usage:    "Location of the local source file or folder",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "to",
+						Usage:    "Location of destination (use prefix 'r:' for pCloud remote)",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "index-root",
+						Usage: "pCloud folder whose files are indexed to find existing content",
+						Value: "/",
+					},
+					&cli.BoolFlag{
+						Name:  "no-overwrite",
+						Usage: "Stop with an error rather than replace a file that exists at the destination",
+					},
+				},
+			},
 		},
 	}
 
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"github.com/seborama/pcloud-sdk/tracker/db"
+)
+
+func migrate(c *cli.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+
+	opts := []db.MigratorOption{db.WithMigrationLogger(logger)}
+	if c.Bool("dry-run") {
+		opts = append(opts, db.WithDryRun(os.Stdout))
+	}
+
+	sqlDB, migrator, err := openMigrator(c, opts...)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = sqlDB.Close() }()
+
+	switch c.String("recover") {
+	case "":
+	case "committed", "rolled-back":
+		version, err := migrator.Recover(ctx, c.String("recover") == "committed")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("recovered migration version: %d\n", version)
+		return nil
+	default:
+		return errors.Errorf("invalid recover value '%s': expected 'committed' or 'rolled-back'", c.String("recover"))
+	}
+
+	version := c.Int("to")
+	if version == -1 {
+		version = migrator.LatestVersion()
+	}
+
+	err = migrator.MigrateTo(ctx, version)
+	if errors.Is(err, db.ErrMigrationInProgress) {
+		return errors.WithMessage(err, "check whether its statements were committed and run the migrate command with --recover")
+	}
+
+	return err
+}
+
+// openMigrator opens the PostgreSQL database when its DSN is set or the SQLite3 database
+// otherwise, without migrating it.
+func openMigrator(c *cli.Context, opts ...db.MigratorOption) (*sql.DB, *db.Migrator, error) {
+	if dsn := c.String("postgres-dsn"); dsn != "" {
+		sqlDB, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		return sqlDB, db.NewPostgresMigrator(sqlDB, opts...), nil
+	}
+
+	if c.String("db-path") == "" {
+		return nil, nil, errors.New("either db-path or postgres-dsn must be set")
+	}
+
+	sqlDB, err := sql.Open("sqlite3", filepath.Join(c.String("db-path"), db.SQLite3Filename))
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	return sqlDB, db.NewMigrator(sqlDB, opts...), nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+// recoverStore discards the refreshes that did not complete and requires the syncs that were
+// interrupted to run again, leaving alone those that are in progress.
+func recoverStore(c *cli.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := openStore(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+
+	fsNames, err := store.RecoverIncompleteRefreshes(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, fsName := range fsNames {
+		fmt.Printf("discarded incomplete refresh of file system: %s\n", fsName)
+	}
+
+	pairNames, err := store.RecoverInterruptedSyncs(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, pairName := range pairNames {
+		fmt.Printf("interrupted sync of pair will need to run again: %s\n", pairName)
+	}
+
+	return nil
+}
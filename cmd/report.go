@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	"github.com/seborama/pcloud-sdk/tracker/db"
+	"github.com/seborama/pcloud-sdk/tracker/report"
+)
+
+func reportFS(c *cli.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := openStore(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+
+	r := report.NewReporter(store, db.FSName(c.String("fs-name")))
+
+	var rep report.Report
+
+	switch c.String("query") {
+	case "largest-files":
+		rep, err = r.LargestFiles(ctx, c.Int("top"))
+	case "largest-folders":
+		rep, err = r.LargestFolders(ctx, c.Int("top"))
+	case "duplicates":
+		rep, err = r.Duplicates(ctx)
+	case "stale":
+		var since time.Time
+		since, err = time.Parse("2006-01-02", c.String("since"))
+		if err != nil {
+			return errors.Wrap(err, "invalid since date: expected YYYY-MM-DD")
+		}
+		rep, err = r.StaleFiles(ctx, since)
+	case "extensions":
+		rep, err = r.Extensions(ctx)
+	case "growth":
+		rep, err = r.Growth(ctx, c.Int("top"))
+	default:
+		return errors.Errorf("unknown query '%s'", c.String("query"))
+	}
+	if err != nil {
+		return err
+	}
+
+	return report.Render(os.Stdout, report.Format(c.String("format")), rep)
+}
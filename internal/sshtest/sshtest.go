@@ -0,0 +1,162 @@
+// Package sshtest provides an in-process SSH server for the tests of the SFTP drivers.
+package sshtest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os/exec"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// NewClient starts an SSH server on the local host, for the duration of the test, and returns
+// a client connected to it.
+// The server offers the "sftp" subsystem over the local file system and runs the commands
+// it is sent with "sh -c", like OpenSSH does. It accepts any user without authentication.
+func NewClient(t *testing.T) *ssh.Client {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serve(conn, config)
+			}()
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // nolint: gosec
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = l.Close()
+		wg.Wait()
+	})
+
+	return client
+}
+
+func serve(conn net.Conn, config *ssh.ServerConfig) {
+	defer func() { _ = conn.Close() }()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveSession(channel, requests)
+		}()
+	}
+}
+
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer func() { _ = channel.Close() }()
+
+	for req := range requests {
+		// the payload of "subsystem" and "exec" requests is a string: its length then its bytes.
+		if len(req.Payload) < 4 {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		value := string(req.Payload[4:])
+
+		switch {
+		case req.Type == "subsystem" && value == "sftp":
+			_ = req.Reply(true, nil)
+
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				return
+			}
+			_ = server.Serve()
+			return
+
+		case req.Type == "exec":
+			_ = req.Reply(true, nil)
+
+			cmd := exec.Command("sh", "-c", value) // nolint: gosec
+			cmd.Stdout = channel
+			cmd.Stderr = channel.Stderr()
+
+			status := uint32(0)
+			err := cmd.Run()
+			if err != nil {
+				status = 255
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					status = uint32(exitErr.ExitCode())
+				}
+			}
+
+			payload := make([]byte, 4)
+			binary.BigEndian.PutUint32(payload, status)
+			_, _ = channel.SendRequest("exit-status", false, payload)
+			_ = channel.CloseWrite()
+			_, _ = io.Copy(io.Discard, channel)
+			return
+
+		default:
+			_ = req.Reply(false, nil)
+		}
+	}
+}
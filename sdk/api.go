@@ -169,7 +169,7 @@ func parseResult(body []byte, err error, r resulter) error {
 		return errors.Wrap(err, "unmarshal")
 	}
 	if r.Result_() != 0 {
-		return errors.Errorf("error %d: %s", r.Result_(), r.Error_())
+		return errors.WithStack(&APIError{Result: r.Result_(), Message: r.Error_()})
 	}
 	return nil
 }
@@ -0,0 +1,247 @@
+package sdk
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrBatchItemSkipped is the error reported for the items of a Batch that were not executed
+// because the Batch stopped on the error of another item.
+var ErrBatchItemSkipped = errors.New("batch item skipped")
+
+// BatchOperation identifies the type of an operation queued in a Batch.
+type BatchOperation string
+
+const (
+	// BatchOperationDeleteFile is a DeleteFile operation.
+	BatchOperationDeleteFile BatchOperation = "deletefile"
+
+	// BatchOperationCopyFile is a CopyFile operation.
+	BatchOperationCopyFile BatchOperation = "copyfile"
+
+	// BatchOperationRenameFile is a RenameFile operation.
+	BatchOperationRenameFile BatchOperation = "renamefile"
+)
+
+// BatchResult contains the outcome of an item of a Batch.
+type BatchResult struct {
+	// Index is the position of the item in the order it was queued in the Batch.
+	Index     int
+	Operation BatchOperation
+
+	// FileResult is the result of the operation, when it succeeded.
+	FileResult *FileResult
+
+	// Err is the error of the operation, if any.
+	// API errors are of type *APIError.
+	// Items that did not execute report ErrBatchItemSkipped or the error of the context.
+	Err error
+}
+
+type batchItem struct {
+	operation BatchOperation
+	execute   func(ctx context.Context) (*FileResult, error)
+}
+
+// Batch queues heterogeneous file operations and executes them with bounded concurrency.
+// It is created with Client.NewBatch.
+//
+// Note that the Client serialises its HTTP calls: the number of workers bounds the number of
+// operations that are queued against the Client at any one time.
+type Batch struct {
+	client *Client
+
+	items []batchItem
+
+	workers     int
+	interval    time.Duration
+	stopOnError bool
+}
+
+// BatchOption is a Go functional parameter signature used to configure a Batch.
+type BatchOption func(b *Batch)
+
+// WithBatchWorkers sets the number of operations of the Batch that execute concurrently.
+// The default is 4.
+func WithBatchWorkers(n int) BatchOption {
+	return func(b *Batch) {
+		if n > 0 {
+			b.workers = n
+		}
+	}
+}
+
+// WithBatchRateLimit sets the maximum number of operations the Batch starts per second.
+// The default is unlimited.
+func WithBatchRateLimit(opsPerSecond int) BatchOption {
+	return func(b *Batch) {
+		if opsPerSecond > 0 {
+			b.interval = time.Second / time.Duration(opsPerSecond)
+		}
+	}
+}
+
+// WithBatchStopOnError stops the Batch at the first operation in error.
+// The operations that have not yet started are not executed and report ErrBatchItemSkipped.
+// The default is best-effort: all operations are attempted.
+func WithBatchStopOnError() BatchOption {
+	return func(b *Batch) {
+		b.stopOnError = true
+	}
+}
+
+// NewBatch creates a new empty Batch of operations to execute with the Client.
+// This is not an SDK method per-se, rather a helper around DeleteFile, CopyFile and RenameFile.
+func (c *Client) NewBatch(opts ...BatchOption) *Batch {
+	b := &Batch{
+		client:  c,
+		workers: 4,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// Len returns the number of operations queued in the Batch.
+func (b *Batch) Len() int {
+	return len(b.items)
+}
+
+// DeleteFile queues a DeleteFile operation in the Batch.
+func (b *Batch) DeleteFile(file T3PathOrFileID, opts ...ClientOption) *Batch {
+	b.items = append(b.items, batchItem{
+		operation: BatchOperationDeleteFile,
+		execute: func(ctx context.Context) (*FileResult, error) {
+			return b.client.DeleteFile(ctx, file, opts...)
+		},
+	})
+
+	return b
+}
+
+// CopyFile queues a CopyFile operation in the Batch.
+func (b *Batch) CopyFile(file T3PathOrFileID, destination ToT3PathOrFolderIDName, noOverOpt bool, mTime, cTime time.Time, opts ...ClientOption) *Batch {
+	b.items = append(b.items, batchItem{
+		operation: BatchOperationCopyFile,
+		execute: func(ctx context.Context) (*FileResult, error) {
+			return b.client.CopyFile(ctx, file, destination, noOverOpt, mTime, cTime, opts...)
+		},
+	})
+
+	return b
+}
+
+// RenameFile queues a RenameFile operation in the Batch.
+func (b *Batch) RenameFile(file T3PathOrFileID, destination ToT3PathOrFolderIDName, opts ...ClientOption) *Batch {
+	b.items = append(b.items, batchItem{
+		operation: BatchOperationRenameFile,
+		execute: func(ctx context.Context) (*FileResult, error) {
+			return b.client.RenameFile(ctx, file, destination, opts...)
+		},
+	})
+
+	return b
+}
+
+// Execute runs the operations of the Batch and returns one result per operation, in the order
+// they were queued.
+// The error returned is the first operation error when the Batch stops on error, or the error
+// of ctx when it is cancelled before all the operations have started. In best-effort mode,
+// the operation errors are only reported in the results.
+func (b *Batch) Execute(ctx context.Context) ([]BatchResult, error) {
+	results := make([]BatchResult, len(b.items))
+	for i, item := range b.items {
+		results[i] = BatchResult{
+			Index:     i,
+			Operation: item.operation,
+			Err:       ErrBatchItemSkipped,
+		}
+	}
+
+	batchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		firstErr error
+		errOnce  sync.Once
+		wg       sync.WaitGroup
+	)
+
+	itemsCh := make(chan int)
+
+	for w := 0; w < b.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range itemsCh {
+				if batchCtx.Err() != nil {
+					continue
+				}
+
+				fr, err := b.items[i].execute(batchCtx)
+				results[i].FileResult = fr
+				results[i].Err = err
+
+				if err != nil && b.stopOnError {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+	b.dispatch(batchCtx, itemsCh)
+	close(itemsCh)
+	wg.Wait()
+
+	if firstErr != nil {
+		return results, firstErr
+	}
+
+	if ctx.Err() != nil {
+		for i := range results {
+			if errors.Is(results[i].Err, ErrBatchItemSkipped) {
+				results[i].Err = ctx.Err()
+			}
+		}
+		return results, errors.WithStack(ctx.Err())
+	}
+
+	return results, nil
+}
+
+// dispatch feeds the index of each item of the batch to itemsCh, at the configured rate,
+// until all items have been dispatched or ctx is done.
+func (b *Batch) dispatch(ctx context.Context, itemsCh chan<- int) {
+	var tick <-chan time.Time
+	if b.interval > 0 {
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for i := range b.items {
+		if tick != nil && i > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case itemsCh <- i:
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package sdk_test
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+)
+
+func (testsuite *IntegrationTestSuite) Test_Batch() {
+	folderPath := testsuite.testFolderPath + "/go_pCloud_" + uuid.New().String()
+
+	_, err := testsuite.pcc.CreateFolder(testsuite.ctx, sdk.T2FolderByPath(folderPath))
+	testsuite.Require().NoError(err)
+
+	num := 5
+
+	b := testsuite.pcc.NewBatch(sdk.WithBatchWorkers(2), sdk.WithBatchRateLimit(10))
+	for i := 0; i < num; i++ {
+		b.CopyFile(sdk.T3FileByID(testsuite.testFileID), sdk.ToT3ByPath(fmt.Sprintf("%s/copy_%d", folderPath, i)), true, time.Time{}, time.Time{})
+	}
+
+	results, err := b.Execute(testsuite.ctx)
+	testsuite.Require().NoError(err)
+	testsuite.Require().Len(results, num)
+	for i, r := range results {
+		testsuite.Require().NoError(r.Err)
+		testsuite.Equal(i, r.Index)
+		testsuite.Equal(sdk.BatchOperationCopyFile, r.Operation)
+		testsuite.Equal(fmt.Sprintf("copy_%d", i), r.FileResult.Metadata.Name)
+	}
+
+	// best-effort: all items are attempted
+	b = testsuite.pcc.NewBatch().
+		RenameFile(sdk.T3FileByPath(folderPath+"/copy_0"), sdk.ToT3ByPath(folderPath+"/renamed_0")).
+		DeleteFile(sdk.T3FileByPath(folderPath + "/does_not_exist")).
+		DeleteFile(sdk.T3FileByPath(folderPath + "/copy_1"))
+
+	results, err = b.Execute(testsuite.ctx)
+	testsuite.Require().NoError(err)
+	testsuite.Require().NoError(results[0].Err)
+	testsuite.Equal("renamed_0", results[0].FileResult.Metadata.Name)
+
+	var apiErr *sdk.APIError
+	testsuite.Require().True(errors.As(results[1].Err, &apiErr))
+	testsuite.Equal(sdk.ErrFileNotFound, apiErr.Result)
+
+	testsuite.Require().NoError(results[2].Err)
+	testsuite.True(results[2].FileResult.Metadata.IsDeleted)
+
+	// stop on first error: the items after the error are skipped
+	b = testsuite.pcc.NewBatch(sdk.WithBatchWorkers(1), sdk.WithBatchStopOnError()).
+		DeleteFile(sdk.T3FileByPath(folderPath + "/does_not_exist")).
+		DeleteFile(sdk.T3FileByPath(folderPath + "/copy_2"))
+
+	results, err = b.Execute(testsuite.ctx)
+	testsuite.Require().Error(err)
+	testsuite.Require().True(errors.As(err, &apiErr))
+	testsuite.Equal(sdk.ErrFileNotFound, apiErr.Result)
+	testsuite.ErrorIs(results[1].Err, sdk.ErrBatchItemSkipped)
+
+	_, err = testsuite.pcc.DeleteFolderRecursive(testsuite.ctx, sdk.T1FolderByPath(folderPath))
+	testsuite.Require().NoError(err)
+}
@@ -0,0 +1,245 @@
+package sdk
+
+import (
+	"context"
+
+	// nolint:gosec
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// IndexedFile contains the checksums of a remote file, as recorded in a ChecksumIndex.
+type IndexedFile struct {
+	FileID uint64
+	Size   uint64
+	SHA1   string
+	SHA256 string // sha256 is only returned by pCloud's Europe API servers
+}
+
+// ChecksumIndex is a local index of the checksums of remote files.
+// It is used by UploadFileDedup to materialise files server-side rather than transferring data
+// that already exists elsewhere in the user's account.
+type ChecksumIndex struct {
+	lock   sync.RWMutex
+	bySHA1 map[string]IndexedFile
+}
+
+// NewChecksumIndex creates a new empty ChecksumIndex.
+func NewChecksumIndex() *ChecksumIndex {
+	return &ChecksumIndex{
+		bySHA1: map[string]IndexedFile{},
+	}
+}
+
+// Add records the checksums of a remote file in the index.
+// When several remote files share the same content, the first one recorded is retained.
+func (ci *ChecksumIndex) Add(f IndexedFile) {
+	ci.lock.Lock()
+	defer ci.lock.Unlock()
+
+	if _, ok := ci.bySHA1[f.SHA1]; ok {
+		return
+	}
+
+	ci.bySHA1[f.SHA1] = f
+}
+
+// Find looks up a remote file with the same content.
+// Both the sha1 and the size must match. The sha256 must also match when it is known on both
+// sides.
+func (ci *ChecksumIndex) Find(size uint64, sha1sum, sha256sum string) (IndexedFile, bool) {
+	ci.lock.RLock()
+	defer ci.lock.RUnlock()
+
+	f, ok := ci.bySHA1[sha1sum]
+	if !ok || f.Size != size {
+		return IndexedFile{}, false
+	}
+
+	if f.SHA256 != "" && sha256sum != "" && f.SHA256 != sha256sum {
+		return IndexedFile{}, false
+	}
+
+	return f, true
+}
+
+// Len returns the number of distinct contents in the index.
+func (ci *ChecksumIndex) Len() int {
+	ci.lock.RLock()
+	defer ci.lock.RUnlock()
+
+	return len(ci.bySHA1)
+}
+
+// BuildChecksumIndex creates a ChecksumIndex of all the files contained in folder and its
+// sub-folders.
+// The files that cannot be checksummed because they were deleted since the listing or because
+// they cannot be read, such as in a Crypto folder, are left out of the index.
+// This is not an SDK method per-se, rather a wrapper around ListFolder and ChecksumFile.
+// Note that ChecksumFile is called for every file: this can take a while for large folders.
+func (c *Client) BuildChecksumIndex(ctx context.Context, folder T1PathOrFolderID, opts ...ClientOption) (*ChecksumIndex, error) {
+	lf, err := c.ListFolder(ctx, folder, true, false, false, false, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	ci := NewChecksumIndex()
+
+	entries := []*Metadata{lf.Metadata}
+
+	for len(entries) > 0 {
+		entry := entries[len(entries)-1]
+		entries = entries[:len(entries)-1]
+
+		if entry.IsDeleted {
+			continue
+		}
+
+		if entry.IsFolder {
+			entries = append(entries, entry.Contents...)
+			continue
+		}
+
+		fc, err := c.ChecksumFile(ctx, T3FileByID(entry.FileID), opts...)
+		if IsNotFound(err) || isAccessDenied(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.WithMessagef(err, "fileID: %d", entry.FileID)
+		}
+
+		ci.Add(IndexedFile{
+			FileID: entry.FileID,
+			Size:   fc.Metadata.Size,
+			SHA1:   fc.SHA1,
+			SHA256: fc.SHA256,
+		})
+	}
+
+	return ci, nil
+}
+
+// isAccessDenied reports whether err indicates that the user is not permitted to perform the
+// operation.
+func isAccessDenied(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Result == ErrAccessDenied
+}
+
+// DedupUpload is returned by the SDK UploadFileDedup() method.
+type DedupUpload struct {
+	Metadata *Metadata
+
+	// Deduplicated is true when the file was copied server-side from an existing file with the
+	// same content, rather than uploaded.
+	Deduplicated bool
+
+	// BytesSaved is the number of bytes that did not need transferring.
+	BytesSaved uint64
+}
+
+// UploadFileDedup uploads the file f as name in the folder identified by folderID, unless a
+// file with the same content is present in index.
+// In that case, the file is materialised server-side with CopyFile and no data is transferred.
+// When noOverOpt is true, an existing file named name in the folder is not replaced: an APIError
+// of ErrFileOrFolderAlreadyExists is returned, whether the file would have been copied or
+// uploaded. Otherwise, the existing file is replaced, as UploadFile does.
+// mTimeOpt and cTimeOpt are applied to the destination file in both cases. It's required to
+// provide mtime to set ctime.
+// Newly uploaded files are added to index so that subsequent duplicates can benefit from them.
+// This is not an SDK method per-se, rather a wrapper around UploadFile and CopyFile.
+//
+// IMPORTANT: f is read in full to calculate its checksums and is rewinded to the beginning
+// before it is uploaded.
+func (c *Client) UploadFileDedup(ctx context.Context, index *ChecksumIndex, folderID uint64, name string, f *os.File, noOverOpt bool, mTimeOpt, cTimeOpt time.Time, opts ...ClientOption) (*DedupUpload, error) {
+	if noOverOpt {
+		// UploadFile has no option to not replace an existing file, unlike CopyFile.
+		exists, err := c.fileExistsInFolder(ctx, folderID, name, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, errors.WithStack(&APIError{Result: ErrFileOrFolderAlreadyExists, Message: "File or folder already exists."})
+		}
+	}
+
+	size, sha1sum, sha256sum, err := checksumData(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if match, ok := index.Find(size, sha1sum, sha256sum); ok {
+		fr, err := c.CopyFile(ctx, T3FileByID(match.FileID), ToT3ByIDName(folderID, name), noOverOpt, mTimeOpt, cTimeOpt, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		return &DedupUpload{
+			Metadata:     &fr.Metadata,
+			Deduplicated: true,
+			BytesSaved:   size,
+		}, nil
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	fu, err := c.UploadFile(ctx, T1FolderByID(folderID), map[string]*os.File{name: f}, true, "", false, mTimeOpt, cTimeOpt, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fu.Metadata) != 1 || len(fu.Checksums) != 1 {
+		return nil, errors.Errorf("unexpected upload result: %d metadata and %d checksums for 1 file", len(fu.Metadata), len(fu.Checksums))
+	}
+
+	index.Add(IndexedFile{
+		FileID: fu.Metadata[0].FileID,
+		Size:   fu.Metadata[0].Size,
+		SHA1:   fu.Checksums[0].SHA1,
+		SHA256: fu.Checksums[0].SHA256,
+	})
+
+	return &DedupUpload{
+		Metadata: fu.Metadata[0],
+	}, nil
+}
+
+// fileExistsInFolder reports whether the folder identified by folderID holds an entry named name.
+func (c *Client) fileExistsInFolder(ctx context.Context, folderID uint64, name string, opts ...ClientOption) (bool, error) {
+	lf, err := c.ListFolder(ctx, T1FolderByID(folderID), false, false, false, true, opts...)
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range lf.Metadata.Contents {
+		if !entry.IsDeleted && entry.Name == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// checksumData reads r in full and returns its size, sha1 and sha256 checksums.
+func checksumData(r io.Reader) (uint64, string, string, error) {
+	// nolint: gosec
+	cs1 := sha1.New()
+	cs256 := sha256.New()
+
+	n, err := io.Copy(io.MultiWriter(cs1, cs256), r)
+	if err != nil {
+		return 0, "", "", errors.WithStack(err)
+	}
+
+	return uint64(n), fmt.Sprintf("%x", cs1.Sum(nil)), fmt.Sprintf("%x", cs256.Sum(nil)), nil
+}
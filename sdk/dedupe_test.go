@@ -0,0 +1,54 @@
+package sdk_test
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+)
+
+func (testsuite *IntegrationTestSuite) Test_UploadFileDedup() {
+	fName := "Test_UploadFileDedup_" + uuid.New().String()
+
+	f, err := os.Create("/tmp/" + fName)
+	testsuite.Require().NoError(err)
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	_, err = f.WriteString(Lipsum)
+	testsuite.Require().NoError(err)
+
+	_, err = f.Seek(0, 0)
+	testsuite.Require().NoError(err)
+
+	index, err := testsuite.pcc.BuildChecksumIndex(testsuite.ctx, sdk.T1FolderByID(testsuite.testFolderID))
+	testsuite.Require().NoError(err)
+
+	mTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	// first upload: the content is not yet known to the index
+	du, err := testsuite.pcc.UploadFileDedup(testsuite.ctx, index, testsuite.testFolderID, fName, f, true, mTime, time.Time{})
+	testsuite.Require().NoError(err)
+	testsuite.False(du.Deduplicated)
+	testsuite.Zero(du.BytesSaved)
+	testsuite.EqualValues(len(Lipsum), du.Metadata.Size)
+
+	// second upload: the content is now known to the index and is copied server-side
+	du, err = testsuite.pcc.UploadFileDedup(testsuite.ctx, index, testsuite.testFolderID, fName+" DEDUP", f, true, mTime, mTime)
+	testsuite.Require().NoError(err)
+	testsuite.True(du.Deduplicated)
+	testsuite.EqualValues(len(Lipsum), du.BytesSaved)
+	testsuite.Equal(fName+" DEDUP", du.Metadata.Name)
+	testsuite.True(mTime.Equal(du.Metadata.Modified.Time))
+
+	// third upload: the file exists already and is not replaced
+	_, err = testsuite.pcc.UploadFileDedup(testsuite.ctx, index, testsuite.testFolderID, fName+" DEDUP", f, true, mTime, mTime)
+	var apiErr *sdk.APIError
+	testsuite.Require().True(errors.As(err, &apiErr))
+	testsuite.Equal(sdk.ErrFileOrFolderAlreadyExists, apiErr.Result)
+}
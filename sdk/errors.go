@@ -1,5 +1,20 @@
 package sdk
 
+import "fmt"
+
+// APIError is returned by the SDK methods when the pCloud API reports an error.
+// Use errors.As to inspect the Result code against the Err* constants.
+type APIError struct {
+	Result  int
+	Message string
+}
+
+// Error returns the string representation of the API error.
+// This is an implementation of Go's "error" interface.
+func (e *APIError) Error() string {
+	return fmt.Sprintf("error %d: %s", e.Result, e.Message)
+}
+
 // https://github.com/pcloudcom/pclouddoc/blob/master/errors.txt
 // https://docs.pcloud.com/errors/
 const (
@@ -186,3 +186,21 @@ func (c *Client) Diff(ctx context.Context, diffID uint64, after time.Time, last
 
 	return dr, nil
 }
+
+// LastDiffID returns the diffid of the last event of the user's account.
+// It calls diff with last set to 0, which is optimised to do nothing more than return the last
+// diffid. This is not possible with Diff, where a last of 0 means that the parameter is not set.
+// https://docs.pcloud.com/methods/general/diff.html
+func (c *Client) LastDiffID(ctx context.Context, opts ...ClientOption) (uint64, error) {
+	q := toQuery(opts...)
+	q.Add("last", "0")
+
+	dr := &DiffResult{}
+
+	err := parseAPIOutput(dr)(c.get(ctx, "diff", q))
+	if err != nil {
+		return 0, err
+	}
+
+	return dr.DiffID, nil
+}
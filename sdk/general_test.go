@@ -27,3 +27,12 @@ func (testsuite *IntegrationTestSuite) Test_Diff() {
 	testsuite.Require().GreaterOrEqual(dr.Entries[0].DiffID, uint64(1))
 	testsuite.Require().NotEmpty(dr.Entries[0].Metadata.Name)
 }
+
+func (testsuite *IntegrationTestSuite) Test_LastDiffID() {
+	dr, err := testsuite.pcc.Diff(testsuite.ctx, 0, time.Now().Add(-10*time.Minute), 0, false, 0)
+	testsuite.Require().NoError(err)
+
+	lastDiffID, err := testsuite.pcc.LastDiffID(testsuite.ctx)
+	testsuite.Require().NoError(err)
+	testsuite.Require().GreaterOrEqual(lastDiffID, dr.DiffID)
+}
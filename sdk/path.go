@@ -0,0 +1,301 @@
+package sdk
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SkipDir is used as a return value from a WalkFunc to indicate that the folder named in the
+// call is to be skipped. It is not returned as an error by any function.
+// When returned for a file, the remaining entries of the containing folder are skipped.
+var SkipDir = errors.New("skip this folder") // nolint: stylecheck,revive
+
+// WalkFunc is the type of the function called by Walk and WalkDir to visit each file or folder.
+// p is the full pCloud path of the entry.
+// If err is not nil, the folder pointed to by p could not be listed and entry may be nil when
+// p is the root of the walk. The function decides how to handle the error: returning it stops
+// the walk.
+type WalkFunc func(p string, entry *Metadata, err error) error
+
+// IsNotFound reports whether err indicates that a file, a folder or a component of the parent
+// path does not exist.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.Result {
+	case ErrComponentOfParentDirectoryNotExists, ErrDirectoryNotExists, ErrFileNotFound:
+		return true
+	default:
+		return false
+	}
+}
+
+// StatPath returns the metadata of the file or folder at path p.
+// It returns an error for which IsNotFound is true when p does not exist.
+// Folder metadata is returned without its Contents.
+// This is not an SDK method per-se, rather a wrapper around Stat and ListFolder.
+func (c *Client) StatPath(ctx context.Context, p string, opts ...ClientOption) (*Metadata, error) {
+	p = path.Clean("/" + p)
+
+	if p != "/" {
+		fr, err := c.Stat(ctx, T3FileByPath(p), opts...)
+		if err == nil {
+			return &fr.Metadata, nil
+		}
+		if !IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	lf, err := c.ListFolder(ctx, T1FolderByPath(p), false, false, true, true, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	lf.Metadata.Contents = nil
+
+	return lf.Metadata, nil
+}
+
+// Exists returns true if a file or folder exists at path p.
+// Unlike StatPath, not-found is not an error. Other errors are returned as is.
+func (c *Client) Exists(ctx context.Context, p string, opts ...ClientOption) (bool, error) {
+	_, err := c.StatPath(ctx, p, opts...)
+	if err != nil {
+		if IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// MkdirAll creates the folder at path p, along with any necessary parents, and returns its
+// metadata. If p is already a folder, MkdirAll does nothing and returns its metadata.
+// This is not an SDK method per-se, rather a wrapper around CreateFolderIfNotExists.
+func (c *Client) MkdirAll(ctx context.Context, p string, opts ...ClientOption) (*Metadata, error) {
+	p = path.Clean("/" + p)
+
+	if p == "/" {
+		return c.StatPath(ctx, p, opts...)
+	}
+
+	// optimistically assume the parent folder exists: that is the most common case.
+	lf, err := c.CreateFolderIfNotExists(ctx, T2FolderByPath(p), opts...)
+	if err == nil {
+		return lf.Metadata, nil
+	}
+	if !IsNotFound(err) {
+		return nil, err
+	}
+
+	folderID := RootFolderID
+
+	for _, name := range strings.Split(p[1:], "/") {
+		lf, err = c.CreateFolderIfNotExists(ctx, T2FolderByIDName(folderID, name), opts...)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "creating folder '%s' in folderID %d", name, folderID)
+		}
+
+		folderID = lf.Metadata.FolderID
+	}
+
+	return lf.Metadata, nil
+}
+
+// RemoveAll removes the file or folder at path p and any children it contains.
+// It returns nil if p does not exist.
+// Note: this deletes files, folders, and removes sharing. Use with extreme care.
+// This is not an SDK method per-se, rather a wrapper around DeleteFile and DeleteFolderRecursive.
+func (c *Client) RemoveAll(ctx context.Context, p string, opts ...ClientOption) error {
+	m, err := c.StatPath(ctx, p, opts...)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if m.IsFolder {
+		_, err = c.DeleteFolderRecursive(ctx, T1FolderByID(m.FolderID), opts...)
+		return err
+	}
+
+	_, err = c.DeleteFile(ctx, T3FileByID(m.FileID), opts...)
+
+	return err
+}
+
+// Walk walks the pCloud file tree rooted at root, calling fn for each file or folder in the
+// tree, including root. Entries are visited in lexical order within each folder.
+// The tree is obtained with a single recursive ListFolder: this is efficient for large trees
+// but SkipDir does not save any API call. See WalkDir.
+func (c *Client) Walk(ctx context.Context, root string, fn WalkFunc, opts ...ClientOption) error {
+	return c.walkFrom(ctx, root, true, fn, func(ctx context.Context, entry *Metadata) ([]*Metadata, error) {
+		return entry.Contents, nil
+	}, opts...)
+}
+
+// WalkDir walks the pCloud file tree rooted at root, calling fn for each file or folder in the
+// tree, including root. Entries are visited in lexical order within each folder.
+// Unlike Walk, WalkDir lists each folder only when it is visited, so SkipDir avoids listing the
+// folders that are skipped.
+func (c *Client) WalkDir(ctx context.Context, root string, fn WalkFunc, opts ...ClientOption) error {
+	return c.walkFrom(ctx, root, false, fn, func(ctx context.Context, entry *Metadata) ([]*Metadata, error) {
+		lf, err := c.ListFolder(ctx, T1FolderByID(entry.FolderID), false, false, false, false, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return lf.Metadata.Contents, nil
+	}, opts...)
+}
+
+type listFunc func(ctx context.Context, entry *Metadata) ([]*Metadata, error)
+
+func (c *Client) walkFrom(ctx context.Context, root string, recursive bool, fn WalkFunc, list listFunc, opts ...ClientOption) error {
+	root = path.Clean("/" + root)
+
+	lf, err := c.ListFolder(ctx, T1FolderByPath(root), recursive, false, false, false, opts...)
+	if err != nil {
+		err = fn(root, nil, err)
+		if errors.Is(err, SkipDir) {
+			return nil
+		}
+		return err
+	}
+
+	rootEntry := lf.Metadata
+	contents := rootEntry.Contents
+
+	err = walk(ctx, root, rootEntry, fn, func(ctx context.Context, entry *Metadata) ([]*Metadata, error) {
+		if entry == rootEntry {
+			// the root folder was listed above, no need to list it again
+			return contents, nil
+		}
+		return list(ctx, entry)
+	})
+	if errors.Is(err, SkipDir) {
+		return nil
+	}
+
+	return err
+}
+
+func walk(ctx context.Context, p string, entry *Metadata, fn WalkFunc, list listFunc) error {
+	err := fn(p, entry, nil)
+	if err != nil || !entry.IsFolder {
+		return err
+	}
+
+	contents, err := list(ctx, entry)
+	if err != nil {
+		// the error is reported against the folder itself
+		return fn(p, entry, err)
+	}
+
+	children := make([]*Metadata, 0, len(contents))
+	for _, child := range contents {
+		if child.IsDeleted {
+			continue
+		}
+		children = append(children, child)
+	}
+
+	sort.Slice(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+
+	for _, child := range children {
+		err = walk(ctx, path.Join(p, child.Name), child, fn, list)
+		if err != nil {
+			if errors.Is(err, SkipDir) {
+				if child.IsFolder {
+					continue
+				}
+				// SkipDir on a file skips the remaining entries of the containing folder
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Glob returns the paths of all the files and folders matching pattern, or nil if there is no
+// matching entry. The syntax of patterns is the same as in path.Match. The pattern must be an
+// absolute pCloud path.
+// Glob ignores API errors encountered while listing the folders that could contain matches,
+// except for the folder that is the static prefix of pattern. The only possible returned error
+// besides API errors is path.ErrBadPattern, when pattern is malformed.
+func (c *Client) Glob(ctx context.Context, pattern string, opts ...ClientOption) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	pattern = path.Clean("/" + pattern)
+
+	components := strings.Split(pattern[1:], "/")
+
+	// find the static prefix of the pattern: this is where the walk starts.
+	staticLen := 0
+	for staticLen < len(components) && !hasMeta(components[staticLen]) {
+		staticLen++
+	}
+
+	if staticLen == len(components) {
+		exists, err := c.Exists(ctx, pattern, opts...)
+		if err != nil || !exists {
+			return nil, err
+		}
+		return []string{pattern}, nil
+	}
+
+	root := "/" + strings.Join(components[:staticLen], "/")
+	depth := len(components)
+
+	var matches []string
+
+	err := c.WalkDir(ctx, root, func(p string, entry *Metadata, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			return SkipDir
+		}
+
+		if p == root {
+			return nil
+		}
+
+		if ok, _ := path.Match(pattern, p); ok {
+			matches = append(matches, p)
+		}
+
+		if entry.IsFolder && strings.Count(p, "/") >= depth {
+			// entries below this level are deeper than the pattern and cannot match.
+			return SkipDir
+		}
+
+		return nil
+	}, opts...)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return matches, nil
+}
+
+// hasMeta reports whether p contains any of the magic characters recognized by path.Match.
+func hasMeta(p string) bool {
+	return strings.ContainsAny(p, `*?[\`)
+}
@@ -0,0 +1,82 @@
+package sdk_test
+
+import (
+	"github.com/google/uuid"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+)
+
+func (testsuite *IntegrationTestSuite) Test_PathHelpers() {
+	root := testsuite.testFolderPath + "/go_pCloud_" + uuid.New().String()
+
+	exists, err := testsuite.pcc.Exists(testsuite.ctx, root)
+	testsuite.Require().NoError(err)
+	testsuite.False(exists)
+
+	_, err = testsuite.pcc.StatPath(testsuite.ctx, root)
+	testsuite.Require().Error(err)
+	testsuite.True(sdk.IsNotFound(err))
+
+	m, err := testsuite.pcc.MkdirAll(testsuite.ctx, root+"/a/b/c")
+	testsuite.Require().NoError(err)
+	testsuite.Equal("c", m.Name)
+
+	// MkdirAll on an existing folder is a no-op
+	m2, err := testsuite.pcc.MkdirAll(testsuite.ctx, root+"/a/b/c")
+	testsuite.Require().NoError(err)
+	testsuite.Equal(m.FolderID, m2.FolderID)
+
+	_, err = testsuite.pcc.MkdirAll(testsuite.ctx, root+"/a/x")
+	testsuite.Require().NoError(err)
+
+	f, err := testsuite.pcc.FileOpen(testsuite.ctx, sdk.O_CREAT, sdk.T4FileByPath(root+"/a/b/file.txt"))
+	testsuite.Require().NoError(err)
+	err = testsuite.pcc.FileClose(testsuite.ctx, f.FD)
+	testsuite.Require().NoError(err)
+
+	exists, err = testsuite.pcc.Exists(testsuite.ctx, root+"/a/b/file.txt")
+	testsuite.Require().NoError(err)
+	testsuite.True(exists)
+
+	m, err = testsuite.pcc.StatPath(testsuite.ctx, root+"/a/b")
+	testsuite.Require().NoError(err)
+	testsuite.True(m.IsFolder)
+	testsuite.Nil(m.Contents)
+
+	for _, walk := range []func(string, sdk.WalkFunc) error{
+		func(p string, fn sdk.WalkFunc) error { return testsuite.pcc.Walk(testsuite.ctx, p, fn) },
+		func(p string, fn sdk.WalkFunc) error { return testsuite.pcc.WalkDir(testsuite.ctx, p, fn) },
+	} {
+		var visited []string
+		err = walk(root, func(p string, entry *sdk.Metadata, err error) error {
+			if err != nil {
+				return err
+			}
+			visited = append(visited, p)
+			if entry.IsFolder && entry.Name == "b" {
+				return sdk.SkipDir
+			}
+			return nil
+		})
+		testsuite.Require().NoError(err)
+		testsuite.Equal([]string{root, root + "/a", root + "/a/b", root + "/a/x"}, visited)
+	}
+
+	matches, err := testsuite.pcc.Glob(testsuite.ctx, root+"/a/*")
+	testsuite.Require().NoError(err)
+	testsuite.Equal([]string{root + "/a/b", root + "/a/x"}, matches)
+
+	matches, err = testsuite.pcc.Glob(testsuite.ctx, root+"/*/b/*.txt")
+	testsuite.Require().NoError(err)
+	testsuite.Equal([]string{root + "/a/b/file.txt"}, matches)
+
+	err = testsuite.pcc.RemoveAll(testsuite.ctx, root+"/a/b/file.txt")
+	testsuite.Require().NoError(err)
+
+	err = testsuite.pcc.RemoveAll(testsuite.ctx, root)
+	testsuite.Require().NoError(err)
+
+	// RemoveAll on a non-existent path is a no-op
+	err = testsuite.pcc.RemoveAll(testsuite.ctx, root)
+	testsuite.Require().NoError(err)
+}
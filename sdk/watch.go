@@ -0,0 +1,252 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DiffCompactionWindow is the age beyond which pCloud reserves the right to compact the diff
+// events. A client state older than this should be re-downloaded from zero.
+// See Diff.
+const DiffCompactionWindow = 6 * 30 * 24 * time.Hour
+
+// DiffCursor is the position of a client state in the stream of diff events.
+type DiffCursor struct {
+	DiffID uint64
+	// Time is the time of the event at DiffID. It is used to detect client states that are
+	// older than DiffCompactionWindow.
+	Time time.Time
+}
+
+// DiffCursorStore persists the DiffCursor of a Watch.
+type DiffCursorStore interface {
+	// LoadDiffCursor returns the last saved DiffCursor or nil if none has been saved yet.
+	LoadDiffCursor(ctx context.Context) (*DiffCursor, error)
+	// SaveDiffCursor saves the DiffCursor.
+	SaveDiffCursor(ctx context.Context, cursor DiffCursor) error
+}
+
+// WatchEvent is sent by Watch for each diff event.
+type WatchEvent struct {
+	Entry Entry
+
+	// Resync is true when the client state must be reset to an empty root directory and rebuilt
+	// from the events that follow.
+	// This is the case of Reset events and when the saved client state is older than
+	// DiffCompactionWindow, in which case Entry is empty.
+	Resync bool
+
+	// Err is set on the last event sent by Watch when it cannot continue. Entry is empty.
+	Err error
+}
+
+type watchConfig struct {
+	store         DiffCursorStore
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+	limit         uint64
+}
+
+// WatchOption is a Go functional parameter signature used to configure Watch.
+type WatchOption func(*watchConfig)
+
+// WithWatchCursorStore sets the DiffCursorStore used by Watch to persist its position.
+func WithWatchCursorStore(store DiffCursorStore) WatchOption {
+	return func(cfg *watchConfig) {
+		cfg.store = store
+	}
+}
+
+// WithWatchRetryDelay sets the initial and maximum delays between retries when Watch
+// encounters network errors. The delay doubles after each consecutive failure.
+// The defaults are 1 second and 1 minute.
+func WithWatchRetryDelay(initial, maximum time.Duration) WatchOption {
+	return func(cfg *watchConfig) {
+		cfg.retryDelay = initial
+		cfg.maxRetryDelay = maximum
+	}
+}
+
+// WithWatchLimit sets the maximum number of entries Watch requests with each call to Diff.
+// The default is no limit.
+func WithWatchLimit(limit uint64) WatchOption {
+	return func(cfg *watchConfig) {
+		cfg.limit = limit
+	}
+}
+
+// Watch subscribes to the diff events of the user's account, starting after fromDiffID and
+// returns a channel of WatchEvent. The channel is closed when ctx is done or after an event
+// with Err is sent.
+// When fromDiffID is 0 and a DiffCursorStore is configured, Watch resumes from the saved
+// cursor. Otherwise, a fromDiffID of 0 starts with the full state of the account.
+// Watch uses long-polling (see the block parameter of Diff) and retries on network errors.
+// The cursor is saved after the events of each call to Diff have been delivered to the
+// channel, so events may be delivered again after a restart: consumers should be idempotent.
+// This is not an SDK method per-se, rather a wrapper around Diff.
+//
+// IMPORTANT: Client serialises its HTTP calls and a blocked Diff call holds the connection until
+// an event arrives. Use a dedicated Client for Watch.
+func (c *Client) Watch(ctx context.Context, fromDiffID uint64, opts ...WatchOption) <-chan WatchEvent {
+	cfg := watchConfig{
+		retryDelay:    time.Second,
+		maxRetryDelay: time.Minute,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	eventsCh := make(chan WatchEvent)
+
+	go func() {
+		defer close(eventsCh)
+
+		err := c.watch(ctx, fromDiffID, cfg, eventsCh)
+		if err != nil && ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case eventsCh <- WatchEvent{Err: err}:
+			}
+		}
+	}()
+
+	return eventsCh
+}
+
+func (c *Client) watch(ctx context.Context, fromDiffID uint64, cfg watchConfig, eventsCh chan<- WatchEvent) error {
+	cursor := DiffCursor{DiffID: fromDiffID}
+
+	if fromDiffID == 0 && cfg.store != nil {
+		saved, err := cfg.store.LoadDiffCursor(ctx)
+		if err != nil {
+			return errors.WithMessage(err, "loading diff cursor")
+		}
+		if saved != nil {
+			cursor = *saved
+		}
+	}
+
+	if cursor.DiffID > 0 && !cursor.Time.IsZero() && time.Since(cursor.Time) > DiffCompactionWindow {
+		if !sendWatchEvent(ctx, eventsCh, WatchEvent{Resync: true}) {
+			return nil
+		}
+		cursor = DiffCursor{}
+	}
+
+	delay := cfg.retryDelay
+
+	for {
+		// blocking only works when diffid is provided.
+		dr, err := c.Diff(ctx, cursor.DiffID, time.Time{}, 0, cursor.DiffID > 0, cfg.limit)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			var apiErr *APIError
+			if errors.As(err, &apiErr) {
+				return err
+			}
+
+			// network error: back off and retry.
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+
+			delay *= 2
+			if delay > cfg.maxRetryDelay {
+				delay = cfg.maxRetryDelay
+			}
+
+			continue
+		}
+
+		delay = cfg.retryDelay
+
+		for _, e := range dr.Entries {
+			if !sendWatchEvent(ctx, eventsCh, WatchEvent{Entry: e, Resync: e.Event == Reset}) {
+				return nil
+			}
+			cursor = DiffCursor{DiffID: e.DiffID, Time: e.Time.Time}
+		}
+
+		if dr.DiffID > cursor.DiffID {
+			cursor.DiffID = dr.DiffID
+		}
+
+		if cfg.store != nil {
+			err = cfg.store.SaveDiffCursor(ctx, cursor)
+			if err != nil {
+				return errors.WithMessage(err, "saving diff cursor")
+			}
+		}
+	}
+}
+
+func sendWatchEvent(ctx context.Context, eventsCh chan<- WatchEvent, event WatchEvent) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case eventsCh <- event:
+		return true
+	}
+}
+
+// FileDiffCursorStore is a DiffCursorStore that persists the DiffCursor in a JSON file.
+type FileDiffCursorStore struct {
+	filename string
+}
+
+// NewFileDiffCursorStore creates a new initialised FileDiffCursorStore that persists the
+// DiffCursor in filename.
+func NewFileDiffCursorStore(filename string) *FileDiffCursorStore {
+	return &FileDiffCursorStore{
+		filename: filename,
+	}
+}
+
+// LoadDiffCursor returns the DiffCursor saved in the file or nil if the file does not exist.
+func (s *FileDiffCursorStore) LoadDiffCursor(_ context.Context) (*DiffCursor, error) {
+	data, err := os.ReadFile(s.filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	cursor := &DiffCursor{}
+
+	err = json.Unmarshal(data, cursor)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal")
+	}
+
+	return cursor, nil
+}
+
+// SaveDiffCursor saves the DiffCursor to the file.
+// The file is replaced atomically so that a crash does not leave a corrupted cursor behind.
+func (s *FileDiffCursorStore) SaveDiffCursor(_ context.Context, cursor DiffCursor) error {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+
+	tmpFilename := filepath.Join(filepath.Dir(s.filename), "."+filepath.Base(s.filename)+".tmp")
+
+	err = os.WriteFile(tmpFilename, data, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(os.Rename(tmpFilename, s.filename))
+}
@@ -0,0 +1,67 @@
+package sdk_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+)
+
+func (testsuite *IntegrationTestSuite) Test_Watch() {
+	dr, err := testsuite.pcc.Diff(testsuite.ctx, 0, time.Time{}, 0, false, 0)
+	testsuite.Require().NoError(err)
+
+	tmpDir, err := os.MkdirTemp("", "go_pCloud_watch")
+	testsuite.Require().NoError(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	store := sdk.NewFileDiffCursorStore(filepath.Join(tmpDir, "cursor.json"))
+	err = store.SaveDiffCursor(testsuite.ctx, sdk.DiffCursor{DiffID: dr.DiffID, Time: time.Now()})
+	testsuite.Require().NoError(err)
+
+	folderName := "go_pCloud_" + uuid.New().String()
+	_, err = testsuite.pcc.CreateFolder(testsuite.ctx, sdk.T2FolderByIDName(testsuite.testFolderID, folderName))
+	testsuite.Require().NoError(err)
+
+	ctx, cancel := context.WithTimeout(testsuite.ctx, 15*time.Second)
+	defer cancel()
+
+	// the folder creation event is already available so Diff does not block.
+	found := false
+	for event := range testsuite.pcc.Watch(ctx, 0, sdk.WithWatchCursorStore(store)) {
+		testsuite.Require().NoError(event.Err)
+		testsuite.False(event.Resync)
+		testsuite.Greater(event.Entry.DiffID, dr.DiffID)
+		if event.Entry.Event == sdk.CreateFolder && event.Entry.Metadata.Name == folderName {
+			found = true
+			cancel()
+		}
+	}
+	testsuite.True(found)
+
+	cursor, err := store.LoadDiffCursor(testsuite.ctx)
+	testsuite.Require().NoError(err)
+	testsuite.Require().NotNil(cursor)
+	testsuite.Greater(cursor.DiffID, dr.DiffID)
+}
+
+func (testsuite *IntegrationTestSuite) Test_Watch_StaleCursorRequestsResync() {
+	tmpDir, err := os.MkdirTemp("", "go_pCloud_watch")
+	testsuite.Require().NoError(err)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	store := sdk.NewFileDiffCursorStore(filepath.Join(tmpDir, "cursor.json"))
+	err = store.SaveDiffCursor(testsuite.ctx, sdk.DiffCursor{DiffID: 1, Time: time.Now().Add(-2 * sdk.DiffCompactionWindow)})
+	testsuite.Require().NoError(err)
+
+	ctx, cancel := context.WithCancel(testsuite.ctx)
+	defer cancel()
+
+	event := <-testsuite.pcc.Watch(ctx, 0, sdk.WithWatchCursorStore(store), sdk.WithWatchLimit(1))
+	testsuite.Require().NoError(event.Err)
+	testsuite.True(event.Resync)
+}
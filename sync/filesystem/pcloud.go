@@ -2,11 +2,15 @@ package filesystem
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"path/filepath"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/seborama/pcloud-sdk/sdk"
+	"github.com/seborama/pcloud-sdk/sync"
 	"github.com/seborama/pcloud-sdk/tracker/db"
 )
 
@@ -16,8 +20,19 @@ type pCloudSDK interface {
 	FileClose(ctx context.Context, fd uint64, opts ...sdk.ClientOption) error
 	FileRead(ctx context.Context, fd, count uint64, opts ...sdk.ClientOption) ([]byte, error)
 	FileWrite(ctx context.Context, fd uint64, data []byte, opts ...sdk.ClientOption) (*sdk.FileDataTransfer, error)
+	CreateFolderIfNotExists(ctx context.Context, folder sdk.T2PathOrFolderIDName, opts ...sdk.ClientOption) (*sdk.FSList, error)
+	DeleteFolder(ctx context.Context, folder sdk.T1PathOrFolderID, opts ...sdk.ClientOption) (*sdk.FSList, error)
+	DeleteFile(ctx context.Context, file sdk.T3PathOrFileID, opts ...sdk.ClientOption) (*sdk.FileResult, error)
+	RenameFolder(ctx context.Context, folder sdk.T1PathOrFolderID, toFolder sdk.ToT2PathOrFolderIDOrFolderIDName, opts ...sdk.ClientOption) (*sdk.FSList, error)
+	RenameFile(ctx context.Context, file sdk.T3PathOrFileID, destination sdk.ToT3PathOrFolderIDName, opts ...sdk.ClientOption) (*sdk.FileResult, error)
+	CopyFile(ctx context.Context, file sdk.T3PathOrFileID, destination sdk.ToT3PathOrFolderIDName, noOverOpt bool, mTime, cTime time.Time, opts ...sdk.ClientOption) (*sdk.FileResult, error)
+	Exists(ctx context.Context, p string, opts ...sdk.ClientOption) (bool, error)
 }
 
+// pCloudTmpFileSuffix is the suffix of the name of the temporary files that MkFileWithTimes
+// writes the data to.
+const pCloudTmpFileSuffix = ".pcloud-sync.tmp"
+
 // PCloud is a file system abstraction for the PCloud file system.
 type PCloud struct {
 	sdk pCloudSDK
@@ -72,22 +87,42 @@ func (fs *PCloud) StreamFileData(ctx context.Context, fsEntry db.FSEntry) (<-cha
 	return dataCh, errCh
 }
 
-// MkDir creates a directory.
+// MkDir creates a directory, and its parents as needed. It is not an error if the directory
+// exists already.
 func (fs *PCloud) MkDir(ctx context.Context, path string) error {
-	panic("not implemented")
+	_, err := fs.sdk.CreateFolderIfNotExists(ctx, sdk.T2FolderByPath(path))
+	if isAPIError(err, sdk.ErrComponentOfParentDirectoryNotExists) && filepath.Dir(path) != path {
+		err = fs.MkDir(ctx, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+
+		_, err = fs.sdk.CreateFolderIfNotExists(ctx, sdk.T2FolderByPath(path))
+	}
+
+	return pCloudError(err, "creating folder '%s'", path)
 }
 
-// MkFile creates a file with the contents streamed through dataCh.
+// MkFile creates a file with the contents streamed through dataCh, and the folders of its path
+// as needed.
 // TODO: wrap the dataCh into a io.ReadWriter so to keep the code simple and offer a familiar Go feel.
 func (fs *PCloud) MkFile(ctx context.Context, path string, dataCh <-chan []byte) (err error) {
 	f, err := fs.sdk.FileOpen(ctx, sdk.O_CREAT|sdk.O_TRUNC, sdk.T4FileByPath(path))
+	if isAPIError(err, sdk.ErrComponentOfParentDirectoryNotExists) {
+		err = fs.MkDir(ctx, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+
+		f, err = fs.sdk.FileOpen(ctx, sdk.O_CREAT|sdk.O_TRUNC, sdk.T4FileByPath(path))
+	}
 	if err != nil {
-		return errors.WithStack(err)
+		return pCloudError(err, "opening '%s'", path)
 	}
 	defer func() {
 		e := fs.sdk.FileClose(ctx, f.FD)
 		if e != nil && err == nil {
-			err = e
+			err = pCloudError(e, "closing '%s'", path)
 			return
 		}
 	}()
@@ -95,29 +130,90 @@ func (fs *PCloud) MkFile(ctx context.Context, path string, dataCh <-chan []byte)
 	for data := range dataCh {
 		_, err = fs.sdk.FileWrite(ctx, f.FD, data)
 		if err != nil {
-			return
+			return pCloudError(err, "writing '%s'", path)
 		}
 	}
 
 	return nil
 }
 
-// RmDir removes a directory.
+// MkFileWithTimes creates a file like MkFile, with the times of modification and creation
+// given. The created time is only set along with the modified time.
+// pCloud only sets the times of a file when it is uploaded or copied: the contents are written
+// to a temporary file that is then copied, with the times, over the file at path.
+func (fs *PCloud) MkFileWithTimes(ctx context.Context, path string, dataCh <-chan []byte, modified, created time.Time) (err error) {
+	if modified.IsZero() {
+		return fs.MkFile(ctx, path, dataCh)
+	}
+
+	tmpPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+pCloudTmpFileSuffix)
+
+	defer func() {
+		_, e := fs.sdk.DeleteFile(ctx, sdk.T3FileByPath(tmpPath))
+		if e != nil && err == nil {
+			err = pCloudError(e, "removing '%s'", tmpPath)
+		}
+	}()
+
+	err = fs.MkFile(ctx, tmpPath, dataCh)
+	if err != nil {
+		return err
+	}
+
+	_, err = fs.sdk.CopyFile(ctx, sdk.T3FileByPath(tmpPath), sdk.ToT3ByPath(path), false, modified, created)
+	return pCloudError(err, "copying '%s' to '%s'", tmpPath, path)
+}
+
+// RmDir removes an empty directory.
 func (fs *PCloud) RmDir(ctx context.Context, path string) error {
-	panic("not implemented")
+	_, err := fs.sdk.DeleteFolder(ctx, sdk.T1FolderByPath(path))
+	return pCloudError(err, "removing folder '%s'", path)
 }
 
 // RmFile removes a file.
 func (fs *PCloud) RmFile(ctx context.Context, path string) error {
-	panic("not implemented")
+	_, err := fs.sdk.DeleteFile(ctx, sdk.T3FileByPath(path))
+	return pCloudError(err, "removing '%s'", path)
 }
 
 // MvDir moves a directory.
 func (fs *PCloud) MvDir(ctx context.Context, fromPath string, toPath string) error {
-	panic("not implemented")
+	_, err := fs.sdk.RenameFolder(ctx, sdk.T1FolderByPath(fromPath), sdk.ToT2FolderByPath(toPath))
+	return pCloudError(err, "moving folder '%s' to '%s'", fromPath, toPath)
 }
 
-// MvFile moves a file.
+// MvFile moves a file. pCloud replaces the file at toPath, if any.
 func (fs *PCloud) MvFile(ctx context.Context, fromPath string, toPath string) error {
-	panic("not implemented")
+	_, err := fs.sdk.RenameFile(ctx, sdk.T3FileByPath(fromPath), sdk.ToT3ByPath(toPath))
+	return pCloudError(err, "moving '%s' to '%s'", fromPath, toPath)
+}
+
+// Exists reports whether a file or folder exists at path.
+func (fs *PCloud) Exists(ctx context.Context, path string) (bool, error) {
+	exists, err := fs.sdk.Exists(ctx, path)
+	return exists, pCloudError(err, "checking whether '%s' exists", path)
+}
+
+// isAPIError reports whether err is the pCloud API error of the result code.
+func isAPIError(err error, result int) bool {
+	var apiErr *sdk.APIError
+	return errors.As(err, &apiErr) && apiErr.Result == result
+}
+
+// pCloudError adds the message to err and reports the pCloud API errors that have a typed
+// outcome in the sync as such (see sync.ErrNotFound and sync.ErrAlreadyExists).
+// It returns nil when err is nil.
+func pCloudError(err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+
+	switch {
+	case sdk.IsNotFound(err):
+		err = fmt.Errorf("%w: %w", sync.ErrNotFound, err)
+	case isAPIError(err, sdk.ErrFileOrFolderAlreadyExists):
+		err = fmt.Errorf("%w: %w", sync.ErrAlreadyExists, err)
+	}
+
+	return errors.WithMessagef(err, format, args...)
 }
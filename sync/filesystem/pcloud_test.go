@@ -2,6 +2,7 @@ package filesystem_test
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net/url"
 	"testing"
@@ -12,6 +13,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/seborama/pcloud-sdk/sdk"
+	"github.com/seborama/pcloud-sdk/sync"
 	"github.com/seborama/pcloud-sdk/sync/filesystem"
 	"github.com/seborama/pcloud-sdk/tracker/db"
 )
@@ -63,7 +65,7 @@ func TestPCloud_StreamFileData_MkFile(t *testing.T) {
 	u2 := filesystem.NewPCloud(pCloudSDK2)
 
 	fsEntry := db.FSEntry{
-		FSName:         db.LocalFileSystem,
+		FSName:         "local",
 		DeviceID:       "dev-id-1",
 		EntryID:        123,
 		IsFolder:       false,
@@ -84,6 +86,129 @@ func TestPCloud_StreamFileData_MkFile(t *testing.T) {
 	require.NoError(t, <-errCh)
 }
 
+// queryMatcher matches the SDK parameters that set the query parameter key to value.
+func queryMatcher(key, value string) interface{} {
+	return mock.MatchedBy(func(f func(q url.Values)) bool {
+		q := url.Values{}
+		f(q)
+		return q.Get(key) == value
+	})
+}
+
+func TestPCloud_MkDir(t *testing.T) {
+	ctx := context.Background()
+
+	pCloudSDK := &mockPCloudSDK{}
+	defer func() { _ = pCloudSDK.AssertExpectations(t) }()
+	pCloudSDK.
+		On("CreateFolderIfNotExists", ctx, queryMatcher("path", "/a/b/c"), []sdk.ClientOption(nil)).
+		Return((*sdk.FSList)(nil), &sdk.APIError{Result: sdk.ErrComponentOfParentDirectoryNotExists}).
+		Once().
+		On("CreateFolderIfNotExists", ctx, queryMatcher("path", "/a/b"), []sdk.ClientOption(nil)).
+		Return((*sdk.FSList)(nil), &sdk.APIError{Result: sdk.ErrComponentOfParentDirectoryNotExists}).
+		Once().
+		On("CreateFolderIfNotExists", ctx, queryMatcher("path", "/a"), []sdk.ClientOption(nil)).
+		Return(&sdk.FSList{}, nil).
+		Once().
+		On("CreateFolderIfNotExists", ctx, queryMatcher("path", "/a/b"), []sdk.ClientOption(nil)).
+		Return(&sdk.FSList{}, nil).
+		Once().
+		On("CreateFolderIfNotExists", ctx, queryMatcher("path", "/a/b/c"), []sdk.ClientOption(nil)).
+		Return(&sdk.FSList{}, nil).
+		Once().
+		On("CreateFolderIfNotExists", ctx, queryMatcher("path", "/file/d"), []sdk.ClientOption(nil)).
+		Return((*sdk.FSList)(nil), &sdk.APIError{Result: sdk.ErrFileOrFolderAlreadyExists}).
+		Once()
+
+	fs := filesystem.NewPCloud(pCloudSDK)
+
+	err := fs.MkDir(ctx, "/a/b/c")
+	require.NoError(t, err)
+
+	err = fs.MkDir(ctx, "/file/d")
+	require.ErrorIs(t, err, sync.ErrAlreadyExists)
+}
+
+func TestPCloud_MkFileWithTimes(t *testing.T) {
+	ctx := context.Background()
+
+	data := []byte("Hello")
+	modified := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	pCloudSDK := &mockPCloudSDK{}
+	defer func() { _ = pCloudSDK.AssertExpectations(t) }()
+	pCloudSDK.
+		On("FileOpen", ctx, uint64(sdk.O_CREAT|sdk.O_TRUNC), queryMatcher("path", "/Folder/.File.pcloud-sync.tmp"), []sdk.ClientOption(nil)).
+		Return(&sdk.File{FD: 7}, nil).
+		Once().
+		On("FileWrite", ctx, uint64(7), data, []sdk.ClientOption(nil)).
+		Return(&sdk.FileDataTransfer{Bytes: uint64(len(data))}, nil).
+		Once().
+		On("FileClose", ctx, uint64(7), []sdk.ClientOption(nil)).
+		Return(nil).
+		Once().
+		On("CopyFile", ctx, queryMatcher("path", "/Folder/.File.pcloud-sync.tmp"), queryMatcher("topath", "/Folder/File"), false, modified, created, []sdk.ClientOption(nil)).
+		Return(&sdk.FileResult{}, nil).
+		Once().
+		On("DeleteFile", ctx, queryMatcher("path", "/Folder/.File.pcloud-sync.tmp"), []sdk.ClientOption(nil)).
+		Return(&sdk.FileResult{}, nil).
+		Once()
+
+	fs := filesystem.NewPCloud(pCloudSDK)
+
+	dataCh := make(chan []byte, 1)
+	dataCh <- data
+	close(dataCh)
+
+	err := fs.MkFileWithTimes(ctx, "/Folder/File", dataCh, modified, created)
+	require.NoError(t, err)
+}
+
+func TestPCloud_Rm_Mv(t *testing.T) {
+	ctx := context.Background()
+
+	pCloudSDK := &mockPCloudSDK{}
+	defer func() { _ = pCloudSDK.AssertExpectations(t) }()
+	pCloudSDK.
+		On("DeleteFolder", ctx, queryMatcher("path", "/Folder"), []sdk.ClientOption(nil)).
+		Return(&sdk.FSList{}, nil).
+		Once().
+		On("DeleteFile", ctx, queryMatcher("path", "/Folder/File"), []sdk.ClientOption(nil)).
+		Return((*sdk.FileResult)(nil), &sdk.APIError{Result: sdk.ErrFileNotFound, Message: "File not found."}).
+		Once().
+		On("RenameFolder", ctx, queryMatcher("path", "/Folder1"), queryMatcher("topath", "/Folder2"), []sdk.ClientOption(nil)).
+		Return((*sdk.FSList)(nil), &sdk.APIError{Result: sdk.ErrFileOrFolderAlreadyExists}).
+		Once().
+		On("RenameFile", ctx, queryMatcher("path", "/File1"), queryMatcher("topath", "/Folder2/File2"), []sdk.ClientOption(nil)).
+		Return(&sdk.FileResult{}, nil).
+		Once().
+		On("Exists", ctx, "/Folder2/File2", []sdk.ClientOption(nil)).
+		Return(true, nil).
+		Once()
+
+	fs := filesystem.NewPCloud(pCloudSDK)
+
+	err := fs.RmDir(ctx, "/Folder")
+	require.NoError(t, err)
+
+	err = fs.RmFile(ctx, "/Folder/File")
+	require.ErrorIs(t, err, sync.ErrNotFound)
+	var apiErr *sdk.APIError
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, sdk.ErrFileNotFound, apiErr.Result)
+
+	err = fs.MvDir(ctx, "/Folder1", "/Folder2")
+	require.ErrorIs(t, err, sync.ErrAlreadyExists)
+
+	err = fs.MvFile(ctx, "/File1", "/Folder2/File2")
+	require.NoError(t, err)
+
+	exists, err := fs.Exists(ctx, "/Folder2/File2")
+	require.NoError(t, err)
+	require.True(t, exists)
+}
+
 type mockPCloudSDK struct {
 	mock.Mock
 }
@@ -107,3 +232,38 @@ func (m *mockPCloudSDK) FileWrite(ctx context.Context, fd uint64, data []byte, o
 	args := m.Called(ctx, fd, data, opts)
 	return args.Get(0).(*sdk.FileDataTransfer), args.Error(1)
 }
+
+func (m *mockPCloudSDK) CreateFolderIfNotExists(ctx context.Context, folder sdk.T2PathOrFolderIDName, opts ...sdk.ClientOption) (*sdk.FSList, error) {
+	args := m.Called(ctx, folder, opts)
+	return args.Get(0).(*sdk.FSList), args.Error(1)
+}
+
+func (m *mockPCloudSDK) DeleteFolder(ctx context.Context, folder sdk.T1PathOrFolderID, opts ...sdk.ClientOption) (*sdk.FSList, error) {
+	args := m.Called(ctx, folder, opts)
+	return args.Get(0).(*sdk.FSList), args.Error(1)
+}
+
+func (m *mockPCloudSDK) DeleteFile(ctx context.Context, file sdk.T3PathOrFileID, opts ...sdk.ClientOption) (*sdk.FileResult, error) {
+	args := m.Called(ctx, file, opts)
+	return args.Get(0).(*sdk.FileResult), args.Error(1)
+}
+
+func (m *mockPCloudSDK) RenameFolder(ctx context.Context, folder sdk.T1PathOrFolderID, toFolder sdk.ToT2PathOrFolderIDOrFolderIDName, opts ...sdk.ClientOption) (*sdk.FSList, error) {
+	args := m.Called(ctx, folder, toFolder, opts)
+	return args.Get(0).(*sdk.FSList), args.Error(1)
+}
+
+func (m *mockPCloudSDK) RenameFile(ctx context.Context, file sdk.T3PathOrFileID, destination sdk.ToT3PathOrFolderIDName, opts ...sdk.ClientOption) (*sdk.FileResult, error) {
+	args := m.Called(ctx, file, destination, opts)
+	return args.Get(0).(*sdk.FileResult), args.Error(1)
+}
+
+func (m *mockPCloudSDK) Exists(ctx context.Context, p string, opts ...sdk.ClientOption) (bool, error) {
+	args := m.Called(ctx, p, opts)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *mockPCloudSDK) CopyFile(ctx context.Context, file sdk.T3PathOrFileID, destination sdk.ToT3PathOrFolderIDName, noOverOpt bool, mTime, cTime time.Time, opts ...sdk.ClientOption) (*sdk.FileResult, error) {
+	args := m.Called(ctx, file, destination, noOverOpt, mTime, cTime, opts)
+	return args.Get(0).(*sdk.FileResult), args.Error(1)
+}
@@ -0,0 +1,298 @@
+package filesystem
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+	"github.com/pkg/errors"
+
+	"github.com/seborama/pcloud-sdk/sync"
+	"github.com/seborama/pcloud-sdk/tracker/db"
+	trackerfs "github.com/seborama/pcloud-sdk/tracker/filesystem"
+)
+
+// s3Client defines the S3 client methods used to perform operations on an S3 bucket.
+type s3Client interface {
+	GetObject(ctx context.Context, bucketName, objectName string, opts minio.GetObjectOptions) (*minio.Object, error)
+	PutObject(ctx context.Context, bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error)
+	PutObjectTagging(ctx context.Context, bucketName, objectName string, otags *tags.Tags, opts minio.PutObjectTaggingOptions) error
+	GetObjectTagging(ctx context.Context, bucketName, objectName string, opts minio.GetObjectTaggingOptions) (*tags.Tags, error)
+	ComposeObject(ctx context.Context, dst minio.CopyDestOptions, srcs ...minio.CopySrcOptions) (minio.UploadInfo, error)
+	RemoveObject(ctx context.Context, bucketName, objectName string, opts minio.RemoveObjectOptions) error
+	ListObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo
+	StatObject(ctx context.Context, bucketName, objectName string, opts minio.StatObjectOptions) (minio.ObjectInfo, error)
+}
+
+// defaultS3PartSize is the size of the parts that files are uploaded in, by default.
+const defaultS3PartSize = 16 * 1_048_576
+
+// S3 is a file system abstraction for a bucket of an S3-compatible object storage.
+// The paths are the keys of the objects, without their leading "/". The folders are empty
+// objects which key ends with "/".
+// S3 has no notion of moving an object: it is copied and then deleted.
+type S3 struct {
+	client   s3Client
+	bucket   string
+	partSize uint64
+}
+
+// S3Option is a Go functional parameter signature used to configure S3.
+type S3Option func(*S3)
+
+// WithS3PartSize sets the size of the parts that files are uploaded in. S3 requires at least
+// 5 MiB. The default is 16 MiB.
+func WithS3PartSize(size uint64) S3Option {
+	return func(fs *S3) {
+		fs.partSize = size
+	}
+}
+
+// NewS3 creates a new initialised S3 structure for the bucket.
+func NewS3(client s3Client, bucket string, opts ...S3Option) *S3 {
+	fs := &S3{
+		client:   client,
+		bucket:   bucket,
+		partSize: defaultS3PartSize,
+	}
+
+	for _, opt := range opts {
+		opt(fs)
+	}
+
+	return fs
+}
+
+// StreamFileData reads the contents of the file pointed to by fsEntry and streams it to the
+// channel the method returns.
+func (fs *S3) StreamFileData(ctx context.Context, fsEntry db.FSEntry) (<-chan []byte, <-chan error) {
+	dataCh := make(chan []byte, 100)
+	errCh := make(chan error)
+
+	go func() {
+		defer close(errCh)
+		defer close(dataCh)
+
+		key := s3Key(filepath.Join(fsEntry.Path, fsEntry.Name))
+
+		o, err := fs.client.GetObject(ctx, fs.bucket, key, minio.GetObjectOptions{})
+		if err != nil {
+			errCh <- errors.WithMessagef(err, "getting object '%s'", key)
+			return
+		}
+		defer func() { _ = o.Close() }()
+
+		for {
+			data := make([]byte, 1_048_576)
+
+			n, err := io.ReadFull(o, data)
+			if n > 0 {
+				dataCh <- data[:n]
+			}
+			if err != nil {
+				if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+					errCh <- errors.WithMessagef(err, "reading object '%s'", key)
+				}
+				return
+			}
+		}
+	}()
+
+	return dataCh, errCh
+}
+
+// MkDir creates a directory, as an empty object which key ends with "/".
+func (fs *S3) MkDir(ctx context.Context, path string) error {
+	key := s3Key(path) + "/"
+
+	_, err := fs.client.PutObject(ctx, fs.bucket, key, strings.NewReader(""), 0, minio.PutObjectOptions{})
+	return errors.WithMessagef(err, "creating folder marker '%s'", key)
+}
+
+// MkFile creates a file with the contents streamed through dataCh.
+// The file is uploaded in parts (see WithS3PartSize) as it is received. The SHA256 of its contents
+// is then set in the tag trackerfs.S3ContentHashTag of the object.
+func (fs *S3) MkFile(ctx context.Context, path string, dataCh <-chan []byte) error {
+	key := s3Key(path)
+
+	pr, pw := io.Pipe()
+	h := sha256.New()
+
+	go func() {
+		for data := range dataCh {
+			_, err := pw.Write(data)
+			if err != nil {
+				// the upload failed: the rest of the contents is drained so that the sender is
+				// not blocked forever.
+				for range dataCh {
+				}
+				return
+			}
+			_, _ = h.Write(data)
+		}
+		_ = pw.Close()
+	}()
+
+	// the size is unknown: the object is uploaded in parts.
+	_, err := fs.client.PutObject(ctx, fs.bucket, key, pr, -1, minio.PutObjectOptions{PartSize: fs.partSize})
+	// stops the transfer of the contents when the upload failed.
+	_ = pr.CloseWithError(errors.New("upload terminated"))
+	if err != nil {
+		return errors.WithMessagef(err, "uploading object '%s'", key)
+	}
+
+	t, err := tags.NewTags(map[string]string{trackerfs.S3ContentHashTag: hex.EncodeToString(h.Sum(nil))}, true)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = fs.client.PutObjectTagging(ctx, fs.bucket, key, t, minio.PutObjectTaggingOptions{})
+	return errors.WithMessagef(err, "tagging object '%s'", key)
+}
+
+// RmDir removes an empty directory, that is its marker object.
+func (fs *S3) RmDir(ctx context.Context, path string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	prefix := s3Key(path) + "/"
+
+	for object := range fs.listObjects(ctx, prefix) {
+		if object.Err != nil {
+			return errors.WithMessagef(object.Err, "listing folder '%s'", prefix)
+		}
+
+		if object.Key != prefix {
+			return errors.Errorf("removing folder '%s': the folder is not empty", prefix)
+		}
+	}
+
+	err := fs.client.RemoveObject(ctx, fs.bucket, prefix, minio.RemoveObjectOptions{})
+	return s3Error(err, "removing object '%s'", prefix)
+}
+
+// RmFile removes a file.
+// S3 does not report the removal of an object that does not exist as an error.
+func (fs *S3) RmFile(ctx context.Context, path string) error {
+	key := s3Key(path)
+
+	err := fs.client.RemoveObject(ctx, fs.bucket, key, minio.RemoveObjectOptions{})
+	return s3Error(err, "removing object '%s'", key)
+}
+
+// MvDir moves a directory with its contents. It reports sync.ErrNotFound when the directory holds
+// no objects, not even its marker.
+func (fs *S3) MvDir(ctx context.Context, fromPath string, toPath string) error {
+	fromPrefix := s3Key(fromPath) + "/"
+	toPrefix := s3Key(toPath) + "/"
+
+	found := false
+
+	for object := range fs.listObjects(ctx, fromPrefix) {
+		if object.Err != nil {
+			return errors.WithMessagef(object.Err, "listing folder '%s'", fromPrefix)
+		}
+
+		found = true
+
+		err := fs.move(ctx, object.Key, toPrefix+strings.TrimPrefix(object.Key, fromPrefix))
+		if err != nil {
+			return err
+		}
+	}
+
+	if !found {
+		return errors.WithMessagef(sync.ErrNotFound, "moving folder '%s'", fromPrefix)
+	}
+
+	return nil
+}
+
+// MvFile moves a file.
+func (fs *S3) MvFile(ctx context.Context, fromPath string, toPath string) error {
+	return fs.move(ctx, s3Key(fromPath), s3Key(toPath))
+}
+
+// Exists reports whether a file, or a folder, exists at path. A folder exists when any object
+// has a key under it, even without its marker.
+func (fs *S3) Exists(ctx context.Context, path string) (bool, error) {
+	key := s3Key(path)
+
+	_, err := fs.client.StatObject(ctx, fs.bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return true, nil
+	}
+	if !isS3NotFound(err) {
+		return false, errors.WithMessagef(err, "getting object '%s'", key)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for object := range fs.listObjects(ctx, key+"/") {
+		if object.Err != nil {
+			return false, errors.WithMessagef(object.Err, "listing folder '%s'", key+"/")
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// move copies the object fromKey to toKey, with its tags, and removes it.
+func (fs *S3) move(ctx context.Context, fromKey, toKey string) error {
+	t, err := fs.client.GetObjectTagging(ctx, fs.bucket, fromKey, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		return s3Error(err, "getting the tags of object '%s'", fromKey)
+	}
+
+	// unlike CopyObject, ComposeObject copies objects larger than 5 GiB.
+	_, err = fs.client.ComposeObject(
+		ctx,
+		minio.CopyDestOptions{Bucket: fs.bucket, Object: toKey, UserTags: t.ToMap(), ReplaceTags: true},
+		minio.CopySrcOptions{Bucket: fs.bucket, Object: fromKey},
+	)
+	if err != nil {
+		return s3Error(err, "copying object '%s' to '%s'", fromKey, toKey)
+	}
+
+	err = fs.client.RemoveObject(ctx, fs.bucket, fromKey, minio.RemoveObjectOptions{})
+	return s3Error(err, "removing object '%s'", fromKey)
+}
+
+// listObjects lists the objects which key starts with prefix, recursively. The listing is
+// stopped when ctx is done.
+func (fs *S3) listObjects(ctx context.Context, prefix string) <-chan minio.ObjectInfo {
+	return fs.client.ListObjects(ctx, fs.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+}
+
+// s3Error adds the message to err and reports the objects that do not exist as such (see
+// sync.ErrNotFound). It returns nil when err is nil.
+func s3Error(err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+
+	if isS3NotFound(err) {
+		err = fmt.Errorf("%w: %w", sync.ErrNotFound, err)
+	}
+
+	return errors.WithMessagef(err, format, args...)
+}
+
+// isS3NotFound reports whether err is the S3 error of an object that does not exist.
+func isS3NotFound(err error) bool {
+	var errResp minio.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Code == "NoSuchKey"
+}
+
+// s3Key returns the key of the object at path.
+func s3Key(path string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+path)), "/")
+}
@@ -0,0 +1,137 @@
+package filesystem
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+
+	"github.com/seborama/pcloud-sdk/tracker/db"
+)
+
+// sftpClient defines the SFTP client methods used to perform operations on a remote file system.
+type sftpClient interface {
+	Open(path string) (*sftp.File, error)
+	Create(path string) (*sftp.File, error)
+	MkdirAll(path string) error
+	Remove(path string) error
+	RemoveDirectory(path string) error
+	PosixRename(oldname, newname string) error
+	Lstat(path string) (os.FileInfo, error)
+}
+
+// SFTP is a file system abstraction for a remote file system reached over SFTP.
+type SFTP struct {
+	client sftpClient
+}
+
+// NewSFTP creates a new initialised SFTP structure. client is typically an *sftp.Client.
+func NewSFTP(client sftpClient) *SFTP {
+	return &SFTP{
+		client: client,
+	}
+}
+
+// StreamFileData reads the contents of the file pointed to by fsEntry and streams it to the
+// channel the method returns.
+func (fs *SFTP) StreamFileData(ctx context.Context, fsEntry db.FSEntry) (<-chan []byte, <-chan error) {
+	dataCh := make(chan []byte, 100)
+	errCh := make(chan error)
+
+	go func() {
+		defer close(errCh)
+		defer close(dataCh)
+
+		path := filepath.Join(fsEntry.Path, fsEntry.Name)
+
+		f, err := fs.client.Open(path)
+		if err != nil {
+			errCh <- errors.WithMessagef(err, "opening '%s'", path)
+			return
+		}
+		defer func() { _ = f.Close() }()
+
+		for {
+			data := make([]byte, 1_048_576)
+
+			n, err := io.ReadFull(f, data)
+			if n > 0 {
+				dataCh <- data[:n]
+			}
+			if err != nil {
+				if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+					errCh <- errors.WithMessagef(err, "reading '%s'", path)
+				}
+				return
+			}
+		}
+	}()
+
+	return dataCh, errCh
+}
+
+// MkDir creates a directory, with its parents as needed.
+func (fs *SFTP) MkDir(ctx context.Context, path string) error {
+	return errors.WithMessagef(fs.client.MkdirAll(path), "creating folder '%s'", path)
+}
+
+// MkFile creates a file with the contents streamed through dataCh.
+func (fs *SFTP) MkFile(ctx context.Context, path string, dataCh <-chan []byte) (err error) {
+	f, err := fs.client.Create(path)
+	if err != nil {
+		return errors.WithMessagef(err, "creating '%s'", path)
+	}
+	defer func() {
+		e := f.Close()
+		if e != nil && err == nil {
+			err = errors.WithMessagef(e, "closing '%s'", path)
+		}
+	}()
+
+	for data := range dataCh {
+		_, err = f.Write(data)
+		if err != nil {
+			return errors.WithMessagef(err, "writing '%s'", path)
+		}
+	}
+
+	return nil
+}
+
+// RmDir removes a directory.
+func (fs *SFTP) RmDir(ctx context.Context, path string) error {
+	return osError(fs.client.RemoveDirectory(path), "removing folder '%s'", path)
+}
+
+// RmFile removes a file.
+func (fs *SFTP) RmFile(ctx context.Context, path string) error {
+	return osError(fs.client.Remove(path), "removing '%s'", path)
+}
+
+// MvDir moves a directory.
+// The remote server must support the posix-rename@openssh.com extension, as OpenSSH does.
+func (fs *SFTP) MvDir(ctx context.Context, fromPath string, toPath string) error {
+	return osError(fs.client.PosixRename(fromPath, toPath), "moving folder '%s' to '%s'", fromPath, toPath)
+}
+
+// MvFile moves a file, replacing the file at toPath if any.
+// The remote server must support the posix-rename@openssh.com extension, as OpenSSH does.
+func (fs *SFTP) MvFile(ctx context.Context, fromPath string, toPath string) error {
+	return osError(fs.client.PosixRename(fromPath, toPath), "moving '%s' to '%s'", fromPath, toPath)
+}
+
+// Exists reports whether an entry exists at path. Symbolic links are not followed.
+func (fs *SFTP) Exists(ctx context.Context, path string) (bool, error) {
+	_, err := fs.client.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.WithMessagef(err, "getting the attributes of '%s'", path)
+	}
+
+	return true, nil
+}
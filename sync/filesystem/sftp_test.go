@@ -0,0 +1,101 @@
+package filesystem_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-sdk/internal/sshtest"
+	"github.com/seborama/pcloud-sdk/sync"
+	"github.com/seborama/pcloud-sdk/sync/filesystem"
+	"github.com/seborama/pcloud-sdk/tracker/db"
+)
+
+func TestSFTP(t *testing.T) {
+	ctx := context.Background()
+
+	root := t.TempDir()
+
+	client, err := sftp.NewClient(sshtest.NewClient(t))
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	fs := filesystem.NewSFTP(client)
+
+	err = fs.MkDir(ctx, filepath.Join(root, "docs", "sub"))
+	require.NoError(t, err)
+	require.DirExists(t, filepath.Join(root, "docs", "sub"))
+
+	data := make([]byte, 3*1_048_576+10)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	dataCh := make(chan []byte)
+	go func() {
+		defer close(dataCh)
+		for i := 0; i < len(data); i += 100_000 {
+			dataCh <- data[i:min(i+100_000, len(data))]
+		}
+	}()
+
+	err = fs.MkFile(ctx, filepath.Join(root, "docs", "a.bin"), dataCh)
+	require.NoError(t, err)
+
+	written, err := os.ReadFile(filepath.Join(root, "docs", "a.bin"))
+	require.NoError(t, err)
+	require.Equal(t, data, written)
+
+	err = fs.MvFile(ctx, filepath.Join(root, "docs", "a.bin"), filepath.Join(root, "docs", "sub", "b.bin"))
+	require.NoError(t, err)
+
+	err = fs.MvDir(ctx, filepath.Join(root, "docs"), filepath.Join(root, "archive"))
+	require.NoError(t, err)
+	require.NoDirExists(t, filepath.Join(root, "docs"))
+
+	dataCh2, errCh := fs.StreamFileData(ctx, db.FSEntry{Path: filepath.Join(root, "archive", "sub"), Name: "b.bin"})
+	streamed := []byte{}
+	for d := range dataCh2 {
+		streamed = append(streamed, d...)
+	}
+	require.NoError(t, <-errCh)
+	require.Equal(t, data, streamed)
+
+	err = fs.RmFile(ctx, filepath.Join(root, "archive", "sub", "b.bin"))
+	require.NoError(t, err)
+	require.NoFileExists(t, filepath.Join(root, "archive", "sub", "b.bin"))
+
+	err = fs.RmDir(ctx, filepath.Join(root, "archive"))
+	require.Error(t, err, "the folder is not empty")
+
+	err = fs.RmDir(ctx, filepath.Join(root, "archive", "sub"))
+	require.NoError(t, err)
+	err = fs.RmDir(ctx, filepath.Join(root, "archive"))
+	require.NoError(t, err)
+	require.NoDirExists(t, filepath.Join(root, "archive"))
+
+	_, errCh = fs.StreamFileData(ctx, db.FSEntry{Path: root, Name: "missing.bin"})
+	require.Error(t, <-errCh)
+
+	// the entries that do not exist are reported as such.
+	err = fs.RmFile(ctx, filepath.Join(root, "missing.bin"))
+	require.ErrorIs(t, err, sync.ErrNotFound)
+
+	err = fs.RmDir(ctx, filepath.Join(root, "missing"))
+	require.ErrorIs(t, err, sync.ErrNotFound)
+
+	err = fs.MvFile(ctx, filepath.Join(root, "missing.bin"), filepath.Join(root, "moved.bin"))
+	require.ErrorIs(t, err, sync.ErrNotFound)
+
+	exists, err := fs.Exists(ctx, filepath.Join(root, "missing.bin"))
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	exists, err = fs.Exists(ctx, root)
+	require.NoError(t, err)
+	require.True(t, exists)
+}
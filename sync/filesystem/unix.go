@@ -3,12 +3,15 @@ package filesystem
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 
 	"github.com/pkg/errors"
 
+	"github.com/seborama/pcloud-sdk/sync"
+	"github.com/seborama/pcloud-sdk/tracker/archos"
 	"github.com/seborama/pcloud-sdk/tracker/db"
 )
 
@@ -109,22 +112,83 @@ func (fs *Unix) MkFile(ctx context.Context, path string, dataCh <-chan []byte) (
 
 // RmDir removes a directory.
 func (fs *Unix) RmDir(ctx context.Context, path string) error {
-	return os.Remove(path)
+	return osError(os.Remove(path), "removing folder '%s'", path)
 }
 
 // RmFile removes a file.
 func (fs *Unix) RmFile(ctx context.Context, path string) error {
-	return os.Remove(path)
+	return osError(os.Remove(path), "removing '%s'", path)
 }
 
 // MvDir moves a directory.
 func (fs *Unix) MvDir(ctx context.Context, fromPath string, toPath string) error {
-	return os.Rename(fromPath, toPath)
+	return osError(os.Rename(fromPath, toPath), "moving folder '%s' to '%s'", fromPath, toPath)
 }
 
 // MvFile moves a file.
 func (fs *Unix) MvFile(ctx context.Context, fromPath string, toPath string) error {
-	return os.Rename(fromPath, toPath)
+	return osError(os.Rename(fromPath, toPath), "moving '%s' to '%s'", fromPath, toPath)
+}
+
+// Exists reports whether an entry exists at path. Symbolic links are not followed.
+func (fs *Unix) Exists(ctx context.Context, path string) (bool, error) {
+	_, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return true, nil
+}
+
+// MkSymlink creates a symbolic link to target.
+func (fs *Unix) MkSymlink(ctx context.Context, path, target string) error {
+	return errors.WithStack(os.Symlink(target, path))
+}
+
+// SetMetadata sets the permissions, owner and extended attributes of the entry at path to those
+// of fsEntry, when they are tracked (see db.FSEntry).
+// The owner is only changed where the process is permitted to. The permissions and extended
+// attributes of symbolic links are left untouched since they cannot be set on all platforms.
+func (fs *Unix) SetMetadata(ctx context.Context, path string, fsEntry db.FSEntry) error {
+	if fsEntry.Mode == 0 {
+		return nil
+	}
+
+	err := os.Lchown(path, int(fsEntry.UID), int(fsEntry.GID))
+	if err != nil && !errors.Is(err, os.ErrPermission) {
+		return errors.WithStack(err)
+	}
+
+	if fsEntry.Kind == db.EntryKindSymlink {
+		return nil
+	}
+
+	err = os.Chmod(path, fileMode(fsEntry.Mode))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(archos.SetXattrs(path, fsEntry.Xattrs))
+}
+
+// fileMode converts POSIX permission bits to an os.FileMode.
+func fileMode(mode uint32) os.FileMode {
+	fileMode := os.FileMode(mode) & os.ModePerm
+
+	if mode&0o4000 != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		fileMode |= os.ModeSticky
+	}
+
+	return fileMode
 }
 
 // GoFSOperations provides abstractions stubs for some of Go's OS operations.
@@ -146,3 +210,21 @@ func (fso *GoFSOperations) OpenFile(name string, flag int, perm os.FileMode) (*o
 	// nolint: gosec
 	return os.OpenFile(name, flag, perm)
 }
+
+// osError adds the message to err and reports the file system errors that have a typed outcome
+// in the sync as such (see sync.ErrNotFound and sync.ErrAlreadyExists), as pCloudError does.
+// It returns nil when err is nil.
+func osError(err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		err = fmt.Errorf("%w: %w", sync.ErrNotFound, err)
+	case errors.Is(err, os.ErrExist):
+		err = fmt.Errorf("%w: %w", sync.ErrAlreadyExists, err)
+	}
+
+	return errors.WithMessagef(err, format, args...)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -11,6 +12,7 @@ import (
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
+	"github.com/seborama/pcloud-sdk/sync"
 	"github.com/seborama/pcloud-sdk/sync/filesystem"
 	"github.com/seborama/pcloud-sdk/tracker/db"
 )
@@ -58,7 +60,7 @@ func TestUnix_StreamFileData_MkFile(t *testing.T) {
 	ctx := context.Background()
 
 	fsEntry := db.FSEntry{
-		FSName:         db.LocalFileSystem,
+		FSName:         "local",
 		DeviceID:       "dev-id-1",
 		EntryID:        123,
 		IsFolder:       false,
@@ -79,6 +81,34 @@ func TestUnix_StreamFileData_MkFile(t *testing.T) {
 	require.NoError(t, <-errCh)
 }
 
+func TestUnix_NotFound(t *testing.T) {
+	ctx := context.Background()
+
+	root := t.TempDir()
+
+	u := filesystem.NewUnix(&mockFSOperations{})
+
+	err := u.RmFile(ctx, filepath.Join(root, "missing.bin"))
+	require.ErrorIs(t, err, sync.ErrNotFound)
+
+	err = u.RmDir(ctx, filepath.Join(root, "missing"))
+	require.ErrorIs(t, err, sync.ErrNotFound)
+
+	err = u.MvFile(ctx, filepath.Join(root, "missing.bin"), filepath.Join(root, "moved.bin"))
+	require.ErrorIs(t, err, sync.ErrNotFound)
+
+	err = u.MvDir(ctx, filepath.Join(root, "missing"), filepath.Join(root, "moved"))
+	require.ErrorIs(t, err, sync.ErrNotFound)
+
+	exists, err := u.Exists(ctx, filepath.Join(root, "missing"))
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	exists, err = u.Exists(ctx, root)
+	require.NoError(t, err)
+	require.True(t, exists)
+}
+
 type mockFSOperations struct {
 	mock.Mock
 }
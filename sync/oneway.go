@@ -2,8 +2,11 @@ package sync
 
 import (
 	"context"
-	"fmt"
+	stderrors "errors"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -14,6 +17,20 @@ type tracker interface {
 	ListMutations(ctx context.Context) (db.FSMutations, error)
 }
 
+// mutationsStreamer is a tracker that is able to stream the mutations rather than list them all
+// at once (see tracker.Tracker.StreamMutations).
+type mutationsStreamer interface {
+	StreamMutations(ctx context.Context, after *db.MutationCursor, pageSize int) (<-chan db.FSMutation, <-chan error)
+}
+
+type syncStatusStorer interface {
+	GetSyncStatus(ctx context.Context, pairName db.PairName) (db.SyncStatus, error)
+	MarkSyncInProgress(ctx context.Context, pairName db.PairName) error
+	MarkSyncComplete(ctx context.Context, pairName db.PairName) error
+	MarkSyncAsChanged(ctx context.Context, pairName db.PairName) error
+	LockSync(ctx context.Context, pairName db.PairName) (func() error, error)
+}
+
 // FSReader represents the behaviour of a file system reader.
 type FSReader interface {
 	StreamFileData(ctx context.Context, fsEntry db.FSEntry) (<-chan []byte, <-chan error)
@@ -29,57 +46,351 @@ type FSWriter interface {
 	MvFile(ctx context.Context, fromPath, toPath string) error
 }
 
+// FSMetadataWriter represents the behaviour of a file system writer that can reproduce symbolic
+// links and the metadata of the entries, such as their permissions and owner.
+// It is optional: symbolic links cannot be synced to, and metadata is not synced to, writers that
+// do not implement it.
+type FSMetadataWriter interface {
+	MkSymlink(ctx context.Context, path, target string) error
+	SetMetadata(ctx context.Context, path string, fsEntry db.FSEntry) error
+}
+
+// FSTimesWriter represents the behaviour of a file system writer that can preserve the times
+// of modification and creation of the files it creates.
+// It is optional: the files created by writers that do not implement it have the current time.
+type FSTimesWriter interface {
+	MkFileWithTimes(ctx context.Context, path string, dataCh <-chan []byte, modified, created time.Time) error
+}
+
+// FSExistenceChecker represents the behaviour of a file system writer that can tell whether an
+// entry exists.
+// It is optional: a move which source is not found on a writer that does not implement it fails,
+// even if the entry has already been moved by a sync that failed part way.
+type FSExistenceChecker interface {
+	Exists(ctx context.Context, path string) (bool, error)
+}
+
+var (
+	// ErrNotFound is reported by an FSWriter when the entry it operates on, or a folder of its
+	// path, does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrAlreadyExists is reported by an FSWriter when an entry is in the way of the entry it
+	// creates or moves.
+	ErrAlreadyExists = errors.New("already exists")
+)
+
 // OneWay holds the from and to file systems and the mutation tracker needed to perform a
 // one-way sync.
 type OneWay struct {
-	from    FSReader
-	to      FSWriter
-	tracker tracker
+	from     FSReader
+	to       FSWriter
+	tracker  tracker
+	store    syncStatusStorer
+	pairName db.PairName
+
+	mutationsPageSize int
+}
+
+// Option is a Go functional parameter signature used to configure OneWay.
+type Option func(*OneWay)
+
+// WithSyncStatus records the status of the sync of the sync pair pairName in store: the sync is
+// "in progress" while it runs and "complete" once all the mutations have been applied, which
+// allows the tracker to rotate the versions of the source file system on its next refresh.
+// A sync that is already complete does nothing.
+func WithSyncStatus(store syncStatusStorer, pairName db.PairName) Option {
+	return func(s *OneWay) {
+		s.store = store
+		s.pairName = pairName
+	}
+}
+
+// WithMutationsPageSize sets the number of mutations read from the tracker at a time, when it is
+// able to stream them. Zero uses the default of the tracker.
+func WithMutationsPageSize(n int) Option {
+	return func(s *OneWay) {
+		s.mutationsPageSize = n
+	}
 }
 
 // NewOneWay creates a new initialised OneWay struct.
-func NewOneWay(from FSReader, to FSWriter, fsTracker tracker) *OneWay {
-	return &OneWay{
+func NewOneWay(from FSReader, to FSWriter, fsTracker tracker, opts ...Option) *OneWay {
+	s := &OneWay{
 		from:    from,
 		to:      to,
 		tracker: fsTracker,
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
 // Sync performs the synchronisation of changes in the source file system to the destination
 // file system.
+// Sync carries on when a mutation cannot be applied, and returns the errors of all the mutations
+// that failed once done. When the status of the sync is recorded (see WithSyncStatus), the sync
+// then remains "required". A single sync of the pair
+// runs at a time, among all the processes that share the store: Sync returns db.ErrLocked when
+// another is in progress.
 func (s *OneWay) Sync(ctx context.Context) error {
+	if s.store == nil {
+		failures, err := s.applyMutations(ctx)
+		if err != nil {
+			return err
+		}
+		return mutationsError(failures)
+	}
+
+	unlock, err := s.store.LockSync(ctx, s.pairName)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = unlock() }()
+
+	status, err := s.store.GetSyncStatus(ctx, s.pairName)
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case db.SyncStatusComplete:
+		return nil
+
+	case db.SyncStatusInProgress:
+		// no other sync of the pair is running: this one was interrupted.
+		err = s.store.MarkSyncAsChanged(ctx, s.pairName)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.store.MarkSyncInProgress(ctx, s.pairName)
+	if err != nil {
+		return err
+	}
+
+	failures, err := s.applyMutations(ctx)
+	if err == nil && len(failures) > 0 {
+		err = errors.WithMessagef(mutationsError(failures), "sync pair '%s'", s.pairName)
+	}
+
+	if err != nil {
+		err1 := s.store.MarkSyncAsChanged(ctx, s.pairName)
+		if err1 != nil {
+			return errors.WithMessagef(err, "failed to mark the sync as required: %v", err1)
+		}
+		return err
+	}
+
+	return s.store.MarkSyncComplete(ctx, s.pairName)
+}
+
+// applyMutations applies the mutations of the source file system to the destination file
+// system, as they are read from the tracker. It returns the errors of the mutations that failed.
+// The moves are applied first, so that the folders that entries are created in, or moved out of,
+// are in place. The folders that entries move into are created as needed. The deletions follow, then the creations and modifications. The mutations are
+// read twice: the moves and deletions are held in memory whereas the creations and modifications
+// are applied as they are read.
+func (s *OneWay) applyMutations(ctx context.Context) ([]error, error) {
 	// TODO: after the one-way sync has completed, delete extraneous entries that exist on the right
 	//       ie files and folder that were created externally on the "to" side, not by the sync.
-	mutations, err := s.tracker.ListMutations(ctx)
+	readMutations := s.streamMutations
+	if _, ok := s.tracker.(mutationsStreamer); !ok {
+		mutations, err := s.tracker.ListMutations(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		readMutations = func(_ context.Context, f func(db.FSMutation) error) error {
+			for _, m := range mutations {
+				err := f(m)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	moves := db.FSMutations{}
+	deletions := db.FSMutations{}
+
+	err := readMutations(ctx, func(m db.FSMutation) error {
+		switch m.Type {
+		case db.MutationTypeCreated, db.MutationTypeModified:
+			// applied once the moves and deletions have been.
+
+		case db.MutationTypeDeleted:
+			deletions = append(deletions, m)
+
+		case db.MutationTypeMoved, db.MutationTypeMovedAndModified:
+			moves = append(moves, m)
+
+		default:
+			return errors.Errorf("unknown mutation type '%s'", string(m.Type))
+		}
+
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, m := range mutations {
+	failures, relocations := s.applyMoves(ctx, moves)
+	failures = append(failures, s.applyDeletions(ctx, deletions, moves, relocations)...)
+
+	err = readMutations(ctx, func(m db.FSMutation) error {
+		var err error
+
 		switch m.Type {
 		case db.MutationTypeCreated:
 			err = s.create(ctx, m.Details)
 
-		case db.MutationTypeDeleted:
-			err = s.delete(ctx, m.Details)
-
 		case db.MutationTypeModified:
 			err = s.update(ctx, m.Details)
 
-		case db.MutationTypeMoved:
-			err = s.move(ctx, m.Details)
-
 		default:
-			return errors.Errorf("unknown mutation type '%s'", string(m.Type))
+			return nil
 		}
 
 		if err != nil {
-			fmt.Printf("error with mutation type '%s': %+v", string(m.Type), err)
+			failures = append(failures, mutationError(m, err))
+		}
+
+		return nil
+	})
+
+	return failures, err
+}
+
+// applyMoves applies the moves, those with the shallowest destination first, so that the folder
+// an entry moves into has been moved in place before the entry. A moved folder carries its
+// contents along: the source path of the moves that follow is relocated accordingly.
+// It returns the errors of the moves that failed and the relocations of the moved folders.
+func (s *OneWay) applyMoves(ctx context.Context, moves db.FSMutations) ([]error, pathRelocations) {
+	sort.SliceStable(moves, func(i, j int) bool {
+		return pathDepth(moves[i].Details) < pathDepth(moves[j].Details)
+	})
+
+	var failures []error
+	relocations := pathRelocations{}
+
+	for _, m := range moves {
+		var err error
+
+		if m.Type == db.MutationTypeMovedAndModified {
+			err = s.moveAndUpdate(ctx, m.Details, &relocations)
+		} else {
+			err = s.move(ctx, m.Details, &relocations)
+		}
+
+		if err != nil {
+			failures = append(failures, mutationError(m, err))
 		}
 	}
 
-	return nil
+	return failures, relocations
+}
+
+// applyDeletions applies the deletions once the moves have been applied: the entries deleted
+// from a moved folder are found where the folder now is. An entry that was replaced by a moved
+// entry is not deleted since the move has overwritten it.
+// The deepest entries are deleted first, so that a folder is empty by the time it is deleted:
+// the writers do not delete folders recursively, which would delete the entries that were to
+// be moved out of them, had their move failed.
+// It returns the errors of the deletions that failed.
+func (s *OneWay) applyDeletions(ctx context.Context, deletions, moves db.FSMutations, relocations pathRelocations) []error {
+	sort.SliceStable(deletions, func(i, j int) bool {
+		return pathDepth(deletions[i].Details) > pathDepth(deletions[j].Details)
+	})
+
+	movedTo := map[string]bool{}
+	for _, m := range moves {
+		if len(m.Details) == 2 {
+			fsEntry := m.Details[1].FSEntry
+			movedTo[filepath.Join(fsEntry.Path, fsEntry.Name)] = true
+		}
+	}
+
+	var failures []error
+
+	for _, m := range deletions {
+		err := s.delete(ctx, m.Details, relocations, movedTo)
+		if err != nil {
+			failures = append(failures, mutationError(m, err))
+		}
+	}
+
+	return failures
+}
+
+// mutationError adds the type of the mutation m to err.
+func mutationError(m db.FSMutation, err error) error {
+	return errors.WithMessagef(err, "mutation type '%s'", string(m.Type))
+}
+
+// mutationsError returns the errors of the mutations that failed as one, or nil if none did.
+func mutationsError(failures []error) error {
+	if len(failures) == 0 {
+		return nil
+	}
+
+	return errors.WithMessagef(stderrors.Join(failures...), "%d mutation(s) failed", len(failures))
+}
+
+// pathDepth returns the depth of the last entry of the mutation details.
+func pathDepth(entryMutations db.EntryMutations) int {
+	if len(entryMutations) == 0 {
+		return 0
+	}
+
+	fsEntry := entryMutations[len(entryMutations)-1].FSEntry
+
+	return strings.Count(filepath.Join(fsEntry.Path, fsEntry.Name), string(filepath.Separator))
+}
+
+// pathRelocations holds the folders moved on the destination file system, in the order they
+// were moved.
+type pathRelocations []pathRelocation
+
+type pathRelocation struct {
+	fromPath string
+	toPath   string
+}
+
+// relocate returns where the entry at path is after the folders have been moved.
+func (r pathRelocations) relocate(path string) string {
+	for _, relocation := range r {
+		if path == relocation.fromPath {
+			path = relocation.toPath
+		} else if strings.HasPrefix(path, relocation.fromPath+string(filepath.Separator)) {
+			path = relocation.toPath + strings.TrimPrefix(path, relocation.fromPath)
+		}
+	}
+
+	return path
+}
+
+// streamMutations calls f with each of the mutations streamed by the tracker (see
+// mutationsStreamer), until f returns an error.
+func (s *OneWay) streamMutations(ctx context.Context, f func(db.FSMutation) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	mutationsCh, errCh := s.tracker.(mutationsStreamer).StreamMutations(ctx, nil, s.mutationsPageSize)
+
+	for m := range mutationsCh {
+		err := f(m)
+		if err != nil {
+			return err
+		}
+	}
+
+	return <-errCh
 }
 
 func (s *OneWay) create(ctx context.Context, entryMutations db.EntryMutations) error {
@@ -89,11 +400,45 @@ func (s *OneWay) create(ctx context.Context, entryMutations db.EntryMutations) e
 
 	fsEntry := entryMutations[0].FSEntry
 
-	if fsEntry.IsFolder {
-		return s.createFolder(ctx, fsEntry)
+	var err error
+
+	switch {
+	case fsEntry.Kind == db.EntryKindOther:
+		// the data of special files cannot be synced.
+		return nil
+	case fsEntry.Kind == db.EntryKindSymlink:
+		err = s.createSymlink(ctx, fsEntry)
+	case fsEntry.IsFolder:
+		err = s.createFolder(ctx, fsEntry)
+	default:
+		err = s.createFile(ctx, fsEntry)
 	}
 
-	return s.createFile(ctx, fsEntry)
+	if err != nil {
+		return err
+	}
+
+	return s.setMetadata(ctx, fsEntry)
+}
+
+func (s *OneWay) createSymlink(ctx context.Context, fsEntry db.FSEntry) error {
+	mw, ok := s.to.(FSMetadataWriter)
+	if !ok {
+		return errors.Errorf("the destination file system does not support symbolic links: path='%s' name='%s'", fsEntry.Path, fsEntry.Name)
+	}
+
+	return mw.MkSymlink(ctx, filepath.Join(fsEntry.Path, fsEntry.Name), fsEntry.LinkTarget)
+}
+
+// setMetadata reproduces the metadata of fsEntry on the destination file system, if it
+// supports it.
+func (s *OneWay) setMetadata(ctx context.Context, fsEntry db.FSEntry) error {
+	mw, ok := s.to.(FSMetadataWriter)
+	if !ok {
+		return nil
+	}
+
+	return mw.SetMetadata(ctx, filepath.Join(fsEntry.Path, fsEntry.Name), fsEntry)
 }
 
 func (s *OneWay) createFolder(ctx context.Context, fsEntry db.FSEntry) error {
@@ -119,7 +464,12 @@ func (s *OneWay) createFile(ctx context.Context, fsEntry db.FSEntry) error {
 		}
 	}()
 
-	err1 := s.to.MkFile(ctx, filepath.Join(fsEntry.Path, fsEntry.Name), dataCh)
+	var err1 error
+	if tw, ok := s.to.(FSTimesWriter); ok {
+		err1 = tw.MkFileWithTimes(ctx, filepath.Join(fsEntry.Path, fsEntry.Name), dataCh, fsEntry.Modified, fsEntry.Created)
+	} else {
+		err1 = s.to.MkFile(ctx, filepath.Join(fsEntry.Path, fsEntry.Name), dataCh)
+	}
 	if err1 != nil && err == nil {
 		return err1
 	}
@@ -127,27 +477,45 @@ func (s *OneWay) createFile(ctx context.Context, fsEntry db.FSEntry) error {
 	return err
 }
 
-func (s *OneWay) delete(ctx context.Context, entryMutations db.EntryMutations) error {
-	// TODO: some ordering of deletions should take place so that files in a folder are deleted before the folder is deleted
+func (s *OneWay) delete(ctx context.Context, entryMutations db.EntryMutations, relocations pathRelocations, movedTo map[string]bool) error {
 	if len(entryMutations) != 1 {
 		return errors.Errorf("expected 1 entry in mutation details but got '%d'", len(entryMutations))
 	}
 
 	fsEntry := entryMutations[0].FSEntry
 
-	if fsEntry.IsFolder {
-		return s.deleteFolder(ctx, fsEntry)
+	path := relocations.relocate(filepath.Join(fsEntry.Path, fsEntry.Name))
+	if movedTo[path] {
+		return nil
 	}
 
-	return s.deleteFile(ctx, fsEntry)
+	var err error
+
+	switch {
+	case fsEntry.Kind == db.EntryKindOther:
+		// special files are not synced.
+		return nil
+	case fsEntry.IsFolder:
+		err = s.deleteFolder(ctx, path)
+	default:
+		// symbolic links are removed like files.
+		err = s.deleteFile(ctx, path)
+	}
+
+	if errors.Is(err, ErrNotFound) {
+		// the entry was already removed, such as by a sync that failed part way.
+		return nil
+	}
+
+	return err
 }
 
-func (s *OneWay) deleteFolder(ctx context.Context, fsEntry db.FSEntry) error {
-	return s.to.RmDir(ctx, filepath.Join(fsEntry.Path, fsEntry.Name))
+func (s *OneWay) deleteFolder(ctx context.Context, path string) error {
+	return s.to.RmDir(ctx, path)
 }
 
-func (s *OneWay) deleteFile(ctx context.Context, fsEntry db.FSEntry) (err error) {
-	return s.to.RmFile(ctx, filepath.Join(fsEntry.Path, fsEntry.Name))
+func (s *OneWay) deleteFile(ctx context.Context, path string) (err error) {
+	return s.to.RmFile(ctx, path)
 }
 
 func (s *OneWay) update(ctx context.Context, entryMutations db.EntryMutations) error {
@@ -155,17 +523,65 @@ func (s *OneWay) update(ctx context.Context, entryMutations db.EntryMutations) e
 		return errors.Errorf("expected 2 entries in mutation details but got '%d'", len(entryMutations))
 	}
 
-	fsEntry := entryMutations[0].FSEntry
+	fsEntry, toFSEntry := newVersion(entryMutations)
+
+	var err error
+
+	switch {
+	case fsEntry.Kind == db.EntryKindOther:
+		// the data of special files cannot be synced.
+		return nil
 
-	if fsEntry.IsFolder {
-		return errors.Errorf("received update for a folder: folderID='%d' path='%s' name='%s'", fsEntry.EntryID, fsEntry.Path, fsEntry.Name)
+	case fsEntry.Kind == db.EntryKindSymlink:
+		if toFSEntry.Kind == fsEntry.Kind && toFSEntry.LinkTarget == fsEntry.LinkTarget {
+			break
+		}
+		// a symbolic link cannot be updated in place.
+		err = s.deleteFile(ctx, filepath.Join(toFSEntry.Path, toFSEntry.Name))
+		if err != nil {
+			return err
+		}
+		err = s.createSymlink(ctx, fsEntry)
+
+	case fsEntry.IsFolder:
+		// only the metadata of a folder can be modified.
+
+	case sameContents(fsEntry, toFSEntry):
+		// only the metadata of the file was modified.
+
+	default:
+		// TODO: refactor and optimise for block-level (differential) copying
+		err = s.createFile(ctx, fsEntry)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return s.setMetadata(ctx, fsEntry)
+}
+
+// newVersion returns the entry of the mutation details to replicate, which is the first one of
+// VersionNew, and the other entry, which holds the state being replaced.
+// The details of a modification within a file system are [Previous, New] whereas those of a
+// difference between file systems are [New of the source, New of the destination].
+func newVersion(entryMutations db.EntryMutations) (db.FSEntry, db.FSEntry) {
+	if entryMutations[0].Version != db.VersionNew && entryMutations[1].Version == db.VersionNew {
+		return entryMutations[1].FSEntry, entryMutations[0].FSEntry
 	}
 
-	// TODO: refactor and optimise for block-level (differential) copying
-	return s.createFile(ctx, fsEntry)
+	return entryMutations[0].FSEntry, entryMutations[1].FSEntry
 }
 
-func (s *OneWay) move(ctx context.Context, entryMutations db.EntryMutations) error {
+// sameContents reports whether the two file entries are known to have the same contents.
+func sameContents(fsEntry1, fsEntry2 db.FSEntry) bool {
+	return fsEntry1.Kind == fsEntry2.Kind &&
+		fsEntry1.ContentHash != "" &&
+		fsEntry1.ContentHashAlgorithm == fsEntry2.ContentHashAlgorithm &&
+		fsEntry1.ContentHash == fsEntry2.ContentHash
+}
+
+func (s *OneWay) move(ctx context.Context, entryMutations db.EntryMutations, relocations *pathRelocations) error {
 	if len(entryMutations) != 2 {
 		return errors.Errorf("expected 2 entries in mutation details but got '%d'", len(entryMutations))
 	}
@@ -173,17 +589,86 @@ func (s *OneWay) move(ctx context.Context, entryMutations db.EntryMutations) err
 	fromFSEntry := entryMutations[0].FSEntry
 	toFSEntry := entryMutations[1].FSEntry
 
+	if fromFSEntry.Kind == db.EntryKindOther {
+		// special files are not synced.
+		return nil
+	}
+
+	fromPath := relocations.relocate(filepath.Join(fromFSEntry.Path, fromFSEntry.Name))
+	toPath := filepath.Join(toFSEntry.Path, toFSEntry.Name)
+
+	// the folder the entry moves into may have been created since the last sync: the creations
+	// are applied after the moves and the writers do not create the folders of the destination.
+	if parent := filepath.Dir(toPath); parent != filepath.Dir(fromPath) && parent != filepath.Dir(parent) {
+		err := s.to.MkDir(ctx, parent)
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+
+	if fromFSEntry.IsFolder {
+		err = s.moveFolder(ctx, fromPath, toPath)
+	} else {
+		err = s.moveFile(ctx, fromPath, toPath)
+	}
+
+	if errors.Is(err, ErrNotFound) {
+		// the entry may have been moved already, by a sync that failed part way.
+		moved, err1 := s.exists(ctx, toPath)
+		if err1 != nil {
+			return errors.WithMessagef(err, "checking whether it was moved already: %v", err1)
+		}
+		if moved {
+			err = nil
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
 	if fromFSEntry.IsFolder {
-		return s.moveFolder(ctx, fromFSEntry, toFSEntry)
+		*relocations = append(*relocations, pathRelocation{fromPath: fromPath, toPath: toPath})
 	}
 
-	return s.moveFile(ctx, fromFSEntry, toFSEntry)
+	return nil
+}
+
+// exists reports whether the entry at path exists on the destination file system. It reports
+// false when the destination cannot tell (see FSExistenceChecker).
+func (s *OneWay) exists(ctx context.Context, path string) (bool, error) {
+	ec, ok := s.to.(FSExistenceChecker)
+	if !ok {
+		return false, nil
+	}
+
+	return ec.Exists(ctx, path)
+}
+
+// moveAndUpdate moves the entry then updates it in its new location.
+func (s *OneWay) moveAndUpdate(ctx context.Context, entryMutations db.EntryMutations, relocations *pathRelocations) error {
+	err := s.move(ctx, entryMutations, relocations)
+	if err != nil {
+		return err
+	}
+
+	fromFSEntry := entryMutations[0].FSEntry
+	toFSEntry := entryMutations[1].FSEntry
+
+	// once moved, the former state of the entry is found where the entry now is.
+	movedFSEntry := fromFSEntry
+	movedFSEntry.Path = toFSEntry.Path
+	movedFSEntry.Name = toFSEntry.Name
+
+	return s.update(ctx, db.EntryMutations{{Version: entryMutations[0].Version, FSEntry: movedFSEntry}, entryMutations[1]})
 }
 
-func (s *OneWay) moveFolder(ctx context.Context, fromFSEntry, toFSEntry db.FSEntry) error {
-	return s.to.MvDir(ctx, filepath.Join(fromFSEntry.Path, fromFSEntry.Name), filepath.Join(toFSEntry.Path, toFSEntry.Name))
+func (s *OneWay) moveFolder(ctx context.Context, fromPath, toPath string) error {
+	return s.to.MvDir(ctx, fromPath, toPath)
 }
 
-func (s *OneWay) moveFile(ctx context.Context, fromFSEntry, toFSEntry db.FSEntry) (err error) {
-	return s.to.MvFile(ctx, filepath.Join(fromFSEntry.Path, fromFSEntry.Name), filepath.Join(toFSEntry.Path, toFSEntry.Name))
+func (s *OneWay) moveFile(ctx context.Context, fromPath, toPath string) (err error) {
+	return s.to.MvFile(ctx, fromPath, toPath)
 }
@@ -2,6 +2,8 @@ package sync_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -248,6 +250,96 @@ func TestOneWay_Sync_Deleted(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestOneWay_Sync_Deleted_NotFound(t *testing.T) {
+	ctx := context.Background()
+
+	expectedFSMutations := db.FSMutations{
+		{
+			Type: db.MutationTypeDeleted,
+			Details: db.EntryMutations{
+				{Version: db.VersionNew, FSEntry: db.FSEntry{FSName: "left", DeviceID: "dev-id", EntryID: 1002, IsFolder: true, Path: "/", Name: "Folder2", ParentFolderID: 1000, Kind: db.EntryKindFolder}},
+			},
+		},
+		{
+			Type: db.MutationTypeDeleted,
+			Details: db.EntryMutations{
+				{Version: db.VersionNew, FSEntry: db.FSEntry{FSName: "left", DeviceID: "dev-id", EntryID: 100201, Path: "/Folder2", Name: "File2-1", ParentFolderID: 1002, Size: 100, Hash: "file2-1-hash", Kind: db.EntryKindFile}},
+			},
+		},
+	}
+
+	pCloudFS := MockPCloudFileSystem{}
+	defer func() { _ = pCloudFS.AssertExpectations(t) }()
+
+	// the file was removed along with its folder.
+	localClient := MockLocalFileSystem{}
+	defer func() { _ = localClient.AssertExpectations(t) }()
+	localClient.
+		On("RmDir", ctx, "/Folder2").
+		Return(nil).
+		Once().
+		On("RmFile", ctx, "/Folder2/File2-1").
+		Return(fmt.Errorf("%w: file not found", sync.ErrNotFound)).
+		Once()
+
+	fsTracker := MockFSTracker{}
+	defer func() { _ = fsTracker.AssertExpectations(t) }()
+	fsTracker.
+		On("ListMutations", ctx).
+		Return(expectedFSMutations, nil).
+		Once()
+
+	s := sync.NewOneWay(&pCloudFS, &localClient, &fsTracker)
+	err := s.Sync(ctx)
+	require.NoError(t, err)
+}
+
+func TestOneWay_Sync_Created_WithTimes(t *testing.T) {
+	ctx := context.Background()
+
+	modified := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	fsEntry := db.FSEntry{FSName: "left", DeviceID: "dev-id", EntryID: 100201, Path: "/Folder2", Name: "File2-1", ParentFolderID: 1002, Created: created, Modified: modified, Size: 5, Hash: "file2-1-hash", Kind: db.EntryKindFile}
+
+	expectedFSMutations := db.FSMutations{
+		{
+			Type:    db.MutationTypeCreated,
+			Details: db.EntryMutations{{Version: db.VersionNew, FSEntry: fsEntry}},
+		},
+	}
+
+	dataCh := make(chan []byte)
+	close(dataCh)
+	errCh := make(chan error)
+	close(errCh)
+
+	pCloudFS := MockPCloudFileSystem{}
+	defer func() { _ = pCloudFS.AssertExpectations(t) }()
+	pCloudFS.
+		On("StreamFileData", mock.Anything, fsEntry).
+		Return((<-chan []byte)(dataCh), (<-chan error)(errCh)).
+		Once()
+
+	localClient := MockTimesLocalFileSystem{}
+	defer func() { _ = localClient.AssertExpectations(t) }()
+	localClient.
+		On("MkFileWithTimes", mock.Anything, "/Folder2/File2-1", mock.Anything, modified, created).
+		Return(nil).
+		Once()
+
+	fsTracker := MockFSTracker{}
+	defer func() { _ = fsTracker.AssertExpectations(t) }()
+	fsTracker.
+		On("ListMutations", ctx).
+		Return(expectedFSMutations, nil).
+		Once()
+
+	s := sync.NewOneWay(&pCloudFS, &localClient, &fsTracker)
+	err := s.Sync(ctx)
+	require.NoError(t, err)
+}
+
 func TestOneWay_Sync_Modified(t *testing.T) {
 	ctx := context.Background()
 
@@ -477,12 +569,16 @@ func TestOneWay_Sync_Moved(t *testing.T) {
 	localClient := MockLocalFileSystem{}
 	defer func() { _ = localClient.AssertExpectations(t) }()
 	localClient.
-		On("MvFile", ctx, "/Folder2/File2-1", "/Moved/MovedFolder2/MovedFile2-1").
+		// Folder2 is moved first: the file is renamed where Folder2 has moved it.
+		On("MvFile", ctx, "/Moved/MovedFolder2/File2-1", "/Moved/MovedFolder2/MovedFile2-1").
 		Return(nil).
 		Once().
 		On("MvDir", ctx, "/Folder1", "/MovedFolder1").
 		Return(nil).
 		Once().
+		On("MkDir", ctx, "/Moved").
+		Return(nil).
+		Once().
 		On("MvDir", ctx, "/Folder2", "/Moved/MovedFolder2").
 		Return(nil).
 		Once()
@@ -499,58 +595,1016 @@ func TestOneWay_Sync_Moved(t *testing.T) {
 	require.NoError(t, err)
 }
 
-func TestOneWay_Sync_MutatedAndMoved(t *testing.T) {
-	t.Skip("NOT YET WRITTEN")
-}
+func TestOneWay_Sync_Moved_Nested(t *testing.T) {
+	ctx := context.Background()
 
-type MockPCloudFileSystem struct {
-	mock.Mock
-}
+	moved := func(isFolder bool, fromPath, fromName, toPath, toName string) db.FSMutation {
+		return db.FSMutation{
+			Type: db.MutationTypeMoved,
+			Details: db.EntryMutations{
+				{Version: db.VersionPrevious, FSEntry: db.FSEntry{FSName: "left", IsFolder: isFolder, Path: fromPath, Name: fromName}},
+				{Version: db.VersionNew, FSEntry: db.FSEntry{FSName: "left", IsFolder: isFolder, Path: toPath, Name: toName}},
+			},
+		}
+	}
 
-func (m *MockPCloudFileSystem) StreamFileData(ctx context.Context, fsEntry db.FSEntry) (<-chan []byte, <-chan error) {
-	args := m.Called(ctx, fsEntry)
-	return args.Get(0).(<-chan []byte), args.Get(1).(<-chan error)
-}
+	expectedFSMutations := db.FSMutations{
+		// a file renamed in a folder that is moved.
+		moved(false, "/X", "File", "/Z/Y/X", "RenamedFile"),
+		// a folder moved into a folder that is moved.
+		moved(true, "/", "X", "/Z/Y", "X"),
+		moved(true, "/", "Y", "/Z", "Y"),
+	}
 
-type MockLocalFileSystem struct {
-	mock.Mock
-}
+	localClient := MockLocalFileSystem{}
+	defer func() { _ = localClient.AssertExpectations(t) }()
 
-func (m *MockLocalFileSystem) MkDir(ctx context.Context, path string) error {
-	args := m.Called(ctx, path)
-	return args.Error(0)
-}
+	// Y moves first so that X can move into it, then the file is renamed where X has moved it.
+	calls := []string{}
+	localClient.
+		On("MkDir", ctx, "/Z").
+		Return(nil).
+		Once().
+		On("MkDir", ctx, "/Z/Y").
+		Return(nil).
+		Once().
+		On("MvDir", ctx, "/Y", "/Z/Y").
+		Run(func(mock.Arguments) { calls = append(calls, "Y") }).
+		Return(nil).
+		Once().
+		On("MvDir", ctx, "/X", "/Z/Y/X").
+		Run(func(mock.Arguments) { calls = append(calls, "X") }).
+		Return(nil).
+		Once().
+		On("MvFile", ctx, "/Z/Y/X/File", "/Z/Y/X/RenamedFile").
+		Run(func(mock.Arguments) { calls = append(calls, "File") }).
+		Return(nil).
+		Once()
 
-func (m *MockLocalFileSystem) MkFile(ctx context.Context, path string, dataCh <-chan []byte) error {
-	args := m.Called(ctx, path, dataCh)
-	return args.Error(0)
-}
+	fsTracker := MockFSTracker{}
+	defer func() { _ = fsTracker.AssertExpectations(t) }()
+	fsTracker.
+		On("ListMutations", ctx).
+		Return(expectedFSMutations, nil).
+		Once()
 
-func (m *MockLocalFileSystem) RmDir(ctx context.Context, path string) error {
-	args := m.Called(ctx, path)
-	return args.Error(0)
+	s := sync.NewOneWay(&MockPCloudFileSystem{}, &localClient, &fsTracker)
+	err := s.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Y", "X", "File"}, calls)
 }
 
-func (m *MockLocalFileSystem) RmFile(ctx context.Context, path string) error {
-	args := m.Called(ctx, path)
-	return args.Error(0)
-}
+func TestOneWay_Sync_Moved_Ordering(t *testing.T) {
+	ctx := context.Background()
 
-func (m *MockLocalFileSystem) MvDir(ctx context.Context, fromPath, toPath string) error {
-	args := m.Called(ctx, fromPath, toPath)
-	return args.Error(0)
-}
+	newFile := db.FSEntry{FSName: "left", EntryID: 1003, Path: "/B", Name: "new.txt", Hash: "new-hash", Kind: db.EntryKindFile}
 
-func (m *MockLocalFileSystem) MvFile(ctx context.Context, fromPath, toPath string) error {
-	args := m.Called(ctx, fromPath, toPath)
-	return args.Error(0)
+	// the mutations are listed in the order of the tracker: creations, deletions then moves.
+	expectedFSMutations := db.FSMutations{
+		// a file added to a renamed folder.
+		{
+			Type:    db.MutationTypeCreated,
+			Details: db.EntryMutations{{Version: db.VersionNew, FSEntry: newFile}},
+		},
+		// a file deleted from the renamed folder.
+		{
+			Type: db.MutationTypeDeleted,
+			Details: db.EntryMutations{
+				{Version: db.VersionPrevious, FSEntry: db.FSEntry{FSName: "left", EntryID: 1004, Path: "/A", Name: "old.txt", Kind: db.EntryKindFile}},
+			},
+		},
+		// a file replaced by a moved file.
+		{
+			Type: db.MutationTypeDeleted,
+			Details: db.EntryMutations{
+				{Version: db.VersionPrevious, FSEntry: db.FSEntry{FSName: "left", EntryID: 1005, Path: "/C", Name: "x", Kind: db.EntryKindFile}},
+			},
+		},
+		{
+			Type: db.MutationTypeMoved,
+			Details: db.EntryMutations{
+				{Version: db.VersionPrevious, FSEntry: db.FSEntry{FSName: "left", EntryID: 1006, Path: "/D", Name: "x", Kind: db.EntryKindFile}},
+				{Version: db.VersionNew, FSEntry: db.FSEntry{FSName: "left", EntryID: 1006, Path: "/C", Name: "x", Kind: db.EntryKindFile}},
+			},
+		},
+		{
+			Type: db.MutationTypeMoved,
+			Details: db.EntryMutations{
+				{Version: db.VersionPrevious, FSEntry: db.FSEntry{FSName: "left", EntryID: 1001, IsFolder: true, Path: "/", Name: "A", Kind: db.EntryKindFolder}},
+				{Version: db.VersionNew, FSEntry: db.FSEntry{FSName: "left", EntryID: 1001, IsFolder: true, Path: "/", Name: "B", Kind: db.EntryKindFolder}},
+			},
+		},
+	}
+
+	calls := []string{}
+
+	dataCh := make(chan []byte)
+	close(dataCh)
+	errCh := make(chan error)
+	close(errCh)
+
+	pCloudFS := MockPCloudFileSystem{}
+	defer func() { _ = pCloudFS.AssertExpectations(t) }()
+	pCloudFS.
+		On("StreamFileData", mock.Anything, newFile).
+		Return((<-chan []byte)(dataCh), (<-chan error)(errCh)).
+		Once()
+
+	// the folder is renamed before the file is created in it and the deleted file is found
+	// where the folder has moved. The replaced file is overwritten by the move.
+	localClient := MockLocalFileSystem{}
+	defer func() { _ = localClient.AssertExpectations(t) }()
+	localClient.
+		On("MvDir", ctx, "/A", "/B").
+		Run(func(mock.Arguments) { calls = append(calls, "MvDir /B") }).
+		Return(nil).
+		Once().
+		On("MkDir", ctx, "/C").
+		Return(nil).
+		Once().
+		On("MvFile", ctx, "/D/x", "/C/x").
+		Run(func(mock.Arguments) { calls = append(calls, "MvFile /C/x") }).
+		Return(nil).
+		Once().
+		On("RmFile", ctx, "/B/old.txt").
+		Run(func(mock.Arguments) { calls = append(calls, "RmFile /B/old.txt") }).
+		Return(nil).
+		Once().
+		On("MkFile", mock.Anything, "/B/new.txt", mock.Anything).
+		Run(func(mock.Arguments) { calls = append(calls, "MkFile /B/new.txt") }).
+		Return(nil).
+		Once()
+
+	fsTracker := MockFSTracker{}
+	defer func() { _ = fsTracker.AssertExpectations(t) }()
+	fsTracker.
+		On("ListMutations", ctx).
+		Return(expectedFSMutations, nil).
+		Once()
+
+	s := sync.NewOneWay(&pCloudFS, &localClient, &fsTracker)
+	err := s.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"MvDir /B", "MvFile /C/x", "RmFile /B/old.txt", "MkFile /B/new.txt"}, calls)
 }
 
-type MockFSTracker struct {
-	mock.Mock
+func TestOneWay_Sync_Moved_OutOfDeletedFolder(t *testing.T) {
+	ctx := context.Background()
+
+	expectedFSMutations := db.FSMutations{
+		// the folder is listed before the file it held.
+		{
+			Type: db.MutationTypeDeleted,
+			Details: db.EntryMutations{
+				{Version: db.VersionPrevious, FSEntry: db.FSEntry{FSName: "left", EntryID: 1001, IsFolder: true, Path: "/", Name: "A", Kind: db.EntryKindFolder}},
+			},
+		},
+		{
+			Type: db.MutationTypeDeleted,
+			Details: db.EntryMutations{
+				{Version: db.VersionPrevious, FSEntry: db.FSEntry{FSName: "left", EntryID: 1002, Path: "/A", Name: "y", Kind: db.EntryKindFile}},
+			},
+		},
+		{
+			Type: db.MutationTypeMoved,
+			Details: db.EntryMutations{
+				{Version: db.VersionPrevious, FSEntry: db.FSEntry{FSName: "left", EntryID: 1003, Path: "/A", Name: "x", Kind: db.EntryKindFile}},
+				{Version: db.VersionNew, FSEntry: db.FSEntry{FSName: "left", EntryID: 1003, Path: "/B", Name: "x", Kind: db.EntryKindFile}},
+			},
+		},
+	}
+
+	// the file is moved out of the folder before the folder is deleted, once empty.
+	calls := []string{}
+	localClient := MockLocalFileSystem{}
+	defer func() { _ = localClient.AssertExpectations(t) }()
+	localClient.
+		On("MkDir", ctx, "/B").
+		Return(nil).
+		Once().
+		On("MvFile", ctx, "/A/x", "/B/x").
+		Run(func(mock.Arguments) { calls = append(calls, "MvFile /B/x") }).
+		Return(nil).
+		Once().
+		On("RmFile", ctx, "/A/y").
+		Run(func(mock.Arguments) { calls = append(calls, "RmFile /A/y") }).
+		Return(nil).
+		Once().
+		On("RmDir", ctx, "/A").
+		Run(func(mock.Arguments) { calls = append(calls, "RmDir /A") }).
+		Return(nil).
+		Once()
+
+	fsTracker := MockFSTracker{}
+	defer func() { _ = fsTracker.AssertExpectations(t) }()
+	fsTracker.
+		On("ListMutations", ctx).
+		Return(expectedFSMutations, nil).
+		Once()
+
+	s := sync.NewOneWay(&MockPCloudFileSystem{}, &localClient, &fsTracker)
+	err := s.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"MvFile /B/x", "RmFile /A/y", "RmDir /A"}, calls)
 }
 
-func (m *MockFSTracker) ListMutations(ctx context.Context) (db.FSMutations, error) {
-	args := m.Called(ctx)
-	return args.Get(0).(db.FSMutations), args.Error(1)
+func TestOneWay_Sync_Moved_IntoCreatedFolder(t *testing.T) {
+	ctx := context.Background()
+
+	newFolder := db.FSEntry{FSName: "left", EntryID: 1001, IsFolder: true, Path: "/", Name: "N", Kind: db.EntryKindFolder}
+
+	expectedFSMutations := db.FSMutations{
+		{
+			Type:    db.MutationTypeCreated,
+			Details: db.EntryMutations{{Version: db.VersionNew, FSEntry: newFolder}},
+		},
+		{
+			Type: db.MutationTypeMoved,
+			Details: db.EntryMutations{
+				{Version: db.VersionPrevious, FSEntry: db.FSEntry{FSName: "left", EntryID: 1002, Path: "/", Name: "x", Kind: db.EntryKindFile}},
+				{Version: db.VersionNew, FSEntry: db.FSEntry{FSName: "left", EntryID: 1002, Path: "/N", Name: "x", Kind: db.EntryKindFile}},
+			},
+		},
+	}
+
+	// the folder is created before the file moves into it, then again, as a no-op, when its
+	// creation is applied.
+	calls := []string{}
+	localClient := MockLocalFileSystem{}
+	defer func() { _ = localClient.AssertExpectations(t) }()
+	localClient.
+		On("MkDir", ctx, "/N").
+		Run(func(mock.Arguments) { calls = append(calls, "MkDir /N") }).
+		Return(nil).
+		Twice().
+		On("MvFile", ctx, "/x", "/N/x").
+		Run(func(mock.Arguments) { calls = append(calls, "MvFile /N/x") }).
+		Return(nil).
+		Once()
+
+	fsTracker := MockFSTracker{}
+	defer func() { _ = fsTracker.AssertExpectations(t) }()
+	fsTracker.
+		On("ListMutations", ctx).
+		Return(expectedFSMutations, nil).
+		Once()
+
+	s := sync.NewOneWay(&MockPCloudFileSystem{}, &localClient, &fsTracker)
+	err := s.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"MkDir /N", "MvFile /N/x", "MkDir /N"}, calls)
+}
+
+func TestOneWay_Sync_Metadata(t *testing.T) {
+	ctx := context.Background()
+
+	expectedFSMutations := db.FSMutations{
+		{
+			Type: db.MutationTypeCreated,
+			Details: db.EntryMutations{
+				{
+					Version: db.VersionNew,
+					FSEntry: db.FSEntry{
+						FSName:         "left",
+						DeviceID:       "dev-id",
+						EntryID:        1001,
+						Path:           "/",
+						Name:           "Link1",
+						ParentFolderID: 1000,
+						Kind:           db.EntryKindSymlink,
+						LinkTarget:     "Folder2/File2-1",
+						Mode:           0o777,
+					},
+				},
+			},
+		},
+		{
+			Type: db.MutationTypeCreated,
+			Details: db.EntryMutations{
+				{
+					Version: db.VersionNew,
+					FSEntry: db.FSEntry{
+						FSName:         "left",
+						DeviceID:       "dev-id",
+						EntryID:        1002,
+						Path:           "/",
+						Name:           "Fifo1",
+						ParentFolderID: 1000,
+						Kind:           db.EntryKindOther,
+						Mode:           0o600,
+					},
+				},
+			},
+		},
+		{
+			Type: db.MutationTypeModified,
+			Details: db.EntryMutations{
+				{
+					Version: db.VersionNew,
+					FSEntry: db.FSEntry{
+						FSName:               "left",
+						DeviceID:             "dev-id",
+						EntryID:              100201,
+						Path:                 "/Folder2",
+						Name:                 "File2-1",
+						ParentFolderID:       1002,
+						Size:                 100,
+						Hash:                 "file2-1-hash",
+						ContentHashAlgorithm: db.HashAlgorithmSHA1,
+						ContentHash:          "file2-1-content-hash",
+						Kind:                 db.EntryKindFile,
+						Mode:                 0o640,
+					},
+				},
+				{
+					Version: db.VersionNew,
+					FSEntry: db.FSEntry{
+						FSName:               "local",
+						DeviceID:             "local-dev-id",
+						EntryID:              100100201,
+						Path:                 "/Folder2",
+						Name:                 "File2-1",
+						ParentFolderID:       1001002,
+						Size:                 100,
+						Hash:                 "local-file2-1-hash",
+						ContentHashAlgorithm: db.HashAlgorithmSHA1,
+						ContentHash:          "file2-1-content-hash",
+						Kind:                 db.EntryKindFile,
+						Mode:                 0o600,
+					},
+				},
+			},
+		},
+	}
+
+	// the data of the files is not read: the link has none, the special file is not synced
+	// and the modified file only has a change of metadata.
+	pCloudFS := MockPCloudFileSystem{}
+	defer func() { _ = pCloudFS.AssertExpectations(t) }()
+
+	fsEntryMatcher := func(entryID uint64) interface{} {
+		return mock.MatchedBy(
+			func(fsEntry db.FSEntry) bool {
+				return fsEntry.EntryID == entryID
+			},
+		)
+	}
+
+	localClient := MockMetadataLocalFileSystem{}
+	defer func() { _ = localClient.AssertExpectations(t) }()
+	localClient.
+		On("MkSymlink", ctx, "/Link1", "Folder2/File2-1").
+		Return(nil).
+		Once().
+		On("SetMetadata", ctx, "/Link1", fsEntryMatcher(1001)).
+		Return(nil).
+		Once().
+		On("SetMetadata", ctx, "/Folder2/File2-1", fsEntryMatcher(100201)).
+		Return(nil).
+		Once()
+
+	fsTracker := MockFSTracker{}
+	defer func() { _ = fsTracker.AssertExpectations(t) }()
+	fsTracker.
+		On("ListMutations", ctx).
+		Return(expectedFSMutations, nil).
+		Once()
+
+	s := sync.NewOneWay(&pCloudFS, &localClient, &fsTracker)
+	err := s.Sync(ctx)
+	require.NoError(t, err)
+}
+
+func TestOneWay_Sync_Metadata_Modified(t *testing.T) {
+	ctx := context.Background()
+
+	// the details of a modification within the file system are [Previous, New].
+	modified := func(previous, new db.FSEntry) db.FSMutation {
+		return db.FSMutation{
+			Type: db.MutationTypeModified,
+			Details: db.EntryMutations{
+				{Version: db.VersionPrevious, FSEntry: previous},
+				{Version: db.VersionNew, FSEntry: new},
+			},
+		}
+	}
+
+	file := db.FSEntry{
+		FSName:               "left",
+		DeviceID:             "dev-id",
+		EntryID:              100201,
+		Path:                 "/Folder2",
+		Name:                 "File2-1",
+		ParentFolderID:       1002,
+		Size:                 100,
+		Hash:                 "file2-1-hash",
+		ContentHashAlgorithm: db.HashAlgorithmSHA1,
+		ContentHash:          "file2-1-content-hash",
+		Kind:                 db.EntryKindFile,
+		Mode:                 0o600,
+		UID:                  1000,
+		Xattrs:               db.Xattrs{"user.tag": []byte("old")},
+	}
+	chmodedFile := file
+	chmodedFile.Mode = 0o640
+	chmodedFile.UID = 1001
+	chmodedFile.Xattrs = db.Xattrs{"user.tag": []byte("new")}
+
+	link := db.FSEntry{
+		FSName:         "left",
+		DeviceID:       "dev-id",
+		EntryID:        1001,
+		Path:           "/",
+		Name:           "Link1",
+		ParentFolderID: 1000,
+		Kind:           db.EntryKindSymlink,
+		LinkTarget:     "Folder2/File2-1",
+		Mode:           0o777,
+	}
+	retargetedLink := link
+	retargetedLink.LinkTarget = "Folder2/File2-2"
+
+	expectedFSMutations := db.FSMutations{
+		modified(file, chmodedFile),
+		modified(link, retargetedLink),
+	}
+
+	// the new state of the entries is replicated.
+	localClient := MockMetadataLocalFileSystem{}
+	defer func() { _ = localClient.AssertExpectations(t) }()
+	localClient.
+		On("SetMetadata", ctx, "/Folder2/File2-1", chmodedFile).
+		Return(nil).
+		Once().
+		On("RmFile", ctx, "/Link1").
+		Return(nil).
+		Once().
+		On("MkSymlink", ctx, "/Link1", "Folder2/File2-2").
+		Return(nil).
+		Once().
+		On("SetMetadata", ctx, "/Link1", retargetedLink).
+		Return(nil).
+		Once()
+
+	fsTracker := MockFSTracker{}
+	defer func() { _ = fsTracker.AssertExpectations(t) }()
+	fsTracker.
+		On("ListMutations", ctx).
+		Return(expectedFSMutations, nil).
+		Once()
+
+	s := sync.NewOneWay(&MockPCloudFileSystem{}, &localClient, &fsTracker)
+	err := s.Sync(ctx)
+	require.NoError(t, err)
+}
+
+func TestOneWay_Sync_MutatedAndMoved(t *testing.T) {
+	ctx := context.Background()
+
+	movedAndModified := func(fromPath, fromName, toPath, toName, fromHash, toHash string) db.FSMutation {
+		return db.FSMutation{
+			Type: db.MutationTypeMovedAndModified,
+			Details: db.EntryMutations{
+				{Version: db.VersionPrevious, FSEntry: db.FSEntry{FSName: "left", Path: fromPath, Name: fromName, Hash: fromHash, Kind: db.EntryKindFile}},
+				{Version: db.VersionNew, FSEntry: db.FSEntry{FSName: "left", Path: toPath, Name: toName, Hash: toHash, Kind: db.EntryKindFile}},
+			},
+		}
+	}
+
+	expectedFSMutations := db.FSMutations{
+		// renamed and edited.
+		movedAndModified("/Folder1", "File1", "/Folder1", "RenamedFile1", "file1-hash", "file1-hash-2"),
+		// moved and edited.
+		movedAndModified("/Folder1", "File2", "/Folder2", "File2", "file2-hash", "file2-hash-2"),
+	}
+
+	// the new contents of the files are streamed once they have moved.
+	pCloudFS := MockPCloudFileSystem{}
+	defer func() { _ = pCloudFS.AssertExpectations(t) }()
+
+	calls := []string{}
+	for _, hash := range []string{"file1-hash-2", "file2-hash-2"} {
+		hash := hash
+
+		dataCh := make(chan []byte)
+		close(dataCh)
+		errCh := make(chan error)
+		close(errCh)
+
+		pCloudFS.
+			On("StreamFileData", mock.Anything, mock.MatchedBy(func(fsEntry db.FSEntry) bool { return fsEntry.Hash == hash })).
+			Run(func(mock.Arguments) { calls = append(calls, "StreamFileData "+hash) }).
+			Return((<-chan []byte)(dataCh), (<-chan error)(errCh)).
+			Once()
+	}
+
+	localClient := MockLocalFileSystem{}
+	defer func() { _ = localClient.AssertExpectations(t) }()
+	localClient.
+		On("MvFile", ctx, "/Folder1/File1", "/Folder1/RenamedFile1").
+		Run(func(mock.Arguments) { calls = append(calls, "MvFile /Folder1/RenamedFile1") }).
+		Return(nil).
+		Once().
+		On("MkFile", mock.Anything, "/Folder1/RenamedFile1", mock.Anything).
+		Run(func(mock.Arguments) { calls = append(calls, "MkFile /Folder1/RenamedFile1") }).
+		Return(nil).
+		Once().
+		On("MkDir", ctx, "/Folder2").
+		Return(nil).
+		Once().
+		On("MvFile", ctx, "/Folder1/File2", "/Folder2/File2").
+		Run(func(mock.Arguments) { calls = append(calls, "MvFile /Folder2/File2") }).
+		Return(nil).
+		Once().
+		On("MkFile", mock.Anything, "/Folder2/File2", mock.Anything).
+		Run(func(mock.Arguments) { calls = append(calls, "MkFile /Folder2/File2") }).
+		Return(nil).
+		Once()
+
+	fsTracker := MockFSTracker{}
+	defer func() { _ = fsTracker.AssertExpectations(t) }()
+	fsTracker.
+		On("ListMutations", ctx).
+		Return(expectedFSMutations, nil).
+		Once()
+
+	s := sync.NewOneWay(&pCloudFS, &localClient, &fsTracker)
+	err := s.Sync(ctx)
+	require.NoError(t, err)
+
+	require.Equal(t,
+		[]string{
+			"MvFile /Folder1/RenamedFile1",
+			"StreamFileData file1-hash-2",
+			"MkFile /Folder1/RenamedFile1",
+			"MvFile /Folder2/File2",
+			"StreamFileData file2-hash-2",
+			"MkFile /Folder2/File2",
+		},
+		calls,
+	)
+}
+
+func TestOneWay_Sync_SyncStatus(t *testing.T) {
+	ctx := context.Background()
+
+	expectedFSMutations := db.FSMutations{
+		{
+			Type: db.MutationTypeCreated,
+			Details: db.EntryMutations{
+				{
+					Version: db.VersionNew,
+					FSEntry: db.FSEntry{
+						FSName:   "left",
+						DeviceID: "dev-id",
+						EntryID:  1001,
+						IsFolder: true,
+						Path:     "/",
+						Name:     "Folder1",
+					},
+				},
+			},
+		},
+	}
+
+	localClient := MockLocalFileSystem{}
+	defer func() { _ = localClient.AssertExpectations(t) }()
+	localClient.
+		On("MkDir", ctx, "/Folder1").
+		Return(nil).
+		Once()
+
+	fsTracker := MockFSTracker{}
+	defer func() { _ = fsTracker.AssertExpectations(t) }()
+	fsTracker.
+		On("ListMutations", ctx).
+		Return(expectedFSMutations, nil).
+		Once()
+
+	store := MockSyncStatusStore{}
+	defer func() { _ = store.AssertExpectations(t) }()
+	store.
+		On("LockSync", ctx, db.PairName("left-right")).
+		Return(func() error { return nil }, nil).
+		Once().
+		On("GetSyncStatus", ctx, db.PairName("left-right")).
+		Return(db.SyncStatusRequired, nil).
+		Once().
+		On("MarkSyncInProgress", ctx, db.PairName("left-right")).
+		Return(nil).
+		Once().
+		On("MarkSyncComplete", ctx, db.PairName("left-right")).
+		Return(nil).
+		Once()
+
+	s := sync.NewOneWay(&MockPCloudFileSystem{}, &localClient, &fsTracker, sync.WithSyncStatus(&store, "left-right"))
+	err := s.Sync(ctx)
+	require.NoError(t, err)
+}
+
+func TestOneWay_Sync_SyncStatus_Complete(t *testing.T) {
+	ctx := context.Background()
+
+	// no mutations are listed nor applied when the sync is already complete.
+	fsTracker := MockFSTracker{}
+	defer func() { _ = fsTracker.AssertExpectations(t) }()
+
+	store := MockSyncStatusStore{}
+	defer func() { _ = store.AssertExpectations(t) }()
+	store.
+		On("LockSync", ctx, db.PairName("left-right")).
+		Return(func() error { return nil }, nil).
+		Once().
+		On("GetSyncStatus", ctx, db.PairName("left-right")).
+		Return(db.SyncStatusComplete, nil).
+		Once()
+
+	s := sync.NewOneWay(&MockPCloudFileSystem{}, &MockLocalFileSystem{}, &fsTracker, sync.WithSyncStatus(&store, "left-right"))
+	err := s.Sync(ctx)
+	require.NoError(t, err)
+}
+
+func TestOneWay_Sync_SyncStatus_Failed(t *testing.T) {
+	ctx := context.Background()
+
+	expectedFSMutations := db.FSMutations{
+		{
+			Type: db.MutationTypeDeleted,
+			Details: db.EntryMutations{
+				{
+					Version: db.VersionPrevious,
+					FSEntry: db.FSEntry{
+						FSName:   "left",
+						DeviceID: "dev-id",
+						EntryID:  1001,
+						IsFolder: true,
+						Path:     "/",
+						Name:     "Folder1",
+					},
+				},
+			},
+		},
+	}
+
+	errDenied := errors.New("permission denied")
+
+	localClient := MockLocalFileSystem{}
+	defer func() { _ = localClient.AssertExpectations(t) }()
+	localClient.
+		On("RmDir", ctx, "/Folder1").
+		Return(errDenied).
+		Once()
+
+	fsTracker := MockFSTracker{}
+	defer func() { _ = fsTracker.AssertExpectations(t) }()
+	fsTracker.
+		On("ListMutations", ctx).
+		Return(expectedFSMutations, nil).
+		Once()
+
+	store := MockSyncStatusStore{}
+	defer func() { _ = store.AssertExpectations(t) }()
+	store.
+		On("LockSync", ctx, db.PairName("left-right")).
+		Return(func() error { return nil }, nil).
+		Once().
+		On("GetSyncStatus", ctx, db.PairName("left-right")).
+		Return(db.SyncStatusRequired, nil).
+		Once().
+		On("MarkSyncInProgress", ctx, db.PairName("left-right")).
+		Return(nil).
+		Once().
+		On("MarkSyncAsChanged", ctx, db.PairName("left-right")).
+		Return(nil).
+		Once()
+
+	s := sync.NewOneWay(&MockPCloudFileSystem{}, &localClient, &fsTracker, sync.WithSyncStatus(&store, "left-right"))
+	err := s.Sync(ctx)
+	require.ErrorIs(t, err, errDenied)
+	assert.Contains(t, err.Error(), "1 mutation(s) failed")
+}
+
+func TestOneWay_Sync_SyncStatus_Rerun(t *testing.T) {
+	ctx := context.Background()
+
+	expectedFSMutations := db.FSMutations{
+		{
+			Type: db.MutationTypeDeleted,
+			Details: db.EntryMutations{
+				{Version: db.VersionPrevious, FSEntry: db.FSEntry{FSName: "left", EntryID: 1001, Path: "/", Name: "c", Kind: db.EntryKindFile}},
+			},
+		},
+		{
+			Type: db.MutationTypeMoved,
+			Details: db.EntryMutations{
+				{Version: db.VersionPrevious, FSEntry: db.FSEntry{FSName: "left", EntryID: 1002, Path: "/", Name: "a", Kind: db.EntryKindFile}},
+				{Version: db.VersionNew, FSEntry: db.FSEntry{FSName: "left", EntryID: 1002, Path: "/", Name: "b", Kind: db.EntryKindFile}},
+			},
+		},
+		{
+			Type: db.MutationTypeMoved,
+			Details: db.EntryMutations{
+				{Version: db.VersionPrevious, FSEntry: db.FSEntry{FSName: "left", EntryID: 1003, IsFolder: true, Path: "/", Name: "F", Kind: db.EntryKindFolder}},
+				{Version: db.VersionNew, FSEntry: db.FSEntry{FSName: "left", EntryID: 1003, IsFolder: true, Path: "/", Name: "G", Kind: db.EntryKindFolder}},
+			},
+		},
+	}
+
+	errDenied := errors.New("permission denied")
+
+	// the first sync moves the entries but fails to delete the file. The second sync finds the
+	// entries already moved and deletes the file.
+	localClient := MockExistenceLocalFileSystem{}
+	defer func() { _ = localClient.AssertExpectations(t) }()
+	localClient.
+		On("MvFile", ctx, "/a", "/b").
+		Return(nil).
+		Once().
+		On("MvDir", ctx, "/F", "/G").
+		Return(nil).
+		Once().
+		On("RmFile", ctx, "/c").
+		Return(errDenied).
+		Once().
+		On("MvFile", ctx, "/a", "/b").
+		Return(errors.Join(sync.ErrNotFound, errors.New("no such file"))).
+		Once().
+		On("Exists", ctx, "/b").
+		Return(true, nil).
+		Once().
+		On("MvDir", ctx, "/F", "/G").
+		Return(errors.Join(sync.ErrNotFound, errors.New("no such folder"))).
+		Once().
+		On("Exists", ctx, "/G").
+		Return(true, nil).
+		Once().
+		On("RmFile", ctx, "/c").
+		Return(nil).
+		Once()
+
+	fsTracker := MockFSTracker{}
+	defer func() { _ = fsTracker.AssertExpectations(t) }()
+	fsTracker.
+		On("ListMutations", ctx).
+		Return(expectedFSMutations, nil).
+		Twice()
+
+	store := MockSyncStatusStore{}
+	defer func() { _ = store.AssertExpectations(t) }()
+	store.
+		On("LockSync", ctx, db.PairName("left-right")).
+		Return(func() error { return nil }, nil).
+		Twice().
+		On("GetSyncStatus", ctx, db.PairName("left-right")).
+		Return(db.SyncStatusRequired, nil).
+		Twice().
+		On("MarkSyncInProgress", ctx, db.PairName("left-right")).
+		Return(nil).
+		Twice().
+		On("MarkSyncAsChanged", ctx, db.PairName("left-right")).
+		Return(nil).
+		Once().
+		On("MarkSyncComplete", ctx, db.PairName("left-right")).
+		Return(nil).
+		Once()
+
+	s := sync.NewOneWay(&MockPCloudFileSystem{}, &localClient, &fsTracker, sync.WithSyncStatus(&store, "left-right"))
+
+	err := s.Sync(ctx)
+	require.ErrorIs(t, err, errDenied)
+
+	err = s.Sync(ctx)
+	require.NoError(t, err)
+}
+
+func TestOneWay_Sync_SyncStatus_Interrupted(t *testing.T) {
+	ctx := context.Background()
+
+	fsTracker := MockFSTracker{}
+	defer func() { _ = fsTracker.AssertExpectations(t) }()
+	fsTracker.
+		On("ListMutations", ctx).
+		Return(db.FSMutations{}, nil).
+		Once()
+
+	// the sync is "in progress" but its lock is free: it was interrupted and runs again.
+	unlocked := false
+
+	store := MockSyncStatusStore{}
+	defer func() { _ = store.AssertExpectations(t) }()
+	store.
+		On("LockSync", ctx, db.PairName("left-right")).
+		Return(func() error { unlocked = true; return nil }, nil).
+		Once().
+		On("GetSyncStatus", ctx, db.PairName("left-right")).
+		Return(db.SyncStatusInProgress, nil).
+		Once().
+		On("MarkSyncAsChanged", ctx, db.PairName("left-right")).
+		Return(nil).
+		Once().
+		On("MarkSyncInProgress", ctx, db.PairName("left-right")).
+		Return(nil).
+		Once().
+		On("MarkSyncComplete", ctx, db.PairName("left-right")).
+		Return(nil).
+		Once()
+
+	s := sync.NewOneWay(&MockPCloudFileSystem{}, &MockLocalFileSystem{}, &fsTracker, sync.WithSyncStatus(&store, "left-right"))
+	err := s.Sync(ctx)
+	require.NoError(t, err)
+	require.True(t, unlocked)
+}
+
+func TestOneWay_Sync_SyncStatus_Locked(t *testing.T) {
+	ctx := context.Background()
+
+	// another sync of the pair is running: its status is left alone.
+	fsTracker := MockFSTracker{}
+	defer func() { _ = fsTracker.AssertExpectations(t) }()
+
+	store := MockSyncStatusStore{}
+	defer func() { _ = store.AssertExpectations(t) }()
+	store.
+		On("LockSync", ctx, db.PairName("left-right")).
+		Return((func() error)(nil), db.ErrLocked).
+		Once()
+
+	s := sync.NewOneWay(&MockPCloudFileSystem{}, &MockLocalFileSystem{}, &fsTracker, sync.WithSyncStatus(&store, "left-right"))
+	err := s.Sync(ctx)
+	require.ErrorIs(t, err, db.ErrLocked)
+}
+
+func TestOneWay_Sync_StreamedMutations(t *testing.T) {
+	ctx := context.Background()
+
+	streamMutations := func() (<-chan db.FSMutation, <-chan error) {
+		mutationsCh := make(chan db.FSMutation, 2)
+		mutationsCh <- db.FSMutation{
+			Type: db.MutationTypeCreated,
+			Details: db.EntryMutations{
+				{Version: db.VersionNew, FSEntry: db.FSEntry{FSName: "left", DeviceID: "dev-id", EntryID: 1001, IsFolder: true, Path: "/", Name: "Folder1", Kind: db.EntryKindFolder}},
+			},
+		}
+		mutationsCh <- db.FSMutation{
+			Type: db.MutationTypeDeleted,
+			Details: db.EntryMutations{
+				{Version: db.VersionPrevious, FSEntry: db.FSEntry{FSName: "left", DeviceID: "dev-id", EntryID: 1002, IsFolder: true, Path: "/", Name: "Folder2", Kind: db.EntryKindFolder}},
+			},
+		}
+		close(mutationsCh)
+
+		errCh := make(chan error, 1)
+		errCh <- nil
+		close(errCh)
+
+		return mutationsCh, errCh
+	}
+
+	localClient := MockLocalFileSystem{}
+	defer func() { _ = localClient.AssertExpectations(t) }()
+	localClient.
+		On("MkDir", ctx, "/Folder1").
+		Return(nil).
+		Once().
+		On("RmDir", ctx, "/Folder2").
+		Return(nil).
+		Once()
+
+	// the mutations are streamed rather than listed when the tracker supports it: once for the
+	// moves and deletions, then once for the creations and modifications.
+	fsTracker := MockStreamingFSTracker{}
+	defer func() { _ = fsTracker.AssertExpectations(t) }()
+	for i := 0; i < 2; i++ {
+		mutationsCh, errCh := streamMutations()
+		fsTracker.
+			On("StreamMutations", mock.Anything, (*db.MutationCursor)(nil), 50).
+			Return(mutationsCh, errCh).
+			Once()
+	}
+
+	s := sync.NewOneWay(&MockPCloudFileSystem{}, &localClient, &fsTracker, sync.WithMutationsPageSize(50))
+	err := s.Sync(ctx)
+	require.NoError(t, err)
+}
+
+type MockPCloudFileSystem struct {
+	mock.Mock
+}
+
+func (m *MockPCloudFileSystem) StreamFileData(ctx context.Context, fsEntry db.FSEntry) (<-chan []byte, <-chan error) {
+	args := m.Called(ctx, fsEntry)
+	return args.Get(0).(<-chan []byte), args.Get(1).(<-chan error)
+}
+
+type MockLocalFileSystem struct {
+	mock.Mock
+}
+
+func (m *MockLocalFileSystem) MkDir(ctx context.Context, path string) error {
+	args := m.Called(ctx, path)
+	return args.Error(0)
+}
+
+func (m *MockLocalFileSystem) MkFile(ctx context.Context, path string, dataCh <-chan []byte) error {
+	args := m.Called(ctx, path, dataCh)
+	return args.Error(0)
+}
+
+func (m *MockLocalFileSystem) RmDir(ctx context.Context, path string) error {
+	args := m.Called(ctx, path)
+	return args.Error(0)
+}
+
+func (m *MockLocalFileSystem) RmFile(ctx context.Context, path string) error {
+	args := m.Called(ctx, path)
+	return args.Error(0)
+}
+
+func (m *MockLocalFileSystem) MvDir(ctx context.Context, fromPath, toPath string) error {
+	args := m.Called(ctx, fromPath, toPath)
+	return args.Error(0)
+}
+
+func (m *MockLocalFileSystem) MvFile(ctx context.Context, fromPath, toPath string) error {
+	args := m.Called(ctx, fromPath, toPath)
+	return args.Error(0)
+}
+
+type MockFSTracker struct {
+	mock.Mock
+}
+
+func (m *MockFSTracker) ListMutations(ctx context.Context) (db.FSMutations, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(db.FSMutations), args.Error(1)
+}
+
+type MockStreamingFSTracker struct {
+	MockFSTracker
+}
+
+func (m *MockStreamingFSTracker) StreamMutations(ctx context.Context, after *db.MutationCursor, pageSize int) (<-chan db.FSMutation, <-chan error) {
+	args := m.Called(ctx, after, pageSize)
+	return args.Get(0).(<-chan db.FSMutation), args.Get(1).(<-chan error)
+}
+
+type MockTimesLocalFileSystem struct {
+	MockLocalFileSystem
+}
+
+func (m *MockTimesLocalFileSystem) MkFileWithTimes(ctx context.Context, path string, dataCh <-chan []byte, modified, created time.Time) error {
+	args := m.Called(ctx, path, dataCh, modified, created)
+	return args.Error(0)
+}
+
+type MockMetadataLocalFileSystem struct {
+	MockLocalFileSystem
+}
+
+func (m *MockMetadataLocalFileSystem) MkSymlink(ctx context.Context, path, target string) error {
+	args := m.Called(ctx, path, target)
+	return args.Error(0)
+}
+
+func (m *MockMetadataLocalFileSystem) SetMetadata(ctx context.Context, path string, fsEntry db.FSEntry) error {
+	args := m.Called(ctx, path, fsEntry)
+	return args.Error(0)
+}
+
+type MockExistenceLocalFileSystem struct {
+	MockLocalFileSystem
+}
+
+func (m *MockExistenceLocalFileSystem) Exists(ctx context.Context, path string) (bool, error) {
+	args := m.Called(ctx, path)
+	return args.Bool(0), args.Error(1)
+}
+
+type MockSyncStatusStore struct {
+	mock.Mock
+}
+
+func (m *MockSyncStatusStore) GetSyncStatus(ctx context.Context, pairName db.PairName) (db.SyncStatus, error) {
+	args := m.Called(ctx, pairName)
+	return args.Get(0).(db.SyncStatus), args.Error(1)
+}
+
+func (m *MockSyncStatusStore) MarkSyncInProgress(ctx context.Context, pairName db.PairName) error {
+	args := m.Called(ctx, pairName)
+	return args.Error(0)
+}
+
+func (m *MockSyncStatusStore) MarkSyncComplete(ctx context.Context, pairName db.PairName) error {
+	args := m.Called(ctx, pairName)
+	return args.Error(0)
+}
+
+func (m *MockSyncStatusStore) MarkSyncAsChanged(ctx context.Context, pairName db.PairName) error {
+	args := m.Called(ctx, pairName)
+	return args.Error(0)
+}
+
+func (m *MockSyncStatusStore) LockSync(ctx context.Context, pairName db.PairName) (func() error, error) {
+	args := m.Called(ctx, pairName)
+	return args.Get(0).(func() error), args.Error(1)
 }
@@ -12,10 +12,33 @@ func CreatedTime(fInfo os.FileInfo) time.Time {
 	return time.Unix(fInfo.Sys().(*syscall.Stat_t).Ctimespec.Sec, fInfo.Sys().(*syscall.Stat_t).Ctimespec.Nsec)
 }
 
-func Device(fInfo os.FileInfo) int32 {
-	return fInfo.Sys().(*syscall.Stat_t).Dev
+// Device returns the ID of the device of the entry, as a uint64 as on the other platforms.
+func Device(fInfo os.FileInfo) uint64 {
+	return uint64(fInfo.Sys().(*syscall.Stat_t).Dev)
 }
 
 func Inode(fInfo os.FileInfo) uint64 {
 	return fInfo.Sys().(*syscall.Stat_t).Ino
 }
+
+// Mode returns the POSIX permission bits of the entry, including the setuid, setgid and sticky bits.
+func Mode(fInfo os.FileInfo) uint32 {
+	return uint32(fInfo.Sys().(*syscall.Stat_t).Mode & 0o7777)
+}
+
+// Owner returns the user ID and the group ID of the owner of the entry.
+func Owner(fInfo os.FileInfo) (uint32, uint32) {
+	return fInfo.Sys().(*syscall.Stat_t).Uid, fInfo.Sys().(*syscall.Stat_t).Gid
+}
+
+// Xattrs returns the extended attributes of the entry at path.
+// Extended attributes are not supported on this platform yet: it always returns nil.
+func Xattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// SetXattrs sets the extended attributes of the entry at path.
+// Extended attributes are not supported on this platform yet: it does nothing.
+func SetXattrs(path string, xattrs map[string][]byte) error {
+	return nil
+}
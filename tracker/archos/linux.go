@@ -3,7 +3,9 @@
 package archos
 
 import (
+	"errors"
 	"os"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -19,3 +21,67 @@ func Device(fInfo os.FileInfo) uint64 {
 func Inode(fInfo os.FileInfo) uint64 {
 	return fInfo.Sys().(*syscall.Stat_t).Ino
 }
+
+// Mode returns the POSIX permission bits of the entry, including the setuid, setgid and sticky bits.
+func Mode(fInfo os.FileInfo) uint32 {
+	return fInfo.Sys().(*syscall.Stat_t).Mode & 0o7777
+}
+
+// Owner returns the user ID and the group ID of the owner of the entry.
+func Owner(fInfo os.FileInfo) (uint32, uint32) {
+	return fInfo.Sys().(*syscall.Stat_t).Uid, fInfo.Sys().(*syscall.Stat_t).Gid
+}
+
+// Xattrs returns the extended attributes of the entry at path, following symbolic links.
+// It returns nil if the file system does not support extended attributes.
+func Xattrs(path string) (map[string][]byte, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil {
+		if errors.Is(err, syscall.ENOTSUP) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if size == 0 {
+		return nil, nil
+	}
+
+	names := make([]byte, size)
+
+	size, err = syscall.Listxattr(path, names)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := map[string][]byte{}
+
+	for _, name := range strings.Split(strings.TrimSuffix(string(names[:size]), "\x00"), "\x00") {
+		valueSize, err := syscall.Getxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		value := make([]byte, valueSize)
+
+		valueSize, err = syscall.Getxattr(path, name, value)
+		if err != nil {
+			return nil, err
+		}
+
+		xattrs[name] = value[:valueSize]
+	}
+
+	return xattrs, nil
+}
+
+// SetXattrs sets the extended attributes of the entry at path, following symbolic links.
+func SetXattrs(path string, xattrs map[string][]byte) error {
+	for name, value := range xattrs {
+		if err := syscall.Setxattr(path, name, value, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
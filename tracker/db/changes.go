@@ -0,0 +1,514 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// ErrResyncRequired is returned when the changes of a file system cannot be applied
+// incrementally. The file system must be walked entirely instead.
+var ErrResyncRequired = errors.New("file system requires a full resync")
+
+// FSChangeType describes the type of change reported by a file system for one of its entries.
+type FSChangeType string
+
+const (
+	// FSChangeTypeCreated means the entry was created.
+	FSChangeTypeCreated FSChangeType = "created"
+	// FSChangeTypeModified means the entry was modified, renamed or moved.
+	FSChangeTypeModified FSChangeType = "modified"
+	// FSChangeTypeDeleted means the entry was deleted.
+	FSChangeTypeDeleted FSChangeType = "deleted"
+)
+
+// FSChange is a change to an entry of a file system, as reported by the file system itself.
+type FSChange struct {
+	Type FSChangeType
+
+	// Entry is the state of the entry after the change.
+	// Its Path is not used: it is resolved from the parent folder of the entry.
+	Entry FSEntry
+
+	// ReplacedEntryID is the ID of the file that Entry replaced, if any.
+	ReplacedEntryID uint64
+}
+
+// FSChanges contains the changes of a file system since a cursor.
+type FSChanges struct {
+	Changes []FSChange
+
+	// Cursor is the position of the file system in its stream of changes, after Changes.
+	Cursor uint64
+
+	// Reset is true when the file system is unable to list its changes since the cursor.
+	// Changes is then incomplete and the file system must be walked entirely.
+	Reset bool
+}
+
+// SetFileSystemCursor records the position of the file system fsName in its stream of changes.
+func (s *SQLite3) SetFileSystemCursor(ctx context.Context, fsName FSName, cursor uint64) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE "fs_info" SET fs_cursor = ?
+		 WHERE "fs_name" = ?`,
+		cursor,
+		fsName,
+	)
+
+	return errors.WithStack(err)
+}
+
+// SeedVersionNew replaces the "new" file system entries for the specified file system with a
+// copy of its "previous" entries.
+// This is the starting point of an incremental refresh, whereby the changes of the file system
+// are then applied to VersionNew with ApplyFileSystemChanges.
+func (s *SQLite3) SeedVersionNew(ctx context.Context, fsName FSName) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.deleteVersion(ctx, tx, fsName, VersionNew)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO "filesystem"
+			(fs_name, version, device_id, entry_id, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs)
+		 SELECT fs_name, :version_new, device_id, entry_id, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs
+		 FROM "filesystem"
+		 WHERE version = :version_previous
+		   AND fs_name = :fs_name`,
+		sql.Named("fs_name", fsName),
+		sql.Named("version_previous", VersionPrevious),
+		sql.Named("version_new", VersionNew),
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = s.deleteFSMutations(ctx, tx, fsName)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	// cross FS mutations are built upon VersionNew so the staging table data needs clearing
+	err = s.deleteCrossFSMutations(ctx, tx)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// SeedVersionStaging replaces the "staging" file system entries for the specified file system
+// with a copy of its "new" entries.
+// This is the starting point of an incremental refresh, whereby the changes of the file system
+// are then applied to VersionStaging with ApplyStagingFileSystemChanges.
+func (s *SQLite3) SeedVersionStaging(ctx context.Context, fsName FSName) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.deleteVersion(ctx, tx, fsName, VersionStaging)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO "filesystem"
+			(fs_name, version, device_id, entry_id, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs)
+		 SELECT fs_name, :version_staging, device_id, entry_id, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs
+		 FROM "filesystem"
+		 WHERE version = :version_new
+		   AND fs_name = :fs_name`,
+		sql.Named("fs_name", fsName),
+		sql.Named("version_new", VersionNew),
+		sql.Named("version_staging", VersionStaging),
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// ApplyFileSystemChanges applies the changes to the "new" file system entries for the specified
+// file system and records cursor as its position in its stream of changes.
+// The path of the created, modified and moved entries is resolved from their parent folder. The
+// entries whose parent folder is not in VersionNew are outside of the tracked file system: they
+// are ignored, or deleted if they were moved out of it.
+// The entries excluded by filter, if not nil, are treated as if they were deleted.
+// It returns ErrResyncRequired when a change cannot be applied, such as when a folder is moved
+// into the tracked file system since its contents are not known.
+// The changes are applied in a single transaction: either all apply or none do.
+func (s *SQLite3) ApplyFileSystemChanges(ctx context.Context, fsName FSName, changes []FSChange, cursor uint64, filter EntryFilter) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.applyFileSystemChanges(ctx, tx, fsName, VersionNew, changes, filter)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE "fs_info" SET fs_cursor = ?
+		 WHERE "fs_name" = ?`,
+		cursor,
+		fsName,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = s.deleteFSMutations(ctx, tx, fsName)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	// cross FS mutations are built upon VersionNew so the staging table data needs clearing
+	err = s.deleteCrossFSMutations(ctx, tx)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// ApplyStagingFileSystemChanges applies the changes to the "staging" file system entries for the
+// specified file system, as per ApplyFileSystemChanges. The staging entries are seeded with
+// SeedVersionStaging and become the "new" entries with CommitVersionStaging.
+// The changes are applied in a single transaction: either all apply or none do.
+func (s *SQLite3) ApplyStagingFileSystemChanges(ctx context.Context, fsName FSName, changes []FSChange, filter EntryFilter) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.applyFileSystemChanges(ctx, tx, fsName, VersionStaging, changes, filter)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+func (s *SQLite3) applyFileSystemChanges(ctx context.Context, tx *sql.Tx, fsName FSName, version Version, changes []FSChange, filter EntryFilter) error {
+	rootID, err := s.findRootFolderID(ctx, tx, fsName, version)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		err = s.applyFileSystemChange(ctx, tx, fsName, version, rootID, change, filter)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findRootFolderID returns the ID of the root folder of the version of the file system: that is
+// the only folder whose parent is not in the file system.
+func (s *SQLite3) findRootFolderID(ctx context.Context, tx *sql.Tx, fsName FSName, version Version) (uint64, error) {
+	var rootID uint64
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT f.entry_id
+		 FROM "filesystem" f
+		 WHERE f.fs_name = :fs_name
+		   AND f.version = :version
+		   AND f.is_folder
+		   AND NOT EXISTS (SELECT 1
+		                   FROM "filesystem" p
+		                   WHERE p.fs_name = f.fs_name
+		                     AND p.version = f.version
+		                     AND p.is_folder
+		                     AND p.entry_id = f.parent_folder_id
+		                     AND p.entry_id != f.entry_id)`,
+		sql.Named("fs_name", fsName),
+		sql.Named("version", version),
+	).Scan(&rootID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errors.Wrapf(ErrResyncRequired, "no root folder found for file system '%s'", fsName)
+		}
+		return 0, errors.WithStack(err)
+	}
+
+	return rootID, nil
+}
+
+// nolint: gocognit
+func (s *SQLite3) applyFileSystemChange(ctx context.Context, tx *sql.Tx, fsName FSName, version Version, rootID uint64, change FSChange, filter EntryFilter) error {
+	entry := change.Entry
+	entry.FSName = fsName
+
+	if change.ReplacedEntryID != 0 {
+		replaced, err := s.findEntry(ctx, tx, fsName, version, entry.DeviceID, change.ReplacedEntryID, false)
+		if err != nil {
+			return err
+		}
+		if replaced != nil {
+			err = s.deleteEntry(ctx, tx, version, replaced)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	existing, err := s.findEntry(ctx, tx, fsName, version, entry.DeviceID, entry.EntryID, entry.IsFolder)
+	if err != nil {
+		return err
+	}
+
+	if change.Type == FSChangeTypeDeleted {
+		if existing == nil {
+			return nil
+		}
+		return s.deleteEntry(ctx, tx, version, existing)
+	}
+
+	if entry.IsFolder && entry.EntryID == rootID {
+		// the root folder is the reference point of the file system: it does not move.
+		entry.Path = existing.Path
+		entry.Name = existing.Name
+		entry.ParentFolderID = existing.ParentFolderID
+		return s.upsertEntry(ctx, tx, version, entry)
+	}
+
+	parent, err := s.findEntry(ctx, tx, fsName, version, entry.DeviceID, entry.ParentFolderID, true)
+	if err != nil {
+		return err
+	}
+
+	if parent == nil {
+		// the entry is outside of the tracked file system: it may have been moved out of it.
+		if existing == nil {
+			return nil
+		}
+		return s.deleteEntry(ctx, tx, version, existing)
+	}
+
+	entry.Path = filepath.Join(parent.Path, parent.Name)
+
+	if filter != nil {
+		excluded, err := filter.Excluded(ctx, entry)
+		if err != nil {
+			return err
+		}
+
+		if excluded {
+			// the entry may have been tracked until now, such as when a file grows too large.
+			if existing == nil {
+				return nil
+			}
+			return s.deleteEntry(ctx, tx, version, existing)
+		}
+	}
+
+	if entry.IsFolder && existing == nil && change.Type == FSChangeTypeModified {
+		return errors.Wrapf(ErrResyncRequired, "folder '%s' (entryID: %d) was moved into the file system", filepath.Join(entry.Path, entry.Name), entry.EntryID)
+	}
+
+	if entry.IsFolder && existing != nil && (existing.Path != entry.Path || existing.Name != entry.Name) {
+		err = s.relocateFolderContents(ctx, tx, fsName, version, filepath.Join(existing.Path, existing.Name), filepath.Join(entry.Path, entry.Name))
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.upsertEntry(ctx, tx, version, entry)
+}
+
+// findEntry returns the entry of the version of the file system or nil if it does not exist.
+// The entry IDs are only unique within a device.
+func (s *SQLite3) findEntry(ctx context.Context, tx *sql.Tx, fsName FSName, version Version, deviceID string, entryID uint64, isFolder bool) (*FSEntry, error) {
+	entry := FSEntry{}
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT fs_name, device_id, entry_id, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs
+		 FROM "filesystem"
+		 WHERE fs_name = ?
+		   AND version = ?
+		   AND device_id = ?
+		   AND entry_id = ?
+		   AND is_folder = ?`,
+		fsName,
+		version,
+		deviceID,
+		fmt.Sprintf("%d", entryID),
+		isFolder,
+	).Scan(
+		&entry.FSName,
+		&entry.DeviceID,
+		&entry.EntryID,
+		&entry.IsFolder,
+		&entry.Path,
+		&entry.Name,
+		&entry.ParentFolderID,
+		&entry.Created,
+		&entry.Modified,
+		&entry.Size,
+		&entry.Hash,
+		&entry.ContentHashAlgorithm,
+		&entry.ContentHash,
+		&entry.Kind,
+		&entry.LinkTarget,
+		&entry.Mode,
+		&entry.UID,
+		&entry.GID,
+		&entry.Xattrs,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	return &entry, nil
+}
+
+func (s *SQLite3) upsertEntry(ctx context.Context, tx *sql.Tx, version Version, entry FSEntry) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO "filesystem"
+			(fs_name, version, device_id, entry_id, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT (fs_name, version, device_id, entry_id)
+		 DO UPDATE SET
+			is_folder = excluded.is_folder,
+			path = excluded.path,
+			name = excluded.name,
+			parent_folder_id = excluded.parent_folder_id,
+			created = excluded.created,
+			modified = excluded.modified,
+			size = excluded.size,
+			hash = excluded.hash,
+			content_hash_algorithm = excluded.content_hash_algorithm,
+			content_hash = excluded.content_hash,
+			kind = excluded.kind,
+			link_target = excluded.link_target,
+			mode = excluded.mode,
+			uid = excluded.uid,
+			gid = excluded.gid,
+			xattrs = excluded.xattrs`,
+		entry.FSName,
+		version,
+		entry.DeviceID,
+		fmt.Sprintf("%d", entry.EntryID),
+		entry.IsFolder,
+		entry.Path,
+		entry.Name,
+		fmt.Sprintf("%d", entry.ParentFolderID),
+		entry.Created,
+		entry.Modified,
+		entry.Size,
+		entry.Hash,
+		entry.ContentHashAlgorithm,
+		entry.ContentHash,
+		entry.Kind,
+		entry.LinkTarget,
+		entry.Mode,
+		entry.UID,
+		entry.GID,
+		entry.Xattrs,
+	)
+	if err != nil {
+		return errors.WithMessagef(err, "deviceID: %s entryID: %d", entry.DeviceID, entry.EntryID)
+	}
+
+	return nil
+}
+
+// deleteEntry removes the entry of the version of the file system and, for a folder, all of its contents.
+func (s *SQLite3) deleteEntry(ctx context.Context, tx *sql.Tx, version Version, entry *FSEntry) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`DELETE FROM "filesystem"
+		 WHERE fs_name = ?
+		   AND version = ?
+		   AND device_id = ?
+		   AND entry_id = ?
+		   AND is_folder = ?`,
+		entry.FSName,
+		version,
+		entry.DeviceID,
+		fmt.Sprintf("%d", entry.EntryID),
+		entry.IsFolder,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !entry.IsFolder {
+		return nil
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM "filesystem"
+		 WHERE fs_name = :fs_name
+		   AND version = :version
+		   AND (path = :folder_path OR substr(path, 1, length(:folder_path) + 1) = :folder_path || '/')`,
+		sql.Named("fs_name", entry.FSName),
+		sql.Named("version", version),
+		sql.Named("folder_path", filepath.Join(entry.Path, entry.Name)),
+	)
+
+	return errors.WithStack(err)
+}
+
+// relocateFolderContents updates the path of all the entries of the version contained in the folder
+// at oldPath, after it has been renamed or moved to newPath.
+func (s *SQLite3) relocateFolderContents(ctx context.Context, tx *sql.Tx, fsName FSName, version Version, oldPath, newPath string) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`UPDATE "filesystem"
+		 SET path = :new_path || substr(path, length(:old_path) + 1)
+		 WHERE fs_name = :fs_name
+		   AND version = :version
+		   AND (path = :old_path OR substr(path, 1, length(:old_path) + 1) = :old_path || '/')`,
+		sql.Named("fs_name", fsName),
+		sql.Named("version", version),
+		sql.Named("old_path", oldPath),
+		sql.Named("new_path", newPath),
+	)
+
+	return errors.WithStack(err)
+}
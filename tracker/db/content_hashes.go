@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// ContentHash is the content hash of a file, as obtained for a particular state of the file
+// on its file system.
+type ContentHash struct {
+	EntryID uint64
+
+	// Hash is the hash of the file on its file system (see FSEntry.Hash) at the time its
+	// content hash was obtained. The content hash is stale when they differ.
+	Hash string
+
+	Algorithm HashAlgorithm
+	Value     string
+}
+
+// GetContentHashes returns the content hashes recorded for the files of the file system fsName,
+// by entry ID.
+func (s *SQLite3) GetContentHashes(ctx context.Context, fsName FSName) (map[uint64]ContentHash, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT entry_id, hash, content_hash_algorithm, content_hash
+		 FROM "content_hashes"
+		 WHERE fs_name = ?`,
+		fsName,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	contentHashes := map[uint64]ContentHash{}
+
+	for rows.Next() {
+		ch := ContentHash{}
+
+		err = rows.Scan(&ch.EntryID, &ch.Hash, &ch.Algorithm, &ch.Value)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		contentHashes[ch.EntryID] = ch
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return contentHashes, nil
+}
+
+// AddContentHashes records the content hashes of files of the file system fsName, replacing
+// those previously recorded for the same entries.
+func (s *SQLite3) AddContentHashes(ctx context.Context, fsName FSName, contentHashes []ContentHash) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, ch := range contentHashes {
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT OR REPLACE INTO "content_hashes" (fs_name, entry_id, hash, content_hash_algorithm, content_hash)
+			 VALUES (?, ?, ?, ?, ?)`,
+			fsName,
+			fmt.Sprintf("%d", ch.EntryID),
+			ch.Hash,
+			ch.Algorithm,
+			ch.Value,
+		)
+		if err != nil {
+			return doRollback(tx, errors.WithMessagef(err, "entryID: %d", ch.EntryID))
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
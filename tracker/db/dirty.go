@@ -0,0 +1,190 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// fullRescanPath is the dirty path recorded when the entire file system must be rescanned.
+const fullRescanPath = ""
+
+// DirtyPaths contains the paths of a file system recorded as changed by a watcher since they
+// were last walked.
+type DirtyPaths struct {
+	Paths []string
+
+	// FullRescan is true when the changes of the file system could not all be recorded, such as
+	// when the watcher was not running or when its queue overflowed.
+	FullRescan bool
+
+	// Seq is the sequence number of the most recently recorded dirty path.
+	// See ClearDirtyPaths.
+	Seq int64
+}
+
+// AddDirtyPaths records paths of the file system fsName as changed.
+// A path that is already recorded is moved to the end of the sequence.
+func (s *SQLite3) AddDirtyPaths(ctx context.Context, fsName FSName, paths []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, path := range paths {
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT OR REPLACE INTO "dirty_paths" (fs_name, path)
+			 VALUES (?, ?)`,
+			fsName,
+			path,
+		)
+		if err != nil {
+			return doRollback(tx, errors.WithMessagef(err, "path: %s", path))
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// MarkFullRescanRequired records that the entire file system fsName must be rescanned because
+// some of its changes may not have been recorded.
+func (s *SQLite3) MarkFullRescanRequired(ctx context.Context, fsName FSName) error {
+	return s.AddDirtyPaths(ctx, fsName, []string{fullRescanPath})
+}
+
+// GetDirtyPaths returns the paths of the file system fsName recorded as changed.
+func (s *SQLite3) GetDirtyPaths(ctx context.Context, fsName FSName) (*DirtyPaths, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT seq, path
+		 FROM "dirty_paths"
+		 WHERE fs_name = ?
+		 ORDER BY seq`,
+		fsName,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	dirtyPaths := &DirtyPaths{
+		Paths: []string{},
+	}
+
+	for rows.Next() {
+		var path string
+
+		err = rows.Scan(&dirtyPaths.Seq, &path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if path == fullRescanPath {
+			dirtyPaths.FullRescan = true
+			continue
+		}
+
+		dirtyPaths.Paths = append(dirtyPaths.Paths, path)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return dirtyPaths, nil
+}
+
+// ClearDirtyPaths removes the dirty paths of the file system fsName up to and including the
+// sequence number seq, as returned by GetDirtyPaths.
+// The paths recorded since are retained, even if they were already dirty.
+func (s *SQLite3) ClearDirtyPaths(ctx context.Context, fsName FSName, seq int64) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM "dirty_paths"
+		 WHERE fs_name = ?
+		   AND seq <= ?`,
+		fsName,
+		seq,
+	)
+
+	return errors.WithStack(err)
+}
+
+// HashCache holds the hashes of the files of a file system that have not changed since they
+// were last hashed.
+type HashCache struct {
+	fsEntries map[string]FSEntry
+}
+
+// NewHashCache creates a new initialised HashCache from the file system entries, leaving out
+// the files that are dirty: those whose path, or the path of one of their parent folders, is
+// in dirtyPaths.
+func NewHashCache(fsEntries []FSEntry, dirtyPaths []string) *HashCache {
+	dirty := make(map[string]struct{}, len(dirtyPaths))
+	for _, p := range dirtyPaths {
+		dirty[filepath.Clean(p)] = struct{}{}
+	}
+
+	isDirty := func(path string) bool {
+		for {
+			if _, ok := dirty[path]; ok {
+				return true
+			}
+
+			parent := filepath.Dir(path)
+			if parent == path {
+				return false
+			}
+			path = parent
+		}
+	}
+
+	cached := make(map[string]FSEntry, len(fsEntries))
+
+	for _, entry := range fsEntries {
+		if entry.IsFolder {
+			continue
+		}
+
+		path := filepath.Join(entry.Path, entry.Name)
+		if isDirty(path) {
+			continue
+		}
+
+		cached[path] = entry
+	}
+
+	return &HashCache{
+		fsEntries: cached,
+	}
+}
+
+// Hash returns the hash of the file at path, if it is known not to have changed. fsEntry is the
+// current state of the file: its device, inode, size, modification time and change time must be
+// those of the cached file, since the changes the watcher has yet to record are not dirty.
+func (c *HashCache) Hash(path string, fsEntry FSEntry) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	cached, ok := c.fsEntries[filepath.Clean(path)]
+	if !ok ||
+		cached.DeviceID != fsEntry.DeviceID ||
+		cached.EntryID != fsEntry.EntryID ||
+		cached.Size != fsEntry.Size ||
+		!cached.Modified.Equal(fsEntry.Modified) ||
+		!cached.Created.Equal(fsEntry.Created) {
+		return "", false
+	}
+
+	return cached.Hash, true
+}
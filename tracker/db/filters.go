@@ -0,0 +1,135 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FilterRules determine which entries of a file system are tracked.
+type FilterRules struct {
+	// Patterns are gitignore-style patterns of the entries that are not tracked.
+	Patterns []string
+
+	// MaxSize is the size in bytes above which files are not tracked. Zero means no limit.
+	MaxSize uint64
+
+	// MaxAge is the age of the last modification beyond which files are not tracked.
+	// Zero means no limit.
+	MaxAge time.Duration
+}
+
+// EntryFilter decides which entries of a file system are not tracked.
+type EntryFilter interface {
+	Excluded(ctx context.Context, entry FSEntry) (bool, error)
+}
+
+// SetFilterRules records the filter rules of the file system fsName, replacing its current rules.
+// Since the changes of the file system cannot tell which entries the new rules apply to, its
+// cursor is reset: the next incremental refresh walks the file system entirely.
+func (s *SQLite3) SetFilterRules(ctx context.Context, fsName FSName, rules FilterRules) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT OR REPLACE INTO "fs_filters" (fs_name, patterns, max_size, max_age)
+		 VALUES (?, ?, ?, ?)`,
+		fsName,
+		strings.Join(rules.Patterns, "\n"),
+		rules.MaxSize,
+		int64(rules.MaxAge/time.Second),
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE "fs_info" SET fs_cursor = 0
+		 WHERE "fs_name" = ?`,
+		fsName,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// GetFilterRules returns the filter rules of the file system fsName.
+// The rules are empty when none were recorded: all entries are tracked.
+func (s *SQLite3) GetFilterRules(ctx context.Context, fsName FSName) (*FilterRules, error) {
+	var (
+		patterns string
+		maxAge   int64
+	)
+
+	rules := &FilterRules{}
+
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT patterns, max_size, max_age
+		 FROM "fs_filters"
+		 WHERE fs_name = ?`,
+		fsName,
+	).Scan(&patterns, &rules.MaxSize, &maxAge)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return rules, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	if patterns != "" {
+		rules.Patterns = strings.Split(patterns, "\n")
+	}
+	rules.MaxAge = time.Duration(maxAge) * time.Second
+
+	return rules, nil
+}
+
+// DeletePreviousFileSystemEntries removes the entries of the previous (i.e. version "Previous")
+// file system entries for the specified file system.
+func (s *SQLite3) DeletePreviousFileSystemEntries(ctx context.Context, fsName FSName, fsEntries []FSEntry) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, entry := range fsEntries {
+		_, err = tx.ExecContext(
+			ctx,
+			`DELETE FROM "filesystem"
+			 WHERE fs_name = ?
+			   AND version = ?
+			   AND device_id = ?
+			   AND entry_id = ?`,
+			fsName,
+			VersionPrevious,
+			entry.DeviceID,
+			fmt.Sprintf("%d", entry.EntryID),
+		)
+		if err != nil {
+			return doRollback(tx, errors.WithMessagef(err, "deviceID: %s entryID: %d", entry.DeviceID, entry.EntryID))
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ErrLocked is returned when a lock of a refresh or of a sync is already held, in this process
+// or in another.
+var ErrLocked = errors.New("a refresh or a sync is already in progress")
+
+// lockKind distinguishes the locks of the refreshes of the file systems from the locks of the
+// syncs of the sync pairs.
+type lockKind string
+
+const (
+	lockKindRefresh lockKind = "refresh"
+	lockKindSync    lockKind = "sync"
+)
+
+// sqlite3LocksFolder is the name of the folder, next to the sqlite3 database, of the files that
+// the refreshes and the syncs lock while they run.
+const sqlite3LocksFolder = "locks"
+
+// LockRefresh takes the lock of the refreshes of the file system fsName. The lock is held until
+// the returned function is called, or until the process ends. It returns ErrLocked when a
+// refresh of the file system is in progress, in this process or in another.
+func (s *SQLite3) LockRefresh(_ context.Context, fsName FSName) (func() error, error) {
+	return s.lock(lockKindRefresh, string(fsName))
+}
+
+// LockSync takes the lock of the syncs of the sync pair pairName, as per LockRefresh.
+func (s *SQLite3) LockSync(_ context.Context, pairName PairName) (func() error, error) {
+	return s.lock(lockKindSync, string(pairName))
+}
+
+// lock takes an exclusive lock on a file of the sqlite3LocksFolder: the operating system releases
+// it should the process end.
+func (s *SQLite3) lock(kind lockKind, name string) (func() error, error) {
+	locksPath := filepath.Join(filepath.Dir(s.dbPathFilename), sqlite3LocksFolder)
+
+	err := os.MkdirAll(locksPath, 0o700)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(locksPath, string(kind)+"-"+url.PathEscape(name)+".lock"), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errors.Wrapf(ErrLocked, "%s of '%s'", kind, name)
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	// closing the file releases the lock.
+	return func() error { return errors.WithStack(f.Close()) }, nil
+}
+
+// execAffectsRows reports whether the statement executed affected any rows.
+func execAffectsRows(res sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return n > 0, nil
+}
@@ -0,0 +1,1516 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Memory is an in-memory store. It is meant for unit tests: its data is not persisted.
+// It behaves like SQLite3 and returns the same sentinel errors, such as sql.ErrNoRows when a
+// file system or a sync pair does not exist.
+type Memory struct {
+	mu sync.Mutex
+
+	fsInfos       map[FSName]FSInfo
+	fsRoots       map[FSName][]FSRoot
+	fsFilters     map[FSName]FilterRules
+	entries       map[FSName]map[Version]map[entryKey]FSEntry
+	fsMutations   map[FSName][]memoryFSMutation
+	dirtyPaths    map[FSName]map[string]int64
+	dirtyPathsSeq int64
+	contentHashes map[FSName]map[uint64]ContentHash
+	syncPairs     map[PairName]memorySyncPair
+
+	snapshotRetention map[FSName]int
+	snapshots         map[FSName][]Snapshot
+	snapshotEntries   map[FSName][]snapshotEntryState
+
+	locks map[memoryLock]struct{}
+}
+
+// memoryLock identifies a lock of a refresh or of a sync (see LockRefresh).
+type memoryLock struct {
+	kind lockKind
+	name string
+}
+
+// entryKey identifies an entry within a version of a file system: the entry IDs are only
+// unique within a device.
+type entryKey struct {
+	deviceID string
+	entryID  uint64
+}
+
+// less sorts the entry keys as SQLite3 does, where the entry IDs are stored as text.
+func (k entryKey) less(other entryKey) bool {
+	if k.deviceID != other.deviceID {
+		return k.deviceID < other.deviceID
+	}
+
+	return fmt.Sprintf("%d", k.entryID) < fmt.Sprintf("%d", other.entryID)
+}
+
+// memoryFSMutation is the equivalent of a row of the SQLite3 table "staging_fs_mutations".
+type memoryFSMutation struct {
+	mType MutationType
+	key   entryKey
+}
+
+type memorySyncPair struct {
+	SyncPair
+	status SyncStatus
+}
+
+// NewMemory creates a new initialised Memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		fsInfos:       map[FSName]FSInfo{},
+		fsRoots:       map[FSName][]FSRoot{},
+		fsFilters:     map[FSName]FilterRules{},
+		entries:       map[FSName]map[Version]map[entryKey]FSEntry{},
+		fsMutations:   map[FSName][]memoryFSMutation{},
+		dirtyPaths:    map[FSName]map[string]int64{},
+		contentHashes: map[FSName]map[uint64]ContentHash{},
+		syncPairs:     map[PairName]memorySyncPair{},
+
+		snapshotRetention: map[FSName]int{},
+		snapshots:         map[FSName][]Snapshot{},
+		snapshotEntries:   map[FSName][]snapshotEntryState{},
+
+		locks: map[memoryLock]struct{}{},
+	}
+}
+
+// Close does nothing: the data of the store is released along with the store.
+func (m *Memory) Close() error {
+	return nil
+}
+
+// RegisterFileSystem records the file system fsName, with its driver and its root path.
+// Registering a file system that already exists updates its driver and its root path but keeps
+// its status.
+func (m *Memory) RegisterFileSystem(_ context.Context, fsName FSName, fsDriver FSDriver, fsRoot string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fsInfo := m.fsInfos[fsName]
+	fsInfo.FSName = fsName
+	fsInfo.FSDriver = fsDriver
+	fsInfo.FSRoot = fsRoot
+	m.fsInfos[fsName] = fsInfo
+
+	return nil
+}
+
+// GetFileSystemInfo returns high level information about the file system fsName.
+// It will return an error (no rows found) if the file system is not registered.
+func (m *Memory) GetFileSystemInfo(_ context.Context, fsName FSName) (*FSInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.getFileSystemInfo(fsName)
+}
+
+func (m *Memory) getFileSystemInfo(fsName FSName) (*FSInfo, error) {
+	fsInfo, ok := m.fsInfos[fsName]
+	if !ok {
+		return nil, errors.Wrapf(sql.ErrNoRows, "file system '%s'", fsName)
+	}
+
+	return &fsInfo, nil
+}
+
+// GetSyncDetails returns the driver for the specified file system name and the root path.
+func (m *Memory) GetSyncDetails(_ context.Context, fsName FSName) (FSDriver, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fsInfo, err := m.getFileSystemInfo(fsName)
+	if err != nil {
+		return "", "", err
+	}
+
+	return fsInfo.FSDriver, fsInfo.FSRoot, nil
+}
+
+// SetFileSystemRoots records the roots of the file system fsName, replacing its current roots.
+func (m *Memory) SetFileSystemRoots(_ context.Context, fsName FSName, roots []FSRoot) error {
+	err := validateFSRoots(roots)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := map[string]struct{}{}
+	for _, root := range roots {
+		if _, ok := names[root.Name]; ok {
+			return errors.Errorf("root '%s': duplicate root name", root.Name)
+		}
+		names[root.Name] = struct{}{}
+	}
+
+	m.fsRoots[fsName] = append([]FSRoot{}, roots...)
+
+	return nil
+}
+
+// GetFileSystemRoots returns the roots of the file system fsName, sorted by name.
+// It returns an empty slice when the file system has a single root path.
+func (m *Memory) GetFileSystemRoots(_ context.Context, fsName FSName) ([]FSRoot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.getFileSystemRoots(fsName), nil
+}
+
+func (m *Memory) getFileSystemRoots(fsName FSName) []FSRoot {
+	roots := append([]FSRoot{}, m.fsRoots[fsName]...)
+	sort.Slice(roots, func(i, j int) bool { return roots[i].Name < roots[j].Name })
+
+	return roots
+}
+
+// SetFilterRules records the filter rules of the file system fsName, replacing its current rules.
+// Its cursor is reset: the next incremental refresh walks the file system entirely.
+func (m *Memory) SetFilterRules(_ context.Context, fsName FSName, rules FilterRules) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rules.Patterns = append([]string(nil), rules.Patterns...)
+	m.fsFilters[fsName] = rules
+
+	if fsInfo, ok := m.fsInfos[fsName]; ok {
+		fsInfo.FSCursor = 0
+		m.fsInfos[fsName] = fsInfo
+	}
+
+	return nil
+}
+
+// GetFilterRules returns the filter rules of the file system fsName.
+// The rules are empty when none were recorded: all entries are tracked.
+func (m *Memory) GetFilterRules(_ context.Context, fsName FSName) (*FilterRules, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rules := m.fsFilters[fsName]
+	if len(rules.Patterns) == 0 {
+		rules.Patterns = nil
+	} else {
+		rules.Patterns = append([]string{}, rules.Patterns...)
+	}
+
+	return &rules, nil
+}
+
+// AddNewFileSystemEntries adds a new file system entry.
+// It returns two channels:
+// - the first is used to supply data to this method
+// - the second is a channel of error type should this function encounter an error.
+// The entries are added once the first channel is closed, all at once or not at all.
+func (m *Memory) AddNewFileSystemEntries(ctx context.Context, opts ...Options) (chan<- FSEntry, <-chan error) {
+	cfg := config{
+		entriesChSize: 100,
+		version:       VersionNew,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	entriesCh := make(chan FSEntry, cfg.entriesChSize)
+	errCh := make(chan error)
+
+	go func() {
+		defer close(errCh)
+
+		fsEntries := []FSEntry{}
+		for entry := range entriesCh {
+			fsEntries = append(fsEntries, entry)
+		}
+
+		if err := ctx.Err(); err != nil {
+			errCh <- errors.WithStack(err)
+			return
+		}
+
+		errCh <- m.addFileSystemEntries(cfg.version, fsEntries)
+	}()
+
+	return entriesCh, errCh
+}
+
+func (m *Memory) addFileSystemEntries(version Version, fsEntries []FSEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	added := map[FSName]map[entryKey]struct{}{}
+
+	for _, entry := range fsEntries {
+		key := entryKey{deviceID: entry.DeviceID, entryID: entry.EntryID}
+
+		_, exists := m.versionEntries(entry.FSName, version)[key]
+		if _, ok := added[entry.FSName][key]; ok || exists {
+			return errors.Errorf("deviceID: %s entryID: %d: the entry already exists", entry.DeviceID, entry.EntryID)
+		}
+
+		if added[entry.FSName] == nil {
+			added[entry.FSName] = map[entryKey]struct{}{}
+		}
+		added[entry.FSName][key] = struct{}{}
+	}
+
+	for _, entry := range fsEntries {
+		m.setEntry(version, entry)
+	}
+
+	return nil
+}
+
+// versionEntries returns the entries of the version of the file system. The returned map is
+// nil when there are none: it must not be written to.
+func (m *Memory) versionEntries(fsName FSName, version Version) map[entryKey]FSEntry {
+	return m.entries[fsName][version]
+}
+
+func (m *Memory) setEntry(version Version, entry FSEntry) {
+	if m.entries[entry.FSName] == nil {
+		m.entries[entry.FSName] = map[Version]map[entryKey]FSEntry{}
+	}
+
+	if m.entries[entry.FSName][version] == nil {
+		m.entries[entry.FSName][version] = map[entryKey]FSEntry{}
+	}
+
+	m.entries[entry.FSName][version][entryKey{deviceID: entry.DeviceID, entryID: entry.EntryID}] = cloneFSEntry(entry)
+}
+
+// cloneFSEntry returns a copy of the entry that does not share its extended attributes. Empty
+// extended attributes are nil, as they are once read from SQLite3.
+func cloneFSEntry(entry FSEntry) FSEntry {
+	if len(entry.Xattrs) == 0 {
+		entry.Xattrs = nil
+		return entry
+	}
+
+	xattrs := make(Xattrs, len(entry.Xattrs))
+	for k, v := range entry.Xattrs {
+		xattrs[k] = append([]byte{}, v...)
+	}
+	entry.Xattrs = xattrs
+
+	return entry
+}
+
+// sortedEntryKeys returns the keys of the entries, sorted.
+func sortedEntryKeys(entries map[entryKey]FSEntry) []entryKey {
+	keys := make([]entryKey, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+
+	return keys
+}
+
+func (m *Memory) getFileSystemEntries(fsName FSName, version Version) []FSEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := m.versionEntries(fsName, version)
+	fsEntries := []FSEntry{}
+
+	for _, key := range sortedEntryKeys(entries) {
+		fsEntries = append(fsEntries, cloneFSEntry(entries[key]))
+	}
+
+	return fsEntries
+}
+
+// GetPreviousFileSystemEntries get the previous (i.e. version "Previous") file system entries
+// for the specified file system type.
+func (m *Memory) GetPreviousFileSystemEntries(_ context.Context, fsName FSName) ([]FSEntry, error) {
+	return m.getFileSystemEntries(fsName, VersionPrevious), nil
+}
+
+// GetLatestFileSystemEntries get the latest (i.e. version "New") file system entries for the
+// specified file system type.
+func (m *Memory) GetLatestFileSystemEntries(_ context.Context, fsName FSName) ([]FSEntry, error) {
+	return m.getFileSystemEntries(fsName, VersionNew), nil
+}
+
+// GetFileSystemMutations returns a slice of mutations for the specified file system, as of its
+// last committed refresh.
+func (m *Memory) GetFileSystemMutations(_ context.Context, fsName FSName) (FSMutations, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fsMutations := FSMutations{}
+
+	for _, fsm := range m.fsMutations[fsName] {
+		mutation, err := m.fsMutation(fsName, fsm)
+		if err != nil {
+			return nil, err
+		}
+
+		if mutation != nil {
+			fsMutations = append(fsMutations, *mutation)
+		}
+	}
+
+	return fsMutations, nil
+}
+
+// GetFileSystemMutationsPage returns at most limit mutations of the file system fsName that
+// follow the cursor after, as per SQLite3.GetFileSystemMutationsPage.
+func (m *Memory) GetFileSystemMutationsPage(_ context.Context, fsName FSName, after *MutationCursor, limit int) (FSMutations, *MutationCursor, error) {
+	if limit <= 0 {
+		return nil, nil, errors.Errorf("invalid page size %d", limit)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fsMutations := FSMutations{}
+	count := 0
+
+	var next *MutationCursor
+
+	for _, fsm := range m.fsMutations[fsName] {
+		if after != nil && !after.before(fsm) {
+			continue
+		}
+
+		mutation, err := m.fsMutation(fsName, fsm)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if mutation != nil {
+			fsMutations = append(fsMutations, *mutation)
+		}
+
+		count++
+		if count == limit {
+			next = &MutationCursor{Type: fsm.mType, DeviceID: fsm.key.deviceID, EntryID: fsm.key.entryID}
+			break
+		}
+	}
+
+	return fsMutations, next, nil
+}
+
+// before reports whether the cursor sorts before the mutation.
+func (c MutationCursor) before(fsm memoryFSMutation) bool {
+	if c.Type != fsm.mType {
+		return c.Type < fsm.mType
+	}
+
+	return entryKey{deviceID: c.DeviceID, entryID: c.EntryID}.less(fsm.key)
+}
+
+// fsMutation returns the details of the mutation fsm of the file system, or nil when neither
+// of its entries exists.
+func (m *Memory) fsMutation(fsName FSName, fsm memoryFSMutation) (*FSMutation, error) {
+	mutation := FSMutation{Type: fsm.mType}
+
+	// `Previous` before `New`
+	for _, version := range []Version{VersionPrevious, VersionNew} {
+		entry, ok := m.versionEntries(fsName, version)[fsm.key]
+		if !ok {
+			continue
+		}
+
+		mutation.Details = append(mutation.Details, VersionedEntry{
+			Version: version,
+			FSEntry: cloneFSEntry(entry),
+		})
+	}
+
+	if len(mutation.Details) == 0 {
+		return nil, nil
+	}
+
+	err := sanitiseFSMDetails(mutation)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "FSName: '%s' DeviceID: '%s' EntryID: '%d'", fsName, fsm.key.deviceID, fsm.key.entryID)
+	}
+
+	return &mutation, nil
+}
+
+// refreshFSMutations records the mutations between the "previous" and the "new" entries of the
+// file system, as per SQLite3.refreshFSMutationsStagingTable.
+func (m *Memory) refreshFSMutations(fsName FSName) {
+	m.fsMutations[fsName] = diffMemoryEntries(m.versionEntries(fsName, VersionPrevious), m.versionEntries(fsName, VersionNew))
+}
+
+// diffMemoryEntries returns the mutations between the previous and the latest entries, sorted by
+// type then by entry key.
+func diffMemoryEntries(previous, latest map[entryKey]FSEntry) []memoryFSMutation {
+	fsMutations := []memoryFSMutation{}
+
+	for key := range previous {
+		if _, ok := latest[key]; !ok {
+			fsMutations = append(fsMutations, memoryFSMutation{mType: MutationTypeDeleted, key: key})
+		}
+	}
+
+	for key, newEntry := range latest {
+		previousEntry, ok := previous[key]
+
+		if !ok {
+			fsMutations = append(fsMutations, memoryFSMutation{mType: MutationTypeCreated, key: key})
+			continue
+		}
+
+		// renames are moves too. The entries whose path only changed because a folder above them
+		// moved are not: the move of that folder moves them along.
+		moved := newEntry.ParentFolderID != previousEntry.ParentFolderID || newEntry.Name != previousEntry.Name
+
+		modified := newEntry.Hash != previousEntry.Hash ||
+			newEntry.Kind != previousEntry.Kind ||
+			newEntry.LinkTarget != previousEntry.LinkTarget ||
+			newEntry.Mode != previousEntry.Mode ||
+			newEntry.UID != previousEntry.UID ||
+			newEntry.GID != previousEntry.GID ||
+			!sameXattrs(newEntry.Xattrs, previousEntry.Xattrs)
+
+		switch {
+		case moved && modified:
+			fsMutations = append(fsMutations, memoryFSMutation{mType: MutationTypeMovedAndModified, key: key})
+		case moved:
+			fsMutations = append(fsMutations, memoryFSMutation{mType: MutationTypeMoved, key: key})
+		case modified:
+			fsMutations = append(fsMutations, memoryFSMutation{mType: MutationTypeModified, key: key})
+		}
+	}
+
+	sort.Slice(fsMutations, func(i, j int) bool {
+		if fsMutations[i].mType != fsMutations[j].mType {
+			return fsMutations[i].mType < fsMutations[j].mType
+		}
+		return fsMutations[i].key.less(fsMutations[j].key)
+	})
+
+	return fsMutations
+}
+
+func sameXattrs(x1, x2 Xattrs) bool {
+	if len(x1) != len(x2) {
+		return false
+	}
+
+	for k, v1 := range x1 {
+		v2, ok := x2[k]
+		if !ok || !bytes.Equal(v1, v2) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SeedVersionStaging replaces the "staging" file system entries for the specified file system
+// with a copy of its "new" entries.
+func (m *Memory) SeedVersionStaging(_ context.Context, fsName FSName) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteVersion(fsName, VersionStaging)
+
+	for _, entry := range m.versionEntries(fsName, VersionNew) {
+		m.setEntry(VersionStaging, entry)
+	}
+
+	return nil
+}
+
+func (m *Memory) deleteVersion(fsName FSName, version Version) {
+	delete(m.entries[fsName], version)
+}
+
+func (m *Memory) renameVersion(fsName FSName, from, to Version) {
+	entries, ok := m.entries[fsName][from]
+	if !ok {
+		return
+	}
+
+	delete(m.entries[fsName], from)
+	m.entries[fsName][to] = entries
+}
+
+// ApplyStagingFileSystemChanges applies the changes to the "staging" file system entries for the
+// specified file system, as per SQLite3.ApplyStagingFileSystemChanges.
+// The changes are applied all at once: either all apply or none do.
+func (m *Memory) ApplyStagingFileSystemChanges(ctx context.Context, fsName FSName, changes []FSChange, filter EntryFilter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	staging := map[entryKey]FSEntry{}
+	for key, entry := range m.versionEntries(fsName, VersionStaging) {
+		staging[key] = entry
+	}
+
+	rootID, err := findMemoryRootFolderID(fsName, staging)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		err = applyMemoryFileSystemChange(ctx, fsName, staging, rootID, change, filter)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.deleteVersion(fsName, VersionStaging)
+
+	for _, entry := range staging {
+		m.setEntry(VersionStaging, entry)
+	}
+
+	return nil
+}
+
+// findMemoryRootFolderID returns the ID of the root folder of the entries: that is the only
+// folder whose parent is not in the entries.
+func findMemoryRootFolderID(fsName FSName, entries map[entryKey]FSEntry) (uint64, error) {
+	folderIDs := map[uint64]struct{}{}
+
+	for _, entry := range entries {
+		if entry.IsFolder {
+			folderIDs[entry.EntryID] = struct{}{}
+		}
+	}
+
+	for _, key := range sortedEntryKeys(entries) {
+		entry := entries[key]
+		if !entry.IsFolder {
+			continue
+		}
+
+		if _, ok := folderIDs[entry.ParentFolderID]; !ok || entry.ParentFolderID == entry.EntryID {
+			return entry.EntryID, nil
+		}
+	}
+
+	return 0, errors.Wrapf(ErrResyncRequired, "no root folder found for file system '%s'", fsName)
+}
+
+// nolint: gocognit
+func applyMemoryFileSystemChange(ctx context.Context, fsName FSName, entries map[entryKey]FSEntry, rootID uint64, change FSChange, filter EntryFilter) error {
+	entry := change.Entry
+	entry.FSName = fsName
+
+	if change.ReplacedEntryID != 0 {
+		replaced := findMemoryEntry(entries, entry.DeviceID, change.ReplacedEntryID, false)
+		if replaced != nil {
+			deleteMemoryEntry(entries, replaced)
+		}
+	}
+
+	existing := findMemoryEntry(entries, entry.DeviceID, entry.EntryID, entry.IsFolder)
+
+	if change.Type == FSChangeTypeDeleted {
+		if existing != nil {
+			deleteMemoryEntry(entries, existing)
+		}
+		return nil
+	}
+
+	if entry.IsFolder && entry.EntryID == rootID {
+		// the root folder is the reference point of the file system: it does not move.
+		entry.Path = existing.Path
+		entry.Name = existing.Name
+		entry.ParentFolderID = existing.ParentFolderID
+		entries[entryKey{deviceID: entry.DeviceID, entryID: entry.EntryID}] = cloneFSEntry(entry)
+		return nil
+	}
+
+	parent := findMemoryEntry(entries, entry.DeviceID, entry.ParentFolderID, true)
+	if parent == nil {
+		// the entry is outside of the tracked file system: it may have been moved out of it.
+		if existing != nil {
+			deleteMemoryEntry(entries, existing)
+		}
+		return nil
+	}
+
+	entry.Path = filepath.Join(parent.Path, parent.Name)
+
+	if filter != nil {
+		excluded, err := filter.Excluded(ctx, entry)
+		if err != nil {
+			return err
+		}
+
+		if excluded {
+			// the entry may have been tracked until now, such as when a file grows too large.
+			if existing != nil {
+				deleteMemoryEntry(entries, existing)
+			}
+			return nil
+		}
+	}
+
+	if entry.IsFolder && existing == nil && change.Type == FSChangeTypeModified {
+		return errors.Wrapf(ErrResyncRequired, "folder '%s' (entryID: %d) was moved into the file system", filepath.Join(entry.Path, entry.Name), entry.EntryID)
+	}
+
+	if entry.IsFolder && existing != nil && (existing.Path != entry.Path || existing.Name != entry.Name) {
+		relocateMemoryFolderContents(entries, filepath.Join(existing.Path, existing.Name), filepath.Join(entry.Path, entry.Name))
+	}
+
+	entries[entryKey{deviceID: entry.DeviceID, entryID: entry.EntryID}] = cloneFSEntry(entry)
+
+	return nil
+}
+
+// findMemoryEntry returns the entry or nil if it does not exist.
+func findMemoryEntry(entries map[entryKey]FSEntry, deviceID string, entryID uint64, isFolder bool) *FSEntry {
+	entry, ok := entries[entryKey{deviceID: deviceID, entryID: entryID}]
+	if !ok || entry.IsFolder != isFolder {
+		return nil
+	}
+
+	return &entry
+}
+
+// deleteMemoryEntry removes the entry and, for a folder, all of its contents.
+func deleteMemoryEntry(entries map[entryKey]FSEntry, entry *FSEntry) {
+	delete(entries, entryKey{deviceID: entry.DeviceID, entryID: entry.EntryID})
+
+	if !entry.IsFolder {
+		return
+	}
+
+	folderPath := filepath.Join(entry.Path, entry.Name)
+
+	for key, e := range entries {
+		if isInFolder(e.Path, folderPath) {
+			delete(entries, key)
+		}
+	}
+}
+
+// relocateMemoryFolderContents updates the path of all the entries contained in the folder at
+// oldPath, after it has been renamed or moved to newPath.
+func relocateMemoryFolderContents(entries map[entryKey]FSEntry, oldPath, newPath string) {
+	for key, e := range entries {
+		if isInFolder(e.Path, oldPath) {
+			e.Path = newPath + e.Path[len(oldPath):]
+			entries[key] = e
+		}
+	}
+}
+
+// isInFolder reports whether path is the path of the folder at folderPath or one of its
+// sub-folders.
+func isInFolder(path, folderPath string) bool {
+	return path == folderPath || strings.HasPrefix(path, folderPath+"/")
+}
+
+// DeleteVersionStaging removes "staging" file system entries for the specified file system.
+func (m *Memory) DeleteVersionStaging(_ context.Context, fsName FSName) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteVersion(fsName, VersionStaging)
+
+	return nil
+}
+
+// CommitVersionStaging completes the refresh of the specified file system, as per
+// SQLite3.CommitVersionStaging.
+func (m *Memory) CommitVersionStaging(_ context.Context, fsName FSName, commit StagingCommit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fsInfo, err := m.getFileSystemInfo(fsName)
+	if err != nil {
+		return err
+	}
+
+	if fsInfo.FSChanged {
+		m.deleteVersion(fsName, VersionNew)
+	} else {
+		m.deleteVersion(fsName, VersionPrevious)
+		m.renameVersion(fsName, VersionNew, VersionPrevious)
+	}
+
+	m.renameVersion(fsName, VersionStaging, VersionNew)
+
+	for _, entry := range commit.ExcludedPreviousEntries {
+		delete(m.entries[fsName][VersionPrevious], entryKey{deviceID: entry.DeviceID, entryID: entry.EntryID})
+	}
+
+	if commit.Cursor != nil {
+		fsInfo.FSCursor = *commit.Cursor
+	}
+
+	m.refreshFSMutations(fsName)
+
+	fsInfo.FSChanged = true
+	m.fsInfos[fsName] = *fsInfo
+
+	// the syncs from this file system must now catch up with its "new" entries
+	for pairName, syncPair := range m.syncPairs {
+		if syncPair.FromFS == fsName {
+			syncPair.status = SyncStatusRequired
+			m.syncPairs[pairName] = syncPair
+		}
+	}
+
+	m.takeSnapshot(fsName)
+
+	return nil
+}
+
+// RecoverIncompleteRefreshes discards the "staging" file system entries of the file systems
+// whose refresh is not in progress (see LockRefresh).
+// It returns the names of the file systems whose refresh was discarded.
+func (m *Memory) RecoverIncompleteRefreshes(_ context.Context) ([]FSName, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fsNames := []FSName{}
+
+	for fsName, versions := range m.entries {
+		if m.isLocked(lockKindRefresh, string(fsName)) {
+			continue
+		}
+		if len(versions[VersionStaging]) > 0 {
+			fsNames = append(fsNames, fsName)
+		}
+		m.deleteVersion(fsName, VersionStaging)
+	}
+
+	sort.Slice(fsNames, func(i, j int) bool { return fsNames[i] < fsNames[j] })
+
+	return fsNames, nil
+}
+
+// AddDirtyPaths records paths of the file system fsName as changed.
+// A path that is already recorded is moved to the end of the sequence.
+func (m *Memory) AddDirtyPaths(_ context.Context, fsName FSName, paths []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.dirtyPaths[fsName] == nil {
+		m.dirtyPaths[fsName] = map[string]int64{}
+	}
+
+	for _, path := range paths {
+		m.dirtyPathsSeq++
+		m.dirtyPaths[fsName][path] = m.dirtyPathsSeq
+	}
+
+	return nil
+}
+
+// MarkFullRescanRequired records that the entire file system fsName must be rescanned because
+// some of its changes may not have been recorded.
+func (m *Memory) MarkFullRescanRequired(ctx context.Context, fsName FSName) error {
+	return m.AddDirtyPaths(ctx, fsName, []string{fullRescanPath})
+}
+
+// GetDirtyPaths returns the paths of the file system fsName recorded as changed.
+func (m *Memory) GetDirtyPaths(_ context.Context, fsName FSName) (*DirtyPaths, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	type dirtyPath struct {
+		path string
+		seq  int64
+	}
+
+	sorted := []dirtyPath{}
+	for path, seq := range m.dirtyPaths[fsName] {
+		sorted = append(sorted, dirtyPath{path: path, seq: seq})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].seq < sorted[j].seq })
+
+	dirtyPaths := &DirtyPaths{
+		Paths: []string{},
+	}
+
+	for _, dp := range sorted {
+		dirtyPaths.Seq = dp.seq
+
+		if dp.path == fullRescanPath {
+			dirtyPaths.FullRescan = true
+			continue
+		}
+
+		dirtyPaths.Paths = append(dirtyPaths.Paths, dp.path)
+	}
+
+	return dirtyPaths, nil
+}
+
+// ClearDirtyPaths removes the dirty paths of the file system fsName up to and including the
+// sequence number seq, as returned by GetDirtyPaths.
+func (m *Memory) ClearDirtyPaths(_ context.Context, fsName FSName, seq int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for path, pathSeq := range m.dirtyPaths[fsName] {
+		if pathSeq <= seq {
+			delete(m.dirtyPaths[fsName], path)
+		}
+	}
+
+	return nil
+}
+
+// GetContentHashes returns the content hashes recorded for the files of the file system fsName,
+// by entry ID.
+func (m *Memory) GetContentHashes(_ context.Context, fsName FSName) (map[uint64]ContentHash, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	contentHashes := map[uint64]ContentHash{}
+	for entryID, ch := range m.contentHashes[fsName] {
+		contentHashes[entryID] = ch
+	}
+
+	return contentHashes, nil
+}
+
+// AddContentHashes records the content hashes of files of the file system fsName, replacing
+// those previously recorded for the same entries.
+func (m *Memory) AddContentHashes(_ context.Context, fsName FSName, contentHashes []ContentHash) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.contentHashes[fsName] == nil {
+		m.contentHashes[fsName] = map[uint64]ContentHash{}
+	}
+
+	for _, ch := range contentHashes {
+		m.contentHashes[fsName][ch.EntryID] = ch
+	}
+
+	return nil
+}
+
+// CreateSyncPair records a new sync pair between two registered file systems.
+func (m *Memory) CreateSyncPair(_ context.Context, syncPair SyncPair) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if syncPair.FromFS == syncPair.ToFS {
+		return errors.Errorf("sync pair '%s' cannot sync file system '%s' with itself", syncPair.PairName, syncPair.FromFS)
+	}
+
+	for _, fsName := range []FSName{syncPair.FromFS, syncPair.ToFS} {
+		_, err := m.getFileSystemInfo(fsName)
+		if err != nil {
+			return errors.WithMessagef(err, "sync pair '%s': file system '%s' is not registered", syncPair.PairName, fsName)
+		}
+	}
+
+	if _, ok := m.syncPairs[syncPair.PairName]; ok {
+		return errors.Errorf("sync pair '%s': the sync pair already exists", syncPair.PairName)
+	}
+
+	m.syncPairs[syncPair.PairName] = memorySyncPair{
+		SyncPair: syncPair,
+		status:   SyncStatusRequired,
+	}
+
+	return nil
+}
+
+// ListSyncPairs returns all the sync pairs, sorted by name.
+func (m *Memory) ListSyncPairs(_ context.Context) ([]SyncPair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.listSyncPairs(func(SyncPair) bool { return true }), nil
+}
+
+func (m *Memory) listSyncPairs(match func(SyncPair) bool) []SyncPair {
+	syncPairs := []SyncPair{}
+
+	for _, syncPair := range m.syncPairs {
+		if match(syncPair.SyncPair) {
+			syncPairs = append(syncPairs, syncPair.SyncPair)
+		}
+	}
+
+	sort.Slice(syncPairs, func(i, j int) bool { return syncPairs[i].PairName < syncPairs[j].PairName })
+
+	return syncPairs
+}
+
+// DeleteSyncPair removes the sync pair pairName.
+// It returns an error that wraps sql.ErrNoRows when the sync pair does not exist.
+func (m *Memory) DeleteSyncPair(_ context.Context, pairName PairName) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.syncPairs[pairName]; !ok {
+		return errors.Wrapf(sql.ErrNoRows, "sync pair '%s'", pairName)
+	}
+
+	delete(m.syncPairs, pairName)
+
+	return nil
+}
+
+func (m *Memory) findSyncPair(pairName PairName) (*memorySyncPair, error) {
+	syncPair, ok := m.syncPairs[pairName]
+	if !ok {
+		return nil, errors.Wrapf(sql.ErrNoRows, "sync pair '%s'", pairName)
+	}
+
+	return &syncPair, nil
+}
+
+// FindSyncPeers returns the names of the file systems that are synced with the file system
+// fsName, in either direction, sorted by name.
+func (m *Memory) FindSyncPeers(_ context.Context, fsName FSName) ([]FSName, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	peersSet := map[FSName]struct{}{}
+
+	for _, syncPair := range m.syncPairs {
+		switch fsName {
+		case syncPair.FromFS:
+			peersSet[syncPair.ToFS] = struct{}{}
+		case syncPair.ToFS:
+			peersSet[syncPair.FromFS] = struct{}{}
+		}
+	}
+
+	peers := make([]FSName, 0, len(peersSet))
+	for peer := range peersSet {
+		peers = append(peers, peer)
+	}
+
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+
+	return peers, nil
+}
+
+// GetCrossFSMutations returns the mutations to apply to the "to" file system of the sync pair
+// pairName so that it mirrors its "from" file system, as per SQLite3.GetCrossFSMutations.
+func (m *Memory) GetCrossFSMutations(_ context.Context, pairName PairName) (FSMutations, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	syncPair, err := m.findSyncPair(pairName)
+	if err != nil {
+		return nil, err
+	}
+
+	fromEntries, err := m.crossFSEntries(syncPair.FromFS)
+	if err != nil {
+		return nil, err
+	}
+
+	toEntries, err := m.crossFSEntries(syncPair.ToFS)
+	if err != nil {
+		return nil, err
+	}
+
+	type crossFSMutation struct {
+		mType   MutationType
+		relPath string
+		details EntryMutations
+	}
+
+	newDetail := func(entry FSEntry) VersionedEntry {
+		return VersionedEntry{Version: VersionNew, FSEntry: cloneFSEntry(entry)}
+	}
+
+	crossMutations := []crossFSMutation{}
+
+	for relPath, fromEntry := range fromEntries {
+		toEntry, ok := toEntries[relPath]
+
+		switch {
+		case !ok:
+			crossMutations = append(crossMutations, crossFSMutation{
+				mType:   MutationTypeCreated,
+				relPath: relPath,
+				details: EntryMutations{newDetail(fromEntry)},
+			})
+
+		case fromEntry.IsFolder != toEntry.IsFolder ||
+			fromEntry.ContentHashAlgorithm != toEntry.ContentHashAlgorithm ||
+			fromEntry.ContentHash != toEntry.ContentHash ||
+			fromEntry.Kind != toEntry.Kind ||
+			fromEntry.LinkTarget != toEntry.LinkTarget:
+			crossMutations = append(crossMutations, crossFSMutation{
+				mType:   MutationTypeModified,
+				relPath: relPath,
+				details: EntryMutations{newDetail(fromEntry), newDetail(toEntry)},
+			})
+		}
+	}
+
+	for relPath, toEntry := range toEntries {
+		if _, ok := fromEntries[relPath]; !ok {
+			crossMutations = append(crossMutations, crossFSMutation{
+				mType:   MutationTypeDeleted,
+				relPath: relPath,
+				details: EntryMutations{newDetail(toEntry)},
+			})
+		}
+	}
+
+	sort.Slice(crossMutations, func(i, j int) bool {
+		if crossMutations[i].mType != crossMutations[j].mType {
+			return crossMutations[i].mType < crossMutations[j].mType
+		}
+		return crossMutations[i].relPath < crossMutations[j].relPath
+	})
+
+	fsMutations := FSMutations{}
+	for _, cm := range crossMutations {
+		fsMutations = append(fsMutations, FSMutation{Type: cm.mType, Details: cm.details})
+	}
+
+	return fsMutations, nil
+}
+
+// crossFSEntries returns the "new" entries of the file system fsName, by their path relative to
+// the root of the file system, as per SQLite3.refreshCrossFSMutationsStagingTable.
+func (m *Memory) crossFSEntries(fsName FSName) (map[string]FSEntry, error) {
+	root := VirtualRootPath
+
+	if len(m.fsRoots[fsName]) == 0 {
+		fsInfo, err := m.getFileSystemInfo(fsName)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "file system '%s'", fsName)
+		}
+		root = fsInfo.FSRoot
+	}
+
+	rootLen := utf8.RuneCountInString(strings.TrimRight(root, "/"))
+
+	entries := map[string]FSEntry{}
+
+	for _, key := range sortedEntryKeys(m.versionEntries(fsName, VersionNew)) {
+		entry := m.entries[fsName][VersionNew][key]
+
+		var fullPath string
+		switch {
+		// the root of a file system is the entry whose path is its name.
+		case entry.Path == entry.Name:
+			fullPath = entry.Path
+		case strings.HasSuffix(entry.Path, "/"):
+			fullPath = entry.Path + entry.Name
+		default:
+			fullPath = entry.Path + "/" + entry.Name
+		}
+
+		// paths are compared by characters, as in SQL.
+		runes := []rune(fullPath)
+		if rootLen < len(runes) {
+			runes = runes[rootLen:]
+		} else {
+			runes = nil
+		}
+
+		relPath := strings.TrimRight(string(runes), "/")
+		if _, ok := entries[relPath]; !ok {
+			entries[relPath] = entry
+		}
+	}
+
+	return entries, nil
+}
+
+// GetSyncStatus returns the status of the sync of the sync pair pairName.
+func (m *Memory) GetSyncStatus(_ context.Context, pairName PairName) (SyncStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	syncPair, err := m.findSyncPair(pairName)
+	if err != nil {
+		return "", err
+	}
+
+	return syncPair.status, nil
+}
+
+// MarkSyncInProgress marks the status of the sync as "in progress".
+// It fails when the sync is not "required", such as when another sync of the pair is in progress.
+func (m *Memory) MarkSyncInProgress(_ context.Context, pairName PairName) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	syncPair, err := m.findSyncPair(pairName)
+	if err != nil {
+		return err
+	}
+
+	if syncPair.status != SyncStatusRequired {
+		return errors.Errorf("cannot start the sync of pair '%s': its status is '%s'", pairName, syncPair.status)
+	}
+
+	syncPair.status = SyncStatusInProgress
+	m.syncPairs[pairName] = *syncPair
+
+	return nil
+}
+
+// MarkSyncComplete marks the status of the sync as "complete", as per SQLite3.MarkSyncComplete.
+func (m *Memory) MarkSyncComplete(_ context.Context, pairName PairName) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	syncPair, err := m.findSyncPair(pairName)
+	if err != nil {
+		return err
+	}
+
+	if syncPair.status == SyncStatusInProgress {
+		syncPair.status = SyncStatusComplete
+		m.syncPairs[pairName] = *syncPair
+	}
+
+	for _, sp := range m.syncPairs {
+		if sp.FromFS == syncPair.FromFS && sp.status != SyncStatusComplete {
+			return nil
+		}
+	}
+
+	if fsInfo, ok := m.fsInfos[syncPair.FromFS]; ok {
+		fsInfo.FSChanged = false
+		m.fsInfos[syncPair.FromFS] = fsInfo
+	}
+
+	return nil
+}
+
+// MarkSyncAsChanged marks the status of the sync as "required", such as after a sync failed.
+func (m *Memory) MarkSyncAsChanged(_ context.Context, pairName PairName) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	syncPair, err := m.findSyncPair(pairName)
+	if err != nil {
+		return err
+	}
+
+	syncPair.status = SyncStatusRequired
+	m.syncPairs[pairName] = *syncPair
+
+	return nil
+}
+
+// RecoverInterruptedSyncs marks the syncs that are "in progress" but not running (see LockSync)
+// as "required".
+// It returns the names of the sync pairs whose sync was interrupted.
+func (m *Memory) RecoverInterruptedSyncs(_ context.Context) ([]PairName, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pairNames := []PairName{}
+
+	for pairName, syncPair := range m.syncPairs {
+		if syncPair.status == SyncStatusInProgress && !m.isLocked(lockKindSync, string(pairName)) {
+			syncPair.status = SyncStatusRequired
+			m.syncPairs[pairName] = syncPair
+			pairNames = append(pairNames, pairName)
+		}
+	}
+
+	sort.Slice(pairNames, func(i, j int) bool { return pairNames[i] < pairNames[j] })
+
+	return pairNames, nil
+}
+
+// LockRefresh takes the lock of the refreshes of the file system fsName, as per
+// SQLite3.LockRefresh. The lock is only known to the store.
+func (m *Memory) LockRefresh(_ context.Context, fsName FSName) (func() error, error) {
+	return m.lock(lockKindRefresh, string(fsName))
+}
+
+// LockSync takes the lock of the syncs of the sync pair pairName, as per SQLite3.LockSync. The
+// lock is only known to the store.
+func (m *Memory) LockSync(_ context.Context, pairName PairName) (func() error, error) {
+	return m.lock(lockKindSync, string(pairName))
+}
+
+func (m *Memory) lock(kind lockKind, name string) (func() error, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.isLocked(kind, name) {
+		return nil, errors.Wrapf(ErrLocked, "%s of '%s'", kind, name)
+	}
+
+	l := memoryLock{kind: kind, name: name}
+	m.locks[l] = struct{}{}
+
+	var once sync.Once
+
+	unlock := func() error {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			delete(m.locks, l)
+		})
+		return nil
+	}
+
+	return unlock, nil
+}
+
+// isLocked reports whether the lock is held. The caller must hold m.mu.
+func (m *Memory) isLocked(kind lockKind, name string) bool {
+	_, ok := m.locks[memoryLock{kind: kind, name: name}]
+	return ok
+}
+
+// SetSnapshotRetention sets the number of snapshots of the file system fsName to keep, as per
+// SQLite3.SetSnapshotRetention.
+func (m *Memory) SetSnapshotRetention(_ context.Context, fsName FSName, retention int) error {
+	if retention < 0 {
+		return errors.Errorf("invalid snapshot retention %d for file system '%s'", retention, fsName)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.getFileSystemInfo(fsName)
+	if err != nil {
+		return err
+	}
+
+	m.snapshotRetention[fsName] = retention
+	m.pruneSnapshots(fsName, retention)
+
+	return nil
+}
+
+// takeSnapshot records the "new" entries of the file system as its next snapshot, as per
+// SQLite3.takeSnapshot.
+func (m *Memory) takeSnapshot(fsName FSName) {
+	retention := m.snapshotRetention[fsName]
+	if retention == 0 {
+		return
+	}
+
+	snapshotID := uint64(1)
+	if snapshots := m.snapshots[fsName]; len(snapshots) > 0 {
+		snapshotID = snapshots[len(snapshots)-1].ID + 1
+	}
+
+	m.snapshots[fsName] = append(m.snapshots[fsName], Snapshot{
+		FSName:  fsName,
+		ID:      snapshotID,
+		TakenAt: time.Now().UTC(),
+	})
+
+	latest := m.versionEntries(fsName, VersionNew)
+	current := map[entryKey]struct{}{}
+
+	states := m.snapshotEntries[fsName]
+	for i := range states {
+		if states[i].validTo != 0 {
+			continue
+		}
+
+		key := entryKey{deviceID: states[i].entry.DeviceID, entryID: states[i].entry.EntryID}
+
+		entry, ok := latest[key]
+		if !ok || !sameFSEntry(entry, states[i].entry) {
+			states[i].validTo = snapshotID
+			continue
+		}
+
+		current[key] = struct{}{}
+	}
+
+	for _, key := range sortedEntryKeys(latest) {
+		if _, ok := current[key]; ok {
+			continue
+		}
+
+		states = append(states, snapshotEntryState{entry: cloneFSEntry(latest[key]), validFrom: snapshotID})
+	}
+
+	m.snapshotEntries[fsName] = states
+
+	m.pruneSnapshots(fsName, retention)
+}
+
+// pruneSnapshots discards the snapshots of the file system beyond the retention, as per
+// SQLite3.pruneSnapshots.
+func (m *Memory) pruneSnapshots(fsName FSName, retention int) {
+	snapshots := m.snapshots[fsName]
+	if len(snapshots) <= retention {
+		return
+	}
+
+	if retention == 0 {
+		delete(m.snapshots, fsName)
+		delete(m.snapshotEntries, fsName)
+		return
+	}
+
+	snapshots = snapshots[len(snapshots)-retention:]
+	m.snapshots[fsName] = append([]Snapshot{}, snapshots...)
+
+	oldestID := snapshots[0].ID
+
+	states := []snapshotEntryState{}
+
+	for _, state := range m.snapshotEntries[fsName] {
+		if state.validTo != 0 && state.validTo <= oldestID {
+			continue
+		}
+
+		if state.validFrom < oldestID {
+			state.validFrom = oldestID
+		}
+
+		states = append(states, state)
+	}
+
+	m.snapshotEntries[fsName] = states
+}
+
+// sameFSEntry returns whether the entries hold the same values.
+func sameFSEntry(e1, e2 FSEntry) bool {
+	return e1.FSName == e2.FSName &&
+		e1.DeviceID == e2.DeviceID &&
+		e1.EntryID == e2.EntryID &&
+		e1.IsFolder == e2.IsFolder &&
+		e1.Path == e2.Path &&
+		e1.Name == e2.Name &&
+		e1.ParentFolderID == e2.ParentFolderID &&
+		e1.Created.Equal(e2.Created) &&
+		e1.Modified.Equal(e2.Modified) &&
+		e1.Size == e2.Size &&
+		e1.Hash == e2.Hash &&
+		e1.ContentHashAlgorithm == e2.ContentHashAlgorithm &&
+		e1.ContentHash == e2.ContentHash &&
+		e1.Kind == e2.Kind &&
+		e1.LinkTarget == e2.LinkTarget &&
+		e1.Mode == e2.Mode &&
+		e1.UID == e2.UID &&
+		e1.GID == e2.GID &&
+		sameXattrs(e1.Xattrs, e2.Xattrs)
+}
+
+// ListSnapshots returns the snapshots kept for the file system fsName, oldest first.
+func (m *Memory) ListSnapshots(_ context.Context, fsName FSName) ([]Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Snapshot{}, m.snapshots[fsName]...), nil
+}
+
+// FindSnapshot returns the latest snapshot of the file system fsName taken at or before the time
+// at, as per SQLite3.FindSnapshot.
+func (m *Memory) FindSnapshot(ctx context.Context, fsName FSName, at time.Time) (*Snapshot, error) {
+	snapshots, err := m.ListSnapshots(ctx, fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	return findSnapshotAt(fsName, snapshots, at)
+}
+
+// GetSnapshotEntries returns the entries of the file system fsName as of the snapshot, as per
+// SQLite3.GetSnapshotEntries.
+func (m *Memory) GetSnapshotEntries(_ context.Context, fsName FSName, snapshotID uint64) ([]FSEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.getSnapshotEntries(fsName, snapshotID)
+}
+
+func (m *Memory) getSnapshotEntries(fsName FSName, snapshotID uint64) ([]FSEntry, error) {
+	_, err := findSnapshotByID(fsName, m.snapshots[fsName], snapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[entryKey]FSEntry{}
+
+	for _, state := range m.snapshotEntries[fsName] {
+		if state.validFrom <= snapshotID && (state.validTo == 0 || state.validTo > snapshotID) {
+			entries[entryKey{deviceID: state.entry.DeviceID, entryID: state.entry.EntryID}] = state.entry
+		}
+	}
+
+	fsEntries := make([]FSEntry, 0, len(entries))
+	for _, key := range sortedEntryKeys(entries) {
+		fsEntries = append(fsEntries, cloneFSEntry(entries[key]))
+	}
+
+	return fsEntries, nil
+}
+
+// GetSnapshotMutations returns the mutations of the file system fsName between the snapshots
+// fromID and toID, as per SQLite3.GetSnapshotMutations.
+func (m *Memory) GetSnapshotMutations(_ context.Context, fsName FSName, fromID, toID uint64) (FSMutations, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fromEntries, err := m.getSnapshotEntries(fsName, fromID)
+	if err != nil {
+		return nil, err
+	}
+
+	toEntries, err := m.getSnapshotEntries(fsName, toID)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffSnapshotEntries(fromEntries, toEntries), nil
+}
+
+// GetPathLastChange returns the snapshot in which the entry at path last changed in the file
+// system fsName, as per SQLite3.GetPathLastChange.
+func (m *Memory) GetPathLastChange(_ context.Context, fsName FSName, path string) (*Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name := filepath.Base(path)
+
+	states := []snapshotEntryState{}
+	for _, state := range m.snapshotEntries[fsName] {
+		if state.entry.Name == name {
+			states = append(states, state)
+		}
+	}
+
+	lastChange, ok := lastChangeOfPath(path, states)
+	if !ok {
+		return nil, errors.Wrapf(sql.ErrNoRows, "no snapshot of file system '%s' holds '%s'", fsName, path)
+	}
+
+	snapshot, err := findSnapshotByID(fsName, m.snapshots[fsName], lastChange)
+	if err != nil {
+		return nil, err
+	}
+
+	result := *snapshot
+
+	return &result, nil
+}
@@ -0,0 +1,26 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Migration is a numbered, reversible change to a database schema.
+type Migration struct {
+	// Version is the number of the migration. The migrations of a schema are numbered from 1,
+	// in the order they apply: version 0 is the empty schema.
+	Version     int
+	Description string
+
+	// Up holds the statements that apply the migration and Down those that revert it.
+	Up   string
+	Down string
+}
+
+// Checksum returns the checksum of the Up statements of the migration.
+// It is recorded when the migration is applied so that a migration that was edited since can be
+// detected. The Down statements are not part of the checksum: they may be fixed after the fact.
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:])
+}
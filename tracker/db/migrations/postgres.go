@@ -0,0 +1,197 @@
+package migrations
+
+// Postgres holds the migrations for the PostgreSQL-based schema.
+// It starts from the schema that the SQLite3 migrations had reached when it was introduced.
+var Postgres = []Migration{
+	{
+		Version:     1,
+		Description: "schema of the SQLite3 migrations up to version 9",
+		Up: `	BEGIN;
+
+		CREATE TABLE IF NOT EXISTS "filesystem" (
+			"fs_name"                 VARCHAR NOT NULL,
+			"version"                 VARCHAR NOT NULL,
+			"device_id"               VARCHAR NOT NULL,
+			"entry_id"                VARCHAR NOT NULL,
+			"is_folder"               BOOLEAN NOT NULL DEFAULT FALSE,
+			"path"                    VARCHAR NOT NULL,
+			"name"                    VARCHAR NOT NULL,
+			"parent_folder_id"        VARCHAR NOT NULL,
+			"created"                 TIMESTAMPTZ NOT NULL,
+			"modified"                TIMESTAMPTZ NOT NULL,
+			"size"                    BIGINT NOT NULL DEFAULT 0,  -- only valid for files
+			"hash"                    VARCHAR NOT NULL DEFAULT '', -- only valid for files
+			"content_hash_algorithm"  VARCHAR NOT NULL DEFAULT '',
+			"content_hash"            VARCHAR NOT NULL DEFAULT '',
+			"kind"                    VARCHAR NOT NULL DEFAULT '', -- file, folder, symlink or other
+			"link_target"             VARCHAR NOT NULL DEFAULT '',
+			"mode"                    BIGINT NOT NULL DEFAULT 0,   -- POSIX permission bits
+			"uid"                     BIGINT NOT NULL DEFAULT 0,
+			"gid"                     BIGINT NOT NULL DEFAULT 0,
+			"xattrs"                  VARCHAR NOT NULL DEFAULT '', -- JSON object of the extended attributes
+
+			PRIMARY KEY (fs_name, version, device_id, entry_id)
+		);
+
+		CREATE INDEX IF NOT EXISTS filesystem_device_entry ON filesystem (device_id, entry_id);
+
+		CREATE TABLE IF NOT EXISTS "fs_info" (
+			"fs_name"     VARCHAR,
+			"fs_driver"   VARCHAR NOT NULL,
+			"fs_root"     VARCHAR NOT NULL,
+			"fs_changed"  BOOLEAN NOT NULL DEFAULT FALSE,
+			"fs_cursor"   BIGINT NOT NULL DEFAULT 0, -- position of the file system in its stream of changes
+
+			PRIMARY KEY ("fs_name")
+		);
+
+		CREATE TABLE IF NOT EXISTS "staging_fs_mutations" (
+			"mutation_type"     VARCHAR,
+			"fs_name"           VARCHAR,
+			"version"           VARCHAR,
+			"device_id"         VARCHAR,
+			"entry_id"          VARCHAR,
+
+			-- a file can both mutate and move
+			PRIMARY KEY (mutation_type, fs_name, version, device_id, entry_id)
+		);
+
+		CREATE INDEX IF NOT EXISTS staging_fs_mutations_fsname_version_device_entry ON staging_fs_mutations (fs_name, version, device_id, entry_id);
+
+		-- paths recorded as changed by a file system watcher since they were last walked
+		CREATE TABLE IF NOT EXISTS "dirty_paths" (
+			"seq"      BIGSERIAL PRIMARY KEY,
+			"fs_name"  VARCHAR NOT NULL,
+			"path"     VARCHAR NOT NULL, -- an empty path means the entire file system must be rescanned
+
+			UNIQUE (fs_name, path)
+		);
+
+		-- content hashes of the files of file systems where they are expensive to obtain
+		CREATE TABLE IF NOT EXISTS "content_hashes" (
+			"fs_name"                 VARCHAR NOT NULL,
+			"entry_id"                VARCHAR NOT NULL,
+			"hash"                    VARCHAR NOT NULL, -- the file system hash the content hash was obtained for
+			"content_hash_algorithm"  VARCHAR NOT NULL,
+			"content_hash"            VARCHAR NOT NULL,
+
+			PRIMARY KEY (fs_name, entry_id)
+		);
+
+		-- rules that determine which entries of a file system are tracked
+		CREATE TABLE IF NOT EXISTS "fs_filters" (
+			"fs_name"   VARCHAR,
+			"patterns"  VARCHAR NOT NULL DEFAULT '', -- gitignore-style patterns, one per line
+			"max_size"  BIGINT NOT NULL DEFAULT 0,   -- in bytes, 0 means no limit
+			"max_age"   BIGINT NOT NULL DEFAULT 0,   -- in seconds, 0 means no limit
+
+			PRIMARY KEY ("fs_name")
+		);
+
+		-- named roots of the file systems that are made of several folders, mapped into one virtual tree
+		CREATE TABLE IF NOT EXISTS "fs_roots" (
+			"fs_name"    VARCHAR NOT NULL,
+			"root_name"  VARCHAR NOT NULL, -- the name of the folder of the root in the virtual tree
+			"root_path"  VARCHAR NOT NULL,
+
+			PRIMARY KEY (fs_name, root_name)
+		);
+
+		-- pairs of file systems that are synced, from one to the other
+		CREATE TABLE IF NOT EXISTS "sync_pairs" (
+			"pair_name"    VARCHAR,
+			"from_fs"      VARCHAR NOT NULL,
+			"to_fs"        VARCHAR NOT NULL,
+			"sync_status"  VARCHAR NOT NULL DEFAULT 'Required', -- see db.SyncStatus
+
+			PRIMARY KEY ("pair_name"),
+			CHECK ("from_fs" != "to_fs")
+		);
+
+		-- the mutations between the file systems of a sync pair, matched by their path relative to
+		-- the root of their file system
+		CREATE TABLE IF NOT EXISTS "staging_cross_mutations" (
+			"pair_name"       VARCHAR NOT NULL,
+			"mutation_type"   VARCHAR NOT NULL,
+			"rel_path"        VARCHAR NOT NULL,
+			"from_device_id"  VARCHAR NULL, -- NULL when the entry only exists on the "to" file system
+			"from_entry_id"   VARCHAR NULL,
+			"to_device_id"    VARCHAR NULL, -- NULL when the entry only exists on the "from" file system
+			"to_entry_id"     VARCHAR NULL,
+
+			PRIMARY KEY (pair_name, mutation_type, rel_path)
+		);
+
+		COMMIT;`,
+		Down: `	BEGIN;
+
+		DROP TABLE IF EXISTS "staging_cross_mutations";
+		DROP TABLE IF EXISTS "sync_pairs";
+		DROP TABLE IF EXISTS "fs_roots";
+		DROP TABLE IF EXISTS "fs_filters";
+		DROP TABLE IF EXISTS "content_hashes";
+		DROP TABLE IF EXISTS "dirty_paths";
+		DROP TABLE IF EXISTS "staging_fs_mutations";
+		DROP TABLE IF EXISTS "fs_info";
+		DROP TABLE IF EXISTS "filesystem";
+
+		COMMIT;`,
+	},
+	{
+		Version:     2,
+		Description: "snapshot history of the file systems",
+		Up: `	BEGIN;
+
+		-- number of snapshots of the file system to keep, 0 means no history is kept
+		ALTER TABLE "fs_info" ADD COLUMN IF NOT EXISTS "snapshot_retention" BIGINT NOT NULL DEFAULT 0;
+
+		-- the snapshots of the "new" entries of the file systems, taken when refreshes are committed
+		CREATE TABLE IF NOT EXISTS "snapshots" (
+			"fs_name"      VARCHAR NOT NULL,
+			"snapshot_id"  BIGINT NOT NULL,
+			"taken_at"     TIMESTAMPTZ NOT NULL,
+
+			PRIMARY KEY (fs_name, snapshot_id)
+		);
+
+		-- the states of the entries of the snapshots, stored as deltas: a state holds from the
+		-- snapshot valid_from up to, but excluding, the snapshot valid_to
+		CREATE TABLE IF NOT EXISTS "snapshot_entries" (
+			"fs_name"                 VARCHAR NOT NULL,
+			"device_id"               VARCHAR NOT NULL,
+			"entry_id"                VARCHAR NOT NULL,
+			"valid_from"              BIGINT NOT NULL,
+			"valid_to"                BIGINT NULL, -- NULL while the state is current
+			"is_folder"               BOOLEAN NOT NULL DEFAULT FALSE,
+			"path"                    VARCHAR NOT NULL,
+			"name"                    VARCHAR NOT NULL,
+			"parent_folder_id"        VARCHAR NOT NULL,
+			"created"                 TIMESTAMPTZ NOT NULL,
+			"modified"                TIMESTAMPTZ NOT NULL,
+			"size"                    BIGINT NOT NULL DEFAULT 0,
+			"hash"                    VARCHAR NOT NULL DEFAULT '',
+			"content_hash_algorithm"  VARCHAR NOT NULL DEFAULT '',
+			"content_hash"            VARCHAR NOT NULL DEFAULT '',
+			"kind"                    VARCHAR NOT NULL DEFAULT '',
+			"link_target"             VARCHAR NOT NULL DEFAULT '',
+			"mode"                    BIGINT NOT NULL DEFAULT 0,
+			"uid"                     BIGINT NOT NULL DEFAULT 0,
+			"gid"                     BIGINT NOT NULL DEFAULT 0,
+			"xattrs"                  VARCHAR NOT NULL DEFAULT '',
+
+			PRIMARY KEY (fs_name, device_id, entry_id, valid_from)
+		);
+
+		CREATE INDEX IF NOT EXISTS snapshot_entries_fsname_name ON snapshot_entries (fs_name, name);
+
+		COMMIT;`,
+		Down: `	BEGIN;
+
+		DROP TABLE IF EXISTS "snapshot_entries";
+		DROP TABLE IF EXISTS "snapshots";
+
+		ALTER TABLE "fs_info" DROP COLUMN IF EXISTS "snapshot_retention";
+
+		COMMIT;`,
+	},
+}
@@ -1,8 +1,13 @@
 package migrations
 
 // SQLite3 holds the migrations for the sqlite3-based schema.
-var SQLite3 = []string{
-	`	BEGIN;
+// Caution: the Up statements of a migration must not be changed once released (see
+// Migration.Checksum). Changes to the schema are made with a new migration.
+var SQLite3 = []Migration{
+	{
+		Version:     1,
+		Description: "file system entries, their information and their mutations",
+		Up: `	BEGIN;
 
 		PRAGMA foreign_keys = ON;
 
@@ -63,4 +68,310 @@ var SQLite3 = []string{
 		CREATE INDEX IF NOT EXISTS staging_fs_mutations_fsname_version_device_entry ON staging_fs_mutations (fs_name, version, device_id, entry_id);
 
 		COMMIT;`,
+		Down: `	BEGIN;
+
+		DROP TABLE IF EXISTS "staging_fs_mutations";
+		DROP TABLE IF EXISTS "staging_cross_mutations";
+		DROP TABLE IF EXISTS "fs_info";
+		DROP TABLE IF EXISTS "filesystem";
+
+		COMMIT;`,
+	},
+	{
+		Version:     2,
+		Description: "file system cursor for incremental refreshes",
+		Up: `	BEGIN;
+
+		-- position of the file system in its stream of changes, for incremental refreshes
+		ALTER TABLE "fs_info" ADD COLUMN "fs_cursor" INTEGER NOT NULL DEFAULT 0;
+
+		COMMIT;`,
+		Down: `	BEGIN;
+
+		ALTER TABLE "fs_info" DROP COLUMN "fs_cursor";
+
+		COMMIT;`,
+	},
+	{
+		Version:     3,
+		Description: "dirty paths recorded by file system watchers",
+		Up: `	BEGIN;
+
+		-- paths recorded as changed by a file system watcher since they were last walked
+		CREATE TABLE IF NOT EXISTS "dirty_paths" (
+			"seq"      INTEGER PRIMARY KEY AUTOINCREMENT,
+			"fs_name"  VARCHAR NOT NULL,
+			"path"     VARCHAR NOT NULL, -- an empty path means the entire file system must be rescanned
+
+			UNIQUE (fs_name, path)
+		);
+
+		COMMIT;`,
+		Down: `	BEGIN;
+
+		DROP TABLE IF EXISTS "dirty_paths";
+
+		COMMIT;`,
+	},
+	{
+		Version:     4,
+		Description: "content hashes comparable across file systems",
+		Up: `	BEGIN;
+
+		-- hash of the contents of the files, comparable across file systems
+		ALTER TABLE "filesystem" ADD COLUMN "content_hash_algorithm" VARCHAR NOT NULL DEFAULT '';
+		ALTER TABLE "filesystem" ADD COLUMN "content_hash" VARCHAR NOT NULL DEFAULT '';
+
+		-- content hashes of the files of file systems where they are expensive to obtain
+		CREATE TABLE IF NOT EXISTS "content_hashes" (
+			"fs_name"                 VARCHAR NOT NULL,
+			"entry_id"                VARCHAR NOT NULL,
+			"hash"                    VARCHAR NOT NULL, -- the file system hash the content hash was obtained for
+			"content_hash_algorithm"  VARCHAR NOT NULL,
+			"content_hash"            VARCHAR NOT NULL,
+
+			PRIMARY KEY (fs_name, entry_id)
+		);
+
+		COMMIT;`,
+		Down: `	BEGIN;
+
+		DROP TABLE IF EXISTS "content_hashes";
+
+		ALTER TABLE "filesystem" DROP COLUMN "content_hash";
+		ALTER TABLE "filesystem" DROP COLUMN "content_hash_algorithm";
+
+		COMMIT;`,
+	},
+	{
+		Version:     5,
+		Description: "filter rules of the file systems",
+		Up: `	BEGIN;
+
+		-- rules that determine which entries of a file system are tracked
+		CREATE TABLE IF NOT EXISTS "fs_filters" (
+			"fs_name"   VARCHAR,
+			"patterns"  VARCHAR NOT NULL DEFAULT '', -- gitignore-style patterns, one per line
+			"max_size"  INTEGER NOT NULL DEFAULT 0,  -- in bytes, 0 means no limit
+			"max_age"   INTEGER NOT NULL DEFAULT 0,  -- in seconds, 0 means no limit
+
+			PRIMARY KEY ("fs_name")
+		);
+
+		COMMIT;`,
+		Down: `	BEGIN;
+
+		DROP TABLE IF EXISTS "fs_filters";
+
+		COMMIT;`,
+	},
+	{
+		Version:     6,
+		Description: "kind and metadata of the entries",
+		Up: `	BEGIN;
+
+		-- kind and metadata of the entries
+		ALTER TABLE "filesystem" ADD COLUMN "kind" VARCHAR NOT NULL DEFAULT ''; -- file, folder, symlink or other
+		ALTER TABLE "filesystem" ADD COLUMN "link_target" VARCHAR NOT NULL DEFAULT '';
+		ALTER TABLE "filesystem" ADD COLUMN "mode" INTEGER NOT NULL DEFAULT 0; -- POSIX permission bits
+		ALTER TABLE "filesystem" ADD COLUMN "uid" INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE "filesystem" ADD COLUMN "gid" INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE "filesystem" ADD COLUMN "xattrs" VARCHAR NOT NULL DEFAULT ''; -- JSON object of the extended attributes
+
+		UPDATE "filesystem" SET "kind" = CASE WHEN "is_folder" THEN 'folder' ELSE 'file' END;
+
+		COMMIT;`,
+		Down: `	BEGIN;
+
+		ALTER TABLE "filesystem" DROP COLUMN "xattrs";
+		ALTER TABLE "filesystem" DROP COLUMN "gid";
+		ALTER TABLE "filesystem" DROP COLUMN "uid";
+		ALTER TABLE "filesystem" DROP COLUMN "mode";
+		ALTER TABLE "filesystem" DROP COLUMN "link_target";
+		ALTER TABLE "filesystem" DROP COLUMN "kind";
+
+		COMMIT;`,
+	},
+	{
+		Version:     7,
+		Description: "named roots of the file systems",
+		Up: `	BEGIN;
+
+		-- named roots of the file systems that are made of several folders, mapped into one virtual tree
+		CREATE TABLE IF NOT EXISTS "fs_roots" (
+			"fs_name"    VARCHAR NOT NULL,
+			"root_name"  VARCHAR NOT NULL, -- the name of the folder of the root in the virtual tree
+			"root_path"  VARCHAR NOT NULL,
+
+			PRIMARY KEY (fs_name, root_name)
+		);
+
+		COMMIT;`,
+		Down: `	BEGIN;
+
+		DROP TABLE IF EXISTS "fs_roots";
+
+		COMMIT;`,
+	},
+	{
+		Version:     8,
+		Description: "sync pairs and their cross file system mutations",
+		Up: `	BEGIN;
+
+		-- fs_info referenced "filesystem"."fs_name", which is not unique and cannot be a foreign key
+		CREATE TABLE "fs_info_new" (
+			"fs_name"     VARCHAR,
+			"fs_driver"   VARCHAR NOT NULL,
+			"fs_root"     VARCHAR NOT NULL,
+			"fs_changed"  BOOL    DEFAULT FALSE,
+			"fs_cursor"   INTEGER NOT NULL DEFAULT 0,
+
+			PRIMARY KEY ("fs_name")
+		);
+
+		INSERT INTO "fs_info_new" (fs_name, fs_driver, fs_root, fs_changed, fs_cursor)
+		SELECT fs_name, COALESCE(fs_driver, ''), COALESCE(fs_root, ''), fs_changed, fs_cursor
+		  FROM "fs_info";
+
+		DROP TABLE "fs_info";
+		ALTER TABLE "fs_info_new" RENAME TO "fs_info";
+
+		-- pairs of file systems that are synced, from one to the other
+		CREATE TABLE IF NOT EXISTS "sync_pairs" (
+			"pair_name"  VARCHAR,
+			"from_fs"    VARCHAR NOT NULL,
+			"to_fs"      VARCHAR NOT NULL,
+
+			PRIMARY KEY ("pair_name"),
+			CHECK ("from_fs" != "to_fs")
+		);
+
+		-- the mutations between the file systems of a sync pair, matched by their path relative to
+		-- the root of their file system
+		DROP TABLE IF EXISTS "staging_cross_mutations";
+
+		CREATE TABLE "staging_cross_mutations" (
+			"pair_name"       VARCHAR NOT NULL,
+			"mutation_type"   VARCHAR NOT NULL,
+			"rel_path"        VARCHAR NOT NULL,
+			"from_device_id"  VARCHAR NULL, -- NULL when the entry only exists on the "to" file system
+			"from_entry_id"   VARCHAR NULL,
+			"to_device_id"    VARCHAR NULL, -- NULL when the entry only exists on the "from" file system
+			"to_entry_id"     VARCHAR NULL,
+
+			PRIMARY KEY (pair_name, mutation_type, rel_path)
+		);
+
+		COMMIT;`,
+		Down: `	BEGIN;
+
+		DROP TABLE IF EXISTS "staging_cross_mutations";
+
+		CREATE TABLE "staging_cross_mutations" (
+			"mutation_type"     VARCHAR,
+			"fs_name"           VARCHAR,
+			"device_id"         VARCHAR,
+			"entry_id"          VARCHAR,
+
+			-- a file can both mutate and move
+			PRIMARY KEY (mutation_type, fs_name, device_id, entry_id)
+		);
+
+		DROP TABLE IF EXISTS "sync_pairs";
+
+		CREATE TABLE "fs_info_old" (
+			"fs_name"     VARCHAR,
+			"fs_driver"   VARCHAR,
+			"fs_root"     VARCHAR,
+			"fs_changed"  BOOL      DEFAULT FALSE,
+			"fs_cursor"   INTEGER NOT NULL DEFAULT 0,
+
+			PRIMARY KEY ("fs_name"),
+
+			CONSTRAINT fk_fs_name
+			FOREIGN KEY(fs_name)
+			REFERENCES filesystem(fs_name)
+		);
+
+		INSERT INTO "fs_info_old" (fs_name, fs_driver, fs_root, fs_changed, fs_cursor)
+		SELECT fs_name, fs_driver, fs_root, fs_changed, fs_cursor
+		  FROM "fs_info";
+
+		DROP TABLE "fs_info";
+		ALTER TABLE "fs_info_old" RENAME TO "fs_info";
+
+		COMMIT;`,
+	},
+	{
+		Version:     9,
+		Description: "sync status of the sync pairs",
+		Up: `	BEGIN;
+
+		-- the status of the sync of each pair (see db.SyncStatus)
+		ALTER TABLE "sync_pairs" ADD COLUMN "sync_status" VARCHAR NOT NULL DEFAULT 'Required';
+
+		COMMIT;`,
+		Down: `	BEGIN;
+
+		ALTER TABLE "sync_pairs" DROP COLUMN "sync_status";
+
+		COMMIT;`,
+	},
+	{
+		Version:     10,
+		Description: "snapshot history of the file systems",
+		Up: `	BEGIN;
+
+		-- number of snapshots of the file system to keep, 0 means no history is kept
+		ALTER TABLE "fs_info" ADD COLUMN "snapshot_retention" INTEGER NOT NULL DEFAULT 0;
+
+		-- the snapshots of the "new" entries of the file systems, taken when refreshes are committed
+		CREATE TABLE IF NOT EXISTS "snapshots" (
+			"fs_name"      VARCHAR NOT NULL,
+			"snapshot_id"  INTEGER NOT NULL,
+			"taken_at"     DATETIME NOT NULL,
+
+			PRIMARY KEY (fs_name, snapshot_id)
+		);
+
+		-- the states of the entries of the snapshots, stored as deltas: a state holds from the
+		-- snapshot valid_from up to, but excluding, the snapshot valid_to
+		CREATE TABLE IF NOT EXISTS "snapshot_entries" (
+			"fs_name"                 VARCHAR NOT NULL,
+			"device_id"               VARCHAR NOT NULL,
+			"entry_id"                VARCHAR NOT NULL,
+			"valid_from"              INTEGER NOT NULL,
+			"valid_to"                INTEGER NULL, -- NULL while the state is current
+			"is_folder"               BOOL DEFAULT FALSE,
+			"path"                    VARCHAR NOT NULL,
+			"name"                    VARCHAR NOT NULL,
+			"parent_folder_id"        VARCHAR NOT NULL,
+			"created"                 DATETIME NOT NULL,
+			"modified"                DATETIME NOT NULL,
+			"size"                    INTEGER NULL,
+			"hash"                    VARCHAR NULL,
+			"content_hash_algorithm"  VARCHAR NOT NULL DEFAULT '',
+			"content_hash"            VARCHAR NOT NULL DEFAULT '',
+			"kind"                    VARCHAR NOT NULL DEFAULT '',
+			"link_target"             VARCHAR NOT NULL DEFAULT '',
+			"mode"                    INTEGER NOT NULL DEFAULT 0,
+			"uid"                     INTEGER NOT NULL DEFAULT 0,
+			"gid"                     INTEGER NOT NULL DEFAULT 0,
+			"xattrs"                  VARCHAR NOT NULL DEFAULT '',
+
+			PRIMARY KEY (fs_name, device_id, entry_id, valid_from)
+		);
+
+		CREATE INDEX IF NOT EXISTS snapshot_entries_fsname_name ON snapshot_entries (fs_name, name);
+
+		COMMIT;`,
+		Down: `	BEGIN;
+
+		DROP TABLE IF EXISTS "snapshot_entries";
+		DROP TABLE IF EXISTS "snapshots";
+
+		ALTER TABLE "fs_info" DROP COLUMN "snapshot_retention";
+
+		COMMIT;`,
+	},
 }
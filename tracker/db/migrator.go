@@ -4,76 +4,181 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"io"
 
 	// sqllite3 sql driver.
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
 
 	"github.com/seborama/pcloud-sdk/tracker/db/migrations"
 )
 
+var (
+	// ErrMigrationInProgress is returned when a migration was interrupted, such as by a crash.
+	// Whether its statements were committed must be checked by hand and the migration resolved
+	// with Migrator.Recover.
+	ErrMigrationInProgress = errors.New("a database migration is recorded in progress")
+
+	// ErrChecksumMismatch is returned when an applied migration was changed since.
+	ErrChecksumMismatch = errors.New("the checksum of an applied database migration does not match")
+)
+
+// Migrator applies and reverts the migrations of a database schema.
+// The applied migrations are recorded in the "schema_history" table with their checksum.
 type Migrator struct {
-	db *sql.DB
+	db              *sql.DB
+	migrations      []migrations.Migration
+	tableExistsStmt string // counts the tables named $1
+	logger          *zap.Logger
+	dryRun          io.Writer
 }
 
-// NewMigrator creates a new initialised Migrator struct.
-func NewMigrator(db *sql.DB) *Migrator {
-	return &Migrator{
-		db: db,
+// MigratorOption is a functional option for the Migrator.
+type MigratorOption func(*Migrator)
+
+// WithMigrationLogger sets the logger that reports the progress of the migrations.
+// By default, nothing is reported.
+func WithMigrationLogger(logger *zap.Logger) MigratorOption {
+	return func(m *Migrator) {
+		m.logger = logger
 	}
 }
 
-func (m *Migrator) MigrateUp(ctx context.Context) (err error) {
-	stmt := `
-		BEGIN;
-		CREATE TABLE IF NOT EXISTS "schema_migrations" ( "version" INTEGER PRIMARY KEY, "status" VARCHAR );
-		COMMIT;`
+// WithDryRun makes the Migrator write the statements of the migrations it would apply or revert
+// to w rather than execute them. The database is left untouched.
+func WithDryRun(w io.Writer) MigratorOption {
+	return func(m *Migrator) {
+		m.dryRun = w
+	}
+}
 
-	_, err = m.db.ExecContext(ctx, stmt)
+// NewMigrator creates a new initialised Migrator struct for the sqlite3-based schema.
+func NewMigrator(db *sql.DB, opts ...MigratorOption) *Migrator {
+	return newMigrator(
+		db,
+		migrations.SQLite3,
+		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1`,
+		opts...,
+	)
+}
+
+// NewPostgresMigrator creates a new initialised Migrator struct for the PostgreSQL-based schema.
+func NewPostgresMigrator(db *sql.DB, opts ...MigratorOption) *Migrator {
+	return newMigrator(
+		db,
+		migrations.Postgres,
+		`SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1`,
+		opts...,
+	)
+}
+
+func newMigrator(db *sql.DB, migrations []migrations.Migration, tableExistsStmt string, opts ...MigratorOption) *Migrator {
+	m := &Migrator{
+		db:              db,
+		migrations:      migrations,
+		tableExistsStmt: tableExistsStmt,
+		logger:          zap.NewNop(),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+type migrationStatus string
+
+const (
+	migrationStatusApplying  migrationStatus = "applying"
+	migrationStatusReverting migrationStatus = "reverting"
+	migrationStatusApplied   migrationStatus = "applied"
+
+	// legacyMigrationStatusInProgress is the status of an interrupted migration in the
+	// "schema_migrations" table that preceded "schema_history".
+	legacyMigrationStatusInProgress migrationStatus = "in progress"
+)
+
+// migrationRecord is a row of the "schema_history" table.
+type migrationRecord struct {
+	version  int
+	checksum string
+	status   migrationStatus
+}
+
+// LatestVersion returns the version of the latest migration available.
+func (m *Migrator) LatestVersion() int {
+	return len(m.migrations)
+}
+
+// Version returns the version of the latest migration applied to the database, 0 when none
+// have been applied.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	history, err := m.loadHistory(ctx)
 	if err != nil {
-		return errors.WithStack(err)
+		return 0, err
 	}
 
 	version := 0
-	var status migrationStatus
 
-	err = m.db.QueryRowContext(ctx, `SELECT version, status FROM "schema_migrations"`).Scan(&version, &status)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return errors.WithStack(err)
+	for _, record := range history {
+		if record.status == migrationStatusApplied {
+			version = record.version
+		}
 	}
 
-	if status == migrationStatusInProgress {
-		return errors.Errorf("unable to apply database migration: migration version %d is recorded 'in progress', probably as the result of a previous failure", version)
-	}
+	return version, nil
+}
+
+// MigrateUp applies all the migrations that are yet to be applied to the database.
+func (m *Migrator) MigrateUp(ctx context.Context) error {
+	return m.MigrateTo(ctx, m.LatestVersion())
+}
 
-	if version == 0 {
-		version = -1
+// MigrateTo applies or reverts the migrations of the database until it reaches the version.
+// Each migration is recorded "applying" or "reverting" while its statements execute so that an
+// interruption is detected on the next run (see ErrMigrationInProgress and Recover).
+// It fails with ErrChecksumMismatch when an applied migration was edited since.
+func (m *Migrator) MigrateTo(ctx context.Context, version int) error {
+	if version < 0 || version > m.LatestVersion() {
+		return errors.Errorf("unknown migration version %d: available versions are 0 to %d", version, m.LatestVersion())
 	}
-	fmt.Printf("current migrations version: %d\n", version)
 
-	if version == len(migrations.SQLite3) {
-		fmt.Printf("migrations up-to-date - version: %d\n", version)
-		return nil
+	history, err := m.loadHistory(ctx)
+	if err != nil {
+		return err
 	}
 
-	if version > len(migrations.SQLite3) {
-		return errors.Errorf("migrations corruption - last executed version: %d - highest available migration version: %d\n", version, len(migrations.SQLite3))
+	err = m.validateHistory(history)
+	if err != nil {
+		return err
 	}
 
-	for version, stmt := range migrations.SQLite3[version+1:] {
-		fmt.Printf("applying migrations version: %d\n", version)
+	current := len(history)
+	m.logger.Info("current schema version", zap.Int("version", current), zap.Int("target_version", version))
 
-		err = m.recordMigrationVersion(ctx, version, migrationStatusInProgress)
+	if current == version {
+		m.logger.Info("schema up-to-date", zap.Int("version", current))
+		return nil
+	}
+
+	if m.dryRun == nil {
+		err = m.persistHistoryTable(ctx)
 		if err != nil {
 			return err
 		}
+	}
 
-		_, err = m.db.ExecContext(ctx, stmt)
+	for v := current + 1; v <= version; v++ {
+		err = m.migrate(ctx, m.migrations[v-1], true)
 		if err != nil {
-			return errors.WithStack(err)
+			return err
 		}
+	}
 
-		err = m.recordMigrationVersion(ctx, version, migrationStatusApplied)
+	for v := current; v > version; v-- {
+		err = m.migrate(ctx, m.migrations[v-1], false)
 		if err != nil {
 			return err
 		}
@@ -82,25 +187,268 @@ func (m *Migrator) MigrateUp(ctx context.Context) (err error) {
 	return nil
 }
 
-type migrationStatus string
+// Recover resolves the migration that was interrupted while it was being applied or reverted
+// (see ErrMigrationInProgress).
+// committed reports whether the statements of the migration were committed, which must be
+// checked by hand: each migration executes in a single transaction, so either all of its
+// statements were committed or none were.
+// It returns the version of the interrupted migration.
+func (m *Migrator) Recover(ctx context.Context, committed bool) (int, error) {
+	history, err := m.loadHistory(ctx)
+	if err != nil {
+		return 0, err
+	}
 
-const (
-	migrationStatusInProgress migrationStatus = "in progress"
-	migrationStatusApplied    migrationStatus = "applied"
-)
+	if len(history) == 0 || history[len(history)-1].status == migrationStatusApplied {
+		return 0, errors.New("no database migration is recorded in progress")
+	}
+
+	record := history[len(history)-1]
+
+	if record.version > m.LatestVersion() {
+		return 0, errors.Errorf("migrations corruption: version %d is recorded but the highest available migration version is %d", record.version, m.LatestVersion())
+	}
+
+	applied := committed
+	if record.status == migrationStatusReverting {
+		applied = !committed
+	}
+
+	m.logger.Info("recovering interrupted migration", zap.Int("version", record.version), zap.String("status", string(record.status)), zap.Bool("applied", applied))
+
+	if m.dryRun != nil {
+		_, err = fmt.Fprintf(m.dryRun, "-- version %d: %s, recorded '%s', is resolved as applied: %t\n", record.version, m.migrations[record.version-1].Description, record.status, applied)
+		return record.version, errors.WithStack(err)
+	}
+
+	err = m.persistHistoryTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if applied {
+		err = m.recordMigration(ctx, m.migrations[record.version-1], migrationStatusApplied)
+	} else {
+		err = m.deleteMigrationRecord(ctx, record.version)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return record.version, nil
+}
+
+func (m *Migrator) migrate(ctx context.Context, migration migrations.Migration, up bool) error {
+	stmt, status, direction := migration.Up, migrationStatusApplying, "up"
+	if !up {
+		stmt, status, direction = migration.Down, migrationStatusReverting, "down"
+	}
+
+	if m.dryRun != nil {
+		_, err := fmt.Fprintf(m.dryRun, "-- version %d (%s): %s\n%s\n\n", migration.Version, direction, migration.Description, stmt)
+		return errors.WithStack(err)
+	}
+
+	m.logger.Info("migrating schema", zap.Int("version", migration.Version), zap.String("direction", direction), zap.String("description", migration.Description))
+
+	err := m.recordMigration(ctx, migration, status)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.db.ExecContext(ctx, stmt)
+	if err != nil {
+		return errors.Wrapf(err, "migration version %d (%s)", migration.Version, direction)
+	}
+
+	if up {
+		return m.recordMigration(ctx, migration, migrationStatusApplied)
+	}
+
+	return m.deleteMigrationRecord(ctx, migration.Version)
+}
+
+// validateHistory checks that the applied migrations are known and unchanged, and that none is
+// in progress.
+func (m *Migrator) validateHistory(history []migrationRecord) error {
+	for i, record := range history {
+		if record.version != i+1 {
+			return errors.Errorf("migrations corruption: version %d is recorded without version %d", record.version, i+1)
+		}
+
+		if record.version > m.LatestVersion() {
+			return errors.Errorf("migrations corruption: version %d is recorded but the highest available migration version is %d", record.version, m.LatestVersion())
+		}
+
+		if record.status != migrationStatusApplied {
+			return errors.Wrapf(ErrMigrationInProgress, "migration version %d is recorded '%s', probably as the result of a previous failure", record.version, record.status)
+		}
+
+		if record.checksum != m.migrations[i].Checksum() {
+			return errors.Wrapf(ErrChecksumMismatch, "migration version %d", record.version)
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) tableExists(ctx context.Context, table string) (bool, error) {
+	var n int
+
+	err := m.db.QueryRowContext(ctx, m.tableExistsStmt, table).Scan(&n)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return n > 0, nil
+}
+
+// loadHistory returns the migrations recorded in the database, sorted by version.
+// The history of a database that predates the "schema_history" table is read from its
+// "schema_migrations" table.
+func (m *Migrator) loadHistory(ctx context.Context) ([]migrationRecord, error) {
+	exists, err := m.tableExists(ctx, "schema_history")
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return m.loadLegacyHistory(ctx)
+	}
+
+	// nolint: rowserrcheck
+	rows, err := m.db.QueryContext(
+		ctx,
+		`SELECT version, checksum, status
+		 FROM "schema_history"
+		 ORDER BY version`,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	history := []migrationRecord{}
+
+	for rows.Next() {
+		record := migrationRecord{}
+
+		err = rows.Scan(&record.version, &record.checksum, &record.status)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		history = append(history, record)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return history, nil
+}
+
+// loadLegacyHistory returns the migrations recorded in the "schema_migrations" table, which
+// held a single row with the index of the last migration attempted and its status.
+// Its migrations carried no checksum: they are assumed unchanged.
+func (m *Migrator) loadLegacyHistory(ctx context.Context) ([]migrationRecord, error) {
+	exists, err := m.tableExists(ctx, "schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return []migrationRecord{}, nil
+	}
+
+	var (
+		index  int
+		status migrationStatus
+	)
+
+	err = m.db.QueryRowContext(ctx, `SELECT version, status FROM "schema_migrations"`).Scan(&index, &status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return []migrationRecord{}, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	history := []migrationRecord{}
+
+	// the index of a migration is one less than its version.
+	for v := 1; v <= index+1; v++ {
+		record := migrationRecord{
+			version: v,
+			status:  migrationStatusApplied,
+		}
+
+		if v <= m.LatestVersion() {
+			record.checksum = m.migrations[v-1].Checksum()
+		}
+
+		history = append(history, record)
+	}
+
+	if status == legacyMigrationStatusInProgress && len(history) > 0 {
+		history[len(history)-1].status = migrationStatusApplying
+	}
+
+	return history, nil
+}
+
+// persistHistoryTable creates the "schema_history" table, if it does not exist yet, and moves
+// the history of the "schema_migrations" table to it.
+func (m *Migrator) persistHistoryTable(ctx context.Context) error {
+	exists, err := m.tableExists(ctx, "schema_history")
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	history, err := m.loadLegacyHistory(ctx)
+	if err != nil {
+		return err
+	}
 
-func (m *Migrator) recordMigrationVersion(ctx context.Context, version int, status migrationStatus) error {
 	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	_, err = tx.ExecContext(ctx, `DELETE FROM "schema_migrations"`)
+	_, err = tx.ExecContext(
+		ctx,
+		`CREATE TABLE "schema_history" (
+			"version"   INTEGER,
+			"checksum"  VARCHAR NOT NULL,
+			"status"    VARCHAR NOT NULL,
+
+			PRIMARY KEY ("version")
+		)`,
+	)
 	if err != nil {
 		return doRollback(tx, err)
 	}
 
-	_, err = tx.ExecContext(ctx, `INSERT INTO "schema_migrations" VALUES (?, ?)`, version, status)
+	for _, record := range history {
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO "schema_history" (version, checksum, status)
+			 VALUES ($1, $2, $3)`,
+			record.version,
+			record.checksum,
+			record.status,
+		)
+		if err != nil {
+			return doRollback(tx, err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DROP TABLE IF EXISTS "schema_migrations"`)
 	if err != nil {
 		return doRollback(tx, err)
 	}
@@ -112,3 +460,30 @@ func (m *Migrator) recordMigrationVersion(ctx context.Context, version int, stat
 
 	return nil
 }
+
+func (m *Migrator) recordMigration(ctx context.Context, migration migrations.Migration, status migrationStatus) error {
+	_, err := m.db.ExecContext(
+		ctx,
+		`INSERT INTO "schema_history" (version, checksum, status)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (version)
+		 DO UPDATE SET checksum = excluded.checksum,
+		               status = excluded.status`,
+		migration.Version,
+		migration.Checksum(),
+		status,
+	)
+
+	return errors.WithStack(err)
+}
+
+func (m *Migrator) deleteMigrationRecord(ctx context.Context, version int) error {
+	_, err := m.db.ExecContext(
+		ctx,
+		`DELETE FROM "schema_history"
+		 WHERE version = $1`,
+		version,
+	)
+
+	return errors.WithStack(err)
+}
@@ -0,0 +1,221 @@
+package db_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-sdk/tracker/db"
+	"github.com/seborama/pcloud-sdk/tracker/db/migrations"
+)
+
+// openMigratorFixture opens an empty sqlite3 database, without migrating it.
+// It returns the database and its folder.
+func openMigratorFixture(t *testing.T) (*sql.DB, string) {
+	dbPath := t.TempDir()
+
+	sqlDB, err := sql.Open("sqlite3", filepath.Join(dbPath, db.SQLite3Filename))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	return sqlDB, dbPath
+}
+
+func tableNames(ctx context.Context, t *testing.T, sqlDB *sql.DB) []string {
+	rows, err := sqlDB.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	require.NoError(t, err)
+	defer func() { _ = rows.Close() }()
+
+	names := []string{}
+
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	require.NoError(t, rows.Err())
+
+	return names
+}
+
+func TestMigrator_UpAndDown(t *testing.T) {
+	ctx := context.Background()
+	sqlDB, dbPath := openMigratorFixture(t)
+	migrator := db.NewMigrator(sqlDB)
+
+	err := migrator.MigrateUp(ctx)
+	require.NoError(t, err)
+
+	version, err := migrator.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, len(migrations.SQLite3), version)
+	require.Equal(t, len(migrations.SQLite3), migrator.LatestVersion())
+
+	// running again is a no-op.
+	err = migrator.MigrateUp(ctx)
+	require.NoError(t, err)
+
+	_, err = sqlDB.ExecContext(ctx, `INSERT INTO "fs_info" (fs_name, fs_driver, fs_root, fs_changed, fs_cursor) VALUES ('local_fs', 'Local', '/data', TRUE, 42)`)
+	require.NoError(t, err)
+
+	_, err = sqlDB.ExecContext(ctx, `INSERT INTO "sync_pairs" (pair_name, from_fs, to_fs) VALUES ('pair1', 'local_fs', 'pcloud_fs')`)
+	require.NoError(t, err)
+
+	// version 8 introduced the sync pairs and rebuilt "fs_info": reverting it retains the file systems.
+	err = migrator.MigrateTo(ctx, 7)
+	require.NoError(t, err)
+
+	version, err = migrator.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 7, version)
+	require.NotContains(t, tableNames(ctx, t, sqlDB), "sync_pairs")
+
+	var fsCursor int
+	err = sqlDB.QueryRowContext(ctx, `SELECT fs_cursor FROM "fs_info" WHERE fs_name = 'local_fs'`).Scan(&fsCursor)
+	require.NoError(t, err)
+	require.Equal(t, 42, fsCursor)
+
+	err = migrator.MigrateTo(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"schema_history"}, tableNames(ctx, t, sqlDB))
+
+	err = migrator.MigrateUp(ctx)
+	require.NoError(t, err)
+
+	store, err := db.NewSQLite3(ctx, dbPath)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	err = store.RegisterFileSystem(ctx, "local_fs", db.FSDriverLocal, "/data")
+	require.NoError(t, err)
+
+	err = migrator.MigrateTo(ctx, len(migrations.SQLite3)+1)
+	require.Error(t, err)
+
+	err = migrator.MigrateTo(ctx, -1)
+	require.Error(t, err)
+}
+
+func TestMigrator_DryRun(t *testing.T) {
+	ctx := context.Background()
+	sqlDB, _ := openMigratorFixture(t)
+
+	err := db.NewMigrator(sqlDB).MigrateTo(ctx, 2)
+	require.NoError(t, err)
+
+	out := &bytes.Buffer{}
+
+	err = db.NewMigrator(sqlDB, db.WithDryRun(out)).MigrateTo(ctx, 3)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "-- version 3 (up): "+migrations.SQLite3[2].Description)
+	require.Contains(t, out.String(), migrations.SQLite3[2].Up)
+	require.NotContains(t, tableNames(ctx, t, sqlDB), "dirty_paths")
+
+	out.Reset()
+
+	err = db.NewMigrator(sqlDB, db.WithDryRun(out)).MigrateTo(ctx, 0)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "-- version 2 (down)")
+	require.Contains(t, out.String(), "-- version 1 (down)")
+
+	version, err := db.NewMigrator(sqlDB).Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, version)
+}
+
+func TestMigrator_Checksum(t *testing.T) {
+	ctx := context.Background()
+	sqlDB, _ := openMigratorFixture(t)
+	migrator := db.NewMigrator(sqlDB)
+
+	err := migrator.MigrateTo(ctx, 3)
+	require.NoError(t, err)
+
+	// the migration was edited after it was applied.
+	_, err = sqlDB.ExecContext(ctx, `UPDATE "schema_history" SET checksum = 'edited' WHERE version = 2`)
+	require.NoError(t, err)
+
+	err = migrator.MigrateUp(ctx)
+	require.ErrorIs(t, err, db.ErrChecksumMismatch)
+
+	version, err := migrator.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, version)
+}
+
+func TestMigrator_LegacyHistoryAndRecovery(t *testing.T) {
+	ctx := context.Background()
+	sqlDB, dbPath := openMigratorFixture(t)
+
+	// a database migrated by the former migrator, which recorded the index of the last migration
+	// it attempted: it was interrupted while it applied the 4th migration.
+	for _, migration := range migrations.SQLite3[:3] {
+		_, err := sqlDB.ExecContext(ctx, migration.Up)
+		require.NoError(t, err)
+	}
+
+	_, err := sqlDB.ExecContext(ctx, `CREATE TABLE "schema_migrations" ( "version" INTEGER PRIMARY KEY, "status" VARCHAR )`)
+	require.NoError(t, err)
+
+	_, err = sqlDB.ExecContext(ctx, `INSERT INTO "schema_migrations" VALUES (3, 'in progress')`)
+	require.NoError(t, err)
+
+	migrator := db.NewMigrator(sqlDB)
+
+	version, err := migrator.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, version)
+
+	err = migrator.MigrateUp(ctx)
+	require.ErrorIs(t, err, db.ErrMigrationInProgress)
+
+	_, err = db.NewSQLite3(ctx, dbPath)
+	require.ErrorIs(t, err, db.ErrMigrationInProgress)
+
+	out := &bytes.Buffer{}
+	v, err := db.NewMigrator(sqlDB, db.WithDryRun(out)).Recover(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, 4, v)
+	require.Contains(t, out.String(), "-- version 4")
+	require.Contains(t, tableNames(ctx, t, sqlDB), "schema_migrations")
+
+	// the 4th migration was not committed: it is applied again.
+	v, err = migrator.Recover(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, 4, v)
+
+	tables := tableNames(ctx, t, sqlDB)
+	require.Contains(t, tables, "schema_history")
+	require.NotContains(t, tables, "schema_migrations")
+
+	_, err = migrator.Recover(ctx, false)
+	require.Error(t, err)
+
+	err = migrator.MigrateUp(ctx)
+	require.NoError(t, err)
+
+	version, err = migrator.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, len(migrations.SQLite3), version)
+
+	// a migration interrupted while it was reverted and whose statements were committed.
+	_, err = sqlDB.ExecContext(ctx, `UPDATE "schema_history" SET status = 'reverting' WHERE version = $1`, version)
+	require.NoError(t, err)
+	_, err = sqlDB.ExecContext(ctx, migrations.SQLite3[version-1].Down)
+	require.NoError(t, err)
+
+	v, err = migrator.Recover(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, version, v)
+
+	version, err = migrator.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, len(migrations.SQLite3)-1, version)
+
+	err = migrator.MigrateUp(ctx)
+	require.NoError(t, err)
+}
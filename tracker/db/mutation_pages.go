@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// MutationCursor is the position of a mutation in the mutations of a file system, which are
+// sorted by type then by device and entry ID (see GetFileSystemMutations).
+// Its fields are exported so that it can be persisted to resume reading the mutations later.
+type MutationCursor struct {
+	Type     MutationType
+	DeviceID string
+	EntryID  uint64
+}
+
+// Cursor returns the position of the mutation in the mutations of its file system.
+func (m FSMutation) Cursor() MutationCursor {
+	if len(m.Details) == 0 {
+		return MutationCursor{Type: m.Type}
+	}
+
+	return MutationCursor{
+		Type:     m.Type,
+		DeviceID: m.Details[0].DeviceID,
+		EntryID:  m.Details[0].EntryID,
+	}
+}
+
+// nextMutationCursor returns the cursor that follows a page of mutations: nil when the page is
+// not full, which means there are no more mutations.
+func nextMutationCursor(fsMutations FSMutations, limit int) *MutationCursor {
+	if len(fsMutations) == 0 || len(fsMutations) < limit {
+		return nil
+	}
+
+	cursor := fsMutations[len(fsMutations)-1].Cursor()
+
+	return &cursor
+}
+
+// GetFileSystemMutationsPage returns at most limit mutations of the file system fsName that
+// follow the cursor after, or from the first mutation when after is nil, in the order of
+// GetFileSystemMutations. It also returns the cursor to pass to read the next page, which is
+// nil once all the mutations have been read.
+// The pages are consistent with each other as long as the file system is not refreshed in
+// between.
+func (s *SQLite3) GetFileSystemMutationsPage(ctx context.Context, fsName FSName, after *MutationCursor, limit int) (FSMutations, *MutationCursor, error) {
+	if limit <= 0 {
+		return nil, nil, errors.Errorf("invalid page size %d", limit)
+	}
+
+	cursor := MutationCursor{}
+	if after != nil {
+		cursor = *after
+	}
+
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`WITH page AS (SELECT mutation_type, fs_name, device_id, entry_id
+		                 FROM staging_fs_mutations
+		                WHERE fs_name = :fs_name
+		                  AND (:first
+		                       OR mutation_type > :mutation_type
+		                       OR (mutation_type = :mutation_type AND device_id > :device_id)
+		                       OR (mutation_type = :mutation_type AND device_id = :device_id AND entry_id > :entry_id))
+		                ORDER BY mutation_type, device_id, entry_id
+		                LIMIT :limit)
+		SELECT scm.mutation_type,
+			    fs.fs_name,
+			    fs.version,
+			    fs.device_id,
+			    fs.entry_id,
+			    fs.is_folder,
+			    fs.path,
+			    fs.name,
+			    fs.parent_folder_id,
+			    fs.created,
+			    fs.modified,
+			    fs.size,
+			    fs.hash,
+			    fs.content_hash_algorithm,
+			    fs.content_hash,
+			    fs.kind,
+			    fs.link_target,
+			    fs.mode,
+			    fs.uid,
+			    fs.gid,
+			    fs.xattrs
+		 FROM page scm
+			  LEFT OUTER JOIN filesystem fs
+			  ON scm.fs_name = fs.fs_name
+			  	 AND scm.device_id = fs.device_id
+			  	 AND scm.entry_id = fs.entry_id
+			  	 -- the entries of a refresh in progress are not part of the mutations
+			  	 AND fs.version != :version_staging
+		 ORDER BY scm.mutation_type, fs.device_id, fs.entry_id, fs.version DESC`, // `fs.version DESC`: `Previous` before `New`
+		sql.Named("fs_name", fsName),
+		sql.Named("first", after == nil),
+		sql.Named("mutation_type", cursor.Type),
+		sql.Named("device_id", cursor.DeviceID),
+		sql.Named("entry_id", fmt.Sprintf("%d", cursor.EntryID)),
+		sql.Named("limit", limit),
+		sql.Named("version_staging", VersionStaging),
+	)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	fsMutations, err := processFSMutationsRows(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return fsMutations, nextMutationCursor(fsMutations, limit), nil
+}
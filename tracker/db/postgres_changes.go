@@ -0,0 +1,353 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// SeedVersionStaging replaces the "staging" file system entries for the specified file system
+// with a copy of its "new" entries.
+func (s *Postgres) SeedVersionStaging(ctx context.Context, fsName FSName) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.deleteVersion(ctx, tx, fsName, VersionStaging)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO "filesystem"
+			(fs_name, version, device_id, entry_id, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs)
+		 SELECT fs_name, $1, device_id, entry_id, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs
+		 FROM "filesystem"
+		 WHERE version = $2
+		   AND fs_name = $3`,
+		VersionStaging,
+		VersionNew,
+		fsName,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// ApplyStagingFileSystemChanges applies the changes to the "staging" file system entries for the
+// specified file system, as per SQLite3.ApplyStagingFileSystemChanges.
+// The changes are applied in a single transaction: either all apply or none do.
+func (s *Postgres) ApplyStagingFileSystemChanges(ctx context.Context, fsName FSName, changes []FSChange, filter EntryFilter) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	rootID, err := s.findRootFolderID(ctx, tx, fsName, VersionStaging)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	for _, change := range changes {
+		err = s.applyFileSystemChange(ctx, tx, fsName, VersionStaging, rootID, change, filter)
+		if err != nil {
+			return doRollback(tx, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// findRootFolderID returns the ID of the root folder of the version of the file system: that is
+// the only folder whose parent is not in the file system.
+func (s *Postgres) findRootFolderID(ctx context.Context, tx *sql.Tx, fsName FSName, version Version) (uint64, error) {
+	var rootID uint64
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT f.entry_id
+		 FROM "filesystem" f
+		 WHERE f.fs_name = $1
+		   AND f.version = $2
+		   AND f.is_folder
+		   AND NOT EXISTS (SELECT 1
+		                   FROM "filesystem" p
+		                   WHERE p.fs_name = f.fs_name
+		                     AND p.version = f.version
+		                     AND p.is_folder
+		                     AND p.entry_id = f.parent_folder_id
+		                     AND p.entry_id != f.entry_id)
+		 ORDER BY f.device_id COLLATE "C", f.entry_id COLLATE "C"
+		 LIMIT 1`,
+		fsName,
+		version,
+	).Scan(&rootID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errors.Wrapf(ErrResyncRequired, "no root folder found for file system '%s'", fsName)
+		}
+		return 0, errors.WithStack(err)
+	}
+
+	return rootID, nil
+}
+
+// nolint: gocognit
+func (s *Postgres) applyFileSystemChange(ctx context.Context, tx *sql.Tx, fsName FSName, version Version, rootID uint64, change FSChange, filter EntryFilter) error {
+	entry := change.Entry
+	entry.FSName = fsName
+
+	if change.ReplacedEntryID != 0 {
+		replaced, err := s.findEntry(ctx, tx, fsName, version, entry.DeviceID, change.ReplacedEntryID, false)
+		if err != nil {
+			return err
+		}
+		if replaced != nil {
+			err = s.deleteEntry(ctx, tx, version, replaced)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	existing, err := s.findEntry(ctx, tx, fsName, version, entry.DeviceID, entry.EntryID, entry.IsFolder)
+	if err != nil {
+		return err
+	}
+
+	if change.Type == FSChangeTypeDeleted {
+		if existing == nil {
+			return nil
+		}
+		return s.deleteEntry(ctx, tx, version, existing)
+	}
+
+	if entry.IsFolder && entry.EntryID == rootID {
+		// the root folder is the reference point of the file system: it does not move.
+		entry.Path = existing.Path
+		entry.Name = existing.Name
+		entry.ParentFolderID = existing.ParentFolderID
+		return s.upsertEntry(ctx, tx, version, entry)
+	}
+
+	parent, err := s.findEntry(ctx, tx, fsName, version, entry.DeviceID, entry.ParentFolderID, true)
+	if err != nil {
+		return err
+	}
+
+	if parent == nil {
+		// the entry is outside of the tracked file system: it may have been moved out of it.
+		if existing == nil {
+			return nil
+		}
+		return s.deleteEntry(ctx, tx, version, existing)
+	}
+
+	entry.Path = filepath.Join(parent.Path, parent.Name)
+
+	if filter != nil {
+		excluded, err := filter.Excluded(ctx, entry)
+		if err != nil {
+			return err
+		}
+
+		if excluded {
+			// the entry may have been tracked until now, such as when a file grows too large.
+			if existing == nil {
+				return nil
+			}
+			return s.deleteEntry(ctx, tx, version, existing)
+		}
+	}
+
+	if entry.IsFolder && existing == nil && change.Type == FSChangeTypeModified {
+		return errors.Wrapf(ErrResyncRequired, "folder '%s' (entryID: %d) was moved into the file system", filepath.Join(entry.Path, entry.Name), entry.EntryID)
+	}
+
+	if entry.IsFolder && existing != nil && (existing.Path != entry.Path || existing.Name != entry.Name) {
+		err = s.relocateFolderContents(ctx, tx, fsName, version, filepath.Join(existing.Path, existing.Name), filepath.Join(entry.Path, entry.Name))
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.upsertEntry(ctx, tx, version, entry)
+}
+
+// findEntry returns the entry of the version of the file system or nil if it does not exist.
+// The entry IDs are only unique within a device.
+func (s *Postgres) findEntry(ctx context.Context, tx *sql.Tx, fsName FSName, version Version, deviceID string, entryID uint64, isFolder bool) (*FSEntry, error) {
+	entry := FSEntry{}
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT fs_name, device_id, entry_id, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs
+		 FROM "filesystem"
+		 WHERE fs_name = $1
+		   AND version = $2
+		   AND device_id = $3
+		   AND entry_id = $4
+		   AND is_folder = $5`,
+		fsName,
+		version,
+		deviceID,
+		fmt.Sprintf("%d", entryID),
+		isFolder,
+	).Scan(
+		&entry.FSName,
+		&entry.DeviceID,
+		&entry.EntryID,
+		&entry.IsFolder,
+		&entry.Path,
+		&entry.Name,
+		&entry.ParentFolderID,
+		&entry.Created,
+		&entry.Modified,
+		&entry.Size,
+		&entry.Hash,
+		&entry.ContentHashAlgorithm,
+		&entry.ContentHash,
+		&entry.Kind,
+		&entry.LinkTarget,
+		&entry.Mode,
+		&entry.UID,
+		&entry.GID,
+		&entry.Xattrs,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	toUTC(&entry)
+
+	return &entry, nil
+}
+
+func (s *Postgres) upsertEntry(ctx context.Context, tx *sql.Tx, version Version, entry FSEntry) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO "filesystem"
+			(fs_name, version, device_id, entry_id, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
+		 ON CONFLICT (fs_name, version, device_id, entry_id)
+		 DO UPDATE SET
+			is_folder = excluded.is_folder,
+			path = excluded.path,
+			name = excluded.name,
+			parent_folder_id = excluded.parent_folder_id,
+			created = excluded.created,
+			modified = excluded.modified,
+			size = excluded.size,
+			hash = excluded.hash,
+			content_hash_algorithm = excluded.content_hash_algorithm,
+			content_hash = excluded.content_hash,
+			kind = excluded.kind,
+			link_target = excluded.link_target,
+			mode = excluded.mode,
+			uid = excluded.uid,
+			gid = excluded.gid,
+			xattrs = excluded.xattrs`,
+		entry.FSName,
+		version,
+		entry.DeviceID,
+		fmt.Sprintf("%d", entry.EntryID),
+		entry.IsFolder,
+		entry.Path,
+		entry.Name,
+		fmt.Sprintf("%d", entry.ParentFolderID),
+		entry.Created,
+		entry.Modified,
+		entry.Size,
+		entry.Hash,
+		entry.ContentHashAlgorithm,
+		entry.ContentHash,
+		entry.Kind,
+		entry.LinkTarget,
+		entry.Mode,
+		entry.UID,
+		entry.GID,
+		entry.Xattrs,
+	)
+	if err != nil {
+		return errors.WithMessagef(err, "deviceID: %s entryID: %d", entry.DeviceID, entry.EntryID)
+	}
+
+	return nil
+}
+
+// deleteEntry removes the entry of the version of the file system and, for a folder, all of its contents.
+func (s *Postgres) deleteEntry(ctx context.Context, tx *sql.Tx, version Version, entry *FSEntry) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`DELETE FROM "filesystem"
+		 WHERE fs_name = $1
+		   AND version = $2
+		   AND device_id = $3
+		   AND entry_id = $4
+		   AND is_folder = $5`,
+		entry.FSName,
+		version,
+		entry.DeviceID,
+		fmt.Sprintf("%d", entry.EntryID),
+		entry.IsFolder,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !entry.IsFolder {
+		return nil
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM "filesystem"
+		 WHERE fs_name = $1
+		   AND version = $2
+		   AND (path = $3 OR substr(path, 1, length($3) + 1) = $3 || '/')`,
+		entry.FSName,
+		version,
+		filepath.Join(entry.Path, entry.Name),
+	)
+
+	return errors.WithStack(err)
+}
+
+// relocateFolderContents updates the path of all the entries of the version contained in the folder
+// at oldPath, after it has been renamed or moved to newPath.
+func (s *Postgres) relocateFolderContents(ctx context.Context, tx *sql.Tx, fsName FSName, version Version, oldPath, newPath string) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`UPDATE "filesystem"
+		 SET path = $4 || substr(path, length($3) + 1)
+		 WHERE fs_name = $1
+		   AND version = $2
+		   AND (path = $3 OR substr(path, 1, length($3) + 1) = $3 || '/')`,
+		fsName,
+		version,
+		oldPath,
+		newPath,
+	)
+
+	return errors.WithStack(err)
+}
@@ -0,0 +1,340 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SetFileSystemRoots records the roots of the file system fsName, replacing its current roots.
+// A file system without roots is tracked from its single root path (see GetSyncDetails).
+func (s *Postgres) SetFileSystemRoots(ctx context.Context, fsName FSName, roots []FSRoot) error {
+	err := validateFSRoots(roots)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM "fs_roots"
+		 WHERE fs_name = $1`,
+		fsName,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	for _, root := range roots {
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO "fs_roots" (fs_name, root_name, root_path)
+			 VALUES ($1, $2, $3)`,
+			fsName,
+			root.Name,
+			root.Path,
+		)
+		if err != nil {
+			return doRollback(tx, errors.WithMessagef(err, "root '%s'", root.Name))
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// GetFileSystemRoots returns the roots of the file system fsName, sorted by name.
+// It returns an empty slice when the file system has a single root path.
+func (s *Postgres) GetFileSystemRoots(ctx context.Context, fsName FSName) ([]FSRoot, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT root_name, root_path
+		 FROM "fs_roots"
+		 WHERE fs_name = $1
+		 ORDER BY root_name COLLATE "C"`,
+		fsName,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	roots := []FSRoot{}
+
+	for rows.Next() {
+		root := FSRoot{}
+
+		err = rows.Scan(&root.Name, &root.Path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		roots = append(roots, root)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return roots, nil
+}
+
+// SetFilterRules records the filter rules of the file system fsName, replacing its current rules.
+// Since the changes of the file system cannot tell which entries the new rules apply to, its
+// cursor is reset: the next incremental refresh walks the file system entirely.
+func (s *Postgres) SetFilterRules(ctx context.Context, fsName FSName, rules FilterRules) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO "fs_filters" (fs_name, patterns, max_size, max_age)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (fs_name)
+		 DO UPDATE SET patterns = excluded.patterns,
+		               max_size = excluded.max_size,
+		               max_age = excluded.max_age`,
+		fsName,
+		strings.Join(rules.Patterns, "\n"),
+		rules.MaxSize,
+		int64(rules.MaxAge/time.Second),
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE "fs_info" SET fs_cursor = 0
+		 WHERE "fs_name" = $1`,
+		fsName,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// GetFilterRules returns the filter rules of the file system fsName.
+// The rules are empty when none were recorded: all entries are tracked.
+func (s *Postgres) GetFilterRules(ctx context.Context, fsName FSName) (*FilterRules, error) {
+	var (
+		patterns string
+		maxAge   int64
+	)
+
+	rules := &FilterRules{}
+
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT patterns, max_size, max_age
+		 FROM "fs_filters"
+		 WHERE fs_name = $1`,
+		fsName,
+	).Scan(&patterns, &rules.MaxSize, &maxAge)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return rules, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	if patterns != "" {
+		rules.Patterns = strings.Split(patterns, "\n")
+	}
+	rules.MaxAge = time.Duration(maxAge) * time.Second
+
+	return rules, nil
+}
+
+// AddDirtyPaths records paths of the file system fsName as changed.
+// A path that is already recorded is moved to the end of the sequence.
+func (s *Postgres) AddDirtyPaths(ctx context.Context, fsName FSName, paths []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, path := range paths {
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO "dirty_paths" (fs_name, path)
+			 VALUES ($1, $2)
+			 ON CONFLICT (fs_name, path)
+			 DO UPDATE SET seq = nextval(pg_get_serial_sequence('dirty_paths', 'seq'))`,
+			fsName,
+			path,
+		)
+		if err != nil {
+			return doRollback(tx, errors.WithMessagef(err, "path: %s", path))
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// MarkFullRescanRequired records that the entire file system fsName must be rescanned because
+// some of its changes may not have been recorded.
+func (s *Postgres) MarkFullRescanRequired(ctx context.Context, fsName FSName) error {
+	return s.AddDirtyPaths(ctx, fsName, []string{fullRescanPath})
+}
+
+// GetDirtyPaths returns the paths of the file system fsName recorded as changed.
+func (s *Postgres) GetDirtyPaths(ctx context.Context, fsName FSName) (*DirtyPaths, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT seq, path
+		 FROM "dirty_paths"
+		 WHERE fs_name = $1
+		 ORDER BY seq`,
+		fsName,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	dirtyPaths := &DirtyPaths{
+		Paths: []string{},
+	}
+
+	for rows.Next() {
+		var path string
+
+		err = rows.Scan(&dirtyPaths.Seq, &path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if path == fullRescanPath {
+			dirtyPaths.FullRescan = true
+			continue
+		}
+
+		dirtyPaths.Paths = append(dirtyPaths.Paths, path)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return dirtyPaths, nil
+}
+
+// ClearDirtyPaths removes the dirty paths of the file system fsName up to and including the
+// sequence number seq, as returned by GetDirtyPaths.
+// The paths recorded since are retained, even if they were already dirty.
+func (s *Postgres) ClearDirtyPaths(ctx context.Context, fsName FSName, seq int64) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM "dirty_paths"
+		 WHERE fs_name = $1
+		   AND seq <= $2`,
+		fsName,
+		seq,
+	)
+
+	return errors.WithStack(err)
+}
+
+// GetContentHashes returns the content hashes recorded for the files of the file system fsName,
+// by entry ID.
+func (s *Postgres) GetContentHashes(ctx context.Context, fsName FSName) (map[uint64]ContentHash, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT entry_id, hash, content_hash_algorithm, content_hash
+		 FROM "content_hashes"
+		 WHERE fs_name = $1`,
+		fsName,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	contentHashes := map[uint64]ContentHash{}
+
+	for rows.Next() {
+		ch := ContentHash{}
+
+		err = rows.Scan(&ch.EntryID, &ch.Hash, &ch.Algorithm, &ch.Value)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		contentHashes[ch.EntryID] = ch
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return contentHashes, nil
+}
+
+// AddContentHashes records the content hashes of files of the file system fsName, replacing
+// those previously recorded for the same entries.
+func (s *Postgres) AddContentHashes(ctx context.Context, fsName FSName, contentHashes []ContentHash) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, ch := range contentHashes {
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO "content_hashes" (fs_name, entry_id, hash, content_hash_algorithm, content_hash)
+			 VALUES ($1, $2, $3, $4, $5)
+			 ON CONFLICT (fs_name, entry_id)
+			 DO UPDATE SET hash = excluded.hash,
+			               content_hash_algorithm = excluded.content_hash_algorithm,
+			               content_hash = excluded.content_hash`,
+			fsName,
+			fmt.Sprintf("%d", ch.EntryID),
+			ch.Hash,
+			ch.Algorithm,
+			ch.Value,
+		)
+		if err != nil {
+			return doRollback(tx, errors.WithMessagef(err, "entryID: %d", ch.EntryID))
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/pkg/errors"
+)
+
+// LockRefresh takes the lock of the refreshes of the file system fsName. The lock is held until
+// the returned function is called, or until the connection of the agent that holds it is lost,
+// such as when the agent dies. It returns ErrLocked when a refresh of the file system is in
+// progress in any agent.
+func (s *Postgres) LockRefresh(ctx context.Context, fsName FSName) (func() error, error) {
+	return s.lock(ctx, lockKindRefresh, string(fsName))
+}
+
+// LockSync takes the lock of the syncs of the sync pair pairName, as per LockRefresh.
+func (s *Postgres) LockSync(ctx context.Context, pairName PairName) (func() error, error) {
+	return s.lock(ctx, lockKindSync, string(pairName))
+}
+
+// lock takes a session-level advisory lock on a connection dedicated to it, so that PostgreSQL
+// releases the lock should the connection be lost.
+// The lock is identified by the hashes of kind and name: two names may share a lock, in which
+// case one is reported as locked while the other is held.
+func (s *Postgres) lock(ctx context.Context, kind lockKind, name string) (func() error, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var locked bool
+
+	err = conn.QueryRowContext(
+		ctx,
+		`SELECT pg_try_advisory_lock(hashtext($1), hashtext($2))`,
+		kind,
+		name,
+	).Scan(&locked)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	if !locked {
+		_ = conn.Close()
+		return nil, errors.Wrapf(ErrLocked, "%s of '%s'", kind, name)
+	}
+
+	unlock := func() error {
+		_, err := conn.ExecContext(
+			context.Background(),
+			`SELECT pg_advisory_unlock(hashtext($1), hashtext($2))`,
+			kind,
+			name,
+		)
+		if err != nil {
+			// the connection is discarded rather than returned to the pool, which releases the
+			// lock.
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+			_ = conn.Close()
+			return errors.WithStack(err)
+		}
+
+		return errors.WithStack(conn.Close())
+	}
+
+	return unlock, nil
+}
+
+// recoverIncompleteRefresh discards the "staging" entries of the file system fsName, unless its
+// refresh is in progress. It reports whether there were any.
+func (s *Postgres) recoverIncompleteRefresh(ctx context.Context, fsName FSName) (bool, error) {
+	unlock, err := s.LockRefresh(ctx, fsName)
+	if errors.Is(err, ErrLocked) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = unlock() }()
+
+	return execAffectsRows(s.db.ExecContext(
+		ctx,
+		`DELETE FROM "filesystem"
+		 WHERE version = $1
+		   AND fs_name = $2`,
+		VersionStaging,
+		fsName,
+	))
+}
+
+// recoverInterruptedSync marks the sync of the sync pair pairName as "required" if it is "in
+// progress", unless it is running. It reports whether it was "in progress".
+func (s *Postgres) recoverInterruptedSync(ctx context.Context, pairName PairName) (bool, error) {
+	unlock, err := s.LockSync(ctx, pairName)
+	if errors.Is(err, ErrLocked) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = unlock() }()
+
+	return execAffectsRows(s.db.ExecContext(
+		ctx,
+		`UPDATE "sync_pairs" SET sync_status = $1
+		 WHERE pair_name = $2
+		   AND sync_status = $3`,
+		SyncStatusRequired,
+		pairName,
+		SyncStatusInProgress,
+	))
+}
@@ -0,0 +1,383 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SetSnapshotRetention sets the number of snapshots of the file system fsName to keep, as per
+// SQLite3.SetSnapshotRetention.
+func (s *Postgres) SetSnapshotRetention(ctx context.Context, fsName FSName, retention int) error {
+	if retention < 0 {
+		return errors.Errorf("invalid snapshot retention %d for file system '%s'", retention, fsName)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res, err := tx.ExecContext(
+		ctx,
+		`UPDATE "fs_info" SET snapshot_retention = $1
+		 WHERE fs_name = $2`,
+		retention,
+		fsName,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	if n == 0 {
+		return doRollback(tx, errors.Wrapf(sql.ErrNoRows, "file system '%s'", fsName))
+	}
+
+	err = s.pruneSnapshots(ctx, tx, fsName, retention)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// takeSnapshot records the "new" entries of the file system as its next snapshot, as per
+// SQLite3.takeSnapshot.
+func (s *Postgres) takeSnapshot(ctx context.Context, tx *sql.Tx, fsName FSName) error {
+	var retention int
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT snapshot_retention
+		 FROM "fs_info"
+		 WHERE fs_name = $1`,
+		fsName,
+	).Scan(&retention)
+	if err != nil {
+		return errors.WithMessagef(err, "file system '%s'", fsName)
+	}
+
+	if retention == 0 {
+		return nil
+	}
+
+	var snapshotID uint64
+
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT COALESCE(MAX(snapshot_id), 0) + 1
+		 FROM "snapshots"
+		 WHERE fs_name = $1`,
+		fsName,
+	).Scan(&snapshotID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO "snapshots" (fs_name, snapshot_id, taken_at)
+		 VALUES ($1, $2, $3)`,
+		fsName,
+		snapshotID,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// unlike SQLite3, the columns are not nullable: they are compared with "="
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE "snapshot_entries" SET valid_to = $1
+		 WHERE fs_name = $2
+		   AND valid_to IS NULL
+		   AND NOT EXISTS (SELECT 1
+		                   FROM "filesystem" f
+		                   WHERE f.fs_name = snapshot_entries.fs_name
+		                     AND f.version = $3
+		                     AND f.device_id = snapshot_entries.device_id
+		                     AND f.entry_id = snapshot_entries.entry_id
+		                     AND f.is_folder = snapshot_entries.is_folder
+		                     AND f.path = snapshot_entries.path
+		                     AND f.name = snapshot_entries.name
+		                     AND f.parent_folder_id = snapshot_entries.parent_folder_id
+		                     AND f.created = snapshot_entries.created
+		                     AND f.modified = snapshot_entries.modified
+		                     AND f.size = snapshot_entries.size
+		                     AND f.hash = snapshot_entries.hash
+		                     AND f.content_hash_algorithm = snapshot_entries.content_hash_algorithm
+		                     AND f.content_hash = snapshot_entries.content_hash
+		                     AND f.kind = snapshot_entries.kind
+		                     AND f.link_target = snapshot_entries.link_target
+		                     AND f.mode = snapshot_entries.mode
+		                     AND f.uid = snapshot_entries.uid
+		                     AND f.gid = snapshot_entries.gid
+		                     AND f.xattrs = snapshot_entries.xattrs)`,
+		snapshotID,
+		fsName,
+		VersionNew,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO "snapshot_entries"
+			(fs_name, device_id, entry_id, valid_from, valid_to, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs)
+		 SELECT fs_name, device_id, entry_id, $1::BIGINT, NULL::BIGINT, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs
+		 FROM "filesystem" f
+		 WHERE f.fs_name = $2
+		   AND f.version = $3
+		   AND NOT EXISTS (SELECT 1
+		                   FROM "snapshot_entries" se
+		                   WHERE se.fs_name = f.fs_name
+		                     AND se.device_id = f.device_id
+		                     AND se.entry_id = f.entry_id
+		                     AND se.valid_to IS NULL)`,
+		snapshotID,
+		fsName,
+		VersionNew,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return s.pruneSnapshots(ctx, tx, fsName, retention)
+}
+
+// pruneSnapshots discards the snapshots of the file system beyond the retention, as per
+// SQLite3.pruneSnapshots.
+func (s *Postgres) pruneSnapshots(ctx context.Context, tx *sql.Tx, fsName FSName, retention int) error {
+	if retention == 0 {
+		_, err := tx.ExecContext(ctx, `DELETE FROM "snapshots" WHERE fs_name = $1`, fsName)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM "snapshot_entries" WHERE fs_name = $1`, fsName)
+
+		return errors.WithStack(err)
+	}
+
+	var oldestID uint64
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT snapshot_id
+		 FROM "snapshots"
+		 WHERE fs_name = $1
+		 ORDER BY snapshot_id DESC
+		 LIMIT 1 OFFSET $2`,
+		fsName,
+		retention-1,
+	).Scan(&oldestID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// there are fewer snapshots than the retention
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM "snapshots"
+		 WHERE fs_name = $1
+		   AND snapshot_id < $2`,
+		fsName,
+		oldestID,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM "snapshot_entries"
+		 WHERE fs_name = $1
+		   AND valid_to <= $2`,
+		fsName,
+		oldestID,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE "snapshot_entries" SET valid_from = $1
+		 WHERE fs_name = $2
+		   AND valid_from < $1`,
+		oldestID,
+		fsName,
+	)
+
+	return errors.WithStack(err)
+}
+
+// ListSnapshots returns the snapshots kept for the file system fsName, oldest first.
+func (s *Postgres) ListSnapshots(ctx context.Context, fsName FSName) ([]Snapshot, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT fs_name, snapshot_id, taken_at
+		 FROM "snapshots"
+		 WHERE fs_name = $1
+		 ORDER BY snapshot_id`,
+		fsName,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	snapshots := []Snapshot{}
+
+	for rows.Next() {
+		snapshot := Snapshot{}
+
+		err = rows.Scan(&snapshot.FSName, &snapshot.ID, &snapshot.TakenAt)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		snapshot.TakenAt = snapshot.TakenAt.UTC()
+		snapshots = append(snapshots, snapshot)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return snapshots, nil
+}
+
+// FindSnapshot returns the latest snapshot of the file system fsName taken at or before the time
+// at, as per SQLite3.FindSnapshot.
+func (s *Postgres) FindSnapshot(ctx context.Context, fsName FSName, at time.Time) (*Snapshot, error) {
+	snapshots, err := s.ListSnapshots(ctx, fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	return findSnapshotAt(fsName, snapshots, at)
+}
+
+// GetSnapshotEntries returns the entries of the file system fsName as of the snapshot, as per
+// SQLite3.GetSnapshotEntries.
+func (s *Postgres) GetSnapshotEntries(ctx context.Context, fsName FSName, snapshotID uint64) ([]FSEntry, error) {
+	snapshots, err := s.ListSnapshots(ctx, fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = findSnapshotByID(fsName, snapshots, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	states, err := s.getSnapshotEntryStates(
+		ctx,
+		`fs_name = $1
+		 AND valid_from <= $2
+		 AND (valid_to IS NULL OR valid_to > $2)`,
+		fsName,
+		snapshotID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	fsEntries := make([]FSEntry, 0, len(states))
+	for _, state := range states {
+		fsEntries = append(fsEntries, state.entry)
+	}
+
+	return fsEntries, nil
+}
+
+// GetSnapshotMutations returns the mutations of the file system fsName between the snapshots
+// fromID and toID, as per SQLite3.GetSnapshotMutations.
+func (s *Postgres) GetSnapshotMutations(ctx context.Context, fsName FSName, fromID, toID uint64) (FSMutations, error) {
+	fromEntries, err := s.GetSnapshotEntries(ctx, fsName, fromID)
+	if err != nil {
+		return nil, err
+	}
+
+	toEntries, err := s.GetSnapshotEntries(ctx, fsName, toID)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffSnapshotEntries(fromEntries, toEntries), nil
+}
+
+// GetPathLastChange returns the snapshot in which the entry at path last changed in the file
+// system fsName, as per SQLite3.GetPathLastChange.
+func (s *Postgres) GetPathLastChange(ctx context.Context, fsName FSName, path string) (*Snapshot, error) {
+	states, err := s.getSnapshotEntryStates(
+		ctx,
+		`fs_name = $1
+		 AND name = $2`,
+		fsName,
+		filepath.Base(path),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	lastChange, ok := lastChangeOfPath(path, states)
+	if !ok {
+		return nil, errors.Wrapf(sql.ErrNoRows, "no snapshot of file system '%s' holds '%s'", fsName, path)
+	}
+
+	snapshots, err := s.ListSnapshots(ctx, fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	return findSnapshotByID(fsName, snapshots, lastChange)
+}
+
+func (s *Postgres) getSnapshotEntryStates(ctx context.Context, where string, args ...interface{}) ([]snapshotEntryState, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT fs_name, device_id, entry_id, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs, valid_from, COALESCE(valid_to, 0)
+		 FROM "snapshot_entries"
+		 WHERE `+where+`
+		 ORDER BY device_id COLLATE "C", entry_id COLLATE "C", valid_from`,
+		args...,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	states, err := scanSnapshotEntryStates(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range states {
+		toUTC(&states[i].entry)
+	}
+
+	return states, nil
+}
@@ -0,0 +1,544 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CreateSyncPair records a new sync pair between two registered file systems
+// (see RegisterFileSystem).
+func (s *Postgres) CreateSyncPair(ctx context.Context, syncPair SyncPair) error {
+	if syncPair.FromFS == syncPair.ToFS {
+		return errors.Errorf("sync pair '%s' cannot sync file system '%s' with itself", syncPair.PairName, syncPair.FromFS)
+	}
+
+	for _, fsName := range []FSName{syncPair.FromFS, syncPair.ToFS} {
+		_, err := s.GetFileSystemInfo(ctx, fsName)
+		if err != nil {
+			return errors.WithMessagef(err, "sync pair '%s': file system '%s' is not registered", syncPair.PairName, fsName)
+		}
+	}
+
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO "sync_pairs" (pair_name, from_fs, to_fs)
+		 VALUES ($1, $2, $3)`,
+		syncPair.PairName,
+		syncPair.FromFS,
+		syncPair.ToFS,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "sync pair '%s'", syncPair.PairName)
+	}
+
+	return nil
+}
+
+// ListSyncPairs returns all the sync pairs, sorted by name.
+func (s *Postgres) ListSyncPairs(ctx context.Context) ([]SyncPair, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT pair_name, from_fs, to_fs
+		 FROM "sync_pairs"
+		 ORDER BY pair_name COLLATE "C"`,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	return scanSyncPairs(rows)
+}
+
+// DeleteSyncPair removes the sync pair pairName.
+// It returns an error that wraps sql.ErrNoRows when the sync pair does not exist.
+func (s *Postgres) DeleteSyncPair(ctx context.Context, pairName PairName) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res, err := tx.ExecContext(
+		ctx,
+		`DELETE FROM "sync_pairs"
+		 WHERE pair_name = $1`,
+		pairName,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	if n == 0 {
+		return doRollback(tx, errors.Wrapf(sql.ErrNoRows, "sync pair '%s'", pairName))
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM "staging_cross_mutations"
+		 WHERE pair_name = $1`,
+		pairName,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+func (s *Postgres) findSyncPair(ctx context.Context, pairName PairName) (*SyncPair, error) {
+	var syncPair SyncPair
+
+	err := s.db.
+		QueryRowContext(
+			ctx,
+			`SELECT pair_name, from_fs, to_fs
+			 FROM sync_pairs
+			 WHERE pair_name = $1`,
+			pairName).
+		Scan(&syncPair.PairName, &syncPair.FromFS, &syncPair.ToFS)
+	if err != nil {
+		return nil, errors.Wrapf(err, "sync pair '%s'", pairName)
+	}
+
+	return &syncPair, nil
+}
+
+// FindSyncPeers returns the names of the file systems that are synced with the file system
+// fsName, in either direction, sorted by name.
+func (s *Postgres) FindSyncPeers(ctx context.Context, fsName FSName) ([]FSName, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT DISTINCT CASE WHEN from_fs = $1 THEN to_fs ELSE from_fs END
+		 FROM sync_pairs
+		 WHERE from_fs = $1 OR to_fs = $1`,
+		fsName,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	peers := []FSName{}
+
+	for rows.Next() {
+		var peer FSName
+
+		err = rows.Scan(&peer)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		peers = append(peers, peer)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+
+	return peers, nil
+}
+
+// GetCrossFSMutations returns the mutations to apply to the "to" file system of the sync pair
+// pairName so that it mirrors its "from" file system, as per SQLite3.GetCrossFSMutations.
+func (s *Postgres) GetCrossFSMutations(ctx context.Context, pairName PairName) (FSMutations, error) {
+	syncPair, err := s.findSyncPair(ctx, pairName)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = s.refreshCrossFSMutationsStagingTable(ctx, tx, *syncPair)
+	if err != nil {
+		return nil, doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, doRollback(tx, err)
+	}
+
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT scm.mutation_type,
+			    scm.rel_path,
+			    fs.fs_name,
+			    fs.version,
+			    fs.device_id,
+			    fs.entry_id,
+			    fs.is_folder,
+			    fs.path,
+			    fs.name,
+			    fs.parent_folder_id,
+			    fs.created,
+			    fs.modified,
+			    fs.size,
+			    fs.hash,
+			    fs.content_hash_algorithm,
+			    fs.content_hash,
+			    fs.kind,
+			    fs.link_target,
+			    fs.mode,
+			    fs.uid,
+			    fs.gid,
+			    fs.xattrs
+		 FROM staging_cross_mutations scm
+			  JOIN sync_pairs sp
+			  ON scm.pair_name = sp.pair_name
+			  JOIN filesystem fs
+			  ON (fs.fs_name = sp.from_fs AND fs.device_id = scm.from_device_id AND fs.entry_id = scm.from_entry_id)
+			     OR (fs.fs_name = sp.to_fs AND fs.device_id = scm.to_device_id AND fs.entry_id = scm.to_entry_id)
+		 WHERE scm.pair_name = $1
+		   AND fs.version = $2
+		 ORDER BY scm.mutation_type COLLATE "C", scm.rel_path COLLATE "C", fs.fs_name = sp.to_fs`, // "from" before "to"
+		pairName,
+		VersionNew,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	fsMutations, err := processCrossFSMutationsRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range fsMutations {
+		for j := range fsMutations[i].Details {
+			toUTC(&fsMutations[i].Details[j].FSEntry)
+		}
+	}
+
+	return fsMutations, nil
+}
+
+// crossFSRoot returns the root of the file system fsName that the paths of its entries are
+// relative to, without its trailing separators.
+func (s *Postgres) crossFSRoot(ctx context.Context, fsName FSName) (string, error) {
+	roots, err := s.GetFileSystemRoots(ctx, fsName)
+	if err != nil {
+		return "", err
+	}
+
+	root := VirtualRootPath
+
+	if len(roots) == 0 {
+		_, root, err = s.GetSyncDetails(ctx, fsName)
+		if err != nil {
+			return "", errors.WithMessagef(err, "file system '%s'", fsName)
+		}
+	}
+
+	return strings.TrimRight(root, "/"), nil
+}
+
+// refreshCrossFSMutationsStagingTable replaces the staging rows of the sync pair with the
+// mutations that exist between the VersionNew entries of its file systems.
+func (s *Postgres) refreshCrossFSMutationsStagingTable(ctx context.Context, tx *sql.Tx, syncPair SyncPair) error {
+	fromRoot, err := s.crossFSRoot(ctx, syncPair.FromFS)
+	if err != nil {
+		return err
+	}
+
+	toRoot, err := s.crossFSRoot(ctx, syncPair.ToFS)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM "staging_cross_mutations"
+		 WHERE pair_name = $1`,
+		syncPair.PairName,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// the root of a file system is the entry whose path is its name.
+	_, err = tx.ExecContext(
+		ctx,
+		`WITH entries AS (SELECT fs_name, device_id, entry_id, is_folder, content_hash_algorithm, content_hash, kind, link_target,
+								 CASE
+									 WHEN path = name THEN path
+									 WHEN right(path, 1) = '/' THEN path || name
+									 ELSE path || '/' || name
+								 END AS full_path
+							FROM filesystem
+						   WHERE version = $6),
+			 from_fs AS (SELECT *, rtrim(substr(full_path, length($4::VARCHAR) + 1), '/') AS rel_path
+						   FROM entries
+						  WHERE fs_name = $2),
+			 to_fs AS (SELECT *, rtrim(substr(full_path, length($5::VARCHAR) + 1), '/') AS rel_path
+						 FROM entries
+						WHERE fs_name = $3)
+
+		INSERT INTO staging_cross_mutations (pair_name, mutation_type, rel_path, from_device_id, from_entry_id, to_device_id, to_entry_id)
+
+		SELECT
+			$1::VARCHAR,
+			$8::VARCHAR,
+			from_fs.rel_path,
+			from_fs.device_id,
+			from_fs.entry_id,
+			NULL,
+			NULL
+		 FROM from_fs LEFT OUTER JOIN to_fs USING (rel_path)
+		 WHERE to_fs.entry_id IS NULL
+
+		 UNION
+
+		 SELECT
+			$1::VARCHAR,
+			$7::VARCHAR,
+			to_fs.rel_path,
+			NULL,
+			NULL,
+			to_fs.device_id,
+			to_fs.entry_id
+		 FROM to_fs LEFT OUTER JOIN from_fs USING (rel_path)
+		 WHERE from_fs.entry_id IS NULL
+
+		 UNION
+
+		 SELECT
+			$1::VARCHAR,
+			$9::VARCHAR,
+			from_fs.rel_path,
+			from_fs.device_id,
+			from_fs.entry_id,
+			to_fs.device_id,
+			to_fs.entry_id
+		 FROM from_fs JOIN to_fs USING (rel_path)
+		 WHERE from_fs.is_folder != to_fs.is_folder
+		    -- see SQLite3.refreshCrossFSMutationsStagingTable
+		    OR from_fs.content_hash_algorithm != to_fs.content_hash_algorithm
+		    OR from_fs.content_hash != to_fs.content_hash
+		    OR from_fs.kind != to_fs.kind
+		    OR from_fs.link_target != to_fs.link_target`,
+		syncPair.PairName,
+		syncPair.FromFS,
+		syncPair.ToFS,
+		fromRoot,
+		toRoot,
+		VersionNew,
+		MutationTypeDeleted,
+		MutationTypeCreated,
+		MutationTypeModified,
+	)
+
+	return errors.WithStack(err)
+}
+
+// GetSyncStatus returns the status of the sync of the sync pair pairName.
+func (s *Postgres) GetSyncStatus(ctx context.Context, pairName PairName) (SyncStatus, error) {
+	var status SyncStatus
+
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT sync_status
+		 FROM "sync_pairs"
+		 WHERE pair_name = $1`,
+		pairName,
+	).Scan(&status)
+	if err != nil {
+		return "", errors.Wrapf(err, "sync pair '%s'", pairName)
+	}
+
+	return status, nil
+}
+
+// MarkSyncInProgress marks the status of the sync as "in progress".
+// It fails when the sync is not "required", such as when another sync of the pair is in progress.
+func (s *Postgres) MarkSyncInProgress(ctx context.Context, pairName PairName) error {
+	res, err := s.db.ExecContext(
+		ctx,
+		`UPDATE "sync_pairs" SET sync_status = $1
+		 WHERE pair_name = $2
+		   AND sync_status = $3`,
+		SyncStatusInProgress,
+		pairName,
+		SyncStatusRequired,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if n == 0 {
+		status, err := s.GetSyncStatus(ctx, pairName)
+		if err != nil {
+			return err
+		}
+		return errors.Errorf("cannot start the sync of pair '%s': its status is '%s'", pairName, status)
+	}
+
+	return nil
+}
+
+// MarkSyncComplete marks the status of the sync as "complete", as per SQLite3.MarkSyncComplete.
+func (s *Postgres) MarkSyncComplete(ctx context.Context, pairName PairName) error {
+	syncPair, err := s.findSyncPair(ctx, pairName)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE "sync_pairs" SET sync_status = $1
+		 WHERE pair_name = $2
+		   AND sync_status = $3`,
+		SyncStatusComplete,
+		pairName,
+		SyncStatusInProgress,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE "fs_info" SET fs_changed = FALSE
+		 WHERE fs_name = $1
+		   AND NOT EXISTS (SELECT 1
+		                   FROM "sync_pairs"
+		                   WHERE from_fs = $1
+		                     AND sync_status != $2)`,
+		syncPair.FromFS,
+		SyncStatusComplete,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// MarkSyncAsChanged marks the status of the sync as "required", such as after a sync failed.
+// This also triggers the internal refresh of the cross file system mutations of the pair.
+func (s *Postgres) MarkSyncAsChanged(ctx context.Context, pairName PairName) error {
+	syncPair, err := s.findSyncPair(ctx, pairName)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.refreshCrossFSMutationsStagingTable(ctx, tx, *syncPair)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE "sync_pairs" SET sync_status = $1
+		 WHERE pair_name = $2`,
+		SyncStatusRequired,
+		pairName,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// RecoverInterruptedSyncs marks the syncs that are "in progress" but not running (see LockSync)
+// as "required": these are left over by the syncs of agents that died. The syncs in progress in
+// the other agents are left alone.
+// It returns the names of the sync pairs whose sync was interrupted, sorted by name.
+func (s *Postgres) RecoverInterruptedSyncs(ctx context.Context) ([]PairName, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT pair_name
+		 FROM "sync_pairs"
+		 WHERE sync_status = $1`,
+		SyncStatusInProgress,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	inProgressPairNames := []PairName{}
+
+	for rows.Next() {
+		var pairName PairName
+
+		err = rows.Scan(&pairName)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		inProgressPairNames = append(inProgressPairNames, pairName)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sort.Slice(inProgressPairNames, func(i, j int) bool { return inProgressPairNames[i] < inProgressPairNames[j] })
+
+	pairNames := []PairName{}
+
+	for _, pairName := range inProgressPairNames {
+		recovered, err := s.recoverInterruptedSync(ctx, pairName)
+		if err != nil {
+			return nil, err
+		}
+
+		if recovered {
+			pairNames = append(pairNames, pairName)
+		}
+	}
+
+	return pairNames, nil
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// VirtualRootPath is the path of the virtual root of the file systems with multiple roots.
+const VirtualRootPath = "/"
+
+// FSRoot is a named root of a file system with multiple roots. Each root is a folder of the
+// virtual tree of the file system, named after the root, directly under its virtual root "/".
+type FSRoot struct {
+	Name string
+	Path string // the path of the root on the file system
+}
+
+// SetFileSystemRoots records the roots of the file system fsName, replacing its current roots.
+// A file system without roots is tracked from its single root path (see GetSyncDetails).
+func (s *SQLite3) SetFileSystemRoots(ctx context.Context, fsName FSName, roots []FSRoot) error {
+	err := validateFSRoots(roots)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM "fs_roots"
+		 WHERE fs_name = ?`,
+		fsName,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	for _, root := range roots {
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO "fs_roots" (fs_name, root_name, root_path)
+			 VALUES (?, ?, ?)`,
+			fsName,
+			root.Name,
+			root.Path,
+		)
+		if err != nil {
+			return doRollback(tx, errors.WithMessagef(err, "root '%s'", root.Name))
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// validateFSRoots checks that the name of each root is a single path element.
+func validateFSRoots(roots []FSRoot) error {
+	for _, root := range roots {
+		if root.Name == "" || root.Name == "." || root.Name == ".." || strings.ContainsAny(root.Name, `/\`) {
+			return errors.Errorf("invalid name '%s' for root '%s': it must be a single path element", root.Name, root.Path)
+		}
+	}
+
+	return nil
+}
+
+// GetFileSystemRoots returns the roots of the file system fsName, sorted by name.
+// It returns an empty slice when the file system has a single root path.
+func (s *SQLite3) GetFileSystemRoots(ctx context.Context, fsName FSName) ([]FSRoot, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT root_name, root_path
+		 FROM "fs_roots"
+		 WHERE fs_name = ?
+		 ORDER BY root_name`,
+		fsName,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	roots := []FSRoot{}
+
+	for rows.Next() {
+		root := FSRoot{}
+
+		err = rows.Scan(&root.Name, &root.Path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		roots = append(roots, root)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return roots, nil
+}
@@ -0,0 +1,524 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Snapshot is a point-in-time record of the "new" entries of a file system. A snapshot is taken
+// each time a refresh is committed (see CommitVersionStaging), provided the file system keeps a
+// history (see SetSnapshotRetention).
+// The snapshots are stored as deltas: only the entries that changed since the previous snapshot
+// take up space.
+type Snapshot struct {
+	FSName  FSName
+	ID      uint64 // increases with each snapshot of the file system
+	TakenAt time.Time
+}
+
+// findSnapshotAt returns the latest of the snapshots, sorted by ID, that was taken at or before
+// the time at.
+func findSnapshotAt(fsName FSName, snapshots []Snapshot, at time.Time) (*Snapshot, error) {
+	i := sort.Search(len(snapshots), func(i int) bool { return snapshots[i].TakenAt.After(at) })
+	if i == 0 {
+		return nil, errors.Wrapf(sql.ErrNoRows, "no snapshot of file system '%s' was taken at or before %s", fsName, at.Format(time.RFC3339))
+	}
+
+	return &snapshots[i-1], nil
+}
+
+// findSnapshotByID returns the snapshot of the snapshots with the ID.
+func findSnapshotByID(fsName FSName, snapshots []Snapshot, snapshotID uint64) (*Snapshot, error) {
+	for i := range snapshots {
+		if snapshots[i].ID == snapshotID {
+			return &snapshots[i], nil
+		}
+	}
+
+	return nil, errors.Wrapf(sql.ErrNoRows, "snapshot %d of file system '%s'", snapshotID, fsName)
+}
+
+// diffSnapshotEntries returns the mutations between the entries of two snapshots, as
+// GetFileSystemMutations does between VersionPrevious and VersionNew: the entries of the
+// earlier snapshot are of VersionPrevious and those of the later snapshot of VersionNew.
+func diffSnapshotEntries(fromEntries, toEntries []FSEntry) FSMutations {
+	from := map[entryKey]FSEntry{}
+	for _, entry := range fromEntries {
+		from[entryKey{deviceID: entry.DeviceID, entryID: entry.EntryID}] = entry
+	}
+
+	to := map[entryKey]FSEntry{}
+	for _, entry := range toEntries {
+		to[entryKey{deviceID: entry.DeviceID, entryID: entry.EntryID}] = entry
+	}
+
+	fsMutations := FSMutations{}
+
+	for _, fsm := range diffMemoryEntries(from, to) {
+		mutation := FSMutation{Type: fsm.mType}
+
+		if entry, ok := from[fsm.key]; ok {
+			mutation.Details = append(mutation.Details, VersionedEntry{Version: VersionPrevious, FSEntry: entry})
+		}
+
+		if entry, ok := to[fsm.key]; ok {
+			mutation.Details = append(mutation.Details, VersionedEntry{Version: VersionNew, FSEntry: entry})
+		}
+
+		fsMutations = append(fsMutations, mutation)
+	}
+
+	return fsMutations
+}
+
+// lastChangeOfPath returns the ID of the last snapshot in which the entry at path changed, from
+// the states of the entries named as path. A state that began before the oldest snapshot kept
+// is recorded as beginning with it.
+func lastChangeOfPath(path string, states []snapshotEntryState) (uint64, bool) {
+	var (
+		lastChange uint64
+		found      bool
+	)
+
+	for _, state := range states {
+		if filepath.Join(state.entry.Path, state.entry.Name) != path {
+			continue
+		}
+
+		found = true
+
+		if state.validFrom > lastChange {
+			lastChange = state.validFrom
+		}
+
+		if state.validTo > lastChange {
+			lastChange = state.validTo
+		}
+	}
+
+	return lastChange, found
+}
+
+// snapshotEntryState is the state of an entry from the snapshot validFrom up to, but excluding,
+// the snapshot validTo. validTo is 0 while the state is current.
+type snapshotEntryState struct {
+	entry     FSEntry
+	validFrom uint64
+	validTo   uint64
+}
+
+// SetSnapshotRetention sets the number of snapshots of the file system fsName to keep. The
+// oldest snapshots are discarded beyond it. 0, the default, keeps no history.
+func (s *SQLite3) SetSnapshotRetention(ctx context.Context, fsName FSName, retention int) error {
+	if retention < 0 {
+		return errors.Errorf("invalid snapshot retention %d for file system '%s'", retention, fsName)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res, err := tx.ExecContext(
+		ctx,
+		`UPDATE "fs_info" SET snapshot_retention = ?
+		 WHERE fs_name = ?`,
+		retention,
+		fsName,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	if n == 0 {
+		return doRollback(tx, errors.Wrapf(sql.ErrNoRows, "file system '%s'", fsName))
+	}
+
+	err = s.pruneSnapshots(ctx, tx, fsName, retention)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// takeSnapshot records the "new" entries of the file system as its next snapshot, when it keeps
+// a history.
+// Only the entries that changed since the previous snapshot are recorded: the current states
+// of the entries that changed or were removed are closed and the new states are opened.
+func (s *SQLite3) takeSnapshot(ctx context.Context, tx *sql.Tx, fsName FSName) error {
+	var retention int
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT snapshot_retention
+		 FROM "fs_info"
+		 WHERE fs_name = ?`,
+		fsName,
+	).Scan(&retention)
+	if err != nil {
+		return errors.WithMessagef(err, "file system '%s'", fsName)
+	}
+
+	if retention == 0 {
+		return nil
+	}
+
+	var snapshotID uint64
+
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT COALESCE(MAX(snapshot_id), 0) + 1
+		 FROM "snapshots"
+		 WHERE fs_name = ?`,
+		fsName,
+	).Scan(&snapshotID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO "snapshots" (fs_name, snapshot_id, taken_at)
+		 VALUES (?, ?, ?)`,
+		fsName,
+		snapshotID,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE "snapshot_entries" SET valid_to = :snapshot_id
+		 WHERE fs_name = :fs_name
+		   AND valid_to IS NULL
+		   AND NOT EXISTS (SELECT 1
+		                   FROM "filesystem" f
+		                   WHERE f.fs_name = snapshot_entries.fs_name
+		                     AND f.version = :version_new
+		                     AND f.device_id = snapshot_entries.device_id
+		                     AND f.entry_id = snapshot_entries.entry_id
+		                     AND f.is_folder IS snapshot_entries.is_folder
+		                     AND f.path IS snapshot_entries.path
+		                     AND f.name IS snapshot_entries.name
+		                     AND f.parent_folder_id IS snapshot_entries.parent_folder_id
+		                     AND f.created IS snapshot_entries.created
+		                     AND f.modified IS snapshot_entries.modified
+		                     AND f.size IS snapshot_entries.size
+		                     AND f.hash IS snapshot_entries.hash
+		                     AND f.content_hash_algorithm IS snapshot_entries.content_hash_algorithm
+		                     AND f.content_hash IS snapshot_entries.content_hash
+		                     AND f.kind IS snapshot_entries.kind
+		                     AND f.link_target IS snapshot_entries.link_target
+		                     AND f.mode IS snapshot_entries.mode
+		                     AND f.uid IS snapshot_entries.uid
+		                     AND f.gid IS snapshot_entries.gid
+		                     AND f.xattrs IS snapshot_entries.xattrs)`,
+		sql.Named("snapshot_id", snapshotID),
+		sql.Named("fs_name", fsName),
+		sql.Named("version_new", VersionNew),
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO "snapshot_entries"
+			(fs_name, device_id, entry_id, valid_from, valid_to, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs)
+		 SELECT fs_name, device_id, entry_id, :snapshot_id, NULL, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs
+		 FROM "filesystem" f
+		 WHERE f.fs_name = :fs_name
+		   AND f.version = :version_new
+		   AND NOT EXISTS (SELECT 1
+		                   FROM "snapshot_entries" se
+		                   WHERE se.fs_name = f.fs_name
+		                     AND se.device_id = f.device_id
+		                     AND se.entry_id = f.entry_id
+		                     AND se.valid_to IS NULL)`,
+		sql.Named("snapshot_id", snapshotID),
+		sql.Named("fs_name", fsName),
+		sql.Named("version_new", VersionNew),
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return s.pruneSnapshots(ctx, tx, fsName, retention)
+}
+
+// pruneSnapshots discards the snapshots of the file system beyond the retention, oldest first.
+// The states of the entries that began before the oldest snapshot kept are made to begin with it.
+func (s *SQLite3) pruneSnapshots(ctx context.Context, tx *sql.Tx, fsName FSName, retention int) error {
+	if retention == 0 {
+		_, err := tx.ExecContext(ctx, `DELETE FROM "snapshots" WHERE fs_name = ?`, fsName)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM "snapshot_entries" WHERE fs_name = ?`, fsName)
+
+		return errors.WithStack(err)
+	}
+
+	var oldestID uint64
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT snapshot_id
+		 FROM "snapshots"
+		 WHERE fs_name = ?
+		 ORDER BY snapshot_id DESC
+		 LIMIT 1 OFFSET ?`,
+		fsName,
+		retention-1,
+	).Scan(&oldestID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// there are fewer snapshots than the retention
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM "snapshots"
+		 WHERE fs_name = ?
+		   AND snapshot_id < ?`,
+		fsName,
+		oldestID,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM "snapshot_entries"
+		 WHERE fs_name = ?
+		   AND valid_to <= ?`,
+		fsName,
+		oldestID,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE "snapshot_entries" SET valid_from = :oldest_id
+		 WHERE fs_name = :fs_name
+		   AND valid_from < :oldest_id`,
+		sql.Named("oldest_id", oldestID),
+		sql.Named("fs_name", fsName),
+	)
+
+	return errors.WithStack(err)
+}
+
+// ListSnapshots returns the snapshots kept for the file system fsName, oldest first.
+func (s *SQLite3) ListSnapshots(ctx context.Context, fsName FSName) ([]Snapshot, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT fs_name, snapshot_id, taken_at
+		 FROM "snapshots"
+		 WHERE fs_name = ?
+		 ORDER BY snapshot_id`,
+		fsName,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	snapshots := []Snapshot{}
+
+	for rows.Next() {
+		snapshot := Snapshot{}
+
+		err = rows.Scan(&snapshot.FSName, &snapshot.ID, &snapshot.TakenAt)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		snapshots = append(snapshots, snapshot)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return snapshots, nil
+}
+
+// FindSnapshot returns the snapshot that describes the file system fsName as it was at the time
+// at: that is the latest snapshot taken at or before it.
+// It returns an error that wraps sql.ErrNoRows when there is no such snapshot.
+func (s *SQLite3) FindSnapshot(ctx context.Context, fsName FSName, at time.Time) (*Snapshot, error) {
+	snapshots, err := s.ListSnapshots(ctx, fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	return findSnapshotAt(fsName, snapshots, at)
+}
+
+// GetSnapshotEntries returns the entries of the file system fsName as of the snapshot.
+// It returns an error that wraps sql.ErrNoRows when the snapshot is not kept.
+func (s *SQLite3) GetSnapshotEntries(ctx context.Context, fsName FSName, snapshotID uint64) ([]FSEntry, error) {
+	snapshots, err := s.ListSnapshots(ctx, fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = findSnapshotByID(fsName, snapshots, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	states, err := s.getSnapshotEntryStates(
+		ctx,
+		`fs_name = :fs_name
+		 AND valid_from <= :snapshot_id
+		 AND (valid_to IS NULL OR valid_to > :snapshot_id)`,
+		sql.Named("fs_name", fsName),
+		sql.Named("snapshot_id", snapshotID),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	fsEntries := make([]FSEntry, 0, len(states))
+	for _, state := range states {
+		fsEntries = append(fsEntries, state.entry)
+	}
+
+	return fsEntries, nil
+}
+
+// GetSnapshotMutations returns the mutations of the file system fsName between the snapshots
+// fromID and toID, as GetFileSystemMutations does between VersionPrevious and VersionNew.
+func (s *SQLite3) GetSnapshotMutations(ctx context.Context, fsName FSName, fromID, toID uint64) (FSMutations, error) {
+	fromEntries, err := s.GetSnapshotEntries(ctx, fsName, fromID)
+	if err != nil {
+		return nil, err
+	}
+
+	toEntries, err := s.GetSnapshotEntries(ctx, fsName, toID)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffSnapshotEntries(fromEntries, toEntries), nil
+}
+
+// GetPathLastChange returns the snapshot in which the entry at path, in full, last changed in
+// the file system fsName: it was created, modified, moved or removed.
+// A path that has not changed since the oldest snapshot kept returns the oldest snapshot.
+// It returns an error that wraps sql.ErrNoRows when no snapshot holds an entry at path.
+func (s *SQLite3) GetPathLastChange(ctx context.Context, fsName FSName, path string) (*Snapshot, error) {
+	states, err := s.getSnapshotEntryStates(
+		ctx,
+		`fs_name = :fs_name
+		 AND name = :name`,
+		sql.Named("fs_name", fsName),
+		sql.Named("name", filepath.Base(path)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	lastChange, ok := lastChangeOfPath(path, states)
+	if !ok {
+		return nil, errors.Wrapf(sql.ErrNoRows, "no snapshot of file system '%s' holds '%s'", fsName, path)
+	}
+
+	snapshots, err := s.ListSnapshots(ctx, fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	return findSnapshotByID(fsName, snapshots, lastChange)
+}
+
+func (s *SQLite3) getSnapshotEntryStates(ctx context.Context, where string, args ...interface{}) ([]snapshotEntryState, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT fs_name, device_id, entry_id, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs, valid_from, COALESCE(valid_to, 0)
+		 FROM "snapshot_entries"
+		 WHERE `+where+`
+		 ORDER BY device_id, entry_id, valid_from`,
+		args...,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	return scanSnapshotEntryStates(rows)
+}
+
+func scanSnapshotEntryStates(rows *sql.Rows) ([]snapshotEntryState, error) {
+	states := []snapshotEntryState{}
+
+	for rows.Next() {
+		state := snapshotEntryState{}
+
+		err := rows.Scan(
+			&state.entry.FSName,
+			&state.entry.DeviceID,
+			&state.entry.EntryID,
+			&state.entry.IsFolder,
+			&state.entry.Path,
+			&state.entry.Name,
+			&state.entry.ParentFolderID,
+			&state.entry.Created,
+			&state.entry.Modified,
+			&state.entry.Size,
+			&state.entry.Hash,
+			&state.entry.ContentHashAlgorithm,
+			&state.entry.ContentHash,
+			&state.entry.Kind,
+			&state.entry.LinkTarget,
+			&state.entry.Mode,
+			&state.entry.UID,
+			&state.entry.GID,
+			&state.entry.Xattrs,
+			&state.validFrom,
+			&state.validTo,
+		)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		states = append(states, state)
+	}
+
+	err := rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return states, nil
+}
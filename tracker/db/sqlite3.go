@@ -3,6 +3,8 @@ package db
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"path/filepath"
 	"time"
@@ -23,11 +25,16 @@ type FSDriver string
 const (
 	FSDriverPCloud FSDriver = "pCloud"
 	FSDriverLocal  FSDriver = "Local"
+	FSDriverS3     FSDriver = "S3"
+	FSDriverSFTP   FSDriver = "SFTP"
 )
 
+// SQLite3Filename is the name of the file of the sqlite3 database in its folder.
+const SQLite3Filename = "tracker.db"
+
 // NewSQLite3 creates a new initialised SQLite3.
 func NewSQLite3(ctx context.Context, dbPath string) (*SQLite3, error) {
-	dbPathFilename := filepath.Join(dbPath, "tracker.db")
+	dbPathFilename := filepath.Join(dbPath, SQLite3Filename)
 
 	db, err := sql.Open("sqlite3", dbPathFilename)
 	if err != nil {
@@ -53,6 +60,10 @@ const (
 	VersionPrevious Version = "Previous"
 	// VersionNew is the newer version of file system entries in the database.
 	VersionNew Version = "New"
+	// VersionStaging is the version of file system entries in the database that a refresh is
+	// being written to. It replaces VersionNew once the refresh completes (see
+	// CommitVersionStaging).
+	VersionStaging Version = "Staging"
 )
 
 // FSEntry is a set of details about an entry (folder or file) in the file system.
@@ -67,11 +78,95 @@ type FSEntry struct {
 	Created        time.Time
 	Modified       time.Time
 	Size           uint64
-	Hash           string
+	Hash           string // identifies the state of a file on its own file system
+
+	// ContentHash is the hash of the contents of a file, computed with ContentHashAlgorithm.
+	// Unlike Hash, it is comparable across file systems.
+	ContentHashAlgorithm HashAlgorithm
+	ContentHash          string
+
+	// Kind is the kind of the entry. IsFolder is true for EntryKindFolder only.
+	Kind       EntryKind
+	LinkTarget string // target of a symbolic link, as stored in the link
+
+	// Mode holds the POSIX permission bits of the entry, including the setuid, setgid and sticky
+	// bits. Mode, UID, GID and Xattrs are only tracked by file systems that support them: a zero
+	// Mode indicates they are not tracked.
+	Mode   uint32
+	UID    uint32
+	GID    uint32
+	Xattrs Xattrs
+}
+
+// EntryKind is the kind of a file system entry.
+type EntryKind string
+
+const (
+	// EntryKindFile is a regular file.
+	EntryKindFile EntryKind = "file"
+	// EntryKindFolder is a folder.
+	EntryKindFolder EntryKind = "folder"
+	// EntryKindSymlink is a symbolic link, tracked as such rather than as its target.
+	EntryKindSymlink EntryKind = "symlink"
+	// EntryKindOther is a special file, such as a named pipe, a socket or a device. The data of
+	// special files is neither hashed nor synced.
+	EntryKindOther EntryKind = "other"
+)
+
+// Xattrs holds the extended attributes of a file system entry, by name.
+type Xattrs map[string][]byte
+
+// Value implements driver.Valuer. The attributes are stored as a JSON object, or an empty string
+// when there are none.
+func (x Xattrs) Value() (driver.Value, error) {
+	if len(x) == 0 {
+		return "", nil
+	}
+
+	// json.Marshal sorts the keys of maps, which makes the stored value comparable.
+	data, err := json.Marshal(map[string][]byte(x))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner.
+func (x *Xattrs) Scan(src interface{}) error {
+	var data []byte
+
+	switch v := src.(type) {
+	case nil:
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return errors.Errorf("unsupported type '%T' for Xattrs", src)
+	}
+
+	if len(data) == 0 {
+		*x = nil
+		return nil
+	}
+
+	return errors.WithStack(json.Unmarshal(data, (*map[string][]byte)(x)))
 }
 
+// HashAlgorithm is the algorithm used to compute the content hash of a file.
+type HashAlgorithm string
+
+const (
+	// HashAlgorithmSHA1 is the SHA1 algorithm, hex encoded.
+	HashAlgorithmSHA1 HashAlgorithm = "sha1"
+	// HashAlgorithmSHA256 is the SHA256 algorithm, hex encoded.
+	HashAlgorithmSHA256 HashAlgorithm = "sha256"
+)
+
 type config struct {
 	entriesChSize int
+	version       Version
 }
 
 // Options defines the signature of a functional parameter for AddNewFileSystemEntries.
@@ -85,6 +180,14 @@ func WithEntriesChannelSize(n int) Options {
 	}
 }
 
+// WithStagingVersion is a functional parameter that makes AddNewFileSystemEntries add the entries
+// to VersionStaging rather than to VersionNew.
+func WithStagingVersion() Options {
+	return func(obj *config) {
+		obj.version = VersionStaging
+	}
+}
+
 // GetSyncDetails returns the driver for the specified file system name and the root path.
 func (s *SQLite3) GetSyncDetails(ctx context.Context, fsName FSName) (FSDriver, string, error) {
 	var fsDriver FSDriver
@@ -92,9 +195,8 @@ func (s *SQLite3) GetSyncDetails(ctx context.Context, fsName FSName) (FSDriver,
 
 	err := s.db.QueryRowContext(
 		ctx,
-		`SELECT fs_driver, fs_root FROM "sync" WHERE "fs_name" = ?`,
+		`SELECT fs_driver, fs_root FROM "fs_info" WHERE "fs_name" = ?`,
 		fsName,
-		fsRoot,
 	).Scan(&fsDriver, &fsRoot)
 
 	return fsDriver, fsRoot, errors.WithStack(err)
@@ -108,6 +210,7 @@ func (s *SQLite3) GetSyncDetails(ctx context.Context, fsName FSName) (FSDriver,
 func (s *SQLite3) AddNewFileSystemEntries(ctx context.Context, opts ...Options) (chan<- FSEntry, <-chan error) {
 	cfg := config{
 		entriesChSize: 100,
+		version:       VersionNew,
 	}
 	for _, opt := range opts {
 		opt(&cfg)
@@ -129,10 +232,10 @@ func (s *SQLite3) AddNewFileSystemEntries(ctx context.Context, opts ...Options)
 			_, err = tx.ExecContext(
 				ctx,
 				`INSERT INTO "filesystem"
-			(fs_name, version, device_id, entry_id, is_folder, path, name, parent_folder_id, created, modified, size, hash)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			(fs_name, version, device_id, entry_id, is_folder, path, name, parent_folder_id, created, modified, size, hash, content_hash_algorithm, content_hash, kind, link_target, mode, uid, gid, xattrs)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 				entry.FSName,
-				VersionNew,
+				cfg.version,
 				entry.DeviceID,
 				fmt.Sprintf("%d", entry.EntryID),
 				entry.IsFolder,
@@ -143,6 +246,14 @@ func (s *SQLite3) AddNewFileSystemEntries(ctx context.Context, opts ...Options)
 				entry.Modified,
 				entry.Size,
 				entry.Hash,
+				entry.ContentHashAlgorithm,
+				entry.ContentHash,
+				entry.Kind,
+				entry.LinkTarget,
+				entry.Mode,
+				entry.UID,
+				entry.GID,
+				entry.Xattrs,
 			)
 			if err != nil {
 				errCh <- doRollback(tx, errors.WithMessagef(err, "deviceID: %s entryID: %d", entry.DeviceID, entry.EntryID))
@@ -172,7 +283,7 @@ func (s *SQLite3) getFileSystemEntries(ctx context.Context, fsName FSName, versi
 	rows, err := s.db.QueryContext(
 		ctx,
 		`SELECT
-			"type",
+			fs_name,
 			device_id,
 			entry_id,
 			is_folder,
@@ -182,9 +293,17 @@ func (s *SQLite3) getFileSystemEntries(ctx context.Context, fsName FSName, versi
 			created,
 			modified,
 			size,
-			hash
+			hash,
+			content_hash_algorithm,
+			content_hash,
+			kind,
+			link_target,
+			mode,
+			uid,
+			gid,
+			xattrs
 		 FROM "filesystem"
-		 WHERE type = ?
+		 WHERE fs_name = ?
 		   AND version = ?`,
 		fsName,
 		version,
@@ -210,6 +329,14 @@ func (s *SQLite3) getFileSystemEntries(ctx context.Context, fsName FSName, versi
 			&entry.Modified,
 			&entry.Size,
 			&entry.Hash,
+			&entry.ContentHashAlgorithm,
+			&entry.ContentHash,
+			&entry.Kind,
+			&entry.LinkTarget,
+			&entry.Mode,
+			&entry.UID,
+			&entry.GID,
+			&entry.Xattrs,
 		)
 		if err != nil {
 			return nil, errors.WithStack(err)
@@ -268,42 +395,11 @@ const (
 	MutationTypeModified MutationType = "modified"
 	// MutationTypeMoved means a file move on the file system.
 	MutationTypeMoved MutationType = "moved"
+	// MutationTypeMovedAndModified means a file move on the file system along with a modification
+	// of its content or its metadata. It is applied as a move followed by a modification.
+	MutationTypeMovedAndModified MutationType = "moved_and_modified"
 )
 
-// GetPCloudVsLocalMutations returns a slice of mutations that exist between the pCloud file system
-// and the local file system.
-func (s *SQLite3) GetPCloudVsLocalMutations(ctx context.Context) (FSMutations, error) {
-	// nolint: rowserrcheck
-	rows, err := s.db.QueryContext(
-		ctx,
-		`SELECT scm.mutation_type,
-				fs.type,
-				fs.version,
-			    fs.device_id,
-			    fs.entry_id,
-			    fs.is_folder,
-			    fs.path,
-			    fs.name,
-			    fs.parent_folder_id,
-			    fs.created,
-			    fs.modified,
-			    fs.size,
-			    fs.hash
-		 FROM staging_cross_mutations scm
-			  LEFT OUTER JOIN filesystem fs
-			  ON scm.fs_name = fs.type
-			     AND scm.device_id = fs.device_id
-				 AND scm.entry_id = fs.entry_id
-		 ORDER BY scm.mutation_type, fs.entry_id, fs.version DESC`, // `fs.version DESC`: `Previous` before `New`
-	)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-	defer func() { _ = rows.Close() }()
-
-	return processFSMutationsRows(rows)
-}
-
 func processFSMutationsRows(rows *sql.Rows) (FSMutations, error) {
 	fsMutations := FSMutations{}
 	fsm := FSMutation{}
@@ -357,7 +453,7 @@ func sanitiseFSMDetails(fsm FSMutation) error {
 		if len(fsm.Details) != 1 {
 			return errors.Errorf("mutation with more than the expected single state in Details")
 		}
-	case MutationTypeModified, MutationTypeMoved:
+	case MutationTypeModified, MutationTypeMoved, MutationTypeMovedAndModified:
 		if len(fsm.Details) != 2 {
 			return errors.Errorf("mutation with more than the expected two states in Details")
 		}
@@ -393,6 +489,14 @@ func getMutationDetails(rows *sql.Rows) (*MutationType, *Version, *FSEntry, erro
 		&fsEntry.Modified,
 		&fsEntry.Size,
 		&fsEntry.Hash,
+		&fsEntry.ContentHashAlgorithm,
+		&fsEntry.ContentHash,
+		&fsEntry.Kind,
+		&fsEntry.LinkTarget,
+		&fsEntry.Mode,
+		&fsEntry.UID,
+		&fsEntry.GID,
+		&fsEntry.Xattrs,
 	)
 	if err != nil {
 		return nil, nil, nil, errors.WithStack(err)
@@ -419,15 +523,26 @@ func (s *SQLite3) GetFileSystemMutations(ctx context.Context, fsName FSName) (FS
 			    fs.created,
 			    fs.modified,
 			    fs.size,
-			    fs.hash
+			    fs.hash,
+			    fs.content_hash_algorithm,
+			    fs.content_hash,
+			    fs.kind,
+			    fs.link_target,
+			    fs.mode,
+			    fs.uid,
+			    fs.gid,
+			    fs.xattrs
 		 FROM staging_fs_mutations scm
 			  LEFT OUTER JOIN filesystem fs
 			  ON scm.fs_name = fs.fs_name
 			  	 AND scm.device_id = fs.device_id
 			  	 AND scm.entry_id = fs.entry_id
+			  	 -- the entries of a refresh in progress are not part of the mutations
+			  	 AND fs.version != :version_staging
 		 WHERE scm.fs_name = :fs_name
-		 ORDER BY scm.mutation_type, fs.entry_id, fs.version DESC`, // `fs.version DESC`: `Previous` before `New`
+		 ORDER BY scm.mutation_type, fs.device_id, fs.entry_id, fs.version DESC`, // `fs.version DESC`: `Previous` before `New`
 		sql.Named("fs_name", fsName),
+		sql.Named("version_staging", VersionStaging),
 	)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -437,153 +552,14 @@ func (s *SQLite3) GetFileSystemMutations(ctx context.Context, fsName FSName) (FS
 	return processFSMutationsRows(rows)
 }
 
-type SyncPair struct {
-	pairName PairName
-	fromFS   FSName
-	toFS     FSName
-}
-
-type PairName string
-
-func (s *SQLite3) findSyncPair(ctx context.Context, pairName PairName) (*SyncPair, error) {
-	var syncPair SyncPair
-
-	err := s.db.
-		QueryRowContext(
-			ctx,
-			`SELECT pair_name, from_fs, to_fs
-			 FROM sync_pairs
-			 WHERE pair_name == :pair_name`,
-			sql.Named("pair_name", pairName)).
-		Scan(&syncPair.pairName, &syncPair.fromFS, &syncPair.toFS)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-
-	return &syncPair, nil
-}
-
-func (s *SQLite3) findSyncPairsByFSName(ctx context.Context, fsName FSName) ([]SyncPair, error) {
-	rows, err := s.db.QueryContext(
-		ctx,
-		`SELECT pair_name, from_fs, to_fs
-		 FROM sync_pairs
-		 WHERE from_fs == :fs_name OR to_fs == :fs_name`,
-		sql.Named("fs_name", fsName),
-	)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-	defer func() { _ = rows.Close() }()
-
-	syncPairs := []SyncPair{}
-
-	for rows.Next() {
-		sp := SyncPair{}
-
-		err := rows.Scan(
-			&sp.fromFS,
-			&sp.toFS,
-		)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-
-		syncPairs = append(syncPairs, sp)
-	}
-
-	err = rows.Err()
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-
-	return syncPairs, nil
-}
-
-// TODO: for this to work reliably, path needs to be standardised (i.e. \ or /, C: or /, etc).
-func (s *SQLite3) refreshCrossFSMutationsStagingTable(ctx context.Context, fsName FSName) error {
-	syncPairs, err := s.findSyncPairsByFSName(ctx, fsName)
-	if err != nil {
-		return err
-	}
-
-	for _, pair := range syncPairs {
-		_, err = s.db.ExecContext(
-			ctx,
-			`WITH from_fs AS (SELECT type, device_id, entry_id, path, name, parent_folder_id, hash
-			                FROM filesystem
-						   WHERE version = :version_new
-					         AND type = :from_fs),
-			 to_fs AS (SELECT type, device_id, entry_id, path, name, parent_folder_id, hash
-				            FROM filesystem
-						   WHERE version = :version_new
-						     AND type = :to_fs)
-
-		INSERT INTO staging_cross_mutations
-
-		SELECT
-			:mutation_type_deleted,
-			from_fs.type,
-			from_fs.device_id,
-			from_fs.entry_id
-		 FROM from_fs LEFT OUTER JOIN to_fs USING (path, name)
-		 WHERE to_fs.entry_id IS NULL
-
-		 UNION
-
-		 SELECT
-			:mutation_type_created,
-			to_fs.type,
-			to_fs.device_id,
-			to_fs.entry_id
-		 FROM to_fs LEFT OUTER JOIN from_fs USING (path, name)
-		 WHERE from_fs.entry_id IS NULL
-
-		 UNION
-
-		 SELECT
-			:mutation_type_modified,
-			to_fs.type,
-			to_fs.device_id,
-			to_fs.entry_id
-		 FROM from_fs JOIN to_fs USING (path, name)
-		 WHERE from_fs.parent_folder_id = to_fs.parent_folder_id
-		   AND (
-		       -- hash is not relevant for folders and that's just fine
-		       from_fs.hash != to_fs.hash
-		   )
-
-		 UNION
-
-		 SELECT
-			:mutation_type_moved,
-			to_fs.type,
-			to_fs.device_id,
-			to_fs.entry_id
-		  FROM from_fs JOIN to_fs USING (path, name)
-		 -- it should be noted that a file may both move and change
-		 WHERE from_fs.parent_folder_id != to_fs.parent_folder_id`,
-			sql.Named("from_fs", pair.fromFS),
-			sql.Named("to_fs", pair.toFS),
-			sql.Named("version_new", VersionNew),
-			sql.Named("mutation_type_deleted", MutationTypeDeleted),
-			sql.Named("mutation_type_created", MutationTypeCreated),
-			sql.Named("mutation_type_modified", MutationTypeModified),
-			sql.Named("mutation_type_moved", MutationTypeMoved),
-		)
-	}
-
-	return errors.WithStack(err)
-}
-
-func (s *SQLite3) refreshFSMutationsStagingTable(ctx context.Context, fsName FSName) error {
-	_, err := s.db.ExecContext(
+func (s *SQLite3) refreshFSMutationsStagingTable(ctx context.Context, tx *sql.Tx, fsName FSName) error {
+	_, err := tx.ExecContext(
 		ctx,
-		`WITH previous AS (SELECT fs_name, version, device_id, entry_id, parent_folder_id, hash
+		`WITH previous AS (SELECT fs_name, version, device_id, entry_id, parent_folder_id, name, hash, kind, link_target, mode, uid, gid, xattrs
 						     FROM filesystem
 						    WHERE version = :version_previous
 					          AND fs_name = :fs_name),
-				  new AS (SELECT fs_name, version, device_id, entry_id, parent_folder_id, hash
+				  new AS (SELECT fs_name, version, device_id, entry_id, parent_folder_id, name, hash, kind, link_target, mode, uid, gid, xattrs
 						    FROM filesystem
 						   WHERE version = :version_new
 						     AND fs_name = :fs_name)
@@ -613,29 +589,42 @@ func (s *SQLite3) refreshFSMutationsStagingTable(ctx context.Context, fsName FSN
 		 UNION
 
 		 SELECT
-			:mutation_type_modified,
+			-- renames are moves too. The entries whose path only changed because a folder above
+			-- them moved are not: the move of that folder moves them along.
+			CASE
+				WHEN new.parent_folder_id = previous.parent_folder_id AND new.name = previous.name
+				THEN :mutation_type_modified
+				-- an entry that both moved and changed must be moved then updated
+				WHEN (
+					-- hash is not relevant for folders and that's just fine
+					new.hash != previous.hash
+					-- changes to the metadata of the entries must be synced too
+					OR new.kind != previous.kind
+					OR new.link_target != previous.link_target
+					OR new.mode != previous.mode
+					OR new.uid != previous.uid
+					OR new.gid != previous.gid
+					OR new.xattrs != previous.xattrs
+				)
+				THEN :mutation_type_moved_and_modified
+				ELSE :mutation_type_moved
+			END,
 			new.fs_name,
 			new.version,
 			new.device_id,
 			new.entry_id
 		 FROM new JOIN previous USING (device_id, entry_id)
-		 WHERE new.parent_folder_id = previous.parent_folder_id
-			AND (
-				-- hash is not relevant for folders and that's just fine
+		 WHERE new.parent_folder_id != previous.parent_folder_id
+			OR new.name != previous.name
+			OR (
 				new.hash != previous.hash
-			)
-
-		 UNION
-
-		 SELECT
-			:mutation_type_moved,
-			new.fs_name,
-			new.version,
-			new.device_id,
-			new.entry_id
-		  FROM new JOIN previous USING (device_id, entry_id)
-		 -- it should be noted that a file may both move and change
-		 WHERE new.parent_folder_id != previous.parent_folder_id`,
+				OR new.kind != previous.kind
+				OR new.link_target != previous.link_target
+				OR new.mode != previous.mode
+				OR new.uid != previous.uid
+				OR new.gid != previous.gid
+				OR new.xattrs != previous.xattrs
+			)`,
 		sql.Named("fs_name", fsName),
 		sql.Named("version_previous", VersionPrevious),
 		sql.Named("version_new", VersionNew),
@@ -643,6 +632,7 @@ func (s *SQLite3) refreshFSMutationsStagingTable(ctx context.Context, fsName FSN
 		sql.Named("mutation_type_created", MutationTypeCreated),
 		sql.Named("mutation_type_modified", MutationTypeModified),
 		sql.Named("mutation_type_moved", MutationTypeMoved),
+		sql.Named("mutation_type_moved_and_modified", MutationTypeMovedAndModified),
 	)
 
 	return errors.WithStack(err)
@@ -651,29 +641,6 @@ func (s *SQLite3) refreshFSMutationsStagingTable(ctx context.Context, fsName FSN
 // FSName is a descriptive name for the tracked file system.
 type FSName string
 
-// SyncStatus defines the status of the sync. It is used to prevent refreshing data in the
-// filesystem table when it has not yet been completely sync'ed.
-// In particular, VersionPrevious should not be replaced with new data until the sync has
-// completed or some delta changes between "previous" and "new" will be lost and non-replicated.
-// This should not be confused with the sync that takes place across filesystems (such as cloud
-// vs local) which only involves VersionNew of each filesystems.
-// It is always safe to update VersionNew (but not VersionPrevious until processed).
-type SyncStatus string
-
-const (
-	// SyncStatusComplete indicates VersionPrevious has been completely sync'ed and can now be
-	// replaced with newer data.
-	SyncStatusComplete SyncStatus = "Complete"
-
-	// SyncStatusRequired indicates VersionPrevious has just been refreshed and requires
-	// sync'ing against VersionNew.
-	SyncStatusRequired SyncStatus = "Required"
-
-	// SyncStatusInProgress indicates that the sync between VersionPrevious and VersionNew is in
-	// progress.
-	SyncStatusInProgress SyncStatus = "In progress"
-)
-
 // DeleteVersionNew removes "new" file system entries for the specified file system.
 // This would be performed with a view to load a new "VersionNew" set, in replacement.
 func (s *SQLite3) DeleteVersionNew(ctx context.Context, fsName FSName) error {
@@ -808,49 +775,6 @@ func (s *SQLite3) RotateFileSystemVersions(ctx context.Context, fsName FSName) e
 	return nil
 }
 
-func (s *SQLite3) FindSyncPeers(ctx context.Context, fsName FSName) ([]FSName, error) {
-	panic("add a new table 'fs_status' (fs_name, fs_status) where fs_status can be ['changed', 'up-to-date']")
-	panic("when the above has been done, FindSyncPeers will likely work differently and so will the other *Sync*() methods")
-
-	rows, err := s.db.QueryContext(
-		ctx,
-		`SELECT from_fs AS fs_name,
-		 FROM sync_pairs
-		 WHERE to_fs == :fs_name
-
-		 UNION
-
-		 SELECT to_fs AS fs_name,
-		 FROM sync_pairs
-		 WHERE from_fs == :fs_name`,
-		sql.Named("fs_name", fsName),
-	)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-	defer func() { _ = rows.Close() }()
-
-	peers := []FSName{}
-
-	for rows.Next() {
-		var peer FSName
-
-		err := rows.Scan(&peer)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-
-		peers = append(peers, peer)
-	}
-
-	err = rows.Err()
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-
-	return peers, nil
-}
-
 // MarkFileSystemAsChanged marks the status of the file system as "changed".
 // This also triggers the internal refresh of all staging tables.
 func (s *SQLite3) MarkFileSystemAsChanged(ctx context.Context, fsName FSName) error {
@@ -859,9 +783,9 @@ func (s *SQLite3) MarkFileSystemAsChanged(ctx context.Context, fsName FSName) er
 		return errors.WithStack(err)
 	}
 
-	err = s.refreshFSMutationsStagingTable(ctx, fsName)
+	err = s.refreshFSMutationsStagingTable(ctx, tx, fsName)
 	if err != nil {
-		return errors.WithStack(err)
+		return doRollback(tx, err)
 	}
 
 	_, err = tx.ExecContext(
@@ -882,96 +806,33 @@ func (s *SQLite3) MarkFileSystemAsChanged(ctx context.Context, fsName FSName) er
 	return nil
 }
 
-// MarkSyncAsChanged marks the status of the sync as "changed".
-// This also triggers the internal refresh of all staging tables.
-func (s *SQLite3) MarkSyncAsChanged(ctx context.Context, fsName FSName) error {
-	panic("this should be for a sync pair, not file system by name")
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	syncPeers, err := s.FindSyncPeers(ctx, fsName)
-	if err != nil {
-		return err
-	}
-
-	syncPeers = append(syncPeers, fsName)
-
-	for _, fsName := range syncPeers {
-		err = s.refreshFSMutationsStagingTable(ctx, fsName)
-		if err != nil {
-			return errors.WithStack(err)
-		}
-	}
-
-	err = s.refreshCrossFSMutationsStagingTable(ctx, fsName)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	_, err = tx.ExecContext(
-		ctx,
-		`INSERT INTO "sync" ("fs_name", "status")
-		 VALUES (?, ?)
-		 ON CONFLICT ("fs_name")
-		 DO UPDATE SET "status" = excluded.status`,
-		fsName,
-		SyncStatusRequired,
-	)
-	if err != nil {
-		return doRollback(tx, err)
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		return doRollback(tx, err)
-	}
-
-	return nil
-}
-
-// MarkSyncInProgress marks the status of the sync as "in progress".
-func (s *SQLite3) MarkSyncInProgress(ctx context.Context, fsName FSName) error {
-	panic("this should be for a sync pair, not file system by name")
-	_, err := s.db.ExecContext(
-		ctx,
-		`INSERT INTO "sync" ("fs_name", "status")
-		 VALUES (?, ?)
-		 ON CONFLICT ("fs_name")
-		 DO UPDATE SET "status" = excluded.status`,
-		fsName,
-		SyncStatusInProgress,
-	)
-
-	return errors.WithStack(err)
+type FSInfo struct {
+	FSName    FSName
+	FSDriver  FSDriver
+	FSRoot    string
+	FSChanged bool
+	FSCursor  uint64 // position of the file system in its stream of changes, if it supports it
 }
 
-// MarkSyncComplete marks the status of the sync as "complete".
-// TODO: it may be that the staging table should be cleared down, although not essential because
-// that is properly taken care of by other methods that change the state of table "filesystem".
-func (s *SQLite3) MarkSyncComplete(ctx context.Context, fsName FSName) error {
-	panic("this should be for a sync pair, not file system by name")
+// RegisterFileSystem records the file system fsName, with its driver and its root path.
+// Registering a file system that already exists updates its driver and its root path but keeps
+// its status.
+func (s *SQLite3) RegisterFileSystem(ctx context.Context, fsName FSName, fsDriver FSDriver, fsRoot string) error {
 	_, err := s.db.ExecContext(
 		ctx,
-		`INSERT INTO "sync" ("fs_name", "status")
-		 VALUES (?, ?)
+		`INSERT INTO "fs_info" ("fs_name", "fs_driver", "fs_root")
+		 VALUES (?, ?, ?)
 		 ON CONFLICT ("fs_name")
-		 DO UPDATE SET "status" = excluded.status`,
+		 DO UPDATE SET "fs_driver" = excluded.fs_driver,
+		               "fs_root" = excluded.fs_root`,
 		fsName,
-		SyncStatusComplete,
+		fsDriver,
+		fsRoot,
 	)
 
 	return errors.WithStack(err)
 }
 
-type FSInfo struct {
-	FSName    FSName
-	FSDriver  FSDriver
-	FSRoot    string
-	FSChanged bool
-}
-
 // GetFileSystemInfo returns high level information about the file system fsName.
 // It will return an error (no rows found) if it cannot find the status row.
 func (s *SQLite3) GetFileSystemInfo(ctx context.Context, fsName FSName) (*FSInfo, error) {
@@ -979,7 +840,7 @@ func (s *SQLite3) GetFileSystemInfo(ctx context.Context, fsName FSName) (*FSInfo
 
 	err := s.db.QueryRowContext(
 		ctx,
-		`SELECT fs_name, fs_driver, fs_root, fs_changed
+		`SELECT fs_name, fs_driver, fs_root, fs_changed, fs_cursor
 		 FROM "fs_info"
 		 WHERE "fs_name" = ?`,
 		fsName,
@@ -988,6 +849,7 @@ func (s *SQLite3) GetFileSystemInfo(ctx context.Context, fsName FSName) (*FSInfo
 		&fsInfo.FSDriver,
 		&fsInfo.FSRoot,
 		&fsInfo.FSChanged,
+		&fsInfo.FSCursor,
 	)
 	if err != nil {
 		return nil, errors.WithStack(err)
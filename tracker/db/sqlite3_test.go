@@ -2,8 +2,10 @@ package db_test
 
 import (
 	"context"
+	"database/sql"
 	"os"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/require"
@@ -25,3 +27,832 @@ func TestSQLite3_MigrationsSuccess(t *testing.T) {
 	_, err = db.NewSQLite3(ctx, dbPath)
 	require.NoError(t, err)
 }
+
+func TestSQLite3_DirtyPaths(t *testing.T) {
+	const dbPath = "/tmp/data_dirty_paths_test"
+	ctx := context.Background()
+
+	err := os.RemoveAll(dbPath)
+	require.NoError(t, err)
+
+	err = os.MkdirAll(dbPath, 0700)
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dbPath) }()
+
+	store, err := db.NewSQLite3(ctx, dbPath)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	err = store.AddDirtyPaths(ctx, "local_fs", []string{"/a/File1", "/a/File2"})
+	require.NoError(t, err)
+
+	err = store.AddDirtyPaths(ctx, "other_fs", []string{"/b/File3"})
+	require.NoError(t, err)
+
+	dirtyPaths, err := store.GetDirtyPaths(ctx, "local_fs")
+	require.NoError(t, err)
+	require.False(t, dirtyPaths.FullRescan)
+	require.Equal(t, []string{"/a/File1", "/a/File2"}, dirtyPaths.Paths)
+
+	// File1 changes again after the dirty paths were read: it must not be cleared.
+	err = store.AddDirtyPaths(ctx, "local_fs", []string{"/a/File1"})
+	require.NoError(t, err)
+
+	err = store.MarkFullRescanRequired(ctx, "local_fs")
+	require.NoError(t, err)
+
+	err = store.ClearDirtyPaths(ctx, "local_fs", dirtyPaths.Seq)
+	require.NoError(t, err)
+
+	dirtyPaths, err = store.GetDirtyPaths(ctx, "local_fs")
+	require.NoError(t, err)
+	require.True(t, dirtyPaths.FullRescan)
+	require.Equal(t, []string{"/a/File1"}, dirtyPaths.Paths)
+
+	err = store.ClearDirtyPaths(ctx, "local_fs", dirtyPaths.Seq)
+	require.NoError(t, err)
+
+	dirtyPaths, err = store.GetDirtyPaths(ctx, "local_fs")
+	require.NoError(t, err)
+	require.False(t, dirtyPaths.FullRescan)
+	require.Empty(t, dirtyPaths.Paths)
+
+	dirtyPaths, err = store.GetDirtyPaths(ctx, "other_fs")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/b/File3"}, dirtyPaths.Paths)
+}
+
+func TestSQLite3_ContentHashes(t *testing.T) {
+	const dbPath = "/tmp/data_content_hashes_test"
+	ctx := context.Background()
+
+	err := os.RemoveAll(dbPath)
+	require.NoError(t, err)
+
+	err = os.MkdirAll(dbPath, 0700)
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dbPath) }()
+
+	store, err := db.NewSQLite3(ctx, dbPath)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	contentHashes, err := store.GetContentHashes(ctx, "pcloud_fs")
+	require.NoError(t, err)
+	require.Empty(t, contentHashes)
+
+	err = store.AddContentHashes(ctx, "pcloud_fs", []db.ContentHash{
+		{EntryID: 20002, Hash: "123", Algorithm: db.HashAlgorithmSHA1, Value: "aaa"},
+		{EntryID: 20003, Hash: "456", Algorithm: db.HashAlgorithmSHA256, Value: "bbb"},
+	})
+	require.NoError(t, err)
+
+	err = store.AddContentHashes(ctx, "other_fs", []db.ContentHash{
+		{EntryID: 20002, Hash: "789", Algorithm: db.HashAlgorithmSHA1, Value: "ccc"},
+	})
+	require.NoError(t, err)
+
+	// the content hash of File 20002 is replaced after it changed.
+	err = store.AddContentHashes(ctx, "pcloud_fs", []db.ContentHash{
+		{EntryID: 20002, Hash: "124", Algorithm: db.HashAlgorithmSHA1, Value: "ddd"},
+	})
+	require.NoError(t, err)
+
+	contentHashes, err = store.GetContentHashes(ctx, "pcloud_fs")
+	require.NoError(t, err)
+
+	expected := map[uint64]db.ContentHash{
+		20002: {EntryID: 20002, Hash: "124", Algorithm: db.HashAlgorithmSHA1, Value: "ddd"},
+		20003: {EntryID: 20003, Hash: "456", Algorithm: db.HashAlgorithmSHA256, Value: "bbb"},
+	}
+	require.Equal(t, expected, contentHashes)
+}
+
+func TestSQLite3_FileSystemEntriesContentHash(t *testing.T) {
+	const dbPath = "/tmp/data_content_hash_test"
+	ctx := context.Background()
+
+	err := os.RemoveAll(dbPath)
+	require.NoError(t, err)
+
+	err = os.MkdirAll(dbPath, 0700)
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dbPath) }()
+
+	store, err := db.NewSQLite3(ctx, dbPath)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	created := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	fsEntries := []db.FSEntry{
+		{
+			FSName:   "local_fs",
+			EntryID:  1,
+			IsFolder: true,
+			Path:     "/",
+			Name:     "/",
+			Created:  created,
+			Modified: created,
+		},
+		{
+			FSName:               "local_fs",
+			EntryID:              2,
+			Path:                 "/",
+			Name:                 "File1",
+			ParentFolderID:       1,
+			Created:              created,
+			Modified:             created,
+			Size:                 3,
+			Hash:                 "aaa",
+			ContentHashAlgorithm: db.HashAlgorithmSHA1,
+			ContentHash:          "aaa",
+		},
+	}
+
+	fsEntriesCh, errCh := store.AddNewFileSystemEntries(ctx)
+	for _, fsEntry := range fsEntries {
+		fsEntriesCh <- fsEntry
+	}
+	close(fsEntriesCh)
+	require.NoError(t, <-errCh)
+
+	latest, err := store.GetLatestFileSystemEntries(ctx, "local_fs")
+	require.NoError(t, err)
+	require.Equal(t, fsEntries, latest)
+}
+
+func TestSQLite3_FileSystemEntriesMetadata(t *testing.T) {
+	const dbPath = "/tmp/data_metadata_test"
+	ctx := context.Background()
+
+	err := os.RemoveAll(dbPath)
+	require.NoError(t, err)
+
+	err = os.MkdirAll(dbPath, 0700)
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dbPath) }()
+
+	store, err := db.NewSQLite3(ctx, dbPath)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	created := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	fsEntries := []db.FSEntry{
+		{
+			FSName:   "local_fs",
+			EntryID:  1,
+			IsFolder: true,
+			Path:     "/",
+			Name:     "/",
+			Created:  created,
+			Modified: created,
+			Kind:     db.EntryKindFolder,
+			Mode:     0o755,
+		},
+		{
+			FSName:         "local_fs",
+			EntryID:        2,
+			Path:           "/",
+			Name:           "File1",
+			ParentFolderID: 1,
+			Created:        created,
+			Modified:       created,
+			Size:           3,
+			Hash:           "aaa",
+			Kind:           db.EntryKindFile,
+			Mode:           0o4640,
+			UID:            1000,
+			GID:            100,
+			Xattrs:         db.Xattrs{"user.b": []byte("2"), "user.a": []byte{0, 1}},
+		},
+		{
+			FSName:         "local_fs",
+			EntryID:        3,
+			Path:           "/",
+			Name:           "Link1",
+			ParentFolderID: 1,
+			Created:        created,
+			Modified:       created,
+			Size:           5,
+			Kind:           db.EntryKindSymlink,
+			LinkTarget:     "File1",
+			Mode:           0o777,
+		},
+	}
+
+	addEntries := func(fsEntries []db.FSEntry) {
+		fsEntriesCh, errCh := store.AddNewFileSystemEntries(ctx)
+		for _, fsEntry := range fsEntries {
+			fsEntriesCh <- fsEntry
+		}
+		close(fsEntriesCh)
+		require.NoError(t, <-errCh)
+	}
+
+	addEntries(fsEntries)
+
+	latest, err := store.GetLatestFileSystemEntries(ctx, "local_fs")
+	require.NoError(t, err)
+	require.Equal(t, fsEntries, latest)
+
+	// changes to the metadata alone are modifications.
+	err = store.RotateFileSystemVersions(ctx, "local_fs")
+	require.NoError(t, err)
+
+	modifiedEntries := append([]db.FSEntry{}, fsEntries...)
+	modifiedEntries[1].Mode = 0o600
+	modifiedEntries[2].LinkTarget = "File2"
+	addEntries(modifiedEntries)
+
+	err = store.MarkFileSystemAsChanged(ctx, "local_fs")
+	require.NoError(t, err)
+
+	mutations, err := store.GetFileSystemMutations(ctx, "local_fs")
+	require.NoError(t, err)
+	require.Len(t, mutations, 2)
+
+	for i, entryID := range []uint64{2, 3} {
+		require.Equal(t, db.MutationTypeModified, mutations[i].Type)
+		require.Len(t, mutations[i].Details, 2)
+		require.Equal(t, entryID, mutations[i].Details[1].EntryID)
+		require.Equal(t, modifiedEntries[entryID-1], mutations[i].Details[1].FSEntry)
+	}
+}
+
+func TestSQLite3_FileSystemRoots(t *testing.T) {
+	const dbPath = "/tmp/data_roots_test"
+	ctx := context.Background()
+
+	err := os.RemoveAll(dbPath)
+	require.NoError(t, err)
+
+	err = os.MkdirAll(dbPath, 0700)
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dbPath) }()
+
+	store, err := db.NewSQLite3(ctx, dbPath)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	roots, err := store.GetFileSystemRoots(ctx, "local_fs")
+	require.NoError(t, err)
+	require.Empty(t, roots)
+
+	err = store.SetFileSystemRoots(ctx, "local_fs", []db.FSRoot{
+		{Name: "photos", Path: "/mnt/photos"},
+		{Name: "media", Path: "/mnt/media"},
+	})
+	require.NoError(t, err)
+
+	err = store.SetFileSystemRoots(ctx, "other_fs", []db.FSRoot{{Name: "other", Path: "/mnt/other"}})
+	require.NoError(t, err)
+
+	roots, err = store.GetFileSystemRoots(ctx, "local_fs")
+	require.NoError(t, err)
+	require.Equal(t, []db.FSRoot{{Name: "media", Path: "/mnt/media"}, {Name: "photos", Path: "/mnt/photos"}}, roots)
+
+	// the roots are replaced.
+	err = store.SetFileSystemRoots(ctx, "local_fs", []db.FSRoot{{Name: "media", Path: "/mnt/media2"}})
+	require.NoError(t, err)
+
+	roots, err = store.GetFileSystemRoots(ctx, "local_fs")
+	require.NoError(t, err)
+	require.Equal(t, []db.FSRoot{{Name: "media", Path: "/mnt/media2"}}, roots)
+
+	err = store.SetFileSystemRoots(ctx, "local_fs", []db.FSRoot{{Name: "a/b", Path: "/mnt/media"}})
+	require.Error(t, err)
+}
+
+func TestSQLite3_FileSystemMutations_InodeCollisions(t *testing.T) {
+	const dbPath = "/tmp/data_inode_collisions_test"
+	ctx := context.Background()
+
+	err := os.RemoveAll(dbPath)
+	require.NoError(t, err)
+
+	err = os.MkdirAll(dbPath, 0700)
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dbPath) }()
+
+	store, err := db.NewSQLite3(ctx, dbPath)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	created := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// the same inodes exist on both mounts.
+	fsEntries := []db.FSEntry{
+		{FSName: "local_fs", IsFolder: true, Path: "/", Name: "/", Created: created, Modified: created, Kind: db.EntryKindFolder},
+		{FSName: "local_fs", DeviceID: "1", EntryID: 2, IsFolder: true, Path: "/", Name: "media", Created: created, Modified: created, Kind: db.EntryKindFolder},
+		{FSName: "local_fs", DeviceID: "1", EntryID: 12, Path: "/media", Name: "File1", ParentFolderID: 2, Created: created, Modified: created, Hash: "aaa", Kind: db.EntryKindFile},
+		{FSName: "local_fs", DeviceID: "2", EntryID: 2, IsFolder: true, Path: "/", Name: "photos", Created: created, Modified: created, Kind: db.EntryKindFolder},
+		{FSName: "local_fs", DeviceID: "2", EntryID: 12, Path: "/photos", Name: "File2", ParentFolderID: 2, Created: created, Modified: created, Hash: "bbb", Kind: db.EntryKindFile},
+	}
+
+	addEntries := func(fsEntries []db.FSEntry) {
+		fsEntriesCh, errCh := store.AddNewFileSystemEntries(ctx)
+		for _, fsEntry := range fsEntries {
+			fsEntriesCh <- fsEntry
+		}
+		close(fsEntriesCh)
+		require.NoError(t, <-errCh)
+	}
+
+	addEntries(fsEntries)
+
+	err = store.RotateFileSystemVersions(ctx, "local_fs")
+	require.NoError(t, err)
+
+	modifiedEntries := append([]db.FSEntry{}, fsEntries...)
+	modifiedEntries[2].Hash = "aaa2"
+	modifiedEntries[4].Hash = "bbb2"
+	addEntries(modifiedEntries)
+
+	err = store.MarkFileSystemAsChanged(ctx, "local_fs")
+	require.NoError(t, err)
+
+	mutations, err := store.GetFileSystemMutations(ctx, "local_fs")
+	require.NoError(t, err)
+	require.Len(t, mutations, 2)
+
+	for i, expected := range []db.FSEntry{modifiedEntries[2], modifiedEntries[4]} {
+		require.Equal(t, db.MutationTypeModified, mutations[i].Type)
+		require.Len(t, mutations[i].Details, 2)
+		require.Equal(t, fsEntries[2*i+2], mutations[i].Details[0].FSEntry)
+		require.Equal(t, expected, mutations[i].Details[1].FSEntry)
+	}
+}
+
+func TestSQLite3_SyncPairs(t *testing.T) {
+	const dbPath = "/tmp/data_sync_pairs_test"
+	ctx := context.Background()
+
+	err := os.RemoveAll(dbPath)
+	require.NoError(t, err)
+
+	err = os.MkdirAll(dbPath, 0700)
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dbPath) }()
+
+	store, err := db.NewSQLite3(ctx, dbPath)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	err = store.RegisterFileSystem(ctx, "pcloud_fs", db.FSDriverPCloud, "/")
+	require.NoError(t, err)
+
+	err = store.RegisterFileSystem(ctx, "local_fs", db.FSDriverLocal, "/tmp")
+	require.NoError(t, err)
+
+	// registering again updates the file system.
+	err = store.RegisterFileSystem(ctx, "local_fs", db.FSDriverLocal, "/home/user/pcloud")
+	require.NoError(t, err)
+
+	fsDriver, fsRoot, err := store.GetSyncDetails(ctx, "local_fs")
+	require.NoError(t, err)
+	require.Equal(t, db.FSDriverLocal, fsDriver)
+	require.Equal(t, "/home/user/pcloud", fsRoot)
+
+	err = store.CreateSyncPair(ctx, db.SyncPair{PairName: "pair1", FromFS: "pcloud_fs", ToFS: "unknown_fs"})
+	require.Error(t, err)
+
+	err = store.CreateSyncPair(ctx, db.SyncPair{PairName: "pair1", FromFS: "pcloud_fs", ToFS: "pcloud_fs"})
+	require.Error(t, err)
+
+	err = store.CreateSyncPair(ctx, db.SyncPair{PairName: "pair2", FromFS: "local_fs", ToFS: "pcloud_fs"})
+	require.NoError(t, err)
+
+	err = store.CreateSyncPair(ctx, db.SyncPair{PairName: "pair1", FromFS: "pcloud_fs", ToFS: "local_fs"})
+	require.NoError(t, err)
+
+	err = store.CreateSyncPair(ctx, db.SyncPair{PairName: "pair1", FromFS: "local_fs", ToFS: "pcloud_fs"})
+	require.Error(t, err)
+
+	syncPairs, err := store.ListSyncPairs(ctx)
+	require.NoError(t, err)
+	require.Equal(t,
+		[]db.SyncPair{
+			{PairName: "pair1", FromFS: "pcloud_fs", ToFS: "local_fs"},
+			{PairName: "pair2", FromFS: "local_fs", ToFS: "pcloud_fs"},
+		},
+		syncPairs,
+	)
+
+	err = store.DeleteSyncPair(ctx, "pair2")
+	require.NoError(t, err)
+
+	err = store.DeleteSyncPair(ctx, "pair2")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	syncPairs, err = store.ListSyncPairs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []db.SyncPair{{PairName: "pair1", FromFS: "pcloud_fs", ToFS: "local_fs"}}, syncPairs)
+
+	_, err = store.GetCrossFSMutations(ctx, "pair2")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestSQLite3_GetCrossFSMutations(t *testing.T) {
+	const dbPath = "/tmp/data_cross_mutations_test"
+	ctx := context.Background()
+
+	err := os.RemoveAll(dbPath)
+	require.NoError(t, err)
+
+	err = os.MkdirAll(dbPath, 0700)
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dbPath) }()
+
+	store, err := db.NewSQLite3(ctx, dbPath)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	err = store.RegisterFileSystem(ctx, "pcloud_fs", db.FSDriverPCloud, "/")
+	require.NoError(t, err)
+
+	err = store.RegisterFileSystem(ctx, "local_fs", db.FSDriverLocal, "/home/user/pcloud")
+	require.NoError(t, err)
+
+	err = store.CreateSyncPair(ctx, db.SyncPair{PairName: "pcloud_to_local", FromFS: "pcloud_fs", ToFS: "local_fs"})
+	require.NoError(t, err)
+
+	created := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	pCloudEntries := []db.FSEntry{
+		{FSName: "pcloud_fs", EntryID: 0, IsFolder: true, Path: "/", Name: "/", Created: created, Modified: created, Kind: db.EntryKindFolder},
+		{FSName: "pcloud_fs", EntryID: 10, IsFolder: true, Path: "/", Name: "Folder1", Created: created, Modified: created, Kind: db.EntryKindFolder},
+		{FSName: "pcloud_fs", EntryID: 11, Path: "/Folder1", Name: "File1", ParentFolderID: 10, Created: created, Modified: created, Size: 3, Hash: "101", ContentHashAlgorithm: db.HashAlgorithmSHA1, ContentHash: "aaa", Kind: db.EntryKindFile},
+		{FSName: "pcloud_fs", EntryID: 12, Path: "/", Name: "File2", Created: created, Modified: created, Size: 3, Hash: "102", ContentHashAlgorithm: db.HashAlgorithmSHA1, ContentHash: "bbb", Kind: db.EntryKindFile},
+		{FSName: "pcloud_fs", EntryID: 13, Path: "/", Name: "OnlyPCloud", Created: created, Modified: created, Size: 3, Hash: "103", ContentHashAlgorithm: db.HashAlgorithmSHA1, ContentHash: "ccc", Kind: db.EntryKindFile},
+	}
+
+	localEntries := []db.FSEntry{
+		{FSName: "local_fs", DeviceID: "1", EntryID: 1, IsFolder: true, Path: "/home/user", Name: "pcloud", ParentFolderID: 1, Created: created, Modified: created, Kind: db.EntryKindFolder, Mode: 0o755},
+		{FSName: "local_fs", DeviceID: "1", EntryID: 20, IsFolder: true, Path: "/home/user/pcloud", Name: "Folder1", ParentFolderID: 1, Created: created, Modified: created, Kind: db.EntryKindFolder, Mode: 0o755},
+		{FSName: "local_fs", DeviceID: "1", EntryID: 21, Path: "/home/user/pcloud/Folder1", Name: "File1", ParentFolderID: 20, Created: created, Modified: created, Size: 3, Hash: "201", ContentHashAlgorithm: db.HashAlgorithmSHA1, ContentHash: "aaa", Kind: db.EntryKindFile, Mode: 0o644},
+		{FSName: "local_fs", DeviceID: "1", EntryID: 22, Path: "/home/user/pcloud", Name: "File2", ParentFolderID: 1, Created: created, Modified: created, Size: 4, Hash: "202", ContentHashAlgorithm: db.HashAlgorithmSHA1, ContentHash: "bbb2", Kind: db.EntryKindFile, Mode: 0o644},
+		{FSName: "local_fs", DeviceID: "1", EntryID: 23, Path: "/home/user/pcloud", Name: "OnlyLocal", ParentFolderID: 1, Created: created, Modified: created, Size: 3, Hash: "203", ContentHashAlgorithm: db.HashAlgorithmSHA1, ContentHash: "ddd", Kind: db.EntryKindFile, Mode: 0o644},
+	}
+
+	for _, fsEntries := range [][]db.FSEntry{pCloudEntries, localEntries} {
+		fsEntriesCh, errCh := store.AddNewFileSystemEntries(ctx)
+		for _, fsEntry := range fsEntries {
+			fsEntriesCh <- fsEntry
+		}
+		close(fsEntriesCh)
+		require.NoError(t, <-errCh)
+	}
+
+	mutations, err := store.GetCrossFSMutations(ctx, "pcloud_to_local")
+	require.NoError(t, err)
+
+	newEntry := func(fsEntry db.FSEntry) db.VersionedEntry {
+		return db.VersionedEntry{Version: db.VersionNew, FSEntry: fsEntry}
+	}
+
+	expected := db.FSMutations{
+		{Type: db.MutationTypeCreated, Details: db.EntryMutations{newEntry(pCloudEntries[4])}},
+		{Type: db.MutationTypeDeleted, Details: db.EntryMutations{newEntry(localEntries[4])}},
+		{Type: db.MutationTypeModified, Details: db.EntryMutations{newEntry(pCloudEntries[3]), newEntry(localEntries[3])}},
+	}
+	require.Equal(t, expected, mutations)
+
+	// the mutations are refreshed when the entries change.
+	err = store.DeleteVersionNew(ctx, "local_fs")
+	require.NoError(t, err)
+
+	mutations, err = store.GetCrossFSMutations(ctx, "pcloud_to_local")
+	require.NoError(t, err)
+	require.Len(t, mutations, len(pCloudEntries))
+
+	// sorted by relative path.
+	for i, j := range []int{0, 3, 1, 2, 4} {
+		require.Equal(t, db.MutationTypeCreated, mutations[i].Type)
+		require.Equal(t, db.EntryMutations{newEntry(pCloudEntries[j])}, mutations[i].Details)
+	}
+}
+
+func TestSQLite3_CommitVersionStaging(t *testing.T) {
+	const dbPath = "/tmp/data_commit_staging_test"
+	ctx := context.Background()
+
+	err := os.RemoveAll(dbPath)
+	require.NoError(t, err)
+
+	err = os.MkdirAll(dbPath, 0700)
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dbPath) }()
+
+	store, err := db.NewSQLite3(ctx, dbPath)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	err = store.RegisterFileSystem(ctx, "local_fs", db.FSDriverLocal, "/data")
+	require.NoError(t, err)
+
+	created := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	root := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 1, IsFolder: true, Path: "/", Name: "data", ParentFolderID: 1, Created: created, Modified: created, Kind: db.EntryKindFolder}
+	file1 := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 2, Path: "/data", Name: "File1", ParentFolderID: 1, Created: created, Modified: created, Hash: "aaa", Kind: db.EntryKindFile}
+	file2 := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 3, Path: "/data", Name: "File2", ParentFolderID: 1, Created: created, Modified: created, Hash: "bbb", Kind: db.EntryKindFile}
+	file3 := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 4, Path: "/data", Name: "File3", ParentFolderID: 1, Created: created, Modified: created, Hash: "ccc", Kind: db.EntryKindFile}
+
+	addStagingEntries := func(fsEntries ...db.FSEntry) {
+		fsEntriesCh, errCh := store.AddNewFileSystemEntries(ctx, db.WithStagingVersion())
+		for _, fsEntry := range fsEntries {
+			fsEntriesCh <- fsEntry
+		}
+		close(fsEntriesCh)
+		require.NoError(t, <-errCh)
+	}
+
+	requireVersions := func(previous, latest []db.FSEntry) {
+		fsEntries, err := store.GetPreviousFileSystemEntries(ctx, "local_fs")
+		require.NoError(t, err)
+		require.ElementsMatch(t, previous, fsEntries)
+
+		fsEntries, err = store.GetLatestFileSystemEntries(ctx, "local_fs")
+		require.NoError(t, err)
+		require.ElementsMatch(t, latest, fsEntries)
+	}
+
+	fsEntriesCh, errCh := store.AddNewFileSystemEntries(ctx)
+	for _, fsEntry := range []db.FSEntry{root, file1, file3} {
+		fsEntriesCh <- fsEntry
+	}
+	close(fsEntriesCh)
+	require.NoError(t, <-errCh)
+
+	// the staging entries are not visible until they are committed.
+	addStagingEntries(root, file1, file2)
+	requireVersions(nil, []db.FSEntry{root, file1, file3})
+
+	// the file system is not marked as changed: the versions rotate.
+	cursor := uint64(42)
+	err = store.CommitVersionStaging(ctx, "local_fs", db.StagingCommit{Cursor: &cursor})
+	require.NoError(t, err)
+	requireVersions([]db.FSEntry{root, file1, file3}, []db.FSEntry{root, file1, file2})
+
+	fsInfo, err := store.GetFileSystemInfo(ctx, "local_fs")
+	require.NoError(t, err)
+	require.True(t, fsInfo.FSChanged)
+	require.Equal(t, uint64(42), fsInfo.FSCursor)
+
+	mutations, err := store.GetFileSystemMutations(ctx, "local_fs")
+	require.NoError(t, err)
+	require.Len(t, mutations, 2)
+
+	// the file system is marked as changed: the previous version is preserved, less the
+	// excluded entries.
+	addStagingEntries(root, file2)
+	err = store.CommitVersionStaging(ctx, "local_fs", db.StagingCommit{ExcludedPreviousEntries: []db.FSEntry{file3}})
+	require.NoError(t, err)
+	requireVersions([]db.FSEntry{root, file1}, []db.FSEntry{root, file2})
+
+	fsInfo, err = store.GetFileSystemInfo(ctx, "local_fs")
+	require.NoError(t, err)
+	require.Equal(t, uint64(42), fsInfo.FSCursor)
+
+	mutations, err = store.GetFileSystemMutations(ctx, "local_fs")
+	require.NoError(t, err)
+	require.Len(t, mutations, 2)
+
+	// a refresh that does not complete leaves its staging entries behind.
+	addStagingEntries(root)
+
+	fsNames, err := store.RecoverIncompleteRefreshes(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []db.FSName{"local_fs"}, fsNames)
+	requireVersions([]db.FSEntry{root, file1}, []db.FSEntry{root, file2})
+
+	err = store.CommitVersionStaging(ctx, "unknown_fs", db.StagingCommit{})
+	require.Error(t, err)
+}
+
+func TestSQLite3_SyncStatus(t *testing.T) {
+	const dbPath = "/tmp/data_sync_status_test"
+	ctx := context.Background()
+
+	err := os.RemoveAll(dbPath)
+	require.NoError(t, err)
+
+	err = os.MkdirAll(dbPath, 0700)
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dbPath) }()
+
+	store, err := db.NewSQLite3(ctx, dbPath)
+	require.NoError(t, err)
+
+	for _, fsName := range []db.FSName{"local_fs", "pcloud_fs", "backup_fs"} {
+		err = store.RegisterFileSystem(ctx, fsName, db.FSDriverLocal, "/data")
+		require.NoError(t, err)
+	}
+
+	err = store.CreateSyncPair(ctx, db.SyncPair{PairName: "pair1", FromFS: "local_fs", ToFS: "pcloud_fs"})
+	require.NoError(t, err)
+
+	err = store.CreateSyncPair(ctx, db.SyncPair{PairName: "pair2", FromFS: "local_fs", ToFS: "backup_fs"})
+	require.NoError(t, err)
+
+	peers, err := store.FindSyncPeers(ctx, "local_fs")
+	require.NoError(t, err)
+	require.Equal(t, []db.FSName{"backup_fs", "pcloud_fs"}, peers)
+
+	peers, err = store.FindSyncPeers(ctx, "pcloud_fs")
+	require.NoError(t, err)
+	require.Equal(t, []db.FSName{"local_fs"}, peers)
+
+	created := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	root := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 1, IsFolder: true, Path: "/", Name: "data", ParentFolderID: 1, Created: created, Modified: created, Kind: db.EntryKindFolder}
+	file1 := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 2, Path: "/data", Name: "File1", ParentFolderID: 1, Created: created, Modified: created, Hash: "aaa", Kind: db.EntryKindFile}
+	file2 := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 3, Path: "/data", Name: "File2", ParentFolderID: 1, Created: created, Modified: created, Hash: "bbb", Kind: db.EntryKindFile}
+
+	refresh := func(fsEntries ...db.FSEntry) {
+		fsEntriesCh, errCh := store.AddNewFileSystemEntries(ctx, db.WithStagingVersion())
+		for _, fsEntry := range fsEntries {
+			fsEntriesCh <- fsEntry
+		}
+		close(fsEntriesCh)
+		require.NoError(t, <-errCh)
+
+		err := store.CommitVersionStaging(ctx, "local_fs", db.StagingCommit{})
+		require.NoError(t, err)
+	}
+
+	requireStatus := func(pairName db.PairName, expected db.SyncStatus) {
+		status, err := store.GetSyncStatus(ctx, pairName)
+		require.NoError(t, err)
+		require.Equal(t, expected, status)
+	}
+
+	requireFSChanged := func(expected bool) {
+		fsInfo, err := store.GetFileSystemInfo(ctx, "local_fs")
+		require.NoError(t, err)
+		require.Equal(t, expected, fsInfo.FSChanged)
+	}
+
+	requireStatus("pair1", db.SyncStatusRequired)
+	requireStatus("pair2", db.SyncStatusRequired)
+
+	_, err = store.GetSyncStatus(ctx, "unknown_pair")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	refresh(root, file1)
+	requireFSChanged(true)
+
+	// a sync can only complete once it is in progress.
+	err = store.MarkSyncComplete(ctx, "pair1")
+	require.NoError(t, err)
+	requireStatus("pair1", db.SyncStatusRequired)
+
+	err = store.MarkSyncInProgress(ctx, "pair1")
+	require.NoError(t, err)
+	requireStatus("pair1", db.SyncStatusInProgress)
+
+	// only one sync of a pair can be in progress.
+	err = store.MarkSyncInProgress(ctx, "pair1")
+	require.Error(t, err)
+
+	err = store.MarkSyncComplete(ctx, "pair1")
+	require.NoError(t, err)
+	requireStatus("pair1", db.SyncStatusComplete)
+
+	// pair2 has not yet been sync'ed.
+	requireFSChanged(true)
+
+	// a failed sync is required again.
+	err = store.MarkSyncInProgress(ctx, "pair2")
+	require.NoError(t, err)
+
+	err = store.MarkSyncAsChanged(ctx, "pair2")
+	require.NoError(t, err)
+	requireStatus("pair2", db.SyncStatusRequired)
+	requireFSChanged(true)
+
+	err = store.MarkSyncInProgress(ctx, "pair2")
+	require.NoError(t, err)
+
+	err = store.MarkSyncComplete(ctx, "pair2")
+	require.NoError(t, err)
+	requireStatus("pair2", db.SyncStatusComplete)
+
+	// all the syncs from local_fs are complete: its next refresh rotates the versions.
+	requireFSChanged(false)
+
+	refresh(root, file1, file2)
+	requireFSChanged(true)
+	requireStatus("pair1", db.SyncStatusRequired)
+	requireStatus("pair2", db.SyncStatusRequired)
+
+	fsEntries, err := store.GetPreviousFileSystemEntries(ctx, "local_fs")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []db.FSEntry{root, file1}, fsEntries)
+
+	// a refresh during the sync makes it required again: it did not apply the latest mutations.
+	err = store.MarkSyncInProgress(ctx, "pair1")
+	require.NoError(t, err)
+
+	refresh(root, file2)
+
+	err = store.MarkSyncComplete(ctx, "pair1")
+	require.NoError(t, err)
+	requireStatus("pair1", db.SyncStatusRequired)
+
+	// opening the store leaves an interrupted sync alone: it is only recovered on request.
+	err = store.MarkSyncInProgress(ctx, "pair2")
+	require.NoError(t, err)
+
+	err = store.Close()
+	require.NoError(t, err)
+
+	store, err = db.NewSQLite3(ctx, dbPath)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	requireStatus("pair1", db.SyncStatusRequired)
+	requireStatus("pair2", db.SyncStatusInProgress)
+
+	pairNames, err := store.RecoverInterruptedSyncs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []db.PairName{"pair2"}, pairNames)
+
+	requireStatus("pair2", db.SyncStatusRequired)
+}
+
+func TestSQLite3_FilterRules(t *testing.T) {
+	const dbPath = "/tmp/data_filter_rules_test"
+	ctx := context.Background()
+
+	err := os.RemoveAll(dbPath)
+	require.NoError(t, err)
+
+	err = os.MkdirAll(dbPath, 0700)
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dbPath) }()
+
+	store, err := db.NewSQLite3(ctx, dbPath)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	rules, err := store.GetFilterRules(ctx, "local_fs")
+	require.NoError(t, err)
+	require.Equal(t, &db.FilterRules{}, rules)
+
+	expected := db.FilterRules{
+		Patterns: []string{".DS_Store", "node_modules/", "*.tmp"},
+		MaxSize:  1_000_000,
+		MaxAge:   48 * time.Hour,
+	}
+
+	err = store.SetFilterRules(ctx, "local_fs", expected)
+	require.NoError(t, err)
+
+	rules, err = store.GetFilterRules(ctx, "local_fs")
+	require.NoError(t, err)
+	require.Equal(t, &expected, rules)
+
+	rules, err = store.GetFilterRules(ctx, "other_fs")
+	require.NoError(t, err)
+	require.Equal(t, &db.FilterRules{}, rules)
+}
+
+func TestNewHashCache(t *testing.T) {
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	file := func(path, name, hash string) db.FSEntry {
+		return db.FSEntry{DeviceID: "dev-id", EntryID: 1, Path: path, Name: name, Size: 10, Modified: modified, Created: modified, Hash: hash}
+	}
+
+	fsEntries := []db.FSEntry{
+		{IsFolder: true, Path: "/", Name: "a"},
+		file("/a", "File1", "hash1"),
+		file("/a/b", "File2", "hash2"),
+		file("/a/c", "File3", "hash3"),
+		file("/a/c", "File4", "hash4"),
+	}
+
+	cache := db.NewHashCache(fsEntries, []string{"/a/b", "/a/c/File3"})
+
+	for path, expected := range map[string]string{
+		"/a/File1":   "hash1",
+		"/a/b/File2": "",
+		"/a/c/File3": "",
+		"/a/c/File4": "hash4",
+		"/a":         "",
+	} {
+		hash, ok := cache.Hash(path, file("", "", ""))
+		require.Equal(t, expected != "", ok, path)
+		require.Equal(t, expected, hash, path)
+	}
+
+	// a file that changed since it was cached, before the change was recorded as dirty.
+	changed := file("", "", "")
+	changed.Modified = modified.Add(time.Second)
+	changed.Created = changed.Modified
+
+	_, ok := cache.Hash("/a/File1", changed)
+	require.False(t, ok)
+}
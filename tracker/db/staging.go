@@ -0,0 +1,251 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// StagingCommit holds the details that CommitVersionStaging records along with the "staging"
+// file system entries.
+type StagingCommit struct {
+	// Cursor, when not nil, is the position of the file system in its stream of changes, as of
+	// the "staging" entries.
+	Cursor *uint64
+
+	// ExcludedPreviousEntries are removed from VersionPrevious, such as those that the filter
+	// rules now exclude.
+	ExcludedPreviousEntries []FSEntry
+}
+
+// DeleteVersionStaging removes "staging" file system entries for the specified file system, such
+// as those left over by a refresh that did not complete.
+func (s *SQLite3) DeleteVersionStaging(ctx context.Context, fsName FSName) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM "filesystem"
+		 WHERE version = ?
+		   AND fs_name = ?`,
+		VersionStaging,
+		fsName,
+	)
+
+	return errors.WithStack(err)
+}
+
+// CommitVersionStaging completes the refresh of the specified file system: its "staging" entries
+// become its "new" entries and the file system is marked as changed.
+// When the file system is not already marked as changed, the current "new" entries first replace
+// the "previous" entries, as per RotateFileSystemVersions. Otherwise, the "previous" entries are
+// preserved since their sync is pending, as per DeleteVersionNew.
+// All of this takes place in a single transaction: should it fail, the file system is left as it
+// was before the refresh.
+func (s *SQLite3) CommitVersionStaging(ctx context.Context, fsName FSName, commit StagingCommit) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var fsChanged bool
+
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT fs_changed
+		 FROM "fs_info"
+		 WHERE "fs_name" = ?`,
+		fsName,
+	).Scan(&fsChanged)
+	if err != nil {
+		return doRollback(tx, errors.WithMessagef(err, "file system '%s'", fsName))
+	}
+
+	if fsChanged {
+		err = s.deleteVersion(ctx, tx, fsName, VersionNew)
+		if err != nil {
+			return doRollback(tx, err)
+		}
+	} else {
+		err = s.deleteVersion(ctx, tx, fsName, VersionPrevious)
+		if err != nil {
+			return doRollback(tx, err)
+		}
+
+		err = s.renameVersion(ctx, tx, fsName, VersionNew, VersionPrevious)
+		if err != nil {
+			return doRollback(tx, err)
+		}
+	}
+
+	err = s.renameVersion(ctx, tx, fsName, VersionStaging, VersionNew)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	for _, entry := range commit.ExcludedPreviousEntries {
+		_, err = tx.ExecContext(
+			ctx,
+			`DELETE FROM "filesystem"
+			 WHERE fs_name = ?
+			   AND version = ?
+			   AND device_id = ?
+			   AND entry_id = ?`,
+			fsName,
+			VersionPrevious,
+			entry.DeviceID,
+			fmt.Sprintf("%d", entry.EntryID),
+		)
+		if err != nil {
+			return doRollback(tx, errors.WithMessagef(err, "deviceID: %s entryID: %d", entry.DeviceID, entry.EntryID))
+		}
+	}
+
+	if commit.Cursor != nil {
+		_, err = tx.ExecContext(
+			ctx,
+			`UPDATE "fs_info" SET fs_cursor = ?
+			 WHERE "fs_name" = ?`,
+			*commit.Cursor,
+			fsName,
+		)
+		if err != nil {
+			return doRollback(tx, err)
+		}
+	}
+
+	err = s.deleteFSMutations(ctx, tx, fsName)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	// cross FS mutations are built upon VersionNew so the staging table data needs clearing
+	err = s.deleteCrossFSMutations(ctx, tx)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = s.refreshFSMutationsStagingTable(ctx, tx, fsName)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE "fs_info" SET fs_changed = TRUE
+		 WHERE "fs_name" = ?`,
+		fsName,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	// the syncs from this file system must now catch up with its "new" entries
+	err = s.markSyncRequired(ctx, tx, "from_fs", string(fsName))
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = s.takeSnapshot(ctx, tx, fsName)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+func (s *SQLite3) renameVersion(ctx context.Context, tx *sql.Tx, fsName FSName, from, to Version) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`UPDATE "filesystem"
+		 SET version = ?
+		 WHERE version = ?
+		   AND fs_name = ?`,
+		to,
+		from,
+		fsName,
+	)
+
+	return errors.WithStack(err)
+}
+
+// RecoverIncompleteRefreshes discards the "staging" file system entries of the file systems
+// whose refresh is not in progress (see LockRefresh). These are left over by refreshes that were
+// interrupted, such as by a crash, before they were committed: VersionPrevious and VersionNew
+// are then still as they were before the refresh. The refreshes in progress are left alone.
+// It returns the names of the file systems whose refresh was discarded.
+func (s *SQLite3) RecoverIncompleteRefreshes(ctx context.Context) ([]FSName, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT DISTINCT fs_name
+		 FROM "filesystem"
+		 WHERE version = ?
+		 ORDER BY fs_name`,
+		VersionStaging,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	fsNames := []FSName{}
+
+	for rows.Next() {
+		var fsName FSName
+
+		err = rows.Scan(&fsName)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		fsNames = append(fsNames, fsName)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	recoveredFSNames := []FSName{}
+
+	for _, fsName := range fsNames {
+		recovered, err := s.recoverIncompleteRefresh(ctx, fsName)
+		if err != nil {
+			return nil, err
+		}
+
+		if recovered {
+			recoveredFSNames = append(recoveredFSNames, fsName)
+		}
+	}
+
+	return recoveredFSNames, nil
+}
+
+// recoverIncompleteRefresh discards the "staging" entries of the file system fsName, unless its
+// refresh is in progress. It reports whether there were any.
+func (s *SQLite3) recoverIncompleteRefresh(ctx context.Context, fsName FSName) (bool, error) {
+	unlock, err := s.LockRefresh(ctx, fsName)
+	if errors.Is(err, ErrLocked) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = unlock() }()
+
+	return execAffectsRows(s.db.ExecContext(
+		ctx,
+		`DELETE FROM "filesystem"
+		 WHERE version = ?
+		   AND fs_name = ?`,
+		VersionStaging,
+		fsName,
+	))
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Store is the behaviour of a tracker database: it holds the entries of the file systems, the
+// mutations between their versions and the state of their syncs.
+// It is implemented by SQLite3, Postgres and Memory, which behave alike (see the conformance
+// tests).
+//
+// The entries of a file system are held in versions:
+//   - VersionNew holds the entries as of the latest refresh of the file system.
+//   - VersionPrevious holds the entries as of the refresh that preceded the last complete sync.
+//   - VersionStaging holds the entries of a refresh in progress, which replace VersionNew once
+//     the refresh is committed.
+//
+// The mutations of a file system are the differences between its VersionPrevious and its
+// VersionNew entries. They are computed when a refresh is committed.
+type Store interface {
+	// RegisterFileSystem records the file system fsName, with its driver and its root path.
+	RegisterFileSystem(ctx context.Context, fsName FSName, fsDriver FSDriver, fsRoot string) error
+	// GetFileSystemInfo returns high level information about the file system fsName.
+	GetFileSystemInfo(ctx context.Context, fsName FSName) (*FSInfo, error)
+	// GetSyncDetails returns the driver and the root path of the file system fsName.
+	GetSyncDetails(ctx context.Context, fsName FSName) (FSDriver, string, error)
+
+	// SetFileSystemRoots records the roots of the file system fsName, replacing its current roots.
+	SetFileSystemRoots(ctx context.Context, fsName FSName, roots []FSRoot) error
+	// GetFileSystemRoots returns the roots of the file system fsName, sorted by name.
+	GetFileSystemRoots(ctx context.Context, fsName FSName) ([]FSRoot, error)
+
+	// SetFilterRules records the filter rules of the file system fsName, replacing its current
+	// rules, and resets its cursor.
+	SetFilterRules(ctx context.Context, fsName FSName, rules FilterRules) error
+	// GetFilterRules returns the filter rules of the file system fsName.
+	GetFilterRules(ctx context.Context, fsName FSName) (*FilterRules, error)
+
+	// AddNewFileSystemEntries adds the entries received on the returned channel to VersionNew or,
+	// with WithStagingVersion, to VersionStaging. The outcome is sent on the error channel once
+	// the entries channel is closed.
+	AddNewFileSystemEntries(ctx context.Context, opts ...Options) (chan<- FSEntry, <-chan error)
+	// GetPreviousFileSystemEntries returns the VersionPrevious entries of the file system fsName.
+	GetPreviousFileSystemEntries(ctx context.Context, fsName FSName) ([]FSEntry, error)
+	// GetLatestFileSystemEntries returns the VersionNew entries of the file system fsName.
+	GetLatestFileSystemEntries(ctx context.Context, fsName FSName) ([]FSEntry, error)
+	// GetFileSystemMutations returns the mutations of the file system fsName, sorted by type
+	// then by device and entry ID.
+	GetFileSystemMutations(ctx context.Context, fsName FSName) (FSMutations, error)
+	// GetFileSystemMutationsPage returns at most limit mutations of the file system fsName that
+	// follow the cursor after (from the first mutation when nil), in the order of
+	// GetFileSystemMutations, and the cursor of the next page, nil after the last page.
+	GetFileSystemMutationsPage(ctx context.Context, fsName FSName, after *MutationCursor, limit int) (FSMutations, *MutationCursor, error)
+
+	// SeedVersionStaging replaces the VersionStaging entries of the file system fsName with a copy
+	// of its VersionNew entries.
+	SeedVersionStaging(ctx context.Context, fsName FSName) error
+	// ApplyStagingFileSystemChanges applies the changes to the VersionStaging entries of the file
+	// system fsName. It returns ErrResyncRequired when a change cannot be applied.
+	ApplyStagingFileSystemChanges(ctx context.Context, fsName FSName, changes []FSChange, filter EntryFilter) error
+	// DeleteVersionStaging removes the VersionStaging entries of the file system fsName.
+	DeleteVersionStaging(ctx context.Context, fsName FSName) error
+	// CommitVersionStaging makes the VersionStaging entries of the file system fsName its
+	// VersionNew entries, atomically.
+	CommitVersionStaging(ctx context.Context, fsName FSName, commit StagingCommit) error
+	// RecoverIncompleteRefreshes discards the VersionStaging entries of the file systems whose
+	// refresh is not in progress.
+	RecoverIncompleteRefreshes(ctx context.Context) ([]FSName, error)
+	// LockRefresh takes the lock of the refreshes of the file system fsName, held until the
+	// returned function is called or its holder dies. It returns ErrLocked when it is held.
+	LockRefresh(ctx context.Context, fsName FSName) (func() error, error)
+
+	// AddDirtyPaths records paths of the file system fsName as changed.
+	AddDirtyPaths(ctx context.Context, fsName FSName, paths []string) error
+	// MarkFullRescanRequired records that the entire file system fsName must be rescanned.
+	MarkFullRescanRequired(ctx context.Context, fsName FSName) error
+	// GetDirtyPaths returns the paths of the file system fsName recorded as changed.
+	GetDirtyPaths(ctx context.Context, fsName FSName) (*DirtyPaths, error)
+	// ClearDirtyPaths removes the dirty paths of the file system fsName up to and including seq.
+	ClearDirtyPaths(ctx context.Context, fsName FSName, seq int64) error
+
+	// GetContentHashes returns the content hashes recorded for the files of the file system
+	// fsName, by entry ID.
+	GetContentHashes(ctx context.Context, fsName FSName) (map[uint64]ContentHash, error)
+	// AddContentHashes records the content hashes of files of the file system fsName.
+	AddContentHashes(ctx context.Context, fsName FSName, contentHashes []ContentHash) error
+
+	// CreateSyncPair records a new sync pair between two registered file systems.
+	CreateSyncPair(ctx context.Context, syncPair SyncPair) error
+	// ListSyncPairs returns all the sync pairs, sorted by name.
+	ListSyncPairs(ctx context.Context) ([]SyncPair, error)
+	// DeleteSyncPair removes the sync pair pairName.
+	DeleteSyncPair(ctx context.Context, pairName PairName) error
+	// FindSyncPeers returns the names of the file systems that are synced with the file system
+	// fsName, sorted by name.
+	FindSyncPeers(ctx context.Context, fsName FSName) ([]FSName, error)
+	// GetCrossFSMutations returns the mutations to apply to the "to" file system of the sync
+	// pair pairName so that it mirrors its "from" file system.
+	GetCrossFSMutations(ctx context.Context, pairName PairName) (FSMutations, error)
+
+	// GetSyncStatus returns the status of the sync of the sync pair pairName.
+	GetSyncStatus(ctx context.Context, pairName PairName) (SyncStatus, error)
+	// MarkSyncInProgress marks the status of the sync as "in progress".
+	MarkSyncInProgress(ctx context.Context, pairName PairName) error
+	// MarkSyncComplete marks the status of the sync as "complete".
+	MarkSyncComplete(ctx context.Context, pairName PairName) error
+	// MarkSyncAsChanged marks the status of the sync as "required".
+	MarkSyncAsChanged(ctx context.Context, pairName PairName) error
+	// RecoverInterruptedSyncs marks the syncs that are "in progress" but not running as
+	// "required".
+	RecoverInterruptedSyncs(ctx context.Context) ([]PairName, error)
+	// LockSync takes the lock of the syncs of the sync pair pairName, as per LockRefresh.
+	LockSync(ctx context.Context, pairName PairName) (func() error, error)
+
+	// SetSnapshotRetention sets the number of snapshots of the file system fsName to keep. A
+	// snapshot of its VersionNew entries is taken each time a refresh is committed.
+	SetSnapshotRetention(ctx context.Context, fsName FSName, retention int) error
+	// ListSnapshots returns the snapshots kept for the file system fsName, oldest first.
+	ListSnapshots(ctx context.Context, fsName FSName) ([]Snapshot, error)
+	// FindSnapshot returns the latest snapshot of the file system fsName taken at or before at.
+	FindSnapshot(ctx context.Context, fsName FSName, at time.Time) (*Snapshot, error)
+	// GetSnapshotEntries returns the entries of the file system fsName as of the snapshot.
+	GetSnapshotEntries(ctx context.Context, fsName FSName, snapshotID uint64) ([]FSEntry, error)
+	// GetSnapshotMutations returns the mutations of the file system fsName between two
+	// snapshots.
+	GetSnapshotMutations(ctx context.Context, fsName FSName, fromID, toID uint64) (FSMutations, error)
+	// GetPathLastChange returns the snapshot in which the entry at path last changed.
+	GetPathLastChange(ctx context.Context, fsName FSName, path string) (*Snapshot, error)
+
+	// Close releases the resources of the store.
+	Close() error
+}
+
+var (
+	_ Store = (*SQLite3)(nil)
+	_ Store = (*Postgres)(nil)
+	_ Store = (*Memory)(nil)
+)
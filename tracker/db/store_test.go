@@ -0,0 +1,855 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-sdk/tracker/db"
+)
+
+// postgresDSNEnv holds the DSN of the PostgreSQL database that the conformance tests run against.
+// The PostgreSQL tests are skipped when it is not set (see `make test-postgres`).
+// Caution: the tables of the database are emptied by the tests.
+const postgresDSNEnv = "PCLOUD_SDK_TEST_POSTGRES_DSN"
+
+// forEachStore runs the conformance test f against each implementation of db.Store, so that
+// they all behave alike.
+func forEachStore(t *testing.T, f func(t *testing.T, store db.Store)) {
+	ctx := context.Background()
+
+	t.Run("SQLite3", func(t *testing.T) {
+		store, err := db.NewSQLite3(ctx, t.TempDir())
+		require.NoError(t, err)
+		defer func() { _ = store.Close() }()
+
+		f(t, store)
+	})
+
+	t.Run("Memory", func(t *testing.T) {
+		store := db.NewMemory()
+		defer func() { _ = store.Close() }()
+
+		f(t, store)
+	})
+
+	t.Run("Postgres", func(t *testing.T) {
+		dsn := os.Getenv(postgresDSNEnv)
+		if dsn == "" {
+			t.Skipf("%s is not set", postgresDSNEnv)
+		}
+
+		store, err := db.NewPostgres(ctx, dsn)
+		require.NoError(t, err)
+		defer func() { _ = store.Close() }()
+
+		truncatePostgres(t, dsn)
+
+		f(t, store)
+	})
+}
+
+func truncatePostgres(t *testing.T, dsn string) {
+	sqlDB, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	defer func() { _ = sqlDB.Close() }()
+
+	_, err = sqlDB.Exec(
+		`TRUNCATE "filesystem", "fs_info", "staging_fs_mutations", "dirty_paths", "content_hashes",
+		          "fs_filters", "fs_roots", "sync_pairs", "staging_cross_mutations", "snapshots", "snapshot_entries"`,
+	)
+	require.NoError(t, err)
+}
+
+func addEntries(ctx context.Context, t *testing.T, store db.Store, opts []db.Options, fsEntries ...db.FSEntry) {
+	fsEntriesCh, errCh := store.AddNewFileSystemEntries(ctx, opts...)
+	for _, fsEntry := range fsEntries {
+		fsEntriesCh <- fsEntry
+	}
+	close(fsEntriesCh)
+	require.NoError(t, <-errCh)
+}
+
+func TestStore_FileSystems(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store db.Store) {
+		ctx := context.Background()
+
+		_, err := store.GetFileSystemInfo(ctx, "local_fs")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		err = store.RegisterFileSystem(ctx, "local_fs", db.FSDriverLocal, "/tmp")
+		require.NoError(t, err)
+
+		// registering again updates the file system.
+		err = store.RegisterFileSystem(ctx, "local_fs", db.FSDriverLocal, "/data")
+		require.NoError(t, err)
+
+		fsDriver, fsRoot, err := store.GetSyncDetails(ctx, "local_fs")
+		require.NoError(t, err)
+		require.Equal(t, db.FSDriverLocal, fsDriver)
+		require.Equal(t, "/data", fsRoot)
+
+		fsInfo, err := store.GetFileSystemInfo(ctx, "local_fs")
+		require.NoError(t, err)
+		require.Equal(t, &db.FSInfo{FSName: "local_fs", FSDriver: db.FSDriverLocal, FSRoot: "/data"}, fsInfo)
+
+		roots, err := store.GetFileSystemRoots(ctx, "local_fs")
+		require.NoError(t, err)
+		require.Empty(t, roots)
+
+		err = store.SetFileSystemRoots(ctx, "local_fs", []db.FSRoot{{Name: "photos", Path: "/mnt/photos"}, {Name: "docs", Path: "/home/user/docs"}})
+		require.NoError(t, err)
+
+		err = store.SetFileSystemRoots(ctx, "local_fs", []db.FSRoot{{Name: "docs", Path: "/a"}, {Name: "docs", Path: "/b"}})
+		require.Error(t, err)
+
+		roots, err = store.GetFileSystemRoots(ctx, "local_fs")
+		require.NoError(t, err)
+		require.Equal(t, []db.FSRoot{{Name: "docs", Path: "/home/user/docs"}, {Name: "photos", Path: "/mnt/photos"}}, roots)
+
+		rules, err := store.GetFilterRules(ctx, "local_fs")
+		require.NoError(t, err)
+		require.Equal(t, &db.FilterRules{}, rules)
+
+		cursor := uint64(42)
+		addEntries(ctx, t, store, []db.Options{db.WithStagingVersion()})
+		err = store.CommitVersionStaging(ctx, "local_fs", db.StagingCommit{Cursor: &cursor})
+		require.NoError(t, err)
+
+		expectedRules := db.FilterRules{Patterns: []string{"*.tmp", "!keep.tmp"}, MaxSize: 1024, MaxAge: 48 * time.Hour}
+		err = store.SetFilterRules(ctx, "local_fs", expectedRules)
+		require.NoError(t, err)
+
+		rules, err = store.GetFilterRules(ctx, "local_fs")
+		require.NoError(t, err)
+		require.Equal(t, &expectedRules, rules)
+
+		// the cursor is reset by new filter rules.
+		fsInfo, err = store.GetFileSystemInfo(ctx, "local_fs")
+		require.NoError(t, err)
+		require.Zero(t, fsInfo.FSCursor)
+	})
+}
+
+func TestStore_DirtyPathsAndContentHashes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store db.Store) {
+		ctx := context.Background()
+
+		err := store.AddDirtyPaths(ctx, "local_fs", []string{"/a/File1", "/a/File2"})
+		require.NoError(t, err)
+
+		dirtyPaths, err := store.GetDirtyPaths(ctx, "local_fs")
+		require.NoError(t, err)
+		require.Equal(t, []string{"/a/File1", "/a/File2"}, dirtyPaths.Paths)
+
+		err = store.AddDirtyPaths(ctx, "local_fs", []string{"/a/File1"})
+		require.NoError(t, err)
+
+		err = store.MarkFullRescanRequired(ctx, "local_fs")
+		require.NoError(t, err)
+
+		err = store.ClearDirtyPaths(ctx, "local_fs", dirtyPaths.Seq)
+		require.NoError(t, err)
+
+		dirtyPaths, err = store.GetDirtyPaths(ctx, "local_fs")
+		require.NoError(t, err)
+		require.True(t, dirtyPaths.FullRescan)
+		require.Equal(t, []string{"/a/File1"}, dirtyPaths.Paths)
+
+		dirtyPaths, err = store.GetDirtyPaths(ctx, "other_fs")
+		require.NoError(t, err)
+		require.False(t, dirtyPaths.FullRescan)
+		require.Empty(t, dirtyPaths.Paths)
+
+		err = store.AddContentHashes(ctx, "pcloud_fs", []db.ContentHash{
+			{EntryID: 1, Hash: "h1", Algorithm: db.HashAlgorithmSHA1, Value: "aaa"},
+			{EntryID: 2, Hash: "h2", Algorithm: db.HashAlgorithmSHA1, Value: "bbb"},
+		})
+		require.NoError(t, err)
+
+		err = store.AddContentHashes(ctx, "pcloud_fs", []db.ContentHash{
+			{EntryID: 2, Hash: "h2b", Algorithm: db.HashAlgorithmSHA256, Value: "ccc"},
+		})
+		require.NoError(t, err)
+
+		contentHashes, err := store.GetContentHashes(ctx, "pcloud_fs")
+		require.NoError(t, err)
+		require.Equal(t,
+			map[uint64]db.ContentHash{
+				1: {EntryID: 1, Hash: "h1", Algorithm: db.HashAlgorithmSHA1, Value: "aaa"},
+				2: {EntryID: 2, Hash: "h2b", Algorithm: db.HashAlgorithmSHA256, Value: "ccc"},
+			},
+			contentHashes,
+		)
+	})
+}
+
+func TestStore_Versions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store db.Store) {
+		ctx := context.Background()
+
+		err := store.RegisterFileSystem(ctx, "local_fs", db.FSDriverLocal, "/data")
+		require.NoError(t, err)
+
+		created := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+		modified := created.Add(time.Hour)
+
+		root := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 1, IsFolder: true, Path: "/", Name: "data", ParentFolderID: 1, Created: created, Modified: created, Kind: db.EntryKindFolder, Mode: 0o755}
+		folder := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 2, IsFolder: true, Path: "/data", Name: "Folder", ParentFolderID: 1, Created: created, Modified: created, Kind: db.EntryKindFolder, Mode: 0o755}
+		file1 := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 3, Path: "/data/Folder", Name: "File1", ParentFolderID: 2, Created: created, Modified: created, Size: 3, Hash: "aaa", Kind: db.EntryKindFile, Mode: 0o644, Xattrs: db.Xattrs{"user.tag": []byte("red")}}
+		file2 := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 4, Path: "/data", Name: "File2", ParentFolderID: 1, Created: created, Modified: created, Size: 3, Hash: "bbb", Kind: db.EntryKindFile, Mode: 0o644}
+
+		addEntries(ctx, t, store, nil, root, folder, file1, file2)
+
+		// an entry cannot be added twice to the same version.
+		fsEntriesCh, errCh := store.AddNewFileSystemEntries(ctx)
+		fsEntriesCh <- file2
+		close(fsEntriesCh)
+		require.Error(t, <-errCh)
+
+		fsEntries, err := store.GetLatestFileSystemEntries(ctx, "local_fs")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []db.FSEntry{root, folder, file1, file2}, fsEntries)
+
+		// the staging version is seeded from the latest version and changed incrementally.
+		err = store.SeedVersionStaging(ctx, "local_fs")
+		require.NoError(t, err)
+
+		file2Moved := file2
+		file2Moved.ParentFolderID = 2
+		file1Modified := file1
+		file1Modified.Modified = modified
+		file1Modified.Hash = "aab"
+		file3 := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 5, Path: "/data", Name: "File3", ParentFolderID: 1, Created: created, Modified: created, Size: 3, Hash: "ccc", Kind: db.EntryKindFile, Mode: 0o644}
+
+		err = store.ApplyStagingFileSystemChanges(
+			ctx,
+			"local_fs",
+			[]db.FSChange{
+				{Type: db.FSChangeTypeModified, Entry: file2Moved},
+				{Type: db.FSChangeTypeModified, Entry: file1Modified},
+				{Type: db.FSChangeTypeCreated, Entry: file3},
+			},
+			nil,
+		)
+		require.NoError(t, err)
+
+		// a folder moved into the file system requires a full resync: nothing is applied.
+		folder2 := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 6, IsFolder: true, Path: "/data", Name: "Folder2", ParentFolderID: 1, Created: created, Modified: created, Kind: db.EntryKindFolder}
+		err = store.ApplyStagingFileSystemChanges(
+			ctx,
+			"local_fs",
+			[]db.FSChange{
+				{Type: db.FSChangeTypeDeleted, Entry: file3},
+				{Type: db.FSChangeTypeModified, Entry: folder2},
+			},
+			nil,
+		)
+		require.ErrorIs(t, err, db.ErrResyncRequired)
+
+		fsEntries, err = store.GetLatestFileSystemEntries(ctx, "local_fs")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []db.FSEntry{root, folder, file1, file2}, fsEntries)
+
+		err = store.CommitVersionStaging(ctx, "local_fs", db.StagingCommit{})
+		require.NoError(t, err)
+
+		file2Moved.Path = "/data/Folder"
+		file1Modified.Path = "/data/Folder"
+
+		fsEntries, err = store.GetPreviousFileSystemEntries(ctx, "local_fs")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []db.FSEntry{root, folder, file1, file2}, fsEntries)
+
+		fsEntries, err = store.GetLatestFileSystemEntries(ctx, "local_fs")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []db.FSEntry{root, folder, file1Modified, file2Moved, file3}, fsEntries)
+
+		mutations, err := store.GetFileSystemMutations(ctx, "local_fs")
+		require.NoError(t, err)
+
+		previousEntry := func(fsEntry db.FSEntry) db.VersionedEntry {
+			return db.VersionedEntry{Version: db.VersionPrevious, FSEntry: fsEntry}
+		}
+		newEntry := func(fsEntry db.FSEntry) db.VersionedEntry {
+			return db.VersionedEntry{Version: db.VersionNew, FSEntry: fsEntry}
+		}
+
+		require.Equal(t,
+			db.FSMutations{
+				{Type: db.MutationTypeCreated, Details: db.EntryMutations{newEntry(file3)}},
+				{Type: db.MutationTypeModified, Details: db.EntryMutations{previousEntry(file1), newEntry(file1Modified)}},
+				{Type: db.MutationTypeMoved, Details: db.EntryMutations{previousEntry(file2), newEntry(file2Moved)}},
+			},
+			mutations,
+		)
+
+		fsInfo, err := store.GetFileSystemInfo(ctx, "local_fs")
+		require.NoError(t, err)
+		require.True(t, fsInfo.FSChanged)
+
+		// a refresh that does not complete is discarded, once its lock is released.
+		unlock, err := store.LockRefresh(ctx, "local_fs")
+		require.NoError(t, err)
+
+		_, err = store.LockRefresh(ctx, "local_fs")
+		require.ErrorIs(t, err, db.ErrLocked)
+
+		err = store.SeedVersionStaging(ctx, "local_fs")
+		require.NoError(t, err)
+
+		fsNames, err := store.RecoverIncompleteRefreshes(ctx)
+		require.NoError(t, err)
+		require.Empty(t, fsNames)
+
+		err = unlock()
+		require.NoError(t, err)
+
+		fsNames, err = store.RecoverIncompleteRefreshes(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []db.FSName{"local_fs"}, fsNames)
+
+		fsNames, err = store.RecoverIncompleteRefreshes(ctx)
+		require.NoError(t, err)
+		require.Empty(t, fsNames)
+
+		err = store.ApplyStagingFileSystemChanges(ctx, "local_fs", []db.FSChange{{Type: db.FSChangeTypeDeleted, Entry: file3}}, nil)
+		require.ErrorIs(t, err, db.ErrResyncRequired)
+
+		err = store.DeleteVersionStaging(ctx, "local_fs")
+		require.NoError(t, err)
+
+		err = store.CommitVersionStaging(ctx, "unknown_fs", db.StagingCommit{})
+		require.Error(t, err)
+	})
+}
+
+func TestStore_MutationPages(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store db.Store) {
+		ctx := context.Background()
+
+		err := store.RegisterFileSystem(ctx, "local_fs", db.FSDriverLocal, "/data")
+		require.NoError(t, err)
+
+		created := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		fileEntry := func(entryID uint64, hash string) db.FSEntry {
+			return db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: entryID, Path: "/data", Name: fmt.Sprintf("File%d", entryID), ParentFolderID: 1, Created: created, Modified: created, Size: 3, Hash: hash, Kind: db.EntryKindFile, Mode: 0o644}
+		}
+
+		root := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 1, IsFolder: true, Path: "/", Name: "data", ParentFolderID: 1, Created: created, Modified: created, Kind: db.EntryKindFolder, Mode: 0o755}
+
+		previousEntries := []db.FSEntry{root}
+		for entryID := uint64(2); entryID <= 12; entryID++ {
+			previousEntries = append(previousEntries, fileEntry(entryID, "aaa"))
+		}
+		addEntries(ctx, t, store, nil, previousEntries...)
+
+		err = store.SeedVersionStaging(ctx, "local_fs")
+		require.NoError(t, err)
+
+		// entry IDs 2 to 12 sort as text: "10" before "2".
+		changes := []db.FSChange{}
+		for entryID := uint64(2); entryID <= 12; entryID++ {
+			switch entryID % 3 {
+			case 0:
+				changes = append(changes, db.FSChange{Type: db.FSChangeTypeDeleted, Entry: fileEntry(entryID, "aaa")})
+			case 1:
+				changes = append(changes, db.FSChange{Type: db.FSChangeTypeModified, Entry: fileEntry(entryID, "bbb")})
+			}
+		}
+		for entryID := uint64(13); entryID <= 15; entryID++ {
+			changes = append(changes, db.FSChange{Type: db.FSChangeTypeCreated, Entry: fileEntry(entryID, "ccc")})
+		}
+
+		err = store.ApplyStagingFileSystemChanges(ctx, "local_fs", changes, nil)
+		require.NoError(t, err)
+
+		err = store.CommitVersionStaging(ctx, "local_fs", db.StagingCommit{})
+		require.NoError(t, err)
+
+		mutations, err := store.GetFileSystemMutations(ctx, "local_fs")
+		require.NoError(t, err)
+		require.Len(t, mutations, 10)
+
+		for _, limit := range []int{1, 3, 10, 100} {
+			pagedMutations := db.FSMutations{}
+			pages := 0
+
+			var cursor *db.MutationCursor
+			for {
+				page, next, err := store.GetFileSystemMutationsPage(ctx, "local_fs", cursor, limit)
+				require.NoError(t, err)
+				require.LessOrEqual(t, len(page), limit)
+
+				pagedMutations = append(pagedMutations, page...)
+				pages++
+
+				if next == nil {
+					break
+				}
+				require.Equal(t, page[len(page)-1].Cursor(), *next)
+				cursor = next
+			}
+
+			require.Equal(t, mutations, pagedMutations, "limit %d", limit)
+			require.Equal(t, len(mutations)/limit+1, pages, "limit %d", limit)
+		}
+
+		// a persisted cursor resumes after its mutation.
+		cursor := mutations[4].Cursor()
+		page, _, err := store.GetFileSystemMutationsPage(ctx, "local_fs", &cursor, 100)
+		require.NoError(t, err)
+		require.Equal(t, mutations[5:], page)
+
+		_, _, err = store.GetFileSystemMutationsPage(ctx, "local_fs", nil, 0)
+		require.Error(t, err)
+	})
+}
+
+func TestStore_SubtreeMoves(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store db.Store) {
+		ctx := context.Background()
+
+		err := store.RegisterFileSystem(ctx, "local_fs", db.FSDriverLocal, "/data")
+		require.NoError(t, err)
+
+		created := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		root := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 1, IsFolder: true, Path: "/", Name: "data", ParentFolderID: 1, Created: created, Modified: created, Kind: db.EntryKindFolder, Mode: 0o755}
+		folder := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 2, IsFolder: true, Path: "/data", Name: "Folder", ParentFolderID: 1, Created: created, Modified: created, Kind: db.EntryKindFolder, Mode: 0o755}
+		subFolder := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 3, IsFolder: true, Path: "/data/Folder", Name: "SubFolder", ParentFolderID: 2, Created: created, Modified: created, Kind: db.EntryKindFolder, Mode: 0o755}
+		fsEntries := []db.FSEntry{root, folder, subFolder}
+		for entryID := uint64(10); entryID < 20; entryID++ {
+			fsEntries = append(fsEntries, db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: entryID, Path: "/data/Folder/SubFolder", Name: fmt.Sprintf("File%d", entryID), ParentFolderID: 3, Created: created, Modified: created, Size: 3, Hash: "aaa", Kind: db.EntryKindFile, Mode: 0o644})
+		}
+		addEntries(ctx, t, store, nil, fsEntries...)
+
+		err = store.SeedVersionStaging(ctx, "local_fs")
+		require.NoError(t, err)
+
+		// Folder is renamed in place: its parent folder is unchanged. One of its files is renamed
+		// too, the others only follow their folder.
+		folderRenamed := folder
+		folderRenamed.Name = "Renamed"
+		file10Renamed := fsEntries[3]
+		file10Renamed.Path = "/data/Renamed/SubFolder"
+		file10Renamed.Name = "File10b"
+
+		err = store.ApplyStagingFileSystemChanges(
+			ctx,
+			"local_fs",
+			[]db.FSChange{
+				{Type: db.FSChangeTypeModified, Entry: folderRenamed},
+				{Type: db.FSChangeTypeModified, Entry: file10Renamed},
+			},
+			nil,
+		)
+		require.NoError(t, err)
+
+		err = store.CommitVersionStaging(ctx, "local_fs", db.StagingCommit{})
+		require.NoError(t, err)
+
+		mutations, err := store.GetFileSystemMutations(ctx, "local_fs")
+		require.NoError(t, err)
+
+		require.Equal(t,
+			db.FSMutations{
+				{Type: db.MutationTypeMoved, Details: db.EntryMutations{{Version: db.VersionPrevious, FSEntry: fsEntries[3]}, {Version: db.VersionNew, FSEntry: file10Renamed}}},
+				{Type: db.MutationTypeMoved, Details: db.EntryMutations{{Version: db.VersionPrevious, FSEntry: folder}, {Version: db.VersionNew, FSEntry: folderRenamed}}},
+			},
+			mutations,
+		)
+	})
+}
+
+func TestStore_MovedAndModified(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store db.Store) {
+		ctx := context.Background()
+
+		err := store.RegisterFileSystem(ctx, "local_fs", db.FSDriverLocal, "/data")
+		require.NoError(t, err)
+
+		created := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		root := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 1, IsFolder: true, Path: "/", Name: "data", ParentFolderID: 1, Created: created, Modified: created, Kind: db.EntryKindFolder, Mode: 0o755}
+		folder := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 2, IsFolder: true, Path: "/data", Name: "Folder", ParentFolderID: 1, Created: created, Modified: created, Kind: db.EntryKindFolder, Mode: 0o755}
+		file3 := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 3, Path: "/data", Name: "File3", ParentFolderID: 1, Created: created, Modified: created, Size: 3, Hash: "aaa", Kind: db.EntryKindFile, Mode: 0o644}
+		file4 := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 4, Path: "/data", Name: "File4", ParentFolderID: 1, Created: created, Modified: created, Size: 3, Hash: "bbb", Kind: db.EntryKindFile, Mode: 0o644}
+		file5 := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 5, Path: "/data", Name: "File5", ParentFolderID: 1, Created: created, Modified: created, Size: 3, Hash: "ccc", Kind: db.EntryKindFile, Mode: 0o644}
+
+		addEntries(ctx, t, store, nil, root, folder, file3, file4, file5)
+
+		err = store.SeedVersionStaging(ctx, "local_fs")
+		require.NoError(t, err)
+
+		// File3 is renamed and edited, File4 is moved and edited and File5 is moved and has its
+		// permissions changed.
+		file3RenamedEdited := file3
+		file3RenamedEdited.Name = "File3b"
+		file3RenamedEdited.Hash = "aab"
+		file4MovedEdited := file4
+		file4MovedEdited.Path = "/data/Folder"
+		file4MovedEdited.ParentFolderID = 2
+		file4MovedEdited.Hash = "bbc"
+		file5MovedChmoded := file5
+		file5MovedChmoded.Path = "/data/Folder"
+		file5MovedChmoded.ParentFolderID = 2
+		file5MovedChmoded.Mode = 0o600
+
+		err = store.ApplyStagingFileSystemChanges(
+			ctx,
+			"local_fs",
+			[]db.FSChange{
+				{Type: db.FSChangeTypeModified, Entry: file3RenamedEdited},
+				{Type: db.FSChangeTypeModified, Entry: file4MovedEdited},
+				{Type: db.FSChangeTypeModified, Entry: file5MovedChmoded},
+			},
+			nil,
+		)
+		require.NoError(t, err)
+
+		err = store.CommitVersionStaging(ctx, "local_fs", db.StagingCommit{})
+		require.NoError(t, err)
+
+		mutations, err := store.GetFileSystemMutations(ctx, "local_fs")
+		require.NoError(t, err)
+
+		details := func(previous, new db.FSEntry) db.EntryMutations {
+			return db.EntryMutations{{Version: db.VersionPrevious, FSEntry: previous}, {Version: db.VersionNew, FSEntry: new}}
+		}
+
+		require.Equal(t,
+			db.FSMutations{
+				{Type: db.MutationTypeMovedAndModified, Details: details(file3, file3RenamedEdited)},
+				{Type: db.MutationTypeMovedAndModified, Details: details(file4, file4MovedEdited)},
+				{Type: db.MutationTypeMovedAndModified, Details: details(file5, file5MovedChmoded)},
+			},
+			mutations,
+		)
+	})
+}
+
+func TestStore_SyncPairs(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store db.Store) {
+		ctx := context.Background()
+
+		err := store.RegisterFileSystem(ctx, "pcloud_fs", db.FSDriverPCloud, "/")
+		require.NoError(t, err)
+
+		err = store.RegisterFileSystem(ctx, "local_fs", db.FSDriverLocal, "/home/user/pcloud")
+		require.NoError(t, err)
+
+		err = store.RegisterFileSystem(ctx, "backup_fs", db.FSDriverLocal, "/backup")
+		require.NoError(t, err)
+
+		err = store.CreateSyncPair(ctx, db.SyncPair{PairName: "pair1", FromFS: "pcloud_fs", ToFS: "unknown_fs"})
+		require.Error(t, err)
+
+		err = store.CreateSyncPair(ctx, db.SyncPair{PairName: "pair1", FromFS: "pcloud_fs", ToFS: "pcloud_fs"})
+		require.Error(t, err)
+
+		err = store.CreateSyncPair(ctx, db.SyncPair{PairName: "pcloud_to_local", FromFS: "pcloud_fs", ToFS: "local_fs"})
+		require.NoError(t, err)
+
+		err = store.CreateSyncPair(ctx, db.SyncPair{PairName: "local_to_backup", FromFS: "local_fs", ToFS: "backup_fs"})
+		require.NoError(t, err)
+
+		err = store.CreateSyncPair(ctx, db.SyncPair{PairName: "pcloud_to_local", FromFS: "local_fs", ToFS: "backup_fs"})
+		require.Error(t, err)
+
+		syncPairs, err := store.ListSyncPairs(ctx)
+		require.NoError(t, err)
+		require.Equal(t,
+			[]db.SyncPair{
+				{PairName: "local_to_backup", FromFS: "local_fs", ToFS: "backup_fs"},
+				{PairName: "pcloud_to_local", FromFS: "pcloud_fs", ToFS: "local_fs"},
+			},
+			syncPairs,
+		)
+
+		peers, err := store.FindSyncPeers(ctx, "local_fs")
+		require.NoError(t, err)
+		require.Equal(t, []db.FSName{"backup_fs", "pcloud_fs"}, peers)
+
+		created := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		pCloudEntries := []db.FSEntry{
+			{FSName: "pcloud_fs", EntryID: 0, IsFolder: true, Path: "/", Name: "/", Created: created, Modified: created, Kind: db.EntryKindFolder},
+			{FSName: "pcloud_fs", EntryID: 12, Path: "/", Name: "File2", Created: created, Modified: created, Size: 3, Hash: "102", ContentHashAlgorithm: db.HashAlgorithmSHA1, ContentHash: "bbb", Kind: db.EntryKindFile},
+			{FSName: "pcloud_fs", EntryID: 13, Path: "/", Name: "OnlyPCloud", Created: created, Modified: created, Size: 3, Hash: "103", ContentHashAlgorithm: db.HashAlgorithmSHA1, ContentHash: "ccc", Kind: db.EntryKindFile},
+		}
+
+		localEntries := []db.FSEntry{
+			{FSName: "local_fs", DeviceID: "1", EntryID: 1, IsFolder: true, Path: "/home/user", Name: "pcloud", ParentFolderID: 1, Created: created, Modified: created, Kind: db.EntryKindFolder, Mode: 0o755},
+			{FSName: "local_fs", DeviceID: "1", EntryID: 22, Path: "/home/user/pcloud", Name: "File2", ParentFolderID: 1, Created: created, Modified: created, Size: 4, Hash: "202", ContentHashAlgorithm: db.HashAlgorithmSHA1, ContentHash: "bbb2", Kind: db.EntryKindFile, Mode: 0o644},
+			{FSName: "local_fs", DeviceID: "1", EntryID: 23, Path: "/home/user/pcloud", Name: "OnlyLocal", ParentFolderID: 1, Created: created, Modified: created, Size: 3, Hash: "203", ContentHashAlgorithm: db.HashAlgorithmSHA1, ContentHash: "ddd", Kind: db.EntryKindFile, Mode: 0o644},
+		}
+
+		addEntries(ctx, t, store, nil, pCloudEntries...)
+		addEntries(ctx, t, store, nil, localEntries...)
+
+		mutations, err := store.GetCrossFSMutations(ctx, "pcloud_to_local")
+		require.NoError(t, err)
+
+		newEntry := func(fsEntry db.FSEntry) db.VersionedEntry {
+			return db.VersionedEntry{Version: db.VersionNew, FSEntry: fsEntry}
+		}
+
+		require.Equal(t,
+			db.FSMutations{
+				{Type: db.MutationTypeCreated, Details: db.EntryMutations{newEntry(pCloudEntries[2])}},
+				{Type: db.MutationTypeDeleted, Details: db.EntryMutations{newEntry(localEntries[2])}},
+				{Type: db.MutationTypeModified, Details: db.EntryMutations{newEntry(pCloudEntries[1]), newEntry(localEntries[1])}},
+			},
+			mutations,
+		)
+
+		// the sync status goes from required to in progress to complete.
+		status, err := store.GetSyncStatus(ctx, "pcloud_to_local")
+		require.NoError(t, err)
+		require.Equal(t, db.SyncStatusRequired, status)
+
+		err = store.MarkSyncInProgress(ctx, "pcloud_to_local")
+		require.NoError(t, err)
+
+		err = store.MarkSyncInProgress(ctx, "pcloud_to_local")
+		require.Error(t, err)
+
+		// a sync that is "in progress" is only recovered once its lock is released.
+		unlock, err := store.LockSync(ctx, "pcloud_to_local")
+		require.NoError(t, err)
+
+		_, err = store.LockSync(ctx, "pcloud_to_local")
+		require.ErrorIs(t, err, db.ErrLocked)
+
+		pairNames, err := store.RecoverInterruptedSyncs(ctx)
+		require.NoError(t, err)
+		require.Empty(t, pairNames)
+
+		err = unlock()
+		require.NoError(t, err)
+
+		pairNames, err = store.RecoverInterruptedSyncs(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []db.PairName{"pcloud_to_local"}, pairNames)
+
+		err = store.MarkSyncInProgress(ctx, "pcloud_to_local")
+		require.NoError(t, err)
+
+		err = store.MarkSyncComplete(ctx, "pcloud_to_local")
+		require.NoError(t, err)
+
+		status, err = store.GetSyncStatus(ctx, "pcloud_to_local")
+		require.NoError(t, err)
+		require.Equal(t, db.SyncStatusComplete, status)
+
+		err = store.MarkSyncAsChanged(ctx, "pcloud_to_local")
+		require.NoError(t, err)
+
+		status, err = store.GetSyncStatus(ctx, "pcloud_to_local")
+		require.NoError(t, err)
+		require.Equal(t, db.SyncStatusRequired, status)
+
+		// a refresh of the "from" file system makes its syncs required.
+		err = store.MarkSyncInProgress(ctx, "local_to_backup")
+		require.NoError(t, err)
+
+		err = store.MarkSyncComplete(ctx, "local_to_backup")
+		require.NoError(t, err)
+
+		addEntries(ctx, t, store, []db.Options{db.WithStagingVersion()}, localEntries...)
+		err = store.CommitVersionStaging(ctx, "local_fs", db.StagingCommit{})
+		require.NoError(t, err)
+
+		status, err = store.GetSyncStatus(ctx, "local_to_backup")
+		require.NoError(t, err)
+		require.Equal(t, db.SyncStatusRequired, status)
+
+		err = store.DeleteSyncPair(ctx, "pcloud_to_local")
+		require.NoError(t, err)
+
+		err = store.DeleteSyncPair(ctx, "pcloud_to_local")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		_, err = store.GetCrossFSMutations(ctx, "pcloud_to_local")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		_, err = store.GetSyncStatus(ctx, "pcloud_to_local")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+}
+
+func TestStore_Snapshots(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store db.Store) {
+		ctx := context.Background()
+
+		err := store.RegisterFileSystem(ctx, "local_fs", db.FSDriverLocal, "/data")
+		require.NoError(t, err)
+
+		err = store.SetSnapshotRetention(ctx, "unknown_fs", 3)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		err = store.SetSnapshotRetention(ctx, "local_fs", -1)
+		require.Error(t, err)
+
+		created := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		root := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 1, IsFolder: true, Path: "/", Name: "data", ParentFolderID: 1, Created: created, Modified: created, Kind: db.EntryKindFolder}
+		folder := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 2, IsFolder: true, Path: "/data", Name: "Folder", ParentFolderID: 1, Created: created, Modified: created, Kind: db.EntryKindFolder}
+		file1 := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 3, Path: "/data", Name: "File1", ParentFolderID: 1, Created: created, Modified: created, Size: 3, Hash: "aaa", Kind: db.EntryKindFile}
+		file2 := db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: 4, Path: "/data", Name: "File2", ParentFolderID: 1, Created: created, Modified: created, Size: 3, Hash: "bbb", Kind: db.EntryKindFile}
+
+		commitRefresh := func(fsEntries ...db.FSEntry) {
+			addEntries(ctx, t, store, []db.Options{db.WithStagingVersion()}, fsEntries...)
+
+			err := store.CommitVersionStaging(ctx, "local_fs", db.StagingCommit{})
+			require.NoError(t, err)
+		}
+
+		// no history is kept by default.
+		commitRefresh(root, folder, file1)
+
+		snapshots, err := store.ListSnapshots(ctx, "local_fs")
+		require.NoError(t, err)
+		require.Empty(t, snapshots)
+
+		err = store.SetSnapshotRetention(ctx, "local_fs", 3)
+		require.NoError(t, err)
+
+		commitRefresh(root, folder, file1)
+
+		file1Modified := file1
+		file1Modified.Hash = "aab"
+		file1Modified.Size = 4
+		commitRefresh(root, folder, file1Modified, file2)
+
+		file2Moved := file2
+		file2Moved.Path = "/data/Folder"
+		file2Moved.ParentFolderID = 2
+		commitRefresh(root, folder, file2Moved)
+
+		snapshots, err = store.ListSnapshots(ctx, "local_fs")
+		require.NoError(t, err)
+		require.Len(t, snapshots, 3)
+
+		for i, snapshot := range snapshots {
+			require.Equal(t, db.FSName("local_fs"), snapshot.FSName)
+			require.Equal(t, uint64(i+1), snapshot.ID)
+		}
+
+		snapshot, err := store.FindSnapshot(ctx, "local_fs", time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		require.Equal(t, snapshots[2].ID, snapshot.ID)
+
+		snapshot, err = store.FindSnapshot(ctx, "local_fs", snapshots[1].TakenAt)
+		require.NoError(t, err)
+		require.Equal(t, snapshots[1].ID, snapshot.ID)
+
+		_, err = store.FindSnapshot(ctx, "local_fs", snapshots[0].TakenAt.Add(-time.Second))
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		fsEntries, err := store.GetSnapshotEntries(ctx, "local_fs", 1)
+		require.NoError(t, err)
+		require.Equal(t, []db.FSEntry{root, folder, file1}, fsEntries)
+
+		fsEntries, err = store.GetSnapshotEntries(ctx, "local_fs", 2)
+		require.NoError(t, err)
+		require.Equal(t, []db.FSEntry{root, folder, file1Modified, file2}, fsEntries)
+
+		fsEntries, err = store.GetSnapshotEntries(ctx, "local_fs", 3)
+		require.NoError(t, err)
+		require.Equal(t, []db.FSEntry{root, folder, file2Moved}, fsEntries)
+
+		_, err = store.GetSnapshotEntries(ctx, "local_fs", 4)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		previousEntry := func(fsEntry db.FSEntry) db.VersionedEntry {
+			return db.VersionedEntry{Version: db.VersionPrevious, FSEntry: fsEntry}
+		}
+		newEntry := func(fsEntry db.FSEntry) db.VersionedEntry {
+			return db.VersionedEntry{Version: db.VersionNew, FSEntry: fsEntry}
+		}
+
+		mutations, err := store.GetSnapshotMutations(ctx, "local_fs", 1, 3)
+		require.NoError(t, err)
+		require.Equal(
+			t,
+			db.FSMutations{
+				{Type: db.MutationTypeCreated, Details: []db.VersionedEntry{newEntry(file2Moved)}},
+				{Type: db.MutationTypeDeleted, Details: []db.VersionedEntry{previousEntry(file1)}},
+			},
+			mutations,
+		)
+
+		mutations, err = store.GetSnapshotMutations(ctx, "local_fs", 2, 3)
+		require.NoError(t, err)
+		require.Equal(
+			t,
+			db.FSMutations{
+				{Type: db.MutationTypeDeleted, Details: []db.VersionedEntry{previousEntry(file1Modified)}},
+				{Type: db.MutationTypeMoved, Details: []db.VersionedEntry{previousEntry(file2), newEntry(file2Moved)}},
+			},
+			mutations,
+		)
+
+		snapshot, err = store.GetPathLastChange(ctx, "local_fs", "/data/File1")
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), snapshot.ID) // deleted
+
+		snapshot, err = store.GetPathLastChange(ctx, "local_fs", "/data/File2")
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), snapshot.ID) // moved away
+
+		snapshot, err = store.GetPathLastChange(ctx, "local_fs", "/data/Folder")
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), snapshot.ID)
+
+		_, err = store.GetPathLastChange(ctx, "local_fs", "/data/Folder/File1")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		// the oldest snapshots are discarded beyond the retention.
+		err = store.SetSnapshotRetention(ctx, "local_fs", 2)
+		require.NoError(t, err)
+
+		commitRefresh(root, folder, file2Moved)
+
+		snapshots, err = store.ListSnapshots(ctx, "local_fs")
+		require.NoError(t, err)
+		require.Len(t, snapshots, 2)
+		require.Equal(t, uint64(3), snapshots[0].ID)
+		require.Equal(t, uint64(4), snapshots[1].ID)
+
+		_, err = store.GetSnapshotEntries(ctx, "local_fs", 2)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		fsEntries, err = store.GetSnapshotEntries(ctx, "local_fs", 4)
+		require.NoError(t, err)
+		require.Equal(t, []db.FSEntry{root, folder, file2Moved}, fsEntries)
+
+		mutations, err = store.GetSnapshotMutations(ctx, "local_fs", 3, 4)
+		require.NoError(t, err)
+		require.Empty(t, mutations)
+
+		_, err = store.GetPathLastChange(ctx, "local_fs", "/data/File1")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		snapshot, err = store.GetPathLastChange(ctx, "local_fs", "/data/Folder/File2")
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), snapshot.ID)
+
+		// disabling the history discards it.
+		err = store.SetSnapshotRetention(ctx, "local_fs", 0)
+		require.NoError(t, err)
+
+		snapshots, err = store.ListSnapshots(ctx, "local_fs")
+		require.NoError(t, err)
+		require.Empty(t, snapshots)
+	})
+}
@@ -0,0 +1,454 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PairName is a descriptive name for a sync pair.
+type PairName string
+
+// SyncPair is a pair of file systems that are synced, from FromFS to ToFS.
+type SyncPair struct {
+	PairName PairName
+	FromFS   FSName
+	ToFS     FSName
+}
+
+// CreateSyncPair records a new sync pair between two registered file systems
+// (see RegisterFileSystem).
+func (s *SQLite3) CreateSyncPair(ctx context.Context, syncPair SyncPair) error {
+	if syncPair.FromFS == syncPair.ToFS {
+		return errors.Errorf("sync pair '%s' cannot sync file system '%s' with itself", syncPair.PairName, syncPair.FromFS)
+	}
+
+	for _, fsName := range []FSName{syncPair.FromFS, syncPair.ToFS} {
+		_, err := s.GetFileSystemInfo(ctx, fsName)
+		if err != nil {
+			return errors.WithMessagef(err, "sync pair '%s': file system '%s' is not registered", syncPair.PairName, fsName)
+		}
+	}
+
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO "sync_pairs" (pair_name, from_fs, to_fs)
+		 VALUES (?, ?, ?)`,
+		syncPair.PairName,
+		syncPair.FromFS,
+		syncPair.ToFS,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "sync pair '%s'", syncPair.PairName)
+	}
+
+	return nil
+}
+
+// ListSyncPairs returns all the sync pairs, sorted by name.
+func (s *SQLite3) ListSyncPairs(ctx context.Context) ([]SyncPair, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT pair_name, from_fs, to_fs
+		 FROM "sync_pairs"
+		 ORDER BY pair_name`,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	return scanSyncPairs(rows)
+}
+
+// DeleteSyncPair removes the sync pair pairName.
+// It returns an error that wraps sql.ErrNoRows when the sync pair does not exist.
+func (s *SQLite3) DeleteSyncPair(ctx context.Context, pairName PairName) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res, err := tx.ExecContext(
+		ctx,
+		`DELETE FROM "sync_pairs"
+		 WHERE pair_name = ?`,
+		pairName,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	if n == 0 {
+		return doRollback(tx, errors.Wrapf(sql.ErrNoRows, "sync pair '%s'", pairName))
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM "staging_cross_mutations"
+		 WHERE pair_name = ?`,
+		pairName,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+func (s *SQLite3) findSyncPair(ctx context.Context, pairName PairName) (*SyncPair, error) {
+	var syncPair SyncPair
+
+	err := s.db.
+		QueryRowContext(
+			ctx,
+			`SELECT pair_name, from_fs, to_fs
+			 FROM sync_pairs
+			 WHERE pair_name == :pair_name`,
+			sql.Named("pair_name", pairName)).
+		Scan(&syncPair.PairName, &syncPair.FromFS, &syncPair.ToFS)
+	if err != nil {
+		return nil, errors.Wrapf(err, "sync pair '%s'", pairName)
+	}
+
+	return &syncPair, nil
+}
+
+func (s *SQLite3) findSyncPairsByFSName(ctx context.Context, fsName FSName) ([]SyncPair, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT pair_name, from_fs, to_fs
+		 FROM sync_pairs
+		 WHERE from_fs == :fs_name OR to_fs == :fs_name
+		 ORDER BY pair_name`,
+		sql.Named("fs_name", fsName),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	return scanSyncPairs(rows)
+}
+
+// FindSyncPeers returns the names of the file systems that are synced with the file system
+// fsName, in either direction, sorted by name.
+func (s *SQLite3) FindSyncPeers(ctx context.Context, fsName FSName) ([]FSName, error) {
+	syncPairs, err := s.findSyncPairsByFSName(ctx, fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	peersSet := map[FSName]struct{}{}
+
+	for _, syncPair := range syncPairs {
+		peer := syncPair.ToFS
+		if peer == fsName {
+			peer = syncPair.FromFS
+		}
+
+		peersSet[peer] = struct{}{}
+	}
+
+	peers := make([]FSName, 0, len(peersSet))
+	for peer := range peersSet {
+		peers = append(peers, peer)
+	}
+
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+
+	return peers, nil
+}
+
+func scanSyncPairs(rows *sql.Rows) ([]SyncPair, error) {
+	syncPairs := []SyncPair{}
+
+	for rows.Next() {
+		sp := SyncPair{}
+
+		err := rows.Scan(
+			&sp.PairName,
+			&sp.FromFS,
+			&sp.ToFS,
+		)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		syncPairs = append(syncPairs, sp)
+	}
+
+	err := rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return syncPairs, nil
+}
+
+// GetCrossFSMutations returns the mutations to apply to the "to" file system of the sync pair
+// pairName so that it mirrors its "from" file system.
+// The entries of both file systems are matched by their path relative to the root of their file
+// system. It should be noted that a move appears as a deletion of the entry at its former path and
+// a creation of the entry at its new path.
+// The details of the created mutations hold the "from" entry, those of the deleted mutations hold
+// the "to" entry and those of the modified mutations hold the "from" entry then the "to" entry.
+// All details are of VersionNew.
+func (s *SQLite3) GetCrossFSMutations(ctx context.Context, pairName PairName) (FSMutations, error) {
+	syncPair, err := s.findSyncPair(ctx, pairName)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = s.refreshCrossFSMutationsStagingTable(ctx, tx, *syncPair)
+	if err != nil {
+		return nil, doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, doRollback(tx, err)
+	}
+
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT scm.mutation_type,
+			    scm.rel_path,
+			    fs.fs_name,
+			    fs.version,
+			    fs.device_id,
+			    fs.entry_id,
+			    fs.is_folder,
+			    fs.path,
+			    fs.name,
+			    fs.parent_folder_id,
+			    fs.created,
+			    fs.modified,
+			    fs.size,
+			    fs.hash,
+			    fs.content_hash_algorithm,
+			    fs.content_hash,
+			    fs.kind,
+			    fs.link_target,
+			    fs.mode,
+			    fs.uid,
+			    fs.gid,
+			    fs.xattrs
+		 FROM staging_cross_mutations scm
+			  JOIN sync_pairs sp
+			  ON scm.pair_name = sp.pair_name
+			  JOIN filesystem fs
+			  ON (fs.fs_name = sp.from_fs AND fs.device_id = scm.from_device_id AND fs.entry_id = scm.from_entry_id)
+			     OR (fs.fs_name = sp.to_fs AND fs.device_id = scm.to_device_id AND fs.entry_id = scm.to_entry_id)
+		 WHERE scm.pair_name = :pair_name
+		   AND fs.version = :version_new
+		 ORDER BY scm.mutation_type, scm.rel_path, fs.fs_name = sp.to_fs`, // "from" before "to"
+		sql.Named("pair_name", pairName),
+		sql.Named("version_new", VersionNew),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	return processCrossFSMutationsRows(rows)
+}
+
+func processCrossFSMutationsRows(rows *sql.Rows) (FSMutations, error) {
+	fsMutations := FSMutations{}
+	previousKey := ""
+
+	for rows.Next() {
+		var (
+			mType   MutationType
+			relPath string
+			ve      VersionedEntry
+		)
+
+		err := rows.Scan(
+			&mType,
+			&relPath,
+			&ve.FSName,
+			&ve.Version,
+			&ve.DeviceID,
+			&ve.EntryID,
+			&ve.IsFolder,
+			&ve.Path,
+			&ve.Name,
+			&ve.ParentFolderID,
+			&ve.Created,
+			&ve.Modified,
+			&ve.Size,
+			&ve.Hash,
+			&ve.ContentHashAlgorithm,
+			&ve.ContentHash,
+			&ve.Kind,
+			&ve.LinkTarget,
+			&ve.Mode,
+			&ve.UID,
+			&ve.GID,
+			&ve.Xattrs,
+		)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		key := string(mType) + "\x00" + relPath
+		if key != previousKey {
+			fsMutations = append(fsMutations, FSMutation{Type: mType})
+			previousKey = key
+		}
+
+		fsm := &fsMutations[len(fsMutations)-1]
+		fsm.Details = append(fsm.Details, ve)
+	}
+
+	err := rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return fsMutations, nil
+}
+
+// crossFSRoot returns the root of the file system fsName that the paths of its entries are
+// relative to, without its trailing separators.
+func (s *SQLite3) crossFSRoot(ctx context.Context, fsName FSName) (string, error) {
+	roots, err := s.GetFileSystemRoots(ctx, fsName)
+	if err != nil {
+		return "", err
+	}
+
+	root := VirtualRootPath
+
+	if len(roots) == 0 {
+		_, root, err = s.GetSyncDetails(ctx, fsName)
+		if err != nil {
+			return "", errors.WithMessagef(err, "file system '%s'", fsName)
+		}
+	}
+
+	return strings.TrimRight(root, "/"), nil
+}
+
+// refreshCrossFSMutationsStagingTable replaces the staging rows of the sync pair with the
+// mutations that exist between the VersionNew entries of its file systems.
+// TODO: for this to work reliably, path needs to be standardised (i.e. \ or /, C: or /, etc).
+func (s *SQLite3) refreshCrossFSMutationsStagingTable(ctx context.Context, tx *sql.Tx, syncPair SyncPair) error {
+	fromRoot, err := s.crossFSRoot(ctx, syncPair.FromFS)
+	if err != nil {
+		return err
+	}
+
+	toRoot, err := s.crossFSRoot(ctx, syncPair.ToFS)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM "staging_cross_mutations"
+		 WHERE pair_name = ?`,
+		syncPair.PairName,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// the root of a file system is the entry whose path is its name.
+	_, err = tx.ExecContext(
+		ctx,
+		`WITH entries AS (SELECT fs_name, device_id, entry_id, is_folder, content_hash_algorithm, content_hash, kind, link_target,
+								 CASE
+									 WHEN path = name THEN path
+									 WHEN substr(path, -1) = '/' THEN path || name
+									 ELSE path || '/' || name
+								 END AS full_path
+							FROM filesystem
+						   WHERE version = :version_new),
+			 from_fs AS (SELECT *, rtrim(substr(full_path, length(:from_root) + 1), '/') AS rel_path
+						   FROM entries
+						  WHERE fs_name = :from_fs),
+			 to_fs AS (SELECT *, rtrim(substr(full_path, length(:to_root) + 1), '/') AS rel_path
+						 FROM entries
+						WHERE fs_name = :to_fs)
+
+		INSERT INTO staging_cross_mutations (pair_name, mutation_type, rel_path, from_device_id, from_entry_id, to_device_id, to_entry_id)
+
+		SELECT
+			:pair_name,
+			:mutation_type_created,
+			from_fs.rel_path,
+			from_fs.device_id,
+			from_fs.entry_id,
+			NULL,
+			NULL
+		 FROM from_fs LEFT OUTER JOIN to_fs USING (rel_path)
+		 WHERE to_fs.entry_id IS NULL
+
+		 UNION
+
+		 SELECT
+			:pair_name,
+			:mutation_type_deleted,
+			to_fs.rel_path,
+			NULL,
+			NULL,
+			to_fs.device_id,
+			to_fs.entry_id
+		 FROM to_fs LEFT OUTER JOIN from_fs USING (rel_path)
+		 WHERE from_fs.entry_id IS NULL
+
+		 UNION
+
+		 SELECT
+			:pair_name,
+			:mutation_type_modified,
+			from_fs.rel_path,
+			from_fs.device_id,
+			from_fs.entry_id,
+			to_fs.device_id,
+			to_fs.entry_id
+		 FROM from_fs JOIN to_fs USING (rel_path)
+		 WHERE from_fs.is_folder != to_fs.is_folder
+		    -- content hash is not relevant for folders and that's just fine
+		    -- the hash of the entries is specific to their file system and cannot be compared
+		    OR from_fs.content_hash_algorithm != to_fs.content_hash_algorithm
+		    OR from_fs.content_hash != to_fs.content_hash
+		    -- the permissions and owners are specific to their file system too
+		    OR from_fs.kind != to_fs.kind
+		    OR from_fs.link_target != to_fs.link_target`,
+		sql.Named("pair_name", syncPair.PairName),
+		sql.Named("from_fs", syncPair.FromFS),
+		sql.Named("to_fs", syncPair.ToFS),
+		sql.Named("from_root", fromRoot),
+		sql.Named("to_root", toRoot),
+		sql.Named("version_new", VersionNew),
+		sql.Named("mutation_type_deleted", MutationTypeDeleted),
+		sql.Named("mutation_type_created", MutationTypeCreated),
+		sql.Named("mutation_type_modified", MutationTypeModified),
+	)
+
+	return errors.WithStack(err)
+}
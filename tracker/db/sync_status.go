@@ -0,0 +1,261 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// SyncStatus defines the status of the sync of a sync pair. It is used to prevent refreshing
+// data in the filesystem table of the "from" file system when it has not yet been completely
+// sync'ed.
+// In particular, VersionPrevious should not be replaced with new data until the sync has
+// completed or some delta changes between "previous" and "new" will be lost and non-replicated.
+// It is always safe to update VersionNew (but not VersionPrevious until processed).
+//
+// The status of a sync pair goes through these transitions:
+//   - Required -> In progress: the sync starts (see MarkSyncInProgress).
+//   - In progress -> Complete: the sync has applied all mutations (see MarkSyncComplete).
+//   - In progress -> Required: the sync failed (see MarkSyncAsChanged) or was interrupted (see
+//     RecoverInterruptedSyncs).
+//   - any -> Required: the "from" file system was refreshed (see CommitVersionStaging).
+type SyncStatus string
+
+const (
+	// SyncStatusComplete indicates VersionPrevious has been completely sync'ed and can now be
+	// replaced with newer data.
+	SyncStatusComplete SyncStatus = "Complete"
+
+	// SyncStatusRequired indicates VersionPrevious has just been refreshed and requires
+	// sync'ing against VersionNew.
+	SyncStatusRequired SyncStatus = "Required"
+
+	// SyncStatusInProgress indicates that the sync between VersionPrevious and VersionNew is in
+	// progress.
+	SyncStatusInProgress SyncStatus = "In progress"
+)
+
+// GetSyncStatus returns the status of the sync of the sync pair pairName.
+func (s *SQLite3) GetSyncStatus(ctx context.Context, pairName PairName) (SyncStatus, error) {
+	var status SyncStatus
+
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT sync_status
+		 FROM "sync_pairs"
+		 WHERE pair_name = ?`,
+		pairName,
+	).Scan(&status)
+	if err != nil {
+		return "", errors.Wrapf(err, "sync pair '%s'", pairName)
+	}
+
+	return status, nil
+}
+
+// MarkSyncInProgress marks the status of the sync as "in progress".
+// It fails when the sync is not "required", such as when another sync of the pair is in progress.
+func (s *SQLite3) MarkSyncInProgress(ctx context.Context, pairName PairName) error {
+	res, err := s.db.ExecContext(
+		ctx,
+		`UPDATE "sync_pairs" SET sync_status = ?
+		 WHERE pair_name = ?
+		   AND sync_status = ?`,
+		SyncStatusInProgress,
+		pairName,
+		SyncStatusRequired,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if n == 0 {
+		status, err := s.GetSyncStatus(ctx, pairName)
+		if err != nil {
+			return err
+		}
+		return errors.Errorf("cannot start the sync of pair '%s': its status is '%s'", pairName, status)
+	}
+
+	return nil
+}
+
+// MarkSyncComplete marks the status of the sync as "complete".
+// Once all the sync pairs of its "from" file system are complete, the file system is no longer
+// marked as changed so that its next refresh replaces VersionPrevious.
+// The sync remains "required" when the "from" file system was refreshed while the sync was in
+// progress, since the sync did not apply the latest mutations.
+// TODO: it may be that the staging table should be cleared down, although not essential because
+// that is properly taken care of by other methods that change the state of table "filesystem".
+func (s *SQLite3) MarkSyncComplete(ctx context.Context, pairName PairName) error {
+	syncPair, err := s.findSyncPair(ctx, pairName)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE "sync_pairs" SET sync_status = ?
+		 WHERE pair_name = ?
+		   AND sync_status = ?`,
+		SyncStatusComplete,
+		pairName,
+		SyncStatusInProgress,
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE "fs_info" SET fs_changed = FALSE
+		 WHERE fs_name = :fs_name
+		   AND NOT EXISTS (SELECT 1
+		                   FROM "sync_pairs"
+		                   WHERE from_fs = :fs_name
+		                     AND sync_status != :sync_status_complete)`,
+		sql.Named("fs_name", syncPair.FromFS),
+		sql.Named("sync_status_complete", SyncStatusComplete),
+	)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// MarkSyncAsChanged marks the status of the sync as "required", such as after a sync failed.
+// This also triggers the internal refresh of the cross file system mutations of the pair.
+func (s *SQLite3) MarkSyncAsChanged(ctx context.Context, pairName PairName) error {
+	syncPair, err := s.findSyncPair(ctx, pairName)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.refreshCrossFSMutationsStagingTable(ctx, tx, *syncPair)
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = s.markSyncRequired(ctx, tx, "pair_name", string(pairName))
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return doRollback(tx, err)
+	}
+
+	return nil
+}
+
+// markSyncRequired marks the status of the syncs whose column equals value as "required".
+func (s *SQLite3) markSyncRequired(ctx context.Context, tx *sql.Tx, column, value string) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`UPDATE "sync_pairs" SET sync_status = ?
+		 WHERE `+column+` = ?`,
+		SyncStatusRequired,
+		value,
+	)
+
+	return errors.WithStack(err)
+}
+
+// RecoverInterruptedSyncs marks the syncs that are "in progress" but not running (see LockSync)
+// as "required". These are left over by syncs that were interrupted, such as by a crash: they
+// will need to run again. The syncs that are running are left alone.
+// It returns the names of the sync pairs whose sync was interrupted, sorted by name.
+func (s *SQLite3) RecoverInterruptedSyncs(ctx context.Context) ([]PairName, error) {
+	// nolint: rowserrcheck
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT pair_name
+		 FROM "sync_pairs"
+		 WHERE sync_status = ?
+		 ORDER BY pair_name`,
+		SyncStatusInProgress,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	inProgressPairNames := []PairName{}
+
+	for rows.Next() {
+		var pairName PairName
+
+		err = rows.Scan(&pairName)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		inProgressPairNames = append(inProgressPairNames, pairName)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	pairNames := []PairName{}
+
+	for _, pairName := range inProgressPairNames {
+		recovered, err := s.recoverInterruptedSync(ctx, pairName)
+		if err != nil {
+			return nil, err
+		}
+
+		if recovered {
+			pairNames = append(pairNames, pairName)
+		}
+	}
+
+	return pairNames, nil
+}
+
+// recoverInterruptedSync marks the sync of the sync pair pairName as "required" if it is "in
+// progress", unless it is running. It reports whether it was "in progress".
+func (s *SQLite3) recoverInterruptedSync(ctx context.Context, pairName PairName) (bool, error) {
+	unlock, err := s.LockSync(ctx, pairName)
+	if errors.Is(err, ErrLocked) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = unlock() }()
+
+	return execAffectsRows(s.db.ExecContext(
+		ctx,
+		`UPDATE "sync_pairs" SET sync_status = ?
+		 WHERE pair_name = ?
+		   AND sync_status = ?`,
+		SyncStatusRequired,
+		pairName,
+		SyncStatusInProgress,
+	))
+}
@@ -10,118 +10,730 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/seborama/pcloud-sdk/tracker/archos"
 	"github.com/seborama/pcloud-sdk/tracker/db"
+	"github.com/seborama/pcloud-sdk/tracker/filter"
 )
 
+// previousEntriesGetter defines the store operation used by Local to reuse the hashes of the
+// files that have not changed.
+type previousEntriesGetter interface {
+	GetPreviousFileSystemEntries(ctx context.Context, fsName db.FSName) ([]db.FSEntry, error)
+}
+
 // Local is a file system abstraction for a local file system.
-type Local struct{}
+// It is not safe for concurrent Walks.
+type Local struct {
+	hashCache   *db.HashCache
+	filter      *filter.Filter
+	store       previousEntriesGetter
+	paranoid    bool
+	concurrency int
+	throttle    *throttle
+
+	symlinkPolicy SymlinkPolicy
+	xattrs        bool
+	crossDevice   bool
+	roots         []db.FSRoot
+
+	stats walkStats
+}
+
+// WalkStats contains statistics about a Walk.
+type WalkStats struct {
+	// FilesHashed is the number of files whose data was read to compute their hash.
+	FilesHashed uint64
+
+	// FilesReused is the number of files whose hash was reused without reading their data.
+	FilesReused uint64
+
+	// BytesRead is the amount of file data read to compute hashes.
+	BytesRead uint64
+}
+
+// walkStats is the concurrency-safe counterpart of WalkStats.
+type walkStats struct {
+	filesHashed atomic.Uint64
+	filesReused atomic.Uint64
+	bytesRead   atomic.Uint64
+}
+
+// LocalOption is a Go functional parameter signature used to configure Local.
+type LocalOption func(*Local)
+
+// WithHashReuse makes Walk reuse the hash of the files of VersionPrevious in store whose
+// device, inode, size, modification time and change time are all unchanged, instead of reading
+// their data.
+func WithHashReuse(store previousEntriesGetter) LocalOption {
+	return func(fs *Local) {
+		fs.store = store
+	}
+}
+
+// WithParanoidHashing makes Walk read the data of all files to compute their hash, even when
+// it could be reused (see WithHashReuse and UseHashCache).
+func WithParanoidHashing() LocalOption {
+	return func(fs *Local) {
+		fs.paranoid = true
+	}
+}
+
+// WithHashingConcurrency sets the number of files that Walk hashes concurrently.
+// The default is 1.
+func WithHashingConcurrency(n int) LocalOption {
+	return func(fs *Local) {
+		if n > 0 {
+			fs.concurrency = n
+		}
+	}
+}
+
+// WithHashingThrottle limits the rate at which Walk reads the data of files to compute their
+// hash, across all concurrent hashings. This is useful to preserve the responsiveness of slow
+// disks. The default is unlimited.
+func WithHashingThrottle(bytesPerSecond uint64) LocalOption {
+	return func(fs *Local) {
+		if bytesPerSecond > 0 {
+			fs.throttle = &throttle{bytesPerSecond: bytesPerSecond}
+		}
+	}
+}
+
+// SymlinkPolicy determines how Walk handles symbolic links.
+type SymlinkPolicy int
+
+const (
+	// SymlinkStoreAsLink tracks symbolic links as entries of kind db.EntryKindSymlink, with
+	// their target. This is the default.
+	SymlinkStoreAsLink SymlinkPolicy = iota
+
+	// SymlinkSkip leaves symbolic links out.
+	SymlinkSkip
+
+	// SymlinkFollow tracks the entries that symbolic links point to, at the path of the link.
+	// An entry that can be reached by several paths is only tracked at the first path walked
+	// and dangling links are left out.
+	SymlinkFollow
+)
+
+// WithSymlinkPolicy sets how Walk handles symbolic links.
+func WithSymlinkPolicy(policy SymlinkPolicy) LocalOption {
+	return func(fs *Local) {
+		fs.symlinkPolicy = policy
+	}
+}
+
+// WithXattrs makes Walk read the extended attributes of the entries, where the platform
+// supports them.
+func WithXattrs() LocalOption {
+	return func(fs *Local) {
+		fs.xattrs = true
+	}
+}
+
+// WithCrossDevice makes Walk descend into the folders that are on other devices than the root,
+// such as mount points. By default, Walk does not cross devices.
+// The entries are identified by their device and their inode.
+func WithCrossDevice() LocalOption {
+	return func(fs *Local) {
+		fs.crossDevice = true
+	}
+}
 
 // NewLocal creates a new initialised Local structure.
-func NewLocal() *Local {
-	return &Local{}
+func NewLocal(opts ...LocalOption) *Local {
+	fs := &Local{
+		concurrency: 1,
+	}
+
+	for _, opt := range opts {
+		opt(fs)
+	}
+
+	return fs
+}
+
+// Stats returns the statistics of the last Walk.
+func (fs *Local) Stats() WalkStats {
+	return WalkStats{
+		FilesHashed: fs.stats.filesHashed.Load(),
+		FilesReused: fs.stats.filesReused.Load(),
+		BytesRead:   fs.stats.bytesRead.Load(),
+	}
+}
+
+// UseHashCache sets the cache of the hashes of the files known not to have changed, which Walk
+// uses instead of hashing the data of the files whose size, modification and change times are
+// unchanged. A nil cache means all files are hashed.
+func (fs *Local) UseHashCache(cache *db.HashCache) {
+	fs.hashCache = cache
+}
+
+// UseFilter sets the filter of the entries that Walk leaves out. The contents of an excluded
+// folder are not traversed. A nil filter means all entries are walked.
+func (fs *Local) UseFilter(f *filter.Filter) {
+	fs.filter = f
+}
+
+// UseRoots sets the roots of a file system with multiple roots. Walk then maps them into a virtual
+// tree, with one folder per root named after it, under the virtual root db.VirtualRootPath.
+// The path of the entries are then virtual paths (see LocalPath). The roots must not overlap.
+// Nil roots means the file system has the single root that is passed to Walk.
+func (fs *Local) UseRoots(roots []db.FSRoot) {
+	fs.roots = roots
+}
+
+// LocalPath returns the path on the local file system of the entry at path in the tree of the
+// entries. It is path itself unless the file system has multiple roots (see UseRoots).
+func (fs *Local) LocalPath(path string) (string, error) {
+	if fs.roots == nil {
+		return path, nil
+	}
+
+	relPath, err := filepath.Rel(db.VirtualRootPath, path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	rootName, rest, _ := strings.Cut(filepath.ToSlash(relPath), "/")
+
+	for _, root := range fs.roots {
+		if root.Name == rootName {
+			return filepath.Join(root.Path, filepath.FromSlash(rest)), nil
+		}
+	}
+
+	return "", errors.Errorf("path '%s' is not in any of the roots of the file system", path)
+}
+
+// ReadIgnoreFile returns the contents of the ignore file of the folder at path dir, or nil if
+// there is none.
+func (fs *Local) ReadIgnoreFile(_ context.Context, dir string) ([]byte, error) {
+	if fs.roots != nil && filepath.Clean(dir) == db.VirtualRootPath {
+		// the virtual root is not a folder of the local file system.
+		return nil, nil
+	}
+
+	dir, err := fs.LocalPath(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, filter.IgnoreFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	return data, nil
 }
 
 // Walk traverses the file system entries and writes each entry to fsEntriesCh.
 // It must check for an error in errCh (which indicates the receiver of fsEntriesCh encountered
 // a problem and terminate if one is present.
 // Walk is the PRODUCER on fsEntriesCh and IS RESPONSIBLE FOR CLOSING IT!!
-// nolint: gocognit, gocyclo
+// Files are hashed concurrently (see WithHashingConcurrency) but the entries are written to
+// fsEntriesCh in the order of the traversal, with parent folders always before their contents.
 func (fs *Local) Walk(ctx context.Context, fsName db.FSName, path string, fsEntriesCh chan<- db.FSEntry, errCh <-chan error) error {
-	fi, err := os.Stat(path)
+	roots, err := fs.getWalkRoots(path)
 	if err != nil {
 		return err
 	}
 
-	if !fi.IsDir() {
-		return errors.Errorf("path is not pointing at a directory: %s", path)
+	previousFiles, err := fs.getPreviousFiles(ctx, fsName)
+	if err != nil {
+		return err
 	}
 
-	deviceID := archos.Device(fi)
+	fs.stats.filesHashed.Store(0)
+	fs.stats.filesReused.Store(0)
+	fs.stats.bytesRead.Store(0)
 
-	folderIDs := map[string]uint64{}
+	walkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	err = func() error {
-		isFSEntriesChOpened := true
+	// pendingEntriesCh holds the entries in the order of the traversal, while their file is hashed.
+	pendingEntriesCh := make(chan *pendingEntry, 2*fs.concurrency)
+	hashJobsCh := make(chan *pendingEntry)
 
-		err = filepath.Walk(path,
-			func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return errors.WithStack(err)
-				}
+	var wg sync.WaitGroup
 
-				if archos.Device(info) != deviceID {
-					return filepath.SkipDir
-				}
+	for i := 0; i < fs.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
 
-				hash := ""
-				dir := filepath.Dir(path) // NOTE: this also calls filepath.Clean
-				if info.IsDir() {
-					dir = filepath.Clean(path)
-					folderIDs[dir] = archos.Inode(info)
+			for pe := range hashJobsCh {
+				if walkCtx.Err() != nil {
+					pe.err = walkCtx.Err()
 				} else {
-					hash, err = hashFileData(path)
-					if err != nil {
-						return err
-					}
+					pe.fsEntry.Hash, pe.err = fs.hashFile(pe.path, pe.fsEntry, previousFiles)
+					// the hash of local files is a SHA1 of their contents.
+					pe.fsEntry.ContentHashAlgorithm = db.HashAlgorithmSHA1
+					pe.fsEntry.ContentHash = pe.fsEntry.Hash
 				}
+				close(pe.done)
+			}
+		}()
+	}
 
-				createdTime := archos.CreatedTime(info)
+	traverseErrCh := make(chan error, 1)
 
-				parentFolderID, ok := folderIDs[dir]
-				if !ok {
-					return errors.Errorf("unable to determine parent folder ID for '%s' using key='%s'", path, dir)
-				}
+	go func() {
+		defer close(pendingEntriesCh)
+		defer close(hashJobsCh)
 
-				// tips for Windows support:
-				// - go/src/os/types_windows.go
-				// - https://stackoverflow.com/questions/7162164/does-windows-have-inode-numbers-like-linux
-				fsEntry := db.FSEntry{
-					FSName:         fsName,
-					DeviceID:       fmt.Sprintf("%d", deviceID),
-					EntryID:        archos.Inode(info),
-					IsFolder:       info.IsDir(),
-					Path:           filepath.Dir(path),
-					Name:           info.Name(),
-					ParentFolderID: parentFolderID,
-					Created:        createdTime,
-					Modified:       info.ModTime(),
-					Size:           uint64(info.Size()),
-					Hash:           hash,
-				}
+		traverseErrCh <- fs.traverse(walkCtx, fsName, roots, pendingEntriesCh, hashJobsCh)
+	}()
+
+	err = func() error {
+		for pe := range pendingEntriesCh {
+			<-pe.done
+			if pe.err != nil {
+				return pe.err
+			}
+
+			select {
+			case err := <-errCh:
+				return &receiverError{err: err}
+			case fsEntriesCh <- pe.fsEntry:
+			}
+		}
+
+		return <-traverseErrCh
+	}()
+
+	// stop the traversal and the hashing, if still in progress.
+	cancel()
+	for range pendingEntriesCh { // nolint: revive
+	}
+	wg.Wait()
+
+	close(fsEntriesCh)
+
+	var rErr *receiverError
+	if errors.As(err, &rErr) {
+		return errors.WithStack(rErr.err)
+	}
+
+	errReceiver := <-errCh
+	if err != nil {
+		return err
+	}
+
+	return errors.WithStack(errReceiver)
+}
+
+// pendingEntry is a file system entry that is pending its hash.
+type pendingEntry struct {
+	path    string
+	fsEntry db.FSEntry
+	err     error
+	done    chan struct{}
+}
+
+// receiverError is an error reported by the receiver of the file system entries.
+type receiverError struct {
+	err error
+}
+
+func (e *receiverError) Error() string {
+	return e.err.Error()
+}
+
+// walkRoot is a root of a Walk.
+type walkRoot struct {
+	path        string // on the local file system
+	virtualPath string // in the tree of the entries
+	info        os.FileInfo
+}
+
+// getWalkRoots returns the roots of a Walk from path: path itself or, when the file system has
+// multiple roots, its roots. The roots are always followed, even if they are symbolic links.
+func (fs *Local) getWalkRoots(path string) ([]walkRoot, error) {
+	if fs.roots == nil {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !fi.IsDir() {
+			return nil, errors.Errorf("path is not pointing at a directory: %s", path)
+		}
+
+		return []walkRoot{{path: filepath.Clean(path), virtualPath: filepath.Clean(path), info: fi}}, nil
+	}
+
+	if path != db.VirtualRootPath {
+		return nil, errors.Errorf("path '%s' is not the virtual root of a file system with multiple roots", path)
+	}
 
-				select {
-				case err := <-errCh:
-					close(fsEntriesCh)
-					isFSEntriesChOpened = false
-					return errors.WithStack(err)
-				case fsEntriesCh <- fsEntry:
+	roots := []walkRoot{}
+
+	for _, root := range fs.roots {
+		fi, err := os.Stat(root.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "root '%s'", root.Name)
+		}
+
+		if !fi.IsDir() {
+			return nil, errors.Errorf("root '%s' is not pointing at a directory: %s", root.Name, root.Path)
+		}
+
+		roots = append(roots, walkRoot{
+			path:        filepath.Clean(root.Path),
+			virtualPath: filepath.Join(db.VirtualRootPath, root.Name),
+			info:        fi,
+		})
+	}
+
+	return roots, nil
+}
+
+// traverse walks the file system from its roots and writes each entry to pendingEntriesCh, in
+// order. The files are also written to hashJobsCh for hashing.
+func (fs *Local) traverse(ctx context.Context, fsName db.FSName, roots []walkRoot, pendingEntriesCh, hashJobsCh chan<- *pendingEntry) error {
+	t := &traversal{
+		fs:               fs,
+		fsName:           fsName,
+		pendingEntriesCh: pendingEntriesCh,
+		hashJobsCh:       hashJobsCh,
+	}
+
+	if fs.symlinkPolicy == SymlinkFollow {
+		t.visited = map[statIdentity]struct{}{}
+	}
+
+	if fs.roots != nil {
+		// the virtual root only exists in the tree of the entries: its ID is zero and its
+		// metadata is not tracked.
+		pe := &pendingEntry{
+			fsEntry: db.FSEntry{
+				FSName:   fsName,
+				IsFolder: true,
+				Path:     db.VirtualRootPath,
+				Name:     db.VirtualRootPath,
+				Kind:     db.EntryKindFolder,
+			},
+			done: make(chan struct{}),
+		}
+		close(pe.done)
+
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case pendingEntriesCh <- pe:
+		}
+	}
+
+	for _, root := range roots {
+		t.deviceID = archos.Device(root.info)
+
+		// a single root is its own parent, multiple roots are in the virtual root.
+		parentFolderID := uint64(0)
+		if fs.roots == nil {
+			parentFolderID = archos.Inode(root.info)
+		}
+
+		err := t.walk(ctx, root.path, root.virtualPath, root.info, parentFolderID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// traversal holds the state of a traversal of the local file system.
+type traversal struct {
+	fs               *Local
+	fsName           db.FSName
+	deviceID         uint64 // the device of the root being walked
+	pendingEntriesCh chan<- *pendingEntry
+	hashJobsCh       chan<- *pendingEntry
+
+	// visited holds the entries already walked, when symbolic links are followed. It prevents
+	// cycles and entries from being tracked twice when they can be reached by several paths.
+	visited map[statIdentity]struct{}
+}
+
+// walk writes the entry at path, which is at virtualPath in the tree of the entries, to
+// pendingEntriesCh and, for a folder, walks its contents.
+// info is the result of os.Lstat for the entry.
+// nolint: gocognit
+func (t *traversal) walk(ctx context.Context, path, virtualPath string, info os.FileInfo, parentFolderID uint64) error {
+	linkTarget := ""
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		switch t.fs.symlinkPolicy {
+		case SymlinkSkip:
+			return nil
+
+		case SymlinkFollow:
+			targetInfo, err := os.Stat(path)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					// a dangling link has nothing to follow.
 					return nil
 				}
-			})
-		if isFSEntriesChOpened {
-			close(fsEntriesCh)
-			isFSEntriesChOpened = false
-			return errors.WithStack(<-errCh)
+				return errors.WithStack(err)
+			}
+			info = targetInfo
+
+		default:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			linkTarget = target
 		}
-		return err
-	}()
+	}
+
+	deviceID := archos.Device(info)
+	if deviceID != t.deviceID && !t.fs.crossDevice {
+		return nil
+	}
+
+	if t.visited != nil {
+		id := statIdentity{deviceID: fmt.Sprintf("%d", deviceID), inode: archos.Inode(info)}
+		if _, ok := t.visited[id]; ok {
+			return nil
+		}
+		t.visited[id] = struct{}{}
+	}
+
+	uid, gid := archos.Owner(info)
+
+	// tips for Windows support:
+	// - go/src/os/types_windows.go
+	// - https://stackoverflow.com/questions/7162164/does-windows-have-inode-numbers-like-linux
+	pe := &pendingEntry{
+		path: path,
+		fsEntry: db.FSEntry{
+			FSName:         t.fsName,
+			DeviceID:       fmt.Sprintf("%d", deviceID),
+			EntryID:        archos.Inode(info),
+			IsFolder:       info.IsDir(),
+			Path:           filepath.Dir(virtualPath),
+			Name:           filepath.Base(virtualPath),
+			ParentFolderID: parentFolderID,
+			Created:        archos.CreatedTime(info),
+			Modified:       info.ModTime(),
+			Size:           uint64(info.Size()),
+			Kind:           entryKind(info.Mode()),
+			LinkTarget:     linkTarget,
+			Mode:           archos.Mode(info),
+			UID:            uid,
+			GID:            gid,
+		},
+		done: make(chan struct{}),
+	}
+
+	if t.fs.filter != nil {
+		excluded, err := t.fs.filter.Excluded(ctx, pe.fsEntry)
+		if err != nil {
+			return err
+		}
+
+		if excluded {
+			return nil
+		}
+	}
+
+	// the extended attributes of a symbolic link cannot be read without following it.
+	if t.fs.xattrs && pe.fsEntry.Kind != db.EntryKindSymlink {
+		xattrs, err := archos.Xattrs(path)
+		if err != nil {
+			return errors.WithMessagef(err, "extended attributes of '%s'", path)
+		}
+		pe.fsEntry.Xattrs = xattrs
+	}
+
+	// only the data of regular files is hashed: reading special files may block forever.
+	if pe.fsEntry.Kind != db.EntryKindFile {
+		close(pe.done)
+	}
+
+	select {
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	case t.pendingEntriesCh <- pe:
+	}
+
+	switch pe.fsEntry.Kind {
+	case db.EntryKindFile:
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case t.hashJobsCh <- pe:
+			return nil
+		}
+
+	case db.EntryKindFolder:
+		dirEntries, err := os.ReadDir(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, dirEntry := range dirEntries {
+			childPath := filepath.Join(path, dirEntry.Name())
+
+			childInfo, err := os.Lstat(childPath)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			err = t.walk(ctx, childPath, filepath.Join(virtualPath, dirEntry.Name()), childInfo, pe.fsEntry.EntryID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// entryKind returns the kind of an entry with the specified mode.
+func entryKind(mode os.FileMode) db.EntryKind {
+	switch {
+	case mode.IsDir():
+		return db.EntryKindFolder
+	case mode.IsRegular():
+		return db.EntryKindFile
+	case mode&os.ModeSymlink != 0:
+		return db.EntryKindSymlink
+	default:
+		return db.EntryKindOther
+	}
+}
+
+// statIdentity identifies a file on a local file system.
+type statIdentity struct {
+	deviceID string
+	inode    uint64
+}
+
+// getPreviousFiles returns the files of VersionPrevious of the file system, when their hash may
+// be reused.
+func (fs *Local) getPreviousFiles(ctx context.Context, fsName db.FSName) (map[statIdentity]db.FSEntry, error) {
+	if fs.store == nil || fs.paranoid {
+		return nil, nil
+	}
+
+	fsEntries, err := fs.store.GetPreviousFileSystemEntries(ctx, fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	previousFiles := make(map[statIdentity]db.FSEntry, len(fsEntries))
+
+	for _, entry := range fsEntries {
+		if entry.IsFolder || entry.Hash == "" {
+			continue
+		}
+		previousFiles[statIdentity{deviceID: entry.DeviceID, inode: entry.EntryID}] = entry
+	}
+
+	return previousFiles, nil
+}
+
+// hashFile returns the hash of the file at path, reusing a known hash when the file has not
+// changed.
+func (fs *Local) hashFile(path string, fsEntry db.FSEntry, previousFiles map[statIdentity]db.FSEntry) (string, error) {
+	if !fs.paranoid {
+		if hash, ok := fs.hashCache.Hash(path, fsEntry); ok {
+			fs.stats.filesReused.Add(1)
+			return hash, nil
+		}
+
+		previous, ok := previousFiles[statIdentity{deviceID: fsEntry.DeviceID, inode: fsEntry.EntryID}]
+		if ok &&
+			previous.Size == fsEntry.Size &&
+			previous.Modified.Equal(fsEntry.Modified) &&
+			previous.Created.Equal(fsEntry.Created) {
+			fs.stats.filesReused.Add(1)
+			return previous.Hash, nil
+		}
+	}
 
-	return err
+	hash, n, err := hashFileData(path, fs.throttle)
+	if err != nil {
+		return "", err
+	}
+
+	fs.stats.filesHashed.Add(1)
+	fs.stats.bytesRead.Add(n)
+
+	return hash, nil
 }
 
-func hashFileData(path string) (string, error) {
+// hashFileData returns the hash of the data of the file at path and the number of bytes read.
+// The reads are slowed down by t, if not nil.
+func hashFileData(path string, t *throttle) (string, uint64, error) {
 	// nolint: gosec
 	f, err := os.Open(path)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 	defer func() { _ = f.Close() }()
 
-	return hashData(bufio.NewReader(f))
+	cr := &countingReader{r: f, throttle: t}
+
+	hash, err := hashData(bufio.NewReader(cr))
+	if err != nil {
+		return "", 0, err
+	}
+
+	return hash, cr.n, nil
+}
+
+// countingReader is an io.Reader that counts the bytes read and optionally throttles the reads.
+type countingReader struct {
+	r        io.Reader
+	n        uint64
+	throttle *throttle
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += uint64(n)
+
+	if cr.throttle != nil {
+		cr.throttle.wait(n)
+	}
+
+	return n, err
+}
+
+// throttle limits the rate of reads to bytesPerSecond, across all its users.
+type throttle struct {
+	bytesPerSecond uint64
+
+	mu   sync.Mutex
+	next time.Time // time from which the next read may proceed
+}
+
+// wait accounts for n bytes read and blocks until the reads are back within the rate limit.
+func (t *throttle) wait(n int) {
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(uint64(n) * uint64(time.Second) / t.bytesPerSecond))
+	delay := t.next.Sub(now)
+	t.mu.Unlock()
+
+	time.Sleep(delay)
 }
 
 func hashData(r io.Reader) (string, error) {
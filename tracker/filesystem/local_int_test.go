@@ -1,17 +1,25 @@
 package filesystem_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/suite"
 
+	"github.com/seborama/pcloud-sdk/tracker/archos"
 	"github.com/seborama/pcloud-sdk/tracker/db"
 	"github.com/seborama/pcloud-sdk/tracker/filesystem"
+	"github.com/seborama/pcloud-sdk/tracker/filter"
 )
 
 type LocalIntegrationTestSuite struct {
@@ -168,3 +176,505 @@ func (testsuite *LocalIntegrationTestSuite) TestLocal_Walk() {
 		testsuite.EqualValues(e.Hash, actualE.Hash)
 	}
 }
+
+func (testsuite *LocalIntegrationTestSuite) TestLocal_Walk_HashCache() {
+	root := filepath.Join(testsuite.localTestPath, "local")
+
+	err := os.MkdirAll(filepath.Join(root, "Folder1"), 0700)
+	testsuite.Require().NoError(err)
+	err = os.WriteFile(filepath.Join(root, "Folder1", "File1"), []byte("This is File1"), 0600)
+	testsuite.Require().NoError(err)
+
+	err = os.MkdirAll(filepath.Join(root, "Folder2"), 0700)
+	testsuite.Require().NoError(err)
+	err = os.WriteFile(filepath.Join(root, "Folder2", "File2"), []byte("This is File2"), 0600)
+	testsuite.Require().NoError(err)
+
+	err = os.WriteFile(filepath.Join(root, "Folder1", "File3"), []byte("This is File3"), 0600)
+	testsuite.Require().NoError(err)
+
+	cachedEntries := testsuite.walk(testsuite.localFS, root)
+
+	// a cached hash is recognisable
+	for i := range cachedEntries {
+		cachedEntries[i].Hash = "cached_hash_of_" + cachedEntries[i].Name
+	}
+
+	// File3 changes but the watcher has yet to report it dirty: it must be rehashed.
+	err = os.WriteFile(filepath.Join(root, "Folder1", "File3"), []byte("This is File3, changed"), 0600)
+	testsuite.Require().NoError(err)
+
+	// Folder2 is dirty: its files must be rehashed.
+	testsuite.localFS.UseHashCache(db.NewHashCache(cachedEntries, []string{filepath.Join(root, "Folder2")}))
+
+	hashes := map[string]string{}
+	for _, fse := range testsuite.walk(testsuite.localFS, root) {
+		if !fse.IsFolder {
+			hashes[fse.Name] = fse.Hash
+		}
+	}
+
+	testsuite.Equal(
+		map[string]string{
+			"File1": "cached_hash_of_File1",
+			"File2": "c28739a884e3742ea784f63dd52d9a4a90372235",
+			"File3": "0e2e59cada54b83e0599a6aecb3e5a58be088444",
+		},
+		hashes,
+	)
+}
+
+func (testsuite *LocalIntegrationTestSuite) TestLocal_Walk_HashReuse() {
+	root := filepath.Join(testsuite.localTestPath, "local")
+
+	err := os.MkdirAll(root, 0700)
+	testsuite.Require().NoError(err)
+	err = os.WriteFile(filepath.Join(root, "File1"), []byte("This is File1"), 0600)
+	testsuite.Require().NoError(err)
+	err = os.WriteFile(filepath.Join(root, "File2"), []byte("This is File2"), 0600)
+	testsuite.Require().NoError(err)
+
+	previousEntries := testsuite.walk(testsuite.localFS, root)
+	testsuite.Equal(filesystem.WalkStats{FilesHashed: 2, BytesRead: 26}, testsuite.localFS.Stats())
+
+	// a reused hash is recognisable
+	for i := range previousEntries {
+		previousEntries[i].Hash = "previous_hash_of_" + previousEntries[i].Name
+	}
+
+	// File2 changes: size and modification time differ
+	err = os.WriteFile(filepath.Join(root, "File2"), []byte("This is File2, changed"), 0600)
+	testsuite.Require().NoError(err)
+
+	localFS := filesystem.NewLocal(filesystem.WithHashReuse(&previousEntriesGetterStub{fsEntries: previousEntries}))
+
+	hashes := map[string]string{}
+	for _, fse := range testsuite.walk(localFS, root) {
+		if !fse.IsFolder {
+			hashes[fse.Name] = fse.Hash
+		}
+	}
+
+	testsuite.Equal(
+		map[string]string{
+			"File1": "previous_hash_of_File1",
+			"File2": "f14bc72ff935bb3979974fd332a27ad2cce6d7ce",
+		},
+		hashes,
+	)
+	testsuite.Equal(filesystem.WalkStats{FilesHashed: 1, FilesReused: 1, BytesRead: 22}, localFS.Stats())
+
+	localFS = filesystem.NewLocal(filesystem.WithHashReuse(&previousEntriesGetterStub{fsEntries: previousEntries}), filesystem.WithParanoidHashing())
+
+	hashes = map[string]string{}
+	for _, fse := range testsuite.walk(localFS, root) {
+		if !fse.IsFolder {
+			hashes[fse.Name] = fse.Hash
+		}
+	}
+
+	testsuite.Equal(
+		map[string]string{
+			"File1": "e8dfb879ddc708ea337a00e9b5580b498193bd2d",
+			"File2": "f14bc72ff935bb3979974fd332a27ad2cce6d7ce",
+		},
+		hashes,
+	)
+	testsuite.Equal(filesystem.WalkStats{FilesHashed: 2, BytesRead: 35}, localFS.Stats())
+}
+
+func (testsuite *LocalIntegrationTestSuite) TestLocal_Walk_Filter() {
+	root := filepath.Join(testsuite.localTestPath, "local")
+
+	files := map[string]string{
+		".DS_Store":                      "x",
+		"File1":                          "This is File1",
+		"File1.tmp":                      "This is File1.tmp",
+		"Large":                          strings.Repeat("x", 1_000),
+		"Folder1/.pcloudignore":          "*.log\n!keep.log\n",
+		"Folder1/File2.log":              "This is File2.log",
+		"Folder1/keep.log":               "This is keep.log",
+		"Folder1/node_modules/File3":     "This is File3",
+		"Folder1/Folder2/.DS_Store":      "x",
+		"Folder1/Folder2/File4.log":      "This is File4.log",
+		"Folder3/File5.log":              "This is File5.log",
+		"Folder3/node_modules/File6.txt": "This is File6.txt",
+	}
+
+	for name, data := range files {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0700)
+		testsuite.Require().NoError(err)
+		err = os.WriteFile(filepath.Join(root, name), []byte(data), 0600)
+		testsuite.Require().NoError(err)
+	}
+
+	f, err := filter.New(
+		db.FilterRules{
+			Patterns: []string{".DS_Store", "*.tmp", "node_modules/"},
+			MaxSize:  500,
+		},
+		root,
+		filter.WithIgnoreFiles(testsuite.localFS),
+	)
+	testsuite.Require().NoError(err)
+
+	testsuite.localFS.UseFilter(f)
+	fsEntries := testsuite.walk(testsuite.localFS, root)
+
+	paths := []string{}
+	for _, fsEntry := range fsEntries {
+		paths = append(paths, filepath.Join(fsEntry.Path, fsEntry.Name))
+	}
+
+	expected := []string{
+		root,
+		filepath.Join(root, "File1"),
+		filepath.Join(root, "Folder1"),
+		filepath.Join(root, "Folder1", ".pcloudignore"),
+		filepath.Join(root, "Folder1", "Folder2"),
+		filepath.Join(root, "Folder1", "keep.log"),
+		filepath.Join(root, "Folder3"),
+		filepath.Join(root, "Folder3", "File5.log"),
+	}
+	testsuite.Equal(expected, paths)
+}
+
+func (testsuite *LocalIntegrationTestSuite) walk(localFS *filesystem.Local, root string) []db.FSEntry {
+	fsEntriesCh := make(chan db.FSEntry)
+	errCh := make(chan error)
+	fsEntries := []db.FSEntry{}
+
+	go func() {
+		for fse := range fsEntriesCh {
+			fsEntries = append(fsEntries, fse)
+		}
+
+		errCh <- nil
+	}()
+
+	err := localFS.Walk(testsuite.ctx, "local_fs", root, fsEntriesCh, errCh)
+	testsuite.Require().NoError(err)
+
+	return fsEntries
+}
+
+type previousEntriesGetterStub struct {
+	fsEntries []db.FSEntry
+}
+
+func (s *previousEntriesGetterStub) GetPreviousFileSystemEntries(_ context.Context, _ db.FSName) ([]db.FSEntry, error) {
+	return s.fsEntries, nil
+}
+
+func (testsuite *LocalIntegrationTestSuite) TestLocal_Walk_ConcurrentHashing() {
+	root := filepath.Join(testsuite.localTestPath, "local")
+
+	for i := 0; i < 5; i++ {
+		folder := filepath.Join(root, fmt.Sprintf("Folder%d", i), fmt.Sprintf("SubFolder%d", i))
+		err := os.MkdirAll(folder, 0700)
+		testsuite.Require().NoError(err)
+
+		for j := 0; j < 10; j++ {
+			data := bytes.Repeat([]byte(fmt.Sprintf("This is File%d%d", i, j)), 1+i*j*1_000)
+			err = os.WriteFile(filepath.Join(filepath.Dir(folder), fmt.Sprintf("File%d", j)), data, 0600)
+			testsuite.Require().NoError(err)
+			err = os.WriteFile(filepath.Join(folder, fmt.Sprintf("File%d", j)), data, 0600)
+			testsuite.Require().NoError(err)
+		}
+	}
+
+	serialLocalFS := filesystem.NewLocal()
+	expected := testsuite.walk(serialLocalFS, root)
+	testsuite.Require().Len(expected, 1+5*2+5*20)
+
+	localFS := filesystem.NewLocal(filesystem.WithHashingConcurrency(8))
+	fsEntries := testsuite.walk(localFS, root)
+
+	// the order of the entries is also expected to be identical
+	if d := cmp.Diff(expected, fsEntries); d != "" {
+		testsuite.Fail(d)
+	}
+	testsuite.Equal(serialLocalFS.Stats(), localFS.Stats())
+}
+
+func (testsuite *LocalIntegrationTestSuite) TestLocal_Walk_HashingThrottle() {
+	root := filepath.Join(testsuite.localTestPath, "local")
+
+	err := os.MkdirAll(root, 0700)
+	testsuite.Require().NoError(err)
+
+	for j := 0; j < 4; j++ {
+		err = os.WriteFile(filepath.Join(root, fmt.Sprintf("File%d", j)), bytes.Repeat([]byte{'x'}, 64*1_024), 0600)
+		testsuite.Require().NoError(err)
+	}
+
+	localFS := filesystem.NewLocal(filesystem.WithHashingConcurrency(4), filesystem.WithHashingThrottle(1_024*1_024))
+
+	start := time.Now()
+	testsuite.walk(localFS, root)
+
+	// 256KiB at 1MiB/s
+	testsuite.GreaterOrEqual(time.Since(start), 200*time.Millisecond)
+	testsuite.EqualValues(256*1_024, localFS.Stats().BytesRead)
+}
+
+func (testsuite *LocalIntegrationTestSuite) TestLocal_Walk_ReceiverError() {
+	root := filepath.Join(testsuite.localTestPath, "local")
+
+	err := os.MkdirAll(root, 0700)
+	testsuite.Require().NoError(err)
+
+	for j := 0; j < 10; j++ {
+		err = os.WriteFile(filepath.Join(root, fmt.Sprintf("File%d", j)), []byte("This is a file"), 0600)
+		testsuite.Require().NoError(err)
+	}
+
+	fsEntriesCh := make(chan db.FSEntry)
+	errCh := make(chan error)
+
+	go func() {
+		<-fsEntriesCh
+		errCh <- errors.New("receiver failure")
+	}()
+
+	err = filesystem.NewLocal(filesystem.WithHashingConcurrency(4)).Walk(testsuite.ctx, "local_fs", root, fsEntriesCh, errCh)
+	testsuite.Require().EqualError(err, "receiver failure")
+
+	_, ok := <-fsEntriesCh
+	testsuite.False(ok)
+}
+
+func (testsuite *LocalIntegrationTestSuite) TestLocal_Walk_Symlinks() {
+	root := filepath.Join(testsuite.localTestPath, "local")
+	outside := filepath.Join(testsuite.localTestPath, "outside")
+
+	err := os.MkdirAll(filepath.Join(root, "Folder1"), 0700)
+	testsuite.Require().NoError(err)
+	err = os.WriteFile(filepath.Join(root, "Folder1", "File1"), []byte("This is File1"), 0600)
+	testsuite.Require().NoError(err)
+	err = os.MkdirAll(outside, 0700)
+	testsuite.Require().NoError(err)
+	err = os.WriteFile(filepath.Join(outside, "File2"), []byte("This is File2"), 0600)
+	testsuite.Require().NoError(err)
+
+	err = os.Symlink(filepath.Join("Folder1", "File1"), filepath.Join(root, "Link1"))
+	testsuite.Require().NoError(err)
+	err = os.Symlink(outside, filepath.Join(root, "Link2"))
+	testsuite.Require().NoError(err)
+	err = os.Symlink("dangling", filepath.Join(root, "Link3"))
+	testsuite.Require().NoError(err)
+	err = os.Symlink("..", filepath.Join(root, "Folder1", "Cycle"))
+	testsuite.Require().NoError(err)
+
+	type entry struct {
+		path       string
+		kind       db.EntryKind
+		linkTarget string
+		hash       string
+	}
+
+	toEntries := func(fsEntries []db.FSEntry) []entry {
+		entries := []entry{}
+		for _, fsEntry := range fsEntries {
+			relPath, err := filepath.Rel(root, filepath.Join(fsEntry.Path, fsEntry.Name))
+			testsuite.Require().NoError(err)
+			entries = append(entries, entry{path: relPath, kind: fsEntry.Kind, linkTarget: fsEntry.LinkTarget, hash: fsEntry.Hash})
+		}
+		return entries
+	}
+
+	tests := map[string]struct {
+		policy   filesystem.SymlinkPolicy
+		expected []entry
+	}{
+		"store as link": {
+			policy: filesystem.SymlinkStoreAsLink,
+			expected: []entry{
+				{path: ".", kind: db.EntryKindFolder},
+				{path: "Folder1", kind: db.EntryKindFolder},
+				{path: "Folder1/Cycle", kind: db.EntryKindSymlink, linkTarget: ".."},
+				{path: "Folder1/File1", kind: db.EntryKindFile, hash: "e8dfb879ddc708ea337a00e9b5580b498193bd2d"},
+				{path: "Link1", kind: db.EntryKindSymlink, linkTarget: "Folder1/File1"},
+				{path: "Link2", kind: db.EntryKindSymlink, linkTarget: outside},
+				{path: "Link3", kind: db.EntryKindSymlink, linkTarget: "dangling"},
+			},
+		},
+		"skip": {
+			policy: filesystem.SymlinkSkip,
+			expected: []entry{
+				{path: ".", kind: db.EntryKindFolder},
+				{path: "Folder1", kind: db.EntryKindFolder},
+				{path: "Folder1/File1", kind: db.EntryKindFile, hash: "e8dfb879ddc708ea337a00e9b5580b498193bd2d"},
+			},
+		},
+		"follow": {
+			policy: filesystem.SymlinkFollow,
+			expected: []entry{
+				{path: ".", kind: db.EntryKindFolder},
+				{path: "Folder1", kind: db.EntryKindFolder},
+				// the cycle leads back to the root, which was already walked.
+				{path: "Folder1/File1", kind: db.EntryKindFile, hash: "e8dfb879ddc708ea337a00e9b5580b498193bd2d"},
+				// Link1 leads to Folder1/File1, which was already walked.
+				{path: "Link2", kind: db.EntryKindFolder},
+				{path: "Link2/File2", kind: db.EntryKindFile, hash: "c28739a884e3742ea784f63dd52d9a4a90372235"},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		testsuite.Run(name, func() {
+			localFS := filesystem.NewLocal(filesystem.WithSymlinkPolicy(tt.policy))
+			fsEntries := testsuite.walk(localFS, root)
+
+			if d := cmp.Diff(tt.expected, toEntries(fsEntries), cmp.AllowUnexported(entry{})); d != "" {
+				testsuite.Failf("unexpected entries", "%s", d)
+			}
+		})
+	}
+}
+
+func (testsuite *LocalIntegrationTestSuite) TestLocal_Walk_SpecialFiles() {
+	root := filepath.Join(testsuite.localTestPath, "local")
+
+	err := os.MkdirAll(root, 0700)
+	testsuite.Require().NoError(err)
+	err = syscall.Mkfifo(filepath.Join(root, "Fifo"), 0600)
+	testsuite.Require().NoError(err)
+
+	// hashing the named pipe would block forever.
+	fsEntries := testsuite.walk(testsuite.localFS, root)
+
+	testsuite.Require().Len(fsEntries, 2)
+	testsuite.Equal("Fifo", fsEntries[1].Name)
+	testsuite.Equal(db.EntryKindOther, fsEntries[1].Kind)
+	testsuite.False(fsEntries[1].IsFolder)
+	testsuite.Empty(fsEntries[1].Hash)
+	testsuite.Empty(fsEntries[1].ContentHash)
+}
+
+func (testsuite *LocalIntegrationTestSuite) TestLocal_Walk_Metadata() {
+	root := filepath.Join(testsuite.localTestPath, "local")
+
+	err := os.MkdirAll(root, 0700)
+	testsuite.Require().NoError(err)
+	err = os.WriteFile(filepath.Join(root, "File1"), []byte("This is File1"), 0600)
+	testsuite.Require().NoError(err)
+	err = os.Chmod(filepath.Join(root, "File1"), 0640|os.ModeSetgid)
+	testsuite.Require().NoError(err)
+
+	xattrs := db.Xattrs{"user.pcloud.test": []byte("value")}
+	err = archos.SetXattrs(filepath.Join(root, "File1"), xattrs)
+	if got, _ := archos.Xattrs(filepath.Join(root, "File1")); err != nil || got == nil {
+		// not all file systems and platforms support extended attributes.
+		xattrs = nil
+	}
+
+	localFS := filesystem.NewLocal(filesystem.WithXattrs())
+	fsEntries := testsuite.walk(localFS, root)
+
+	testsuite.Require().Len(fsEntries, 2)
+
+	testsuite.EqualValues(0o700, fsEntries[0].Mode)
+	testsuite.EqualValues(0o2640, fsEntries[1].Mode)
+	testsuite.EqualValues(os.Getuid(), fsEntries[1].UID)
+	testsuite.EqualValues(os.Getgid(), fsEntries[1].GID)
+	testsuite.Equal(xattrs, fsEntries[1].Xattrs)
+}
+
+func (testsuite *LocalIntegrationTestSuite) TestLocal_Walk_MultipleRoots() {
+	root1 := filepath.Join(testsuite.localTestPath, "local", "disk1")
+	root2 := filepath.Join(testsuite.localTestPath, "local", "disk2")
+
+	err := os.MkdirAll(filepath.Join(root1, "Folder1"), 0700)
+	testsuite.Require().NoError(err)
+	err = os.WriteFile(filepath.Join(root1, "Folder1", "File1"), []byte("This is File1"), 0600)
+	testsuite.Require().NoError(err)
+	err = os.MkdirAll(root2, 0700)
+	testsuite.Require().NoError(err)
+	err = os.WriteFile(filepath.Join(root2, "File2"), []byte("This is File2"), 0600)
+	testsuite.Require().NoError(err)
+	err = os.WriteFile(filepath.Join(root2, filter.IgnoreFileName), []byte("*.tmp\n"), 0600)
+	testsuite.Require().NoError(err)
+
+	localFS := filesystem.NewLocal()
+	localFS.UseRoots([]db.FSRoot{
+		{Name: "media", Path: root1},
+		{Name: "photos", Path: root2},
+	})
+
+	fsEntries := testsuite.walk(localFS, db.VirtualRootPath)
+
+	paths := []string{}
+	for _, fsEntry := range fsEntries {
+		paths = append(paths, filepath.Join(fsEntry.Path, fsEntry.Name))
+	}
+
+	expected := []string{
+		"/",
+		"/media",
+		"/media/Folder1",
+		"/media/Folder1/File1",
+		"/photos",
+		"/photos/" + filter.IgnoreFileName,
+		"/photos/File2",
+	}
+	testsuite.Equal(expected, paths)
+
+	testsuite.Zero(fsEntries[0].EntryID)
+	testsuite.Zero(fsEntries[1].ParentFolderID)
+	testsuite.Zero(fsEntries[4].ParentFolderID)
+	testsuite.Equal(fsEntries[2].EntryID, fsEntries[3].ParentFolderID)
+	testsuite.Equal("e8dfb879ddc708ea337a00e9b5580b498193bd2d", fsEntries[3].Hash)
+
+	localPath, err := localFS.LocalPath("/photos/File2")
+	testsuite.Require().NoError(err)
+	testsuite.Equal(filepath.Join(root2, "File2"), localPath)
+
+	_, err = localFS.LocalPath("/videos/File3")
+	testsuite.Require().Error(err)
+
+	data, err := localFS.ReadIgnoreFile(testsuite.ctx, "/photos")
+	testsuite.Require().NoError(err)
+	testsuite.Equal("*.tmp\n", string(data))
+
+	data, err = localFS.ReadIgnoreFile(testsuite.ctx, db.VirtualRootPath)
+	testsuite.Require().NoError(err)
+	testsuite.Nil(data)
+}
+
+func (testsuite *LocalIntegrationTestSuite) TestLocal_Walk_CrossDevice() {
+	otherDevice, err := os.MkdirTemp("/dev/shm", "go_pCloud_test")
+	if err != nil {
+		testsuite.T().Skip("no other device available:", err)
+	}
+	defer func() { _ = os.RemoveAll(otherDevice) }()
+
+	root := filepath.Join(testsuite.localTestPath, "local")
+
+	err = os.MkdirAll(root, 0700)
+	testsuite.Require().NoError(err)
+	err = os.WriteFile(filepath.Join(otherDevice, "File1"), []byte("This is File1"), 0600)
+	testsuite.Require().NoError(err)
+
+	rootInfo, err := os.Stat(root)
+	testsuite.Require().NoError(err)
+	otherInfo, err := os.Stat(otherDevice)
+	testsuite.Require().NoError(err)
+	if rootInfo.Sys().(*syscall.Stat_t).Dev == otherInfo.Sys().(*syscall.Stat_t).Dev {
+		testsuite.T().Skip("no other device available")
+	}
+
+	// the followed link leads to another device, like a mount point would.
+	err = os.Symlink(otherDevice, filepath.Join(root, "Mount"))
+	testsuite.Require().NoError(err)
+
+	fsEntries := testsuite.walk(filesystem.NewLocal(filesystem.WithSymlinkPolicy(filesystem.SymlinkFollow)), root)
+	testsuite.Require().Len(fsEntries, 1)
+
+	fsEntries = testsuite.walk(filesystem.NewLocal(filesystem.WithSymlinkPolicy(filesystem.SymlinkFollow), filesystem.WithCrossDevice()), root)
+	testsuite.Require().Len(fsEntries, 3)
+	testsuite.Equal("Mount", fsEntries[1].Name)
+	testsuite.Equal("File1", fsEntries[2].Name)
+	testsuite.Equal(fmt.Sprintf("%d", otherInfo.Sys().(*syscall.Stat_t).Dev), fsEntries[2].DeviceID)
+	testsuite.NotEqual(fsEntries[0].DeviceID, fsEntries[2].DeviceID)
+}
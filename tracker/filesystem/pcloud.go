@@ -4,34 +4,82 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/seborama/pcloud-sdk/sdk"
 	"github.com/seborama/pcloud-sdk/tracker/db"
+	"github.com/seborama/pcloud-sdk/tracker/filter"
 )
 
 // pCloudSDK defines the SDK methods used to perform operations on the PCloud file system.
 type pCloudSDK interface {
 	ListFolder(ctx context.Context, folder sdk.T1PathOrFolderID, recursiveOpt, showDeletedOpt, noFilesOpt, noSharesOpt bool, opts ...sdk.ClientOption) (*sdk.FSList, error)
+	Diff(ctx context.Context, diffID uint64, after time.Time, last uint64, block bool, limit uint64, opts ...sdk.ClientOption) (*sdk.DiffResult, error)
+	LastDiffID(ctx context.Context, opts ...sdk.ClientOption) (uint64, error)
+	ChecksumFile(ctx context.Context, file sdk.T3PathOrFileID, opts ...sdk.ClientOption) (*sdk.FileChecksum, error)
+	FileOpen(ctx context.Context, flags uint64, file sdk.T4PathOrFileIDOrFolderIDName, opts ...sdk.ClientOption) (*sdk.File, error)
+	FileRead(ctx context.Context, fd, count uint64, opts ...sdk.ClientOption) ([]byte, error)
+	FileClose(ctx context.Context, fd uint64, opts ...sdk.ClientOption) error
 }
 
+// contentHashStore defines the store operations used by PCloud to cache the content hashes of
+// the files.
+type contentHashStore interface {
+	GetContentHashes(ctx context.Context, fsName db.FSName) (map[uint64]db.ContentHash, error)
+	AddContentHashes(ctx context.Context, fsName db.FSName, contentHashes []db.ContentHash) error
+}
+
+const (
+	// diffLimit is the maximum number of diff events requested from pCloud at once.
+	diffLimit = 10_000
+
+	// ignoreFileReadSize is the amount of data of an ignore file read from pCloud at once.
+	ignoreFileReadSize = 64 * 1_024
+)
+
 // PCloud is a file system abstraction for the PCloud file system.
 type PCloud struct {
-	sdk pCloudSDK
+	sdk    pCloudSDK
+	store  contentHashStore
+	filter *filter.Filter
+
+	// ignoreFileIDs holds the file ID of the ignore file of the folders seen during a Walk, by
+	// path. It is 0 when a folder has none.
+	ignoreFileIDs map[string]uint64
+}
+
+// PCloudOption is a Go functional parameter signature used to configure PCloud.
+type PCloudOption func(*PCloud)
+
+// WithContentHashCache caches the content hashes of the files in store, so that they are only
+// obtained from pCloud when the files change. Without it, the content hash of every file is
+// obtained from pCloud on each Walk.
+func WithContentHashCache(store contentHashStore) PCloudOption {
+	return func(fs *PCloud) {
+		fs.store = store
+	}
 }
 
 // NewPCloud creates a new initialised PCloud structure.
-func NewPCloud(sdk pCloudSDK) *PCloud {
-	return &PCloud{
+func NewPCloud(sdk pCloudSDK, opts ...PCloudOption) *PCloud {
+	fs := &PCloud{
 		sdk: sdk,
 	}
+
+	for _, opt := range opts {
+		opt(fs)
+	}
+
+	return fs
 }
 
 // Walk traverses the file system entries and writes each entry to fsEntriesCh.
 // It must check for an error in errCh (which indicates the receiver of fsEntriesCh encountered
 // a problem and terminate if one is present.
 // Walk is the PRODUCER on fsEntriesCh and IS RESPONSIBLE FOR CLOSING IT!!
+// The content hash of the files is obtained with ChecksumFile (see WithContentHashCache).
 // nolint: gocognit
 func (fs *PCloud) Walk(ctx context.Context, fsName db.FSName, path string, fsEntriesCh chan<- db.FSEntry, errCh <-chan error) error {
 	lf, err := fs.sdk.ListFolder(ctx, sdk.T1FolderByPath(path), true, false, false, false)
@@ -39,26 +87,34 @@ func (fs *PCloud) Walk(ctx context.Context, fsName db.FSName, path string, fsEnt
 		return err
 	}
 
+	contentHashes, err := fs.loadContentHashes(ctx, fsName)
+	if err != nil {
+		return err
+	}
+
+	fs.ignoreFileIDs = map[string]uint64{}
+	defer func() { fs.ignoreFileIDs = nil }()
+
 	err = func() error {
 		var entries stack
 		entries.add(lf.Metadata)
 
+		// fail terminates the receiver when the walk fails.
+		fail := func(err error) error {
+			close(fsEntriesCh)
+			<-errCh
+			return err
+		}
+
 		for entries.hasNext() {
 			entry := entries.pop()
 
 			hash := ""
 			entryID := entry.FileID
+			kind := db.EntryKindFile
 			if entry.IsFolder {
-				for _, e := range entry.Contents {
-					if e.IsDeleted {
-						continue
-					}
-
-					e.Path = filepath.Join(entry.Path, entry.Name)
-					entries.add(e)
-				}
-
 				entryID = entry.FolderID
+				kind = db.EntryKindFolder
 			} else {
 				hash = fmt.Sprintf("%d", entry.Hash)
 			}
@@ -74,6 +130,42 @@ func (fs *PCloud) Walk(ctx context.Context, fsName db.FSName, path string, fsEnt
 				Modified:       entry.Modified.Time,
 				Size:           entry.Size,
 				Hash:           hash,
+				Kind:           kind,
+			}
+
+			if fs.filter != nil {
+				excluded, err := fs.filter.Excluded(ctx, fsEntry)
+				if err != nil {
+					return fail(err)
+				}
+
+				if excluded {
+					// the contents of an excluded folder are left out too.
+					continue
+				}
+			}
+
+			if entry.IsFolder {
+				folderPath := filepath.Join(entry.Path, entry.Name)
+				fs.ignoreFileIDs[folderPath] = 0
+
+				for _, e := range entry.Contents {
+					if e.IsDeleted {
+						continue
+					}
+
+					if !e.IsFolder && e.Name == filter.IgnoreFileName {
+						fs.ignoreFileIDs[folderPath] = e.FileID
+					}
+
+					e.Path = folderPath
+					entries.add(e)
+				}
+			}
+
+			err = contentHashes.set(ctx, &fsEntry)
+			if err != nil {
+				return fail(err)
 			}
 
 			select {
@@ -87,8 +179,250 @@ func (fs *PCloud) Walk(ctx context.Context, fsName db.FSName, path string, fsEnt
 
 		return errors.WithStack(<-errCh)
 	}()
+	if err != nil {
+		return err
+	}
+
+	return contentHashes.save(ctx)
+}
+
+// UseFilter sets the filter of the entries that Walk leaves out. The contents of an excluded
+// folder are left out too. A nil filter means all entries are walked.
+func (fs *PCloud) UseFilter(f *filter.Filter) {
+	fs.filter = f
+}
+
+// ReadIgnoreFile returns the contents of the ignore file of the folder at path dir, or nil if
+// there is none.
+// During a Walk, the ignore files are known from the folder listing. Otherwise, they are looked
+// up by path.
+func (fs *PCloud) ReadIgnoreFile(ctx context.Context, dir string) ([]byte, error) {
+	file := sdk.T4FileByPath(filepath.Join(dir, filter.IgnoreFileName))
+
+	if fileID, ok := fs.ignoreFileIDs[dir]; ok {
+		if fileID == 0 {
+			return nil, nil
+		}
+		file = sdk.T4FileByID(fileID)
+	}
+
+	f, err := fs.sdk.FileOpen(ctx, 0, file)
+	if err != nil {
+		if sdk.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.WithMessagef(err, "opening the ignore file of '%s'", dir)
+	}
+	defer func() { _ = fs.sdk.FileClose(ctx, f.FD) }()
+
+	data := []byte{}
+
+	for {
+		chunk, err := fs.sdk.FileRead(ctx, f.FD, ignoreFileReadSize)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "reading the ignore file of '%s'", dir)
+		}
+
+		data = append(data, chunk...)
+
+		// pCloud returns fewer bytes than requested at the end of the file.
+		if len(chunk) < ignoreFileReadSize {
+			return data, nil
+		}
+	}
+}
+
+// Cursor returns the current position of the file system in its stream of changes: that is the
+// diffid of the last event of the pCloud account.
+func (fs *PCloud) Cursor(ctx context.Context) (uint64, error) {
+	return fs.sdk.LastDiffID(ctx)
+}
+
+// Changes lists the changes made to the pCloud file system since cursor, which is a diffid.
+// Events that are not related to files and folders are ignored. A reset event means the
+// state of the file system must be re-downloaded entirely: Reset is set on the result and
+// Changes returns immediately.
+func (fs *PCloud) Changes(ctx context.Context, fsName db.FSName, cursor uint64) (*db.FSChanges, error) {
+	changes := &db.FSChanges{
+		Cursor: cursor,
+	}
+
+	for {
+		dr, err := fs.sdk.Diff(ctx, changes.Cursor, time.Time{}, 0, false, diffLimit)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range dr.Entries {
+			if e.Event == sdk.Reset {
+				changes.Reset = true
+				return changes, nil
+			}
+
+			// as per the pCloud documentation, events that are not understood are ignored.
+			if changeType, ok := fsChangeTypes[e.Event]; ok {
+				changes.Changes = append(changes.Changes, toFSChange(fsName, changeType, e.Metadata))
+			}
+
+			changes.Cursor = e.DiffID
+		}
+
+		if dr.DiffID > changes.Cursor {
+			changes.Cursor = dr.DiffID
+		}
+
+		if len(dr.Entries) < diffLimit {
+			break
+		}
+	}
+
+	contentHashes, err := fs.loadContentHashes(ctx, fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range changes.Changes {
+		if changes.Changes[i].Type == db.FSChangeTypeDeleted {
+			continue
+		}
+
+		err = contentHashes.set(ctx, &changes.Changes[i].Entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = contentHashes.save(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+var fsChangeTypes = map[sdk.Event]db.FSChangeType{
+	sdk.CreateFolder: db.FSChangeTypeCreated,
+	sdk.ModifyFolder: db.FSChangeTypeModified,
+	sdk.DeleteFolder: db.FSChangeTypeDeleted,
+	sdk.CreateFile:   db.FSChangeTypeCreated,
+	sdk.ModifyFile:   db.FSChangeTypeModified,
+	sdk.DeleteFile:   db.FSChangeTypeDeleted,
+}
+
+func toFSChange(fsName db.FSName, changeType db.FSChangeType, m sdk.Metadata) db.FSChange {
+	fsEntry := db.FSEntry{
+		FSName:         fsName,
+		EntryID:        m.FileID,
+		IsFolder:       m.IsFolder,
+		Name:           m.Name,
+		ParentFolderID: m.ParentFolderID,
+		Size:           m.Size,
+		Kind:           db.EntryKindFile,
+	}
+
+	if m.IsFolder {
+		fsEntry.EntryID = m.FolderID
+		fsEntry.Kind = db.EntryKindFolder
+	} else {
+		fsEntry.Hash = fmt.Sprintf("%d", m.Hash)
+	}
+
+	if m.Created != nil {
+		fsEntry.Created = m.Created.Time
+	}
+
+	if m.Modified != nil {
+		fsEntry.Modified = m.Modified.Time
+	}
+
+	return db.FSChange{
+		Type:            changeType,
+		Entry:           fsEntry,
+		ReplacedEntryID: m.DeletedFileID,
+	}
+}
+
+// contentHashes obtains the content hashes of the files of a file system, from the cache when
+// they have not changed.
+type contentHashes struct {
+	fs      *PCloud
+	fsName  db.FSName
+	cached  map[uint64]db.ContentHash
+	fetched []db.ContentHash
+}
+
+func (fs *PCloud) loadContentHashes(ctx context.Context, fsName db.FSName) (*contentHashes, error) {
+	ch := &contentHashes{
+		fs:     fs,
+		fsName: fsName,
+		cached: map[uint64]db.ContentHash{},
+	}
+
+	if fs.store == nil {
+		return ch, nil
+	}
+
+	cached, err := fs.store.GetContentHashes(ctx, fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	ch.cached = cached
+
+	return ch, nil
+}
+
+// set sets the content hash of the file entry. It has no effect on folders.
+func (ch *contentHashes) set(ctx context.Context, fsEntry *db.FSEntry) error {
+	if fsEntry.IsFolder {
+		return nil
+	}
+
+	if c, ok := ch.cached[fsEntry.EntryID]; ok && c.Hash == fsEntry.Hash {
+		fsEntry.ContentHashAlgorithm = c.Algorithm
+		fsEntry.ContentHash = c.Value
+		return nil
+	}
+
+	fc, err := ch.fs.sdk.ChecksumFile(ctx, sdk.T3FileByID(fsEntry.EntryID))
+	if err != nil {
+		return errors.WithMessagef(err, "fileID: %d", fsEntry.EntryID)
+	}
+
+	// SHA1 is preferred because it is available in all pCloud regions and matches the hash of
+	// the local file system.
+	c := db.ContentHash{
+		EntryID: fsEntry.EntryID,
+		// the file may have changed since it was listed: the checksum is that of its
+		// current state.
+		Hash: fmt.Sprintf("%d", fc.Metadata.Hash),
+	}
+
+	switch {
+	case fc.SHA1 != "":
+		c.Algorithm, c.Value = db.HashAlgorithmSHA1, fc.SHA1
+	case fc.SHA256 != "":
+		c.Algorithm, c.Value = db.HashAlgorithmSHA256, fc.SHA256
+	default:
+		return errors.Errorf("no SHA1 or SHA256 checksum for fileID: %d", fsEntry.EntryID)
+	}
+
+	fsEntry.ContentHashAlgorithm = c.Algorithm
+	fsEntry.ContentHash = c.Value
+
+	ch.cached[c.EntryID] = c
+	ch.fetched = append(ch.fetched, c)
+
+	return nil
+}
+
+// save records the content hashes that were obtained from pCloud in the cache.
+func (ch *contentHashes) save(ctx context.Context) error {
+	if ch.fs.store == nil || len(ch.fetched) == 0 {
+		return nil
+	}
 
-	return err
+	return ch.fs.store.AddContentHashes(ctx, ch.fsName, ch.fetched)
 }
 
 type stack struct {
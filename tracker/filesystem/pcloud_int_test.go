@@ -1,7 +1,10 @@
 package filesystem_test
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"net/url"
 	"os"
 	"sort"
 	"testing"
@@ -15,6 +18,7 @@ import (
 	"github.com/seborama/pcloud-sdk/sdk"
 	"github.com/seborama/pcloud-sdk/tracker/db"
 	"github.com/seborama/pcloud-sdk/tracker/filesystem"
+	"github.com/seborama/pcloud-sdk/tracker/filter"
 )
 
 type PCloudIntegrationTestSuite struct {
@@ -79,6 +83,167 @@ func (testsuite *PCloudIntegrationTestSuite) TestPCloud_Walk() {
 		Return(lf, nil).
 		Once()
 
+	testsuite.onChecksumFile(20002, 9876543210100020002, "2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a020002")
+	testsuite.onChecksumFile(1000003, 9876543210101000003, "2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a000003")
+
+	fsEntries := testsuite.walk(testsuite.pcloudFS)
+
+	expected := fsEntrySample1(time1, time4, time5, time6, time7)
+
+	sortedEntries := func(elements []db.FSEntry) func(i, j int) bool {
+		return func(i, j int) bool { return elements[i].EntryID < elements[j].EntryID }
+	}
+	sort.Slice(expected, sortedEntries(expected))
+	sort.Slice(fsEntries, sortedEntries(fsEntries))
+	if d := cmp.Diff(expected, fsEntries, cmpopts.IgnoreUnexported()); d != "" {
+		testsuite.Fail(d)
+	}
+}
+
+func (testsuite *PCloudIntegrationTestSuite) TestPCloud_Walk_ContentHashCache() {
+	time1 := time.Now().Add(-24 * time.Hour)
+	time2 := time.Now().Add(-23 * time.Hour)
+	time3 := time.Now().Add(-22 * time.Hour)
+	time4 := time.Now().Add(-21 * time.Hour)
+	time5 := time.Now().Add(-20 * time.Hour)
+	time6 := time.Now().Add(-19 * time.Hour)
+	time7 := time.Now().Add(-18 * time.Hour)
+
+	lf := pCloudFolderTreeSample1(time1, time2, time3, time4, time5, time6, time7)
+
+	testsuite.pCloudClient.
+		On("ListFolder", testsuite.ctx, mock.AnythingOfType("sdk.T1PathOrFolderID"), true, false, false, false, []sdk.ClientOption(nil)).
+		Return(lf, nil).
+		Once()
+
+	store := &contentHashStoreStub{
+		contentHashes: map[uint64]db.ContentHash{
+			// unchanged file
+			20002: {
+				EntryID:   20002,
+				Hash:      "9876543210100020002",
+				Algorithm: db.HashAlgorithmSHA1,
+				Value:     "2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a020002",
+			},
+			// changed file
+			1000003: {
+				EntryID:   1000003,
+				Hash:      "1111111111111111111",
+				Algorithm: db.HashAlgorithmSHA1,
+				Value:     "1111111111111111111111111111111111111111",
+			},
+		},
+	}
+
+	testsuite.onChecksumFile(1000003, 9876543210101000003, "2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a000003")
+
+	fsEntries := testsuite.walk(filesystem.NewPCloud(testsuite.pCloudClient, filesystem.WithContentHashCache(store)))
+
+	expected := fsEntrySample1(time1, time4, time5, time6, time7)
+
+	sortedEntries := func(elements []db.FSEntry) func(i, j int) bool {
+		return func(i, j int) bool { return elements[i].EntryID < elements[j].EntryID }
+	}
+	sort.Slice(expected, sortedEntries(expected))
+	sort.Slice(fsEntries, sortedEntries(fsEntries))
+	if d := cmp.Diff(expected, fsEntries, cmpopts.IgnoreUnexported()); d != "" {
+		testsuite.Fail(d)
+	}
+
+	expectedAdded := []db.ContentHash{
+		{
+			EntryID:   1000003,
+			Hash:      "9876543210101000003",
+			Algorithm: db.HashAlgorithmSHA1,
+			Value:     "2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a000003",
+		},
+	}
+	testsuite.Equal(expectedAdded, store.added)
+}
+
+func (testsuite *PCloudIntegrationTestSuite) TestPCloud_Walk_Filter() {
+	time1 := time.Now().Add(-24 * time.Hour)
+	time2 := time.Now().Add(-23 * time.Hour)
+	time3 := time.Now().Add(-22 * time.Hour)
+	time4 := time.Now().Add(-21 * time.Hour)
+	time5 := time.Now().Add(-20 * time.Hour)
+	time6 := time.Now().Add(-19 * time.Hour)
+	time7 := time.Now().Add(-18 * time.Hour)
+
+	lf := pCloudFolderTreeSample1(time1, time2, time3, time4, time5, time6, time7)
+	lf.Metadata.Contents = append(lf.Metadata.Contents, &sdk.Metadata{
+		Name:           ".pcloudignore",
+		Created:        &sdk.APITime{Time: time7},
+		Modified:       &sdk.APITime{Time: time7},
+		ParentFolderID: 0,
+		FileID:         90001,
+		Hash:           90001,
+		Size:           8,
+	})
+
+	testsuite.pCloudClient.
+		On("ListFolder", testsuite.ctx, mock.AnythingOfType("sdk.T1PathOrFolderID"), true, false, false, false, []sdk.ClientOption(nil)).
+		Return(lf, nil).
+		Once()
+
+	testsuite.pCloudClient.
+		On("FileOpen", testsuite.ctx, uint64(0), mock.MatchedBy(func(file sdk.T4PathOrFileIDOrFolderIDName) bool {
+			q := url.Values{}
+			file(q)
+			return q.Get("fileid") == "90001"
+		}), []sdk.ClientOption(nil)).
+		Return(&sdk.File{FD: 7, FileID: 90001}, nil).
+		Once().
+		On("FileRead", testsuite.ctx, uint64(7), uint64(64*1_024), []sdk.ClientOption(nil)).
+		Return([]byte("File000\n"), nil).
+		Once().
+		On("FileClose", testsuite.ctx, uint64(7), []sdk.ClientOption(nil)).
+		Return(nil).
+		Once()
+
+	testsuite.onChecksumFile(90001, 90001, "2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a090001")
+
+	f, err := filter.New(db.FilterRules{Patterns: []string{"/Folder2/"}}, "/", filter.WithIgnoreFiles(testsuite.pcloudFS))
+	testsuite.Require().NoError(err)
+
+	testsuite.pcloudFS.UseFilter(f)
+	fsEntries := testsuite.walk(testsuite.pcloudFS)
+
+	entryIDs := []uint64{}
+	for _, fsEntry := range fsEntries {
+		entryIDs = append(entryIDs, fsEntry.EntryID)
+	}
+
+	// Folder2 (20001) and its contents are excluded by the filter rules and File000 (1000003)
+	// by the ignore file.
+	testsuite.ElementsMatch([]uint64{0, 30001, 90001}, entryIDs)
+}
+
+func (testsuite *PCloudIntegrationTestSuite) TestPCloud_ReadIgnoreFile() {
+	testsuite.pCloudClient.
+		On("FileOpen", testsuite.ctx, uint64(0), mock.MatchedBy(func(file sdk.T4PathOrFileIDOrFolderIDName) bool {
+			q := url.Values{}
+			file(q)
+			return q.Get("path") == "/Folder2/.pcloudignore"
+		}), []sdk.ClientOption(nil)).
+		Return(&sdk.File{FD: 7, FileID: 90001}, nil).
+		Once().
+		On("FileRead", testsuite.ctx, uint64(7), uint64(64*1_024), []sdk.ClientOption(nil)).
+		Return(bytes.Repeat([]byte("x"), 64*1_024), nil).
+		Once().
+		On("FileRead", testsuite.ctx, uint64(7), uint64(64*1_024), []sdk.ClientOption(nil)).
+		Return([]byte("y"), nil).
+		Once().
+		On("FileClose", testsuite.ctx, uint64(7), []sdk.ClientOption(nil)).
+		Return(nil).
+		Once()
+
+	data, err := testsuite.pcloudFS.ReadIgnoreFile(testsuite.ctx, "/Folder2")
+	testsuite.Require().NoError(err)
+	testsuite.Len(data, 64*1_024+1)
+}
+
+func (testsuite *PCloudIntegrationTestSuite) walk(pcloudFS *filesystem.PCloud) []db.FSEntry {
 	fsEntriesCh := make(chan db.FSEntry)
 	errCh := make(chan error)
 	fsEntries := []db.FSEntry{}
@@ -91,21 +256,181 @@ func (testsuite *PCloudIntegrationTestSuite) TestPCloud_Walk() {
 		errCh <- nil
 	}()
 
-	err := testsuite.pcloudFS.Walk(testsuite.ctx, "pcloud_fs", "/", fsEntriesCh, errCh)
+	err := pcloudFS.Walk(testsuite.ctx, "pcloud_fs", "/", fsEntriesCh, errCh)
 	testsuite.Require().NoError(err)
 
-	expected := fsEntrySample1(time1, time4, time5, time6, time7)
+	return fsEntries
+}
 
-	sortedEntries := func(elements []db.FSEntry) func(i, j int) bool {
-		return func(i, j int) bool { return elements[i].EntryID < elements[j].EntryID }
+func (testsuite *PCloudIntegrationTestSuite) onChecksumFile(fileID, hash uint64, sha1 string) {
+	testsuite.pCloudClient.
+		On("ChecksumFile", testsuite.ctx, mock.MatchedBy(func(file sdk.T3PathOrFileID) bool {
+			q := url.Values{}
+			file(q)
+			return q.Get("fileid") == fmt.Sprintf("%d", fileID)
+		}), []sdk.ClientOption(nil)).
+		Return(&sdk.FileChecksum{
+			SHA1: sha1,
+			Metadata: sdk.Metadata{
+				FileID: fileID,
+				Hash:   hash,
+			},
+		}, nil).
+		Once()
+}
+
+func (testsuite *PCloudIntegrationTestSuite) TestPCloud_Changes() {
+	time1 := time.Now().Add(-24 * time.Hour)
+	time2 := time.Now().Add(-23 * time.Hour)
+
+	dr := &sdk.DiffResult{
+		DiffID: 105,
+		Entries: []sdk.Entry{
+			{
+				Event:  sdk.CreateFolder,
+				DiffID: 101,
+				Metadata: sdk.Metadata{
+					Name:           "Folder4",
+					Created:        &sdk.APITime{Time: time1},
+					Modified:       &sdk.APITime{Time: time1},
+					IsFolder:       true,
+					ParentFolderID: 0,
+					FolderID:       40001,
+				},
+			},
+			{
+				Event:    sdk.ModifyUserInfo,
+				DiffID:   102,
+				Metadata: sdk.Metadata{},
+			},
+			{
+				Event:  sdk.ModifyFile,
+				DiffID: 103,
+				Metadata: sdk.Metadata{
+					Name:           "File2",
+					Created:        &sdk.APITime{Time: time1},
+					Modified:       &sdk.APITime{Time: time2},
+					ParentFolderID: 40001,
+					FileID:         20002,
+					DeletedFileID:  20003,
+					Hash:           1234,
+					Size:           987,
+				},
+			},
+			{
+				Event:  sdk.DeleteFolder,
+				DiffID: 104,
+				Metadata: sdk.Metadata{
+					Name:           "Folder3",
+					Created:        &sdk.APITime{Time: time1},
+					Modified:       &sdk.APITime{Time: time1},
+					IsFolder:       true,
+					IsDeleted:      true,
+					ParentFolderID: 0,
+					FolderID:       30001,
+				},
+			},
+		},
 	}
-	sort.Slice(expected, sortedEntries(expected))
-	sort.Slice(fsEntries, sortedEntries(fsEntries))
-	if d := cmp.Diff(expected, fsEntries, cmpopts.IgnoreUnexported()); d != "" {
+
+	testsuite.pCloudClient.
+		On("Diff", testsuite.ctx, uint64(100), time.Time{}, uint64(0), false, uint64(10_000), []sdk.ClientOption(nil)).
+		Return(dr, nil).
+		Once()
+
+	testsuite.onChecksumFile(20002, 1234, "2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a020002")
+
+	changes, err := testsuite.pcloudFS.Changes(testsuite.ctx, "pcloud_fs", 100)
+	testsuite.Require().NoError(err)
+
+	expected := &db.FSChanges{
+		Changes: []db.FSChange{
+			{
+				Type: db.FSChangeTypeCreated,
+				Entry: db.FSEntry{
+					FSName:         "pcloud_fs",
+					EntryID:        40001,
+					IsFolder:       true,
+					Kind:           db.EntryKindFolder,
+					Name:           "Folder4",
+					ParentFolderID: 0,
+					Created:        time1,
+					Modified:       time1,
+				},
+			},
+			{
+				Type: db.FSChangeTypeModified,
+				Entry: db.FSEntry{
+					FSName:         "pcloud_fs",
+					EntryID:        20002,
+					IsFolder:       false,
+					Kind:           db.EntryKindFile,
+					Name:           "File2",
+					ParentFolderID: 40001,
+					Created:        time1,
+					Modified:       time2,
+					Size:           987,
+					Hash:           "1234",
+
+					ContentHashAlgorithm: db.HashAlgorithmSHA1,
+					ContentHash:          "2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a020002",
+				},
+				ReplacedEntryID: 20003,
+			},
+			{
+				Type: db.FSChangeTypeDeleted,
+				Entry: db.FSEntry{
+					FSName:         "pcloud_fs",
+					EntryID:        30001,
+					IsFolder:       true,
+					Kind:           db.EntryKindFolder,
+					Name:           "Folder3",
+					ParentFolderID: 0,
+					Created:        time1,
+					Modified:       time1,
+				},
+			},
+		},
+		Cursor: 105,
+		Reset:  false,
+	}
+
+	if d := cmp.Diff(expected, changes); d != "" {
 		testsuite.Fail(d)
 	}
 }
 
+func (testsuite *PCloudIntegrationTestSuite) TestPCloud_Changes_Reset() {
+	dr := &sdk.DiffResult{
+		DiffID: 102,
+		Entries: []sdk.Entry{
+			{
+				Event:  sdk.Reset,
+				DiffID: 101,
+			},
+			{
+				Event:  sdk.CreateFolder,
+				DiffID: 102,
+				Metadata: sdk.Metadata{
+					Name:     "Folder4",
+					IsFolder: true,
+					FolderID: 40001,
+				},
+			},
+		},
+	}
+
+	testsuite.pCloudClient.
+		On("Diff", testsuite.ctx, uint64(100), time.Time{}, uint64(0), false, uint64(10_000), []sdk.ClientOption(nil)).
+		Return(dr, nil).
+		Once()
+
+	changes, err := testsuite.pcloudFS.Changes(testsuite.ctx, "pcloud_fs", 100)
+	testsuite.Require().NoError(err)
+	testsuite.True(changes.Reset)
+	testsuite.Empty(changes.Changes)
+}
+
 // /
 // ├── Folder1 (deleted)
 // │   ├── File1 (deleted)
@@ -270,6 +595,20 @@ func pCloudFolderTreeSample1(time1, time2, time3, time4, time5, time6, time7 tim
 	}
 }
 
+type contentHashStoreStub struct {
+	contentHashes map[uint64]db.ContentHash
+	added         []db.ContentHash
+}
+
+func (s *contentHashStoreStub) GetContentHashes(_ context.Context, _ db.FSName) (map[uint64]db.ContentHash, error) {
+	return s.contentHashes, nil
+}
+
+func (s *contentHashStoreStub) AddContentHashes(_ context.Context, _ db.FSName, contentHashes []db.ContentHash) error {
+	s.added = append(s.added, contentHashes...)
+	return nil
+}
+
 type pCloudClientMock struct {
 	mock.Mock
 }
@@ -279,6 +618,36 @@ func (m *pCloudClientMock) ListFolder(ctx context.Context, folder sdk.T1PathOrFo
 	return args.Get(0).(*sdk.FSList), args.Error(1)
 }
 
+func (m *pCloudClientMock) Diff(ctx context.Context, diffID uint64, after time.Time, last uint64, block bool, limit uint64, opts ...sdk.ClientOption) (*sdk.DiffResult, error) {
+	args := m.Called(ctx, diffID, after, last, block, limit, opts)
+	return args.Get(0).(*sdk.DiffResult), args.Error(1)
+}
+
+func (m *pCloudClientMock) ChecksumFile(ctx context.Context, file sdk.T3PathOrFileID, opts ...sdk.ClientOption) (*sdk.FileChecksum, error) {
+	args := m.Called(ctx, file, opts)
+	return args.Get(0).(*sdk.FileChecksum), args.Error(1)
+}
+
+func (m *pCloudClientMock) FileOpen(ctx context.Context, flags uint64, file sdk.T4PathOrFileIDOrFolderIDName, opts ...sdk.ClientOption) (*sdk.File, error) {
+	args := m.Called(ctx, flags, file, opts)
+	return args.Get(0).(*sdk.File), args.Error(1)
+}
+
+func (m *pCloudClientMock) FileRead(ctx context.Context, fd, count uint64, opts ...sdk.ClientOption) ([]byte, error) {
+	args := m.Called(ctx, fd, count, opts)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *pCloudClientMock) FileClose(ctx context.Context, fd uint64, opts ...sdk.ClientOption) error {
+	args := m.Called(ctx, fd, opts)
+	return args.Error(0)
+}
+
+func (m *pCloudClientMock) LastDiffID(ctx context.Context, opts ...sdk.ClientOption) (uint64, error) {
+	args := m.Called(ctx, opts)
+	return args.Get(0).(uint64), args.Error(1)
+}
+
 // fsEntrySample1 counterpart to folderTreeSample1().
 func fsEntrySample1(time1, time4, time5, time6, time7 time.Time) []db.FSEntry {
 	return []db.FSEntry{
@@ -286,6 +655,7 @@ func fsEntrySample1(time1, time4, time5, time6, time7 time.Time) []db.FSEntry {
 			FSName:         "pcloud_fs",
 			EntryID:        0,
 			IsFolder:       true,
+			Kind:           db.EntryKindFolder,
 			Path:           "/",
 			Name:           "/",
 			ParentFolderID: 0,
@@ -296,6 +666,7 @@ func fsEntrySample1(time1, time4, time5, time6, time7 time.Time) []db.FSEntry {
 			FSName:         "pcloud_fs",
 			EntryID:        20001,
 			IsFolder:       true,
+			Kind:           db.EntryKindFolder,
 			Path:           "/",
 			Name:           "Folder2",
 			ParentFolderID: 0,
@@ -306,6 +677,7 @@ func fsEntrySample1(time1, time4, time5, time6, time7 time.Time) []db.FSEntry {
 			FSName:         "pcloud_fs",
 			EntryID:        20002,
 			IsFolder:       false,
+			Kind:           db.EntryKindFile,
 			Path:           "/Folder2",
 			Name:           "File2",
 			ParentFolderID: 20001,
@@ -313,11 +685,15 @@ func fsEntrySample1(time1, time4, time5, time6, time7 time.Time) []db.FSEntry {
 			Modified:       time5,
 			Size:           789,
 			Hash:           "9876543210100020002",
+
+			ContentHashAlgorithm: db.HashAlgorithmSHA1,
+			ContentHash:          "2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a020002",
 		},
 		{
 			FSName:         "pcloud_fs",
 			EntryID:        30001,
 			IsFolder:       true,
+			Kind:           db.EntryKindFolder,
 			Path:           "/",
 			Name:           "Folder3",
 			ParentFolderID: 0,
@@ -328,6 +704,7 @@ func fsEntrySample1(time1, time4, time5, time6, time7 time.Time) []db.FSEntry {
 			FSName:         "pcloud_fs",
 			EntryID:        1000003,
 			IsFolder:       false,
+			Kind:           db.EntryKindFile,
 			Path:           "/",
 			Name:           "File000",
 			ParentFolderID: 0,
@@ -335,6 +712,9 @@ func fsEntrySample1(time1, time4, time5, time6, time7 time.Time) []db.FSEntry {
 			Modified:       time7,
 			Size:           456,
 			Hash:           "9876543210101000003",
+
+			ContentHashAlgorithm: db.HashAlgorithmSHA1,
+			ContentHash:          "2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a000003",
 		},
 	}
 }
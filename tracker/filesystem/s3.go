@@ -0,0 +1,236 @@
+package filesystem
+
+import (
+	"context"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/errors"
+
+	"github.com/seborama/pcloud-sdk/tracker/db"
+)
+
+// s3Client defines the S3 client methods used to walk an S3 bucket.
+type s3Client interface {
+	ListObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo
+}
+
+// S3ContentHashTag is the tag of the objects that holds the SHA256 of their contents, in hex.
+// S3 does not offer a hash of the whole contents of the objects uploaded in multiple parts, so it
+// is set by the sync S3 driver once an object is uploaded.
+const S3ContentHashTag = "sha256"
+
+// S3 is a file system abstraction for a bucket of an S3-compatible object storage.
+// S3 has no folders: the prefixes of the object keys, delimited by "/", stand for the folders.
+// The entry IDs are derived from the object keys: a renamed object is a different entry.
+type S3 struct {
+	client s3Client
+	bucket string
+}
+
+// NewS3 creates a new initialised S3 structure for the bucket.
+func NewS3(client s3Client, bucket string) *S3 {
+	return &S3{
+		client: client,
+		bucket: bucket,
+	}
+}
+
+// Walk traverses the file system entries and writes each entry to fsEntriesCh.
+// It must check for an error in errCh (which indicates the receiver of fsEntriesCh encountered
+// a problem and terminate if one is present.
+// Walk is the PRODUCER on fsEntriesCh and IS RESPONSIBLE FOR CLOSING IT!!
+// The hash of the objects is their ETag. Their content hash is the SHA256 held in their
+// S3ContentHashTag tag, when the object storage lists the tags of the objects, as MinIO does.
+// The folders are reported before their contents.
+func (fs *S3) Walk(ctx context.Context, fsName db.FSName, path string, fsEntriesCh chan<- db.FSEntry, errCh <-chan error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rootKey := s3FolderKey(path)
+
+	objectsCh := fs.client.ListObjects(ctx, fs.bucket, minio.ListObjectsOptions{
+		Prefix:       rootKey,
+		Recursive:    true,
+		WithMetadata: true,
+	})
+
+	// folders holds the keys of the folders already reported.
+	folders := map[string]bool{rootKey: true}
+
+	// send reports the entry. It returns false when the receiver encountered a problem, with
+	// the problem.
+	send := func(fsEntry db.FSEntry) (bool, error) {
+		select {
+		case err := <-errCh:
+			close(fsEntriesCh)
+			return false, errors.WithStack(err)
+		case fsEntriesCh <- fsEntry:
+			return true, nil
+		}
+	}
+
+	// sendFolders reports the folders that hold the key and are not reported yet.
+	sendFolders := func(key string) (bool, error) {
+		for _, folderKey := range s3ParentKeys(rootKey, key) {
+			if folders[folderKey] {
+				continue
+			}
+			folders[folderKey] = true
+
+			if ok, err := send(fs.folderEntry(fsName, rootKey, folderKey, nil)); !ok {
+				return false, err
+			}
+		}
+
+		return true, nil
+	}
+
+	if ok, err := send(fs.folderEntry(fsName, rootKey, rootKey, nil)); !ok {
+		return err
+	}
+
+	for object := range objectsCh {
+		if object.Err != nil {
+			close(fsEntriesCh)
+			<-errCh
+			return errors.WithMessagef(object.Err, "listing bucket '%s'", fs.bucket)
+		}
+
+		if ok, err := sendFolders(object.Key); !ok {
+			return err
+		}
+
+		fsEntry := fs.fileEntry(fsName, object)
+
+		if strings.HasSuffix(object.Key, "/") {
+			// an object which key ends with "/" is the marker of a folder, such as those
+			// created by the sync S3 driver. It is listed before the contents of the folder.
+			if folders[object.Key] {
+				continue
+			}
+			folders[object.Key] = true
+
+			fsEntry = fs.folderEntry(fsName, rootKey, object.Key, &object)
+		}
+
+		if ok, err := send(fsEntry); !ok {
+			return err
+		}
+	}
+	close(fsEntriesCh)
+
+	return errors.WithStack(<-errCh)
+}
+
+func (fs *S3) folderEntry(fsName db.FSName, rootKey, key string, marker *minio.ObjectInfo) db.FSEntry {
+	path, name := s3PathName(key)
+
+	parentFolderID := uint64(0)
+	if key != rootKey {
+		parentFolderID = s3EntryID(s3ParentKey(key))
+	}
+
+	fsEntry := db.FSEntry{
+		FSName:         fsName,
+		DeviceID:       fs.bucket,
+		EntryID:        s3EntryID(key),
+		IsFolder:       true,
+		Path:           path,
+		Name:           name,
+		ParentFolderID: parentFolderID,
+		Kind:           db.EntryKindFolder,
+	}
+
+	if marker != nil {
+		fsEntry.Created = marker.LastModified
+		fsEntry.Modified = marker.LastModified
+	}
+
+	return fsEntry
+}
+
+func (fs *S3) fileEntry(fsName db.FSName, object minio.ObjectInfo) db.FSEntry {
+	path, name := s3PathName(object.Key)
+
+	fsEntry := db.FSEntry{
+		FSName:         fsName,
+		DeviceID:       fs.bucket,
+		EntryID:        s3EntryID(object.Key),
+		Path:           path,
+		Name:           name,
+		ParentFolderID: s3EntryID(s3ParentKey(object.Key)),
+		// S3 does not keep the time of creation of the objects: the last time they were
+		// written to is the closest.
+		Created:  object.LastModified,
+		Modified: object.LastModified,
+		Size:     uint64(object.Size),
+		Hash:     strings.Trim(object.ETag, `"`),
+		Kind:     db.EntryKindFile,
+	}
+
+	if sha256, ok := object.UserTags[S3ContentHashTag]; ok {
+		fsEntry.ContentHashAlgorithm = db.HashAlgorithmSHA256
+		fsEntry.ContentHash = sha256
+	}
+
+	return fsEntry
+}
+
+// s3FolderKey returns the key prefix of the folder at path: "" for the root of the bucket,
+// otherwise the path without its leading "/" and with a trailing "/".
+func s3FolderKey(path string) string {
+	key := strings.Trim(filepath.ToSlash(filepath.Clean("/"+path)), "/")
+	if key == "" {
+		return ""
+	}
+
+	return key + "/"
+}
+
+// s3ParentKey returns the key prefix of the folder that holds the object or folder key.
+func s3ParentKey(key string) string {
+	key = strings.TrimSuffix(key, "/")
+
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		return ""
+	}
+
+	return key[:i+1]
+}
+
+// s3ParentKeys returns the key prefixes of the folders below rootKey that hold the object or
+// folder key, from the top down.
+func s3ParentKeys(rootKey, key string) []string {
+	keys := []string{}
+
+	for i := len(rootKey); i < len(key)-1; i++ {
+		if key[i] == '/' {
+			keys = append(keys, key[:i+1])
+		}
+	}
+
+	return keys
+}
+
+// s3PathName returns the path of the folder and the name of the object or folder key.
+// The root of the bucket is "/".
+func s3PathName(key string) (string, string) {
+	path := "/" + strings.TrimSuffix(key, "/")
+	if path == "/" {
+		return "/", "/"
+	}
+
+	return filepath.Dir(path), filepath.Base(path)
+}
+
+// s3EntryID returns the entry ID of the object or folder key.
+func s3EntryID(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+
+	return h.Sum64()
+}
@@ -0,0 +1,358 @@
+package filesystem
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1" // nolint: gosec
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/seborama/pcloud-sdk/tracker/db"
+)
+
+// sshConn defines the SSH connection methods used to run commands on the remote host.
+type sshConn interface {
+	NewSession() (*ssh.Session, error)
+}
+
+// sftpClient defines the SFTP client methods used to walk the remote file system.
+type sftpClient interface {
+	Lstat(path string) (os.FileInfo, error)
+	ReadDir(path string) ([]os.FileInfo, error)
+	ReadLink(path string) (string, error)
+	Open(path string) (*sftp.File, error)
+}
+
+// SFTP is a file system abstraction for a remote file system reached over SSH.
+// The entries are listed with SFTP. SFTP does not expose the inode numbers of the entries: they
+// are listed with GNU find over SSH, so that the entry IDs are stable across renames as on a
+// local file system.
+type SFTP struct {
+	conn          sshConn
+	client        sftpClient
+	remoteHashing bool
+}
+
+// SFTPOption is a Go functional parameter signature used to configure SFTP.
+type SFTPOption func(*SFTP)
+
+// WithRemoteHashing hashes the files on the remote host, with sha1sum over SSH, rather than by
+// reading their data over SFTP. All the files are hashed on each Walk but none of their data
+// crosses the network.
+func WithRemoteHashing() SFTPOption {
+	return func(fs *SFTP) {
+		fs.remoteHashing = true
+	}
+}
+
+// NewSFTP creates a new initialised SFTP structure.
+// client is an SFTP client over conn, such as one from sftp.NewClient. Both are left open.
+func NewSFTP(conn sshConn, client sftpClient, opts ...SFTPOption) *SFTP {
+	fs := &SFTP{
+		conn:   conn,
+		client: client,
+	}
+
+	for _, opt := range opts {
+		opt(fs)
+	}
+
+	return fs
+}
+
+// sftpEntryID identifies an entry of the remote file system.
+type sftpEntryID struct {
+	deviceID string
+	inode    uint64
+}
+
+// sftpFolder is a folder of the remote file system that remains to be walked.
+type sftpFolder struct {
+	path     string
+	folderID uint64
+}
+
+// Walk traverses the file system entries and writes each entry to fsEntriesCh.
+// It must check for an error in errCh (which indicates the receiver of fsEntriesCh encountered
+// a problem and terminate if one is present.
+// Walk is the PRODUCER on fsEntriesCh and IS RESPONSIBLE FOR CLOSING IT!!
+// The hash of the files is a SHA1 of their data (see WithRemoteHashing). Symbolic links are
+// stored as links. The entries created during the Walk may be left out: they are tracked by the
+// next Walk.
+// nolint: gocognit
+func (fs *SFTP) Walk(ctx context.Context, fsName db.FSName, path string, fsEntriesCh chan<- db.FSEntry, errCh <-chan error) error {
+	root := filepath.Clean(path)
+
+	entryIDs, err := fs.listEntryIDs(ctx, root)
+	if err != nil {
+		return err
+	}
+
+	var hashes map[string]string
+	if fs.remoteHashing {
+		hashes, err = fs.listHashes(ctx, root)
+		if err != nil {
+			return err
+		}
+	}
+
+	rootInfo, err := fs.client.Lstat(root)
+	if err != nil {
+		return errors.WithMessagef(err, "stat of '%s'", root)
+	}
+
+	// fail terminates the receiver when the walk fails.
+	fail := func(err error) error {
+		close(fsEntriesCh)
+		<-errCh
+		return err
+	}
+
+	// a single root is its own parent, as with the local file system.
+	rootID := entryIDs[root]
+	folders := []sftpFolder{}
+
+	send := func(path string, info os.FileInfo, parentFolderID uint64) (bool, error) {
+		id, ok := entryIDs[path]
+		if !ok {
+			// the entry was created after the inodes were listed.
+			return true, nil
+		}
+
+		fsEntry, err := fs.newFSEntry(fsName, path, info, id, parentFolderID, hashes)
+		if err != nil {
+			return false, fail(err)
+		}
+
+		if fsEntry.IsFolder {
+			folders = append(folders, sftpFolder{path: path, folderID: id.inode})
+		}
+
+		select {
+		case err = <-errCh:
+			close(fsEntriesCh)
+			return false, errors.WithStack(err)
+		case fsEntriesCh <- fsEntry:
+			return true, nil
+		}
+	}
+
+	if ok, err := send(root, rootInfo, rootID.inode); !ok {
+		return err
+	}
+
+	for len(folders) > 0 {
+		folder := folders[0]
+		folders = folders[1:]
+
+		infos, err := fs.client.ReadDir(folder.path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// the folder was removed during the walk.
+				continue
+			}
+			return fail(errors.WithMessagef(err, "reading folder '%s'", folder.path))
+		}
+
+		for _, info := range infos {
+			if ok, err := send(filepath.Join(folder.path, info.Name()), info, folder.folderID); !ok {
+				return err
+			}
+		}
+	}
+	close(fsEntriesCh)
+
+	return errors.WithStack(<-errCh)
+}
+
+func (fs *SFTP) newFSEntry(fsName db.FSName, path string, info os.FileInfo, id sftpEntryID, parentFolderID uint64, hashes map[string]string) (db.FSEntry, error) {
+	fsEntry := db.FSEntry{
+		FSName:         fsName,
+		DeviceID:       id.deviceID,
+		EntryID:        id.inode,
+		IsFolder:       info.IsDir(),
+		Path:           filepath.Dir(path),
+		Name:           filepath.Base(path),
+		ParentFolderID: parentFolderID,
+		// SFTP does not expose the time of creation of the entries.
+		Created:  info.ModTime(),
+		Modified: info.ModTime(),
+		Size:     uint64(info.Size()),
+		Kind:     entryKind(info.Mode()),
+	}
+
+	if stat, ok := info.Sys().(*sftp.FileStat); ok {
+		fsEntry.Mode = stat.Mode & 0o7777
+		fsEntry.UID = stat.UID
+		fsEntry.GID = stat.GID
+	}
+
+	switch fsEntry.Kind {
+	case db.EntryKindSymlink:
+		target, err := fs.client.ReadLink(path)
+		if err != nil {
+			return db.FSEntry{}, errors.WithMessagef(err, "reading link '%s'", path)
+		}
+		fsEntry.LinkTarget = target
+
+	case db.EntryKindFile:
+		hash, ok := hashes[path]
+		if !ok {
+			var err error
+			hash, err = fs.hashFileData(path)
+			if err != nil {
+				return db.FSEntry{}, err
+			}
+		}
+
+		fsEntry.Hash = hash
+		fsEntry.ContentHashAlgorithm = db.HashAlgorithmSHA1
+		fsEntry.ContentHash = hash
+	}
+
+	return fsEntry, nil
+}
+
+// hashFileData returns the SHA1 of the data of the file at path, read over SFTP.
+func (fs *SFTP) hashFileData(path string) (string, error) {
+	f, err := fs.client.Open(path)
+	if err != nil {
+		return "", errors.WithMessagef(err, "opening '%s'", path)
+	}
+	defer func() { _ = f.Close() }()
+
+	cs := sha1.New() // nolint: gosec
+
+	_, err = io.Copy(cs, f)
+	if err != nil {
+		return "", errors.WithMessagef(err, "reading '%s'", path)
+	}
+
+	return fmt.Sprintf("%x", cs.Sum(nil)), nil
+}
+
+// listEntryIDs returns the device and inode of the entries below root, and of root, by path.
+func (fs *SFTP) listEntryIDs(ctx context.Context, root string) (map[string]sftpEntryID, error) {
+	entryIDs := map[string]sftpEntryID{}
+
+	err := fs.run(ctx, "find "+shellQuote(root)+` -printf '%D %i %p\0'`, func(record string) error {
+		fields := strings.SplitN(record, " ", 3)
+		if len(fields) != 3 {
+			return errors.Errorf("unexpected output of find: '%s'", record)
+		}
+
+		inode, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return errors.WithMessagef(err, "inode of '%s'", fields[2])
+		}
+
+		entryIDs[fields[2]] = sftpEntryID{deviceID: fields[0], inode: inode}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entryIDs, nil
+}
+
+// listHashes returns the SHA1 of the data of the files below root, by path.
+func (fs *SFTP) listHashes(ctx context.Context, root string) (map[string]string, error) {
+	hashes := map[string]string{}
+
+	err := fs.run(ctx, "find "+shellQuote(root)+" -type f -exec sha1sum -z -- {} +", func(record string) error {
+		// sha1sum prints the hash, a space and a space or "*" (the mode), then the path.
+		if len(record) < 42 {
+			return errors.Errorf("unexpected output of sha1sum: '%s'", record)
+		}
+
+		hashes[record[42:]] = record[:40]
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// run runs cmd on the remote host and calls f with each of the NUL terminated records of its
+// output.
+func (fs *SFTP) run(ctx context.Context, cmd string, f func(record string) error) error {
+	session, err := fs.conn.NewSession()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() { _ = session.Close() }()
+
+	stop := context.AfterFunc(ctx, func() { _ = session.Close() })
+	defer stop()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	stderr := &bytes.Buffer{}
+	session.Stderr = stderr
+
+	err = session.Start(cmd)
+	if err != nil {
+		return errors.WithMessagef(err, "running '%s'", cmd)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Split(scanNULTerminated)
+
+	for scanner.Scan() {
+		err = f(scanner.Text())
+		if err != nil {
+			// drains the output so that the command terminates.
+			_, _ = io.Copy(io.Discard, stdout)
+			_ = session.Wait()
+			return err
+		}
+	}
+
+	err = scanner.Err()
+	if err == nil {
+		err = session.Wait()
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return errors.WithStack(ctx.Err())
+		}
+		return errors.WithMessagef(err, "running '%s': %s", cmd, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// scanNULTerminated is a bufio.SplitFunc that splits the records terminated by NUL.
+func scanNULTerminated(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
+
+// shellQuote quotes s for the POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
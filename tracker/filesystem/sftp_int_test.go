@@ -0,0 +1,109 @@
+package filesystem_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-sdk/internal/sshtest"
+	"github.com/seborama/pcloud-sdk/tracker/archos"
+	"github.com/seborama/pcloud-sdk/tracker/db"
+	"github.com/seborama/pcloud-sdk/tracker/filesystem"
+)
+
+func walkSFTP(ctx context.Context, t *testing.T, fs *filesystem.SFTP, path string) map[string]db.FSEntry {
+	fsEntriesCh := make(chan db.FSEntry)
+	errCh := make(chan error)
+
+	walkErrCh := make(chan error, 1)
+	go func() {
+		walkErrCh <- fs.Walk(ctx, "sftp", path, fsEntriesCh, errCh)
+	}()
+
+	fsEntries := map[string]db.FSEntry{}
+	for fsEntry := range fsEntriesCh {
+		fsEntries[filepath.Join(fsEntry.Path, fsEntry.Name)] = fsEntry
+	}
+	close(errCh)
+
+	require.NoError(t, <-walkErrCh)
+
+	return fsEntries
+}
+
+func inode(t *testing.T, path string) uint64 {
+	info, err := os.Lstat(path)
+	require.NoError(t, err)
+	return archos.Inode(info)
+}
+
+func TestSFTP_Walk(t *testing.T) {
+	ctx := context.Background()
+
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "docs", "sub"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("hello"), 0o640))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "it's here.txt"), []byte("bye"), 0o600))
+	require.NoError(t, os.Symlink("docs/a.txt", filepath.Join(root, "link")))
+
+	conn := sshtest.NewClient(t)
+	client, err := sftp.NewClient(conn)
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	for name, opts := range map[string][]filesystem.SFTPOption{
+		"data read over SFTP": nil,
+		"remote hashing":      {filesystem.WithRemoteHashing()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fs := filesystem.NewSFTP(conn, client, opts...)
+
+			fsEntries := walkSFTP(ctx, t, fs, root)
+			require.Len(t, fsEntries, 6)
+
+			rootEntry := fsEntries[root]
+			require.True(t, rootEntry.IsFolder)
+			require.Equal(t, inode(t, root), rootEntry.EntryID)
+			require.Equal(t, rootEntry.EntryID, rootEntry.ParentFolderID)
+
+			for path, fsEntry := range fsEntries {
+				require.Equal(t, inode(t, path), fsEntry.EntryID, path)
+				require.Equal(t, rootEntry.DeviceID, fsEntry.DeviceID, path)
+				if path != root {
+					require.Equal(t, inode(t, fsEntry.Path), fsEntry.ParentFolderID, path)
+				}
+			}
+
+			a := fsEntries[filepath.Join(root, "docs", "a.txt")]
+			require.Equal(t, db.EntryKindFile, a.Kind)
+			require.EqualValues(t, 5, a.Size)
+			require.EqualValues(t, 0o640, a.Mode)
+			require.Equal(t, "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d", a.Hash)
+			require.Equal(t, db.HashAlgorithmSHA1, a.ContentHashAlgorithm)
+			require.Equal(t, a.Hash, a.ContentHash)
+
+			require.Equal(t, "78c9a53e2f28b543ea62c8266acfdf36d5c63e61", fsEntries[filepath.Join(root, "it's here.txt")].Hash)
+
+			link := fsEntries[filepath.Join(root, "link")]
+			require.Equal(t, db.EntryKindSymlink, link.Kind)
+			require.Equal(t, "docs/a.txt", link.LinkTarget)
+			require.Empty(t, link.Hash)
+
+			require.Equal(t, db.EntryKindFolder, fsEntries[filepath.Join(root, "docs", "sub")].Kind)
+		})
+	}
+
+	// the entry IDs are stable across renames.
+	fs := filesystem.NewSFTP(conn, client)
+	before := walkSFTP(ctx, t, fs, root)[filepath.Join(root, "docs", "a.txt")]
+
+	require.NoError(t, os.Rename(filepath.Join(root, "docs", "a.txt"), filepath.Join(root, "docs", "sub", "b.txt")))
+
+	after := walkSFTP(ctx, t, fs, root)[filepath.Join(root, "docs", "sub", "b.txt")]
+	require.Equal(t, before.EntryID, after.EntryID)
+	require.Equal(t, before.Hash, after.Hash)
+}
@@ -0,0 +1,68 @@
+package filesystem
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/seborama/pcloud-sdk/tracker/db"
+)
+
+// ErrWatcherNotSupported is returned by Watcher.Run on platforms where watching a local file
+// system is not supported.
+var ErrWatcherNotSupported = errors.New("watching a local file system is not supported on this platform")
+
+// dirtyPathsRecorder defines the store operations used by the Watcher to record changes.
+type dirtyPathsRecorder interface {
+	AddDirtyPaths(ctx context.Context, fsName db.FSName, paths []string) error
+	MarkFullRescanRequired(ctx context.Context, fsName db.FSName) error
+}
+
+// Watcher watches a local file system in real-time and records the paths that change in the
+// tracker database. This allows the tracker to only rehash the files that have changed when it
+// refreshes the file system (see tracker.WithDirtyPaths).
+type Watcher struct {
+	store  dirtyPathsRecorder
+	fsName db.FSName
+	root   string
+
+	flushInterval time.Duration
+
+	watching atomic.Bool
+}
+
+// WatcherOption is a Go functional parameter signature used to configure a Watcher.
+type WatcherOption func(*Watcher)
+
+// WithWatcherFlushInterval sets the maximum interval at which the changed paths are recorded in
+// the tracker database. The default is 1 second.
+func WithWatcherFlushInterval(d time.Duration) WatcherOption {
+	return func(w *Watcher) {
+		if d > 0 {
+			w.flushInterval = d
+		}
+	}
+}
+
+// NewWatcher creates a new initialised Watcher of the local file system fsName at root.
+func NewWatcher(store dirtyPathsRecorder, fsName db.FSName, root string, opts ...WatcherOption) *Watcher {
+	w := &Watcher{
+		store:         store,
+		fsName:        fsName,
+		root:          root,
+		flushInterval: time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	return w
+}
+
+// Watching reports whether the Watcher is currently recording all the changes of the file system.
+func (w *Watcher) Watching() bool {
+	return w.watching.Load()
+}
@@ -0,0 +1,268 @@
+//go:build linux
+// +build linux
+
+package filesystem
+
+import (
+	"context"
+	"encoding/binary"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/seborama/pcloud-sdk/tracker/archos"
+)
+
+const (
+	inotifyWatchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+		syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF |
+		syscall.IN_ONLYDIR | syscall.IN_DONT_FOLLOW | syscall.IN_EXCL_UNLINK
+
+	// maxPendingDirtyPaths is the number of changed paths beyond which they are recorded without
+	// waiting for the flush interval.
+	maxPendingDirtyPaths = 1_000
+
+	// nameMax is the maximum length of a file name on Linux.
+	nameMax = 255
+)
+
+// Run watches the file system recursively with inotify and records the paths that change until
+// ctx is done.
+// Changes that take place while the Watcher is not running cannot be recorded: Run records that
+// a full rescan is required when it starts and when it returns. The same applies when the kernel
+// event queue overflows.
+// Note that the number of folders that can be watched is limited by the kernel parameter
+// fs.inotify.max_user_watches.
+func (w *Watcher) Run(ctx context.Context) (err error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// a non-blocking file descriptor is handled by the runtime poller, which supports deadlines.
+	f := os.NewFile(uintptr(fd), "inotify")
+	defer func() { _ = f.Close() }()
+
+	defer func() {
+		w.watching.Store(false)
+
+		// ctx is likely done by now.
+		errMark := w.store.MarkFullRescanRequired(context.Background(), w.fsName)
+		if err == nil {
+			err = errMark
+		}
+	}()
+
+	err = w.store.MarkFullRescanRequired(ctx, w.fsName)
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(w.root)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	watches := &inotifyWatches{
+		fd:       fd,
+		deviceID: archos.Device(fi),
+		paths:    map[int32]string{},
+		wds:      map[string]int32{},
+	}
+
+	err = watches.addRecursive(filepath.Clean(w.root))
+	if err != nil {
+		return err
+	}
+
+	w.watching.Store(true)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+nameMax+1))
+	dirtyPaths := map[string]struct{}{}
+	lastFlush := time.Now()
+
+	flush := func(ctx context.Context) error {
+		lastFlush = time.Now()
+
+		if len(dirtyPaths) == 0 {
+			return nil
+		}
+
+		paths := make([]string, 0, len(dirtyPaths))
+		for p := range dirtyPaths {
+			paths = append(paths, p)
+		}
+
+		err := w.store.AddDirtyPaths(ctx, w.fsName, paths)
+		if err != nil {
+			return err
+		}
+
+		dirtyPaths = map[string]struct{}{}
+
+		return nil
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return flush(context.Background())
+		}
+
+		if len(dirtyPaths) >= maxPendingDirtyPaths || time.Since(lastFlush) >= w.flushInterval {
+			err = flush(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = f.SetReadDeadline(time.Now().Add(w.flushInterval))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		n, err := f.Read(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
+			return errors.WithStack(err)
+		}
+
+		overflow, err := watches.handleEvents(buf[:n], dirtyPaths)
+		if err != nil {
+			return err
+		}
+
+		if overflow {
+			err = w.store.MarkFullRescanRequired(ctx, w.fsName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// inotifyWatches keeps track of the folders watched with inotify.
+type inotifyWatches struct {
+	fd       int
+	deviceID uint64
+	paths    map[int32]string // watch descriptor -> path
+	wds      map[string]int32 // path -> watch descriptor
+}
+
+// handleEvents records the paths of the inotify events in buf into dirtyPaths and watches the
+// new folders. It returns true if the kernel event queue overflowed.
+func (iw *inotifyWatches) handleEvents(buf []byte, dirtyPaths map[string]struct{}) (bool, error) {
+	overflow := false
+
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		wd := int32(binary.NativeEndian.Uint32(buf[offset:]))
+		mask := binary.NativeEndian.Uint32(buf[offset+4:])
+		nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+
+		nameStart := offset + syscall.SizeofInotifyEvent
+		name := strings.TrimRight(string(buf[nameStart:nameStart+nameLen]), "\x00")
+		offset = nameStart + nameLen
+
+		if mask&syscall.IN_Q_OVERFLOW != 0 {
+			overflow = true
+			continue
+		}
+
+		dir, ok := iw.paths[wd]
+		if !ok {
+			continue
+		}
+
+		if mask&syscall.IN_IGNORED != 0 {
+			// the watch was removed: explicitly or because the folder was deleted.
+			delete(iw.paths, wd)
+			delete(iw.wds, dir)
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		dirtyPaths[path] = struct{}{}
+
+		if mask&syscall.IN_ISDIR == 0 {
+			continue
+		}
+
+		switch {
+		case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+			// entries may be created in the folder before it is watched but they are covered
+			// by the folder itself being dirty.
+			err := iw.addRecursive(path)
+			if err != nil {
+				return overflow, err
+			}
+
+		case mask&syscall.IN_MOVED_FROM != 0:
+			iw.removeRecursive(path)
+		}
+	}
+
+	return overflow, nil
+}
+
+// addRecursive watches the folder at root and all its sub-folders on the same device.
+func (iw *inotifyWatches) addRecursive(root string) error {
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// the entry was removed in the meantime
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return errors.WithStack(err)
+		}
+
+		if archos.Device(info) != iw.deviceID {
+			return filepath.SkipDir
+		}
+
+		wd, err := syscall.InotifyAddWatch(iw.fd, path, inotifyWatchMask)
+		if err != nil {
+			if errors.Is(err, syscall.ENOENT) {
+				return filepath.SkipDir
+			}
+			return errors.WithMessagef(err, "watching '%s'", path)
+		}
+
+		iw.paths[int32(wd)] = path
+		iw.wds[path] = int32(wd)
+
+		return nil
+	})
+
+	return err
+}
+
+// removeRecursive stops watching the folder at root and all its sub-folders.
+func (iw *inotifyWatches) removeRecursive(root string) {
+	for path, wd := range iw.wds {
+		if path != root && !strings.HasPrefix(path, root+string(filepath.Separator)) {
+			continue
+		}
+
+		_, _ = syscall.InotifyRmWatch(iw.fd, uint32(wd))
+		delete(iw.paths, wd)
+		delete(iw.wds, path)
+	}
+}
@@ -0,0 +1,110 @@
+package filesystem_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-sdk/tracker/db"
+	"github.com/seborama/pcloud-sdk/tracker/filesystem"
+)
+
+func TestWatcher_Run(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	root, err := os.MkdirTemp("", "go_pCloud_watcher_test")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(root) }()
+
+	err = os.MkdirAll(filepath.Join(root, "Folder1"), 0700)
+	require.NoError(t, err)
+
+	store := &dirtyPathsRecorderStub{dirtyPaths: map[string]struct{}{}}
+
+	w := filesystem.NewWatcher(store, "local_fs", root, filesystem.WithWatcherFlushInterval(10*time.Millisecond))
+
+	runErrCh := make(chan error)
+	go func() {
+		runErrCh <- w.Run(ctx)
+	}()
+
+	require.Eventually(t, w.Watching, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, store.getFullRescans())
+
+	err = os.WriteFile(filepath.Join(root, "File000"), []byte("This is File000"), 0600)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(root, "Folder1", "File1"), []byte("This is File1"), 0600)
+	require.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(root, "Folder2"), 0700)
+	require.NoError(t, err)
+
+	// Folder2 is watched as soon as it is created
+	require.Eventually(t, func() bool { return store.hasDirtyPath(filepath.Join(root, "Folder2")) }, 5*time.Second, 10*time.Millisecond)
+
+	err = os.WriteFile(filepath.Join(root, "Folder2", "File2"), []byte("This is File2"), 0600)
+	require.NoError(t, err)
+
+	for _, p := range []string{
+		filepath.Join(root, "File000"),
+		filepath.Join(root, "Folder1", "File1"),
+		filepath.Join(root, "Folder2", "File2"),
+	} {
+		p := p
+		require.Eventually(t, func() bool { return store.hasDirtyPath(p) }, 5*time.Second, 10*time.Millisecond, p)
+	}
+
+	cancel()
+	require.NoError(t, <-runErrCh)
+	require.False(t, w.Watching())
+
+	// changes are not recorded once the watcher has stopped
+	require.Equal(t, 2, store.getFullRescans())
+}
+
+type dirtyPathsRecorderStub struct {
+	mu          sync.Mutex
+	dirtyPaths  map[string]struct{}
+	fullRescans int
+}
+
+func (s *dirtyPathsRecorderStub) AddDirtyPaths(_ context.Context, _ db.FSName, paths []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range paths {
+		s.dirtyPaths[p] = struct{}{}
+	}
+
+	return nil
+}
+
+func (s *dirtyPathsRecorderStub) MarkFullRescanRequired(_ context.Context, _ db.FSName) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fullRescans++
+
+	return nil
+}
+
+func (s *dirtyPathsRecorderStub) hasDirtyPath(p string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.dirtyPaths[p]
+
+	return ok
+}
+
+func (s *dirtyPathsRecorderStub) getFullRescans() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.fullRescans
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package filesystem
+
+import (
+	"context"
+)
+
+// Run is not supported on this platform: it returns ErrWatcherNotSupported.
+func (w *Watcher) Run(_ context.Context) error {
+	return ErrWatcherNotSupported
+}
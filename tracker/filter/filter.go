@@ -0,0 +1,169 @@
+package filter
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/seborama/pcloud-sdk/tracker/db"
+)
+
+// IgnoreFileName is the name of the files that hold gitignore-style patterns for the entries
+// of the folder they are in, and its sub-folders.
+const IgnoreFileName = ".pcloudignore"
+
+// IgnoreFileReader reads the ignore files of a file system.
+type IgnoreFileReader interface {
+	// ReadIgnoreFile returns the contents of the ignore file of the folder at path dir, or nil
+	// if the folder has no ignore file.
+	ReadIgnoreFile(ctx context.Context, dir string) ([]byte, error)
+}
+
+// Filter decides which entries of a file system are not tracked, based on the filter rules of
+// the file system and on the ignore files of its folders.
+// As with gitignore, the last matching pattern decides and the patterns of the ignore files
+// take precedence over those of their parent folders and over the filter rules.
+// Files beyond the size or age limits of the filter rules are excluded regardless of the patterns.
+// The entries of an excluded folder are excluded too: it is the responsibility of the caller not
+// to enquire about them.
+// Filter is not safe for concurrent use.
+type Filter struct {
+	root     string
+	patterns []pattern
+	maxSize  uint64
+	maxAge   time.Duration
+	now      func() time.Time
+
+	ignoreFileReader IgnoreFileReader
+	ignoreFiles      map[string][]pattern // relative folder path -> patterns of its ignore file
+}
+
+// Option is a Go functional parameter signature used to configure a Filter.
+type Option func(*Filter)
+
+// WithIgnoreFiles makes the Filter honour the ignore files of the folders (see IgnoreFileName),
+// which are read with reader.
+func WithIgnoreFiles(reader IgnoreFileReader) Option {
+	return func(f *Filter) {
+		f.ignoreFileReader = reader
+	}
+}
+
+// WithClock sets the function that returns the current time, against which the age of the files
+// is measured. The default is time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(f *Filter) {
+		f.now = now
+	}
+}
+
+// New creates a new initialised Filter of the file system at root.
+func New(rules db.FilterRules, root string, opts ...Option) (*Filter, error) {
+	patterns, err := parsePatterns("", "filter rules", []byte(strings.Join(rules.Patterns, "\n")))
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Filter{
+		root:        filepath.Clean(root),
+		patterns:    patterns,
+		maxSize:     rules.MaxSize,
+		maxAge:      rules.MaxAge,
+		now:         time.Now,
+		ignoreFiles: map[string][]pattern{},
+	}
+
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return f, nil
+}
+
+// Excluded reports whether the entry is not tracked.
+// The root of the file system is never excluded.
+func (f *Filter) Excluded(ctx context.Context, entry db.FSEntry) (bool, error) {
+	relPath, err := filepath.Rel(f.root, filepath.Join(entry.Path, entry.Name))
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	if relPath == "." {
+		return false, nil
+	}
+
+	if relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return false, errors.Errorf("entry '%s' is outside of the file system root '%s'", filepath.Join(entry.Path, entry.Name), f.root)
+	}
+
+	relPath = filepath.ToSlash(relPath)
+
+	if !entry.IsFolder {
+		if f.maxSize > 0 && entry.Size > f.maxSize {
+			return true, nil
+		}
+
+		if f.maxAge > 0 && entry.Modified.Before(f.now().Add(-f.maxAge)) {
+			return true, nil
+		}
+	}
+
+	excluded := matchPatterns(f.patterns, relPath, entry.IsFolder, false)
+
+	if f.ignoreFileReader == nil {
+		return excluded, nil
+	}
+
+	// the ignore files of the folders from the root down to the parent of the entry.
+	dir := ""
+	for _, element := range strings.Split(relPath, "/") {
+		patterns, err := f.getIgnoreFile(ctx, dir)
+		if err != nil {
+			return false, err
+		}
+
+		excluded = matchPatterns(patterns, relPath, entry.IsFolder, excluded)
+
+		dir = strings.TrimPrefix(dir+"/"+element, "/")
+	}
+
+	return excluded, nil
+}
+
+// matchPatterns returns whether relPath is excluded by the last of patterns that matches it, or
+// excluded if none matches.
+func matchPatterns(patterns []pattern, relPath string, isDir, excluded bool) bool {
+	for _, p := range patterns {
+		if p.match(relPath, isDir) {
+			excluded = !p.negate
+		}
+	}
+
+	return excluded
+}
+
+// getIgnoreFile returns the patterns of the ignore file of the folder at relDir.
+func (f *Filter) getIgnoreFile(ctx context.Context, relDir string) ([]pattern, error) {
+	if patterns, ok := f.ignoreFiles[relDir]; ok {
+		return patterns, nil
+	}
+
+	dir := filepath.Join(f.root, filepath.FromSlash(relDir))
+
+	data, err := f.ignoreFileReader.ReadIgnoreFile(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	patterns, err := parsePatterns(relDir, filepath.Join(dir, IgnoreFileName), data)
+	if err != nil {
+		return nil, err
+	}
+
+	f.ignoreFiles[relDir] = patterns
+
+	return patterns, nil
+}
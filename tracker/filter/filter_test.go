@@ -0,0 +1,158 @@
+package filter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-sdk/tracker/db"
+	"github.com/seborama/pcloud-sdk/tracker/filter"
+)
+
+func TestFilter_Excluded_Patterns(t *testing.T) {
+	patterns := []string{
+		"# comment",
+		"",
+		".DS_Store",
+		"*.tmp",
+		"!keep.tmp",
+		"node_modules/",
+		"/Crypto Folder/",
+		"docs/**/*.pdf",
+		"build/**",
+		`\#hash`,
+		"trailing  ",
+		"[!a]x",
+	}
+
+	f, err := filter.New(db.FilterRules{Patterns: patterns}, "/root")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		path     string
+		name     string
+		isFolder bool
+		want     bool
+	}{
+		"root":                           {path: "/", name: "root", isFolder: true, want: false},
+		"basename at the root":           {path: "/root", name: ".DS_Store", want: true},
+		"basename at depth":              {path: "/root/a/b", name: ".DS_Store", want: true},
+		"glob":                           {path: "/root/a", name: "file.tmp", want: true},
+		"negated glob":                   {path: "/root/a", name: "keep.tmp", want: false},
+		"not matching":                   {path: "/root/a", name: "file.txt", want: false},
+		"folder only pattern on folder":  {path: "/root/a", name: "node_modules", isFolder: true, want: true},
+		"folder only pattern on file":    {path: "/root/a", name: "node_modules", want: false},
+		"anchored pattern":               {path: "/root", name: "Crypto Folder", isFolder: true, want: true},
+		"anchored pattern at depth":      {path: "/root/a", name: "Crypto Folder", isFolder: true, want: false},
+		"double star zero folder":        {path: "/root/docs", name: "a.pdf", want: true},
+		"double star many folders":       {path: "/root/docs/a/b", name: "a.pdf", want: true},
+		"double star other folder":       {path: "/root/other/a", name: "a.pdf", want: false},
+		"trailing double star contents":  {path: "/root/build/a", name: "out", want: true},
+		"trailing double star folder":    {path: "/root", name: "build", isFolder: true, want: false},
+		"escaped hash":                   {path: "/root", name: "#hash", want: true},
+		"trailing spaces":                {path: "/root", name: "trailing", want: true},
+		"negated character class":        {path: "/root", name: "bx", want: true},
+		"negated character class no hit": {path: "/root", name: "ax", want: false},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			excluded, err := f.Excluded(context.Background(), db.FSEntry{Path: tt.path, Name: tt.name, IsFolder: tt.isFolder})
+			require.NoError(t, err)
+			require.Equal(t, tt.want, excluded)
+		})
+	}
+}
+
+func TestFilter_Excluded_Limits(t *testing.T) {
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	f, err := filter.New(db.FilterRules{MaxSize: 100, MaxAge: 24 * time.Hour}, "/root", filter.WithClock(func() time.Time { return now }))
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		entry db.FSEntry
+		want  bool
+	}{
+		"small and recent file": {entry: db.FSEntry{Path: "/root", Name: "f", Size: 100, Modified: now.Add(-time.Hour)}, want: false},
+		"large file":            {entry: db.FSEntry{Path: "/root", Name: "f", Size: 101, Modified: now.Add(-time.Hour)}, want: true},
+		"old file":              {entry: db.FSEntry{Path: "/root", Name: "f", Size: 1, Modified: now.Add(-25 * time.Hour)}, want: true},
+		"large and old folder":  {entry: db.FSEntry{Path: "/root", Name: "d", IsFolder: true, Size: 101, Modified: now.Add(-25 * time.Hour)}, want: false},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			excluded, err := f.Excluded(context.Background(), tt.entry)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, excluded)
+		})
+	}
+}
+
+func TestFilter_Excluded_IgnoreFiles(t *testing.T) {
+	reader := &ignoreFileReaderStub{
+		ignoreFiles: map[string]string{
+			"/root":   "*.log\n/sub/only-here\n",
+			"/root/a": "!keep.log\nlocal\n",
+		},
+	}
+
+	f, err := filter.New(db.FilterRules{Patterns: []string{"*.bak"}}, "/root", filter.WithIgnoreFiles(reader))
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		path string
+		name string
+		want bool
+	}{
+		"filter rule":                        {path: "/root/a", name: "x.bak", want: true},
+		"root ignore file":                   {path: "/root/b", name: "x.log", want: true},
+		"overridden by folder ignore file":   {path: "/root/a/c", name: "keep.log", want: false},
+		"folder ignore file":                 {path: "/root/a/c", name: "local", want: true},
+		"folder ignore file outside folder":  {path: "/root/b", name: "local", want: false},
+		"anchored to the ignore file folder": {path: "/root/sub", name: "only-here", want: true},
+		"anchored elsewhere":                 {path: "/root/a/sub", name: "only-here", want: false},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			excluded, err := f.Excluded(context.Background(), db.FSEntry{Path: tt.path, Name: tt.name})
+			require.NoError(t, err)
+			require.Equal(t, tt.want, excluded)
+		})
+	}
+
+	// each ignore file is read once only
+	require.Equal(t, 1, reader.reads["/root"])
+	require.Equal(t, 1, reader.reads["/root/a"])
+}
+
+func TestFilter_New_BadPattern(t *testing.T) {
+	_, err := filter.New(db.FilterRules{Patterns: []string{"*.tmp", "[a-"}}, "/root")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "filter rules:2")
+}
+
+type ignoreFileReaderStub struct {
+	ignoreFiles map[string]string
+	reads       map[string]int
+}
+
+func (s *ignoreFileReaderStub) ReadIgnoreFile(_ context.Context, dir string) ([]byte, error) {
+	if s.reads == nil {
+		s.reads = map[string]int{}
+	}
+	s.reads[dir]++
+
+	data, ok := s.ignoreFiles[dir]
+	if !ok {
+		return nil, nil
+	}
+
+	return []byte(data), nil
+}
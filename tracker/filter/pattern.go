@@ -0,0 +1,160 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// pattern is a gitignore-style pattern.
+type pattern struct {
+	// base is the folder, relative to the root of the file system, that the pattern is relative
+	// to. It is empty for the root of the file system.
+	base string
+
+	// segments are the glob segments of the pattern, one per path element. A pattern that
+	// matches at any depth starts with a "**" segment.
+	segments []string
+
+	negate  bool
+	dirOnly bool
+}
+
+// parsePatterns parses gitignore-style patterns, relative to the folder base.
+// source describes the origin of the patterns for error messages.
+func parsePatterns(base, source string, data []byte) ([]pattern, error) {
+	patterns := []pattern{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		p, ok, err := parsePattern(base, scanner.Text())
+		if err != nil {
+			return nil, errors.WithMessagef(err, "%s:%d", source, lineNumber)
+		}
+
+		if ok {
+			patterns = append(patterns, p)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithMessagef(err, "%s", source)
+	}
+
+	return patterns, nil
+}
+
+// parsePattern parses a single line of gitignore-style patterns. It returns false when the line
+// does not contain a pattern, such as a blank line or a comment.
+func parsePattern(base, line string) (pattern, bool, error) {
+	line = trimTrailingSpaces(strings.TrimSuffix(line, "\r"))
+
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern{}, false, nil
+	}
+
+	p := pattern{
+		base: base,
+	}
+
+	switch {
+	case strings.HasPrefix(line, "!"):
+		p.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// a pattern without a separator (other than a trailing one) matches at any depth.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	if line == "" {
+		return pattern{}, false, nil
+	}
+
+	if !anchored {
+		p.segments = append(p.segments, "**")
+	}
+
+	for _, segment := range strings.Split(line, "/") {
+		// gitignore negates character classes with "!" where path.Match uses "^".
+		segment = strings.ReplaceAll(segment, "[!", "[^")
+
+		if _, err := path.Match(segment, ""); err != nil {
+			return pattern{}, false, errors.Wrapf(err, "pattern '%s'", line)
+		}
+
+		p.segments = append(p.segments, segment)
+	}
+
+	return p, true, nil
+}
+
+// trimTrailingSpaces removes the trailing spaces of line, unless they are escaped with a
+// backslash.
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+
+	return line
+}
+
+// match reports whether the pattern matches the entry at relPath, relative to the root of the
+// file system.
+func (p pattern) match(relPath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+
+	if p.base != "" {
+		if !strings.HasPrefix(relPath, p.base+"/") {
+			return false
+		}
+		relPath = strings.TrimPrefix(relPath, p.base+"/")
+	}
+
+	return matchSegments(p.segments, strings.Split(relPath, "/"))
+}
+
+func matchSegments(patternSegments, pathSegments []string) bool {
+	if len(patternSegments) == 0 {
+		return len(pathSegments) == 0
+	}
+
+	if patternSegments[0] == "**" {
+		// a trailing "**" matches everything inside a folder, but not the folder itself.
+		minSkip := 0
+		if len(patternSegments) == 1 {
+			minSkip = 1
+		}
+
+		for i := minSkip; i <= len(pathSegments); i++ {
+			if matchSegments(patternSegments[1:], pathSegments[i:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(pathSegments) == 0 {
+		return false
+	}
+
+	// the pattern was validated when it was parsed.
+	if ok, _ := path.Match(patternSegments[0], pathSegments[0]); !ok {
+		return false
+	}
+
+	return matchSegments(patternSegments[1:], pathSegments[1:])
+}
@@ -0,0 +1,174 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Format is the format a report is rendered in.
+type Format string
+
+const (
+	// FormatTable renders a report as a table aligned with spaces, for humans.
+	FormatTable Format = "table"
+	// FormatCSV renders a report as CSV, with a header line.
+	FormatCSV Format = "csv"
+	// FormatJSON renders a report as a JSON array.
+	FormatJSON Format = "json"
+)
+
+// Report is the result of a query of the Reporter, that can be rendered.
+type Report interface {
+	header() []string
+	rows() [][]string
+}
+
+// FileStats is a list of files.
+type FileStats []FileStat
+
+// FolderStats is a list of folders.
+type FolderStats []FolderStat
+
+// DuplicateGroups is a list of groups of duplicate files.
+type DuplicateGroups []DuplicateGroup
+
+// ExtensionStats is a list of totals of files by extension.
+type ExtensionStats []ExtensionStat
+
+// FolderGrowths is a list of changes of size of folders.
+type FolderGrowths []FolderGrowth
+
+// Render writes report to w in the format f.
+func Render(w io.Writer, f Format, report Report) error {
+	switch f {
+	case FormatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+		_, err := fmt.Fprintln(tw, strings.Join(report.header(), "\t"))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, row := range report.rows() {
+			_, err = fmt.Fprintln(tw, strings.Join(row, "\t"))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		return errors.WithStack(tw.Flush())
+
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+
+		err := cw.Write(report.header())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = cw.WriteAll(report.rows())
+		return errors.WithStack(err)
+
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return errors.WithStack(enc.Encode(report))
+
+	default:
+		return errors.Errorf("unknown report format '%s'", f)
+	}
+}
+
+func (files FileStats) header() []string {
+	return []string{"path", "size", "modified", "hash"}
+}
+
+func (files FileStats) rows() [][]string {
+	rows := make([][]string, 0, len(files))
+	for _, file := range files {
+		rows = append(rows, []string{
+			file.Path,
+			strconv.FormatUint(file.Size, 10),
+			file.Modified.UTC().Format(time.RFC3339),
+			file.Hash,
+		})
+	}
+	return rows
+}
+
+func (folders FolderStats) header() []string {
+	return []string{"path", "size", "files"}
+}
+
+func (folders FolderStats) rows() [][]string {
+	rows := make([][]string, 0, len(folders))
+	for _, folder := range folders {
+		rows = append(rows, []string{
+			folder.Path,
+			strconv.FormatUint(folder.Size, 10),
+			strconv.Itoa(folder.FileCount),
+		})
+	}
+	return rows
+}
+
+func (groups DuplicateGroups) header() []string {
+	return []string{"hash", "size", "wasted", "path"}
+}
+
+// rows returns one row per duplicate file so that the CSV remains flat.
+func (groups DuplicateGroups) rows() [][]string {
+	rows := [][]string{}
+	for _, group := range groups {
+		for _, path := range group.Paths {
+			rows = append(rows, []string{
+				group.Hash,
+				strconv.FormatUint(group.Size, 10),
+				strconv.FormatUint(group.Wasted(), 10),
+				path,
+			})
+		}
+	}
+	return rows
+}
+
+func (extensions ExtensionStats) header() []string {
+	return []string{"extension", "size", "files"}
+}
+
+func (extensions ExtensionStats) rows() [][]string {
+	rows := make([][]string, 0, len(extensions))
+	for _, ext := range extensions {
+		rows = append(rows, []string{
+			ext.Extension,
+			strconv.FormatUint(ext.Size, 10),
+			strconv.Itoa(ext.FileCount),
+		})
+	}
+	return rows
+}
+
+func (growths FolderGrowths) header() []string {
+	return []string{"path", "previous_size", "new_size", "growth"}
+}
+
+func (growths FolderGrowths) rows() [][]string {
+	rows := make([][]string, 0, len(growths))
+	for _, growth := range growths {
+		rows = append(rows, []string{
+			growth.Path,
+			strconv.FormatUint(growth.PreviousSize, 10),
+			strconv.FormatUint(growth.NewSize, 10),
+			strconv.FormatInt(growth.Growth(), 10),
+		})
+	}
+	return rows
+}
@@ -0,0 +1,348 @@
+// Package report answers queries over the entries of a tracked file system, such as its largest
+// files or its duplicate files, and renders them as a table, CSV or JSON.
+package report
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/seborama/pcloud-sdk/tracker/db"
+)
+
+type storer interface {
+	GetPreviousFileSystemEntries(ctx context.Context, fsName db.FSName) ([]db.FSEntry, error)
+	GetLatestFileSystemEntries(ctx context.Context, fsName db.FSName) ([]db.FSEntry, error)
+}
+
+// any db.Store can back a Reporter.
+var _ storer = db.Store(nil)
+
+// Reporter runs the queries over the entries of a file system.
+// Unless stated otherwise, the queries are about the "new" entries of the file system, that is
+// as of its latest refresh.
+type Reporter struct {
+	store  storer
+	fsName db.FSName
+}
+
+// NewReporter creates a new initialised Reporter for the file system fsName.
+func NewReporter(store storer, fsName db.FSName) *Reporter {
+	return &Reporter{
+		store:  store,
+		fsName: fsName,
+	}
+}
+
+// FileStat describes a file.
+type FileStat struct {
+	Path     string
+	Size     uint64
+	Modified time.Time
+	Hash     string
+}
+
+// FolderStat describes a folder with the aggregated size of the files it holds, including
+// those of its sub-folders.
+type FolderStat struct {
+	Path      string
+	Size      uint64
+	FileCount int
+}
+
+// DuplicateGroup is a group of files that have the same contents.
+type DuplicateGroup struct {
+	Hash  string
+	Size  uint64 // size of each file
+	Paths []string
+}
+
+// Wasted returns the space taken by the duplicates: all the files but one.
+func (g DuplicateGroup) Wasted() uint64 {
+	return g.Size * uint64(len(g.Paths)-1)
+}
+
+// ExtensionStat holds the totals of the files of an extension.
+type ExtensionStat struct {
+	Extension string // lower case, without the dot; empty for files without an extension
+	Size      uint64
+	FileCount int
+}
+
+// FolderGrowth describes how the aggregated size of a folder changed between the "previous"
+// and the "new" entries of the file system.
+type FolderGrowth struct {
+	Path         string
+	PreviousSize uint64
+	NewSize      uint64
+}
+
+// Growth returns the number of bytes by which the folder grew, negative when it shrank.
+func (g FolderGrowth) Growth() int64 {
+	return int64(g.NewSize) - int64(g.PreviousSize)
+}
+
+// LargestFiles returns the n largest files, largest first. n <= 0 returns all the files.
+func (r *Reporter) LargestFiles(ctx context.Context, n int) (FileStats, error) {
+	fsEntries, err := r.store.GetLatestFileSystemEntries(ctx, r.fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	files := fileStats(fsEntries)
+
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Size > files[j].Size })
+
+	if n > 0 && n < len(files) {
+		files = files[:n]
+	}
+
+	return files, nil
+}
+
+// LargestFolders returns the n largest folders, by the aggregated size of their files, largest
+// first. n <= 0 returns all the folders.
+func (r *Reporter) LargestFolders(ctx context.Context, n int) (FolderStats, error) {
+	fsEntries, err := r.store.GetLatestFileSystemEntries(ctx, r.fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	folders := folderStats(fsEntries)
+
+	sort.SliceStable(folders, func(i, j int) bool { return folders[i].Size > folders[j].Size })
+
+	if n > 0 && n < len(folders) {
+		folders = folders[:n]
+	}
+
+	return folders, nil
+}
+
+// Duplicates returns the groups of files that have the same contents, the most wasteful first.
+// The files are compared by content hash where it is known, and by hash otherwise. Empty files
+// are not reported.
+func (r *Reporter) Duplicates(ctx context.Context) (DuplicateGroups, error) {
+	fsEntries, err := r.store.GetLatestFileSystemEntries(ctx, r.fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := map[string]*DuplicateGroup{}
+
+	for _, fsEntry := range fsEntries {
+		if fsEntry.Kind != db.EntryKindFile || fsEntry.Size == 0 {
+			continue
+		}
+
+		hash := fsEntry.Hash
+		if fsEntry.ContentHash != "" {
+			hash = string(fsEntry.ContentHashAlgorithm) + ":" + fsEntry.ContentHash
+		}
+
+		if hash == "" {
+			continue
+		}
+
+		group, ok := groups[hash]
+		if !ok {
+			group = &DuplicateGroup{Hash: hash, Size: fsEntry.Size}
+			groups[hash] = group
+		}
+
+		group.Paths = append(group.Paths, filepath.Join(fsEntry.Path, fsEntry.Name))
+	}
+
+	duplicates := DuplicateGroups{}
+
+	for _, group := range groups {
+		if len(group.Paths) < 2 {
+			continue
+		}
+
+		sort.Strings(group.Paths)
+		duplicates = append(duplicates, *group)
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool {
+		if duplicates[i].Wasted() != duplicates[j].Wasted() {
+			return duplicates[i].Wasted() > duplicates[j].Wasted()
+		}
+		return duplicates[i].Hash < duplicates[j].Hash
+	})
+
+	return duplicates, nil
+}
+
+// StaleFiles returns the files that were last modified before the time since, oldest first.
+func (r *Reporter) StaleFiles(ctx context.Context, since time.Time) (FileStats, error) {
+	fsEntries, err := r.store.GetLatestFileSystemEntries(ctx, r.fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	files := FileStats{}
+
+	for _, file := range fileStats(fsEntries) {
+		if file.Modified.Before(since) {
+			files = append(files, file)
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Modified.Before(files[j].Modified) })
+
+	return files, nil
+}
+
+// Extensions returns the totals of the files by extension, largest first.
+func (r *Reporter) Extensions(ctx context.Context) (ExtensionStats, error) {
+	fsEntries, err := r.store.GetLatestFileSystemEntries(ctx, r.fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := map[string]*ExtensionStat{}
+
+	for _, file := range fileStats(fsEntries) {
+		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(file.Path), "."))
+
+		total, ok := totals[ext]
+		if !ok {
+			total = &ExtensionStat{Extension: ext}
+			totals[ext] = total
+		}
+
+		total.Size += file.Size
+		total.FileCount++
+	}
+
+	extensions := ExtensionStats{}
+	for _, total := range totals {
+		extensions = append(extensions, *total)
+	}
+
+	sort.Slice(extensions, func(i, j int) bool {
+		if extensions[i].Size != extensions[j].Size {
+			return extensions[i].Size > extensions[j].Size
+		}
+		return extensions[i].Extension < extensions[j].Extension
+	})
+
+	return extensions, nil
+}
+
+// Growth returns the n folders whose aggregated size changed the most between the "previous"
+// and the "new" entries of the file system, largest growth first. The folders that did not
+// change are not reported. n <= 0 returns all the folders that changed.
+func (r *Reporter) Growth(ctx context.Context, n int) (FolderGrowths, error) {
+	previousEntries, err := r.store.GetPreviousFileSystemEntries(ctx, r.fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	newEntries, err := r.store.GetLatestFileSystemEntries(ctx, r.fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	growths := map[string]*FolderGrowth{}
+
+	for _, folder := range folderStats(previousEntries) {
+		growths[folder.Path] = &FolderGrowth{Path: folder.Path, PreviousSize: folder.Size}
+	}
+
+	for _, folder := range folderStats(newEntries) {
+		growth, ok := growths[folder.Path]
+		if !ok {
+			growth = &FolderGrowth{Path: folder.Path}
+			growths[folder.Path] = growth
+		}
+
+		growth.NewSize = folder.Size
+	}
+
+	folders := FolderGrowths{}
+
+	for _, growth := range growths {
+		if growth.Growth() != 0 {
+			folders = append(folders, *growth)
+		}
+	}
+
+	sort.Slice(folders, func(i, j int) bool {
+		if folders[i].Growth() != folders[j].Growth() {
+			return folders[i].Growth() > folders[j].Growth()
+		}
+		return folders[i].Path < folders[j].Path
+	})
+
+	if n > 0 && n < len(folders) {
+		folders = folders[:n]
+	}
+
+	return folders, nil
+}
+
+// fileStats returns the files of the entries, sorted by path.
+func fileStats(fsEntries []db.FSEntry) FileStats {
+	files := FileStats{}
+
+	for _, fsEntry := range fsEntries {
+		if fsEntry.Kind != db.EntryKindFile {
+			continue
+		}
+
+		files = append(files, FileStat{
+			Path:     filepath.Join(fsEntry.Path, fsEntry.Name),
+			Size:     fsEntry.Size,
+			Modified: fsEntry.Modified,
+			Hash:     fsEntry.Hash,
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	return files
+}
+
+// folderStats returns the folders of the entries with the aggregated size of their files,
+// sorted by path.
+func folderStats(fsEntries []db.FSEntry) FolderStats {
+	folders := map[string]*FolderStat{}
+
+	for _, fsEntry := range fsEntries {
+		if fsEntry.Kind == db.EntryKindFolder {
+			path := filepath.Join(fsEntry.Path, fsEntry.Name)
+			folders[path] = &FolderStat{Path: path}
+		}
+	}
+
+	for _, fsEntry := range fsEntries {
+		if fsEntry.Kind != db.EntryKindFile {
+			continue
+		}
+
+		// the file counts towards each of its ancestors
+		for path := filepath.Clean(fsEntry.Path); ; path = filepath.Dir(path) {
+			if folder, ok := folders[path]; ok {
+				folder.Size += fsEntry.Size
+				folder.FileCount++
+			}
+
+			if path == filepath.Dir(path) {
+				break
+			}
+		}
+	}
+
+	stats := make(FolderStats, 0, len(folders))
+	for _, folder := range folders {
+		stats = append(stats, *folder)
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Path < stats[j].Path })
+
+	return stats
+}
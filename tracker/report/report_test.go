@@ -0,0 +1,141 @@
+package report_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-sdk/tracker/db"
+	"github.com/seborama/pcloud-sdk/tracker/report"
+)
+
+func addEntries(ctx context.Context, t *testing.T, store db.Store, opts []db.Options, fsEntries ...db.FSEntry) {
+	fsEntriesCh, errCh := store.AddNewFileSystemEntries(ctx, opts...)
+	for _, fsEntry := range fsEntries {
+		fsEntriesCh <- fsEntry
+	}
+	close(fsEntriesCh)
+	require.NoError(t, <-errCh)
+}
+
+func folderEntry(id, parentID uint64, path, name string) db.FSEntry {
+	return db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: id, IsFolder: true, Path: path, Name: name, ParentFolderID: parentID, Kind: db.EntryKindFolder}
+}
+
+func fileEntry(id, parentID uint64, path, name string, size uint64, modified time.Time, hash string) db.FSEntry {
+	return db.FSEntry{FSName: "local_fs", DeviceID: "1", EntryID: id, Path: path, Name: name, ParentFolderID: parentID, Modified: modified, Size: size, Hash: hash, Kind: db.EntryKindFile}
+}
+
+func newReporter(ctx context.Context, t *testing.T) *report.Reporter {
+	store := db.NewMemory()
+	t.Cleanup(func() { _ = store.Close() })
+
+	err := store.RegisterFileSystem(ctx, "local_fs", db.FSDriverLocal, "/")
+	require.NoError(t, err)
+
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	root := folderEntry(1, 0, "/", "/")
+	docs := folderEntry(2, 1, "/", "docs")
+	photos := folderEntry(3, 1, "/", "photos")
+	archive := folderEntry(4, 2, "/docs", "archive")
+
+	addEntries(ctx, t, store, nil,
+		root, docs, photos, archive,
+		fileEntry(10, 2, "/docs", "a.txt", 100, old, "h1"),
+		fileEntry(11, 3, "/photos", "b.JPG", 1000, recent, "h2"),
+	)
+
+	err = store.SeedVersionStaging(ctx, "local_fs")
+	require.NoError(t, err)
+
+	addEntries(ctx, t, store, []db.Options{db.WithStagingVersion()},
+		fileEntry(12, 4, "/docs/archive", "a-copy.txt", 100, recent, "h1"),
+		fileEntry(13, 3, "/photos", "c.jpg", 3000, recent, "h3"),
+		fileEntry(14, 1, "/", "README", 10, old, "h4"),
+	)
+
+	err = store.CommitVersionStaging(ctx, "local_fs", db.StagingCommit{})
+	require.NoError(t, err)
+
+	return report.NewReporter(store, "local_fs")
+}
+
+func TestReporter(t *testing.T) {
+	ctx := context.Background()
+	r := newReporter(ctx, t)
+
+	files, err := r.LargestFiles(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	require.Equal(t, "/photos/c.jpg", files[0].Path)
+	require.Equal(t, "/photos/b.JPG", files[1].Path)
+
+	folders, err := r.LargestFolders(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, report.FolderStats{
+		{Path: "/", Size: 4210, FileCount: 5},
+		{Path: "/photos", Size: 4000, FileCount: 2},
+		{Path: "/docs", Size: 200, FileCount: 2},
+		{Path: "/docs/archive", Size: 100, FileCount: 1},
+	}, folders)
+
+	duplicates, err := r.Duplicates(ctx)
+	require.NoError(t, err)
+	require.Equal(t, report.DuplicateGroups{
+		{Hash: "h1", Size: 100, Paths: []string{"/docs/a.txt", "/docs/archive/a-copy.txt"}},
+	}, duplicates)
+	require.EqualValues(t, 100, duplicates[0].Wasted())
+
+	stale, err := r.StaleFiles(ctx, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, stale, 2)
+	require.Equal(t, "/README", stale[0].Path)
+	require.Equal(t, "/docs/a.txt", stale[1].Path)
+
+	extensions, err := r.Extensions(ctx)
+	require.NoError(t, err)
+	require.Equal(t, report.ExtensionStats{
+		{Extension: "jpg", Size: 4000, FileCount: 2},
+		{Extension: "txt", Size: 200, FileCount: 2},
+		{Extension: "", Size: 10, FileCount: 1},
+	}, extensions)
+
+	growths, err := r.Growth(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, report.FolderGrowths{
+		{Path: "/", PreviousSize: 1100, NewSize: 4210},
+		{Path: "/photos", PreviousSize: 1000, NewSize: 4000},
+		{Path: "/docs", PreviousSize: 100, NewSize: 200},
+		{Path: "/docs/archive", PreviousSize: 0, NewSize: 100},
+	}, growths)
+}
+
+func TestRender(t *testing.T) {
+	extensions := report.ExtensionStats{
+		{Extension: "jpg", Size: 4000, FileCount: 2},
+		{Extension: "txt", Size: 200, FileCount: 2},
+	}
+
+	buf := &bytes.Buffer{}
+	err := report.Render(buf, report.FormatTable, extensions)
+	require.NoError(t, err)
+	require.Equal(t, "extension  size  files\njpg        4000  2\ntxt        200   2\n", buf.String())
+
+	buf.Reset()
+	err = report.Render(buf, report.FormatCSV, extensions)
+	require.NoError(t, err)
+	require.Equal(t, "extension,size,files\njpg,4000,2\ntxt,200,2\n", buf.String())
+
+	buf.Reset()
+	err = report.Render(buf, report.FormatJSON, extensions)
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"Extension":"jpg","Size":4000,"FileCount":2},{"Extension":"txt","Size":200,"FileCount":2}]`, buf.String())
+
+	err = report.Render(buf, "xml", extensions)
+	require.Error(t, err)
+}
@@ -2,24 +2,38 @@ package tracker
 
 import (
 	"context"
-	"fmt"
+	"path/filepath"
+	"sort"
 
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
 	"github.com/seborama/pcloud-sdk/tracker/db"
+	"github.com/seborama/pcloud-sdk/tracker/filter"
 )
 
 type storer interface {
 	AddNewFileSystemEntries(ctx context.Context, opts ...db.Options) (chan<- db.FSEntry, <-chan error)
 	GetFileSystemMutations(ctx context.Context, fsName db.FSName) (db.FSMutations, error)
-	DeleteVersionNew(ctx context.Context, fsName db.FSName) error
-	RotateFileSystemVersions(ctx context.Context, fsName db.FSName) error
-	MarkFileSystemAsChanged(ctx context.Context, fsName db.FSName) error
+	GetFileSystemMutationsPage(ctx context.Context, fsName db.FSName, after *db.MutationCursor, limit int) (db.FSMutations, *db.MutationCursor, error)
+	DeleteVersionStaging(ctx context.Context, fsName db.FSName) error
+	CommitVersionStaging(ctx context.Context, fsName db.FSName, commit db.StagingCommit) error
 	GetFileSystemInfo(ctx context.Context, fsName db.FSName) (*db.FSInfo, error)
 	GetSyncDetails(ctx context.Context, fsName db.FSName) (db.FSDriver, string, error)
+	SeedVersionStaging(ctx context.Context, fsName db.FSName) error
+	ApplyStagingFileSystemChanges(ctx context.Context, fsName db.FSName, changes []db.FSChange, filter db.EntryFilter) error
+	GetLatestFileSystemEntries(ctx context.Context, fsName db.FSName) ([]db.FSEntry, error)
+	GetPreviousFileSystemEntries(ctx context.Context, fsName db.FSName) ([]db.FSEntry, error)
+	GetFilterRules(ctx context.Context, fsName db.FSName) (*db.FilterRules, error)
+	GetFileSystemRoots(ctx context.Context, fsName db.FSName) ([]db.FSRoot, error)
+	GetDirtyPaths(ctx context.Context, fsName db.FSName) (*db.DirtyPaths, error)
+	ClearDirtyPaths(ctx context.Context, fsName db.FSName, seq int64) error
+	LockRefresh(ctx context.Context, fsName db.FSName) (func() error, error)
 }
 
+// any db.Store can back a Tracker.
+var _ storer = db.Store(nil)
+
 // Tracker contains the elements necessary to track file system mutations.
 type Tracker struct {
 	logger   *zap.Logger
@@ -49,6 +63,55 @@ type FSDriver interface {
 	Walk(ctx context.Context, fsName db.FSName, path string, fsEntriesCh chan<- db.FSEntry, errCh <-chan error) error
 }
 
+// IncrementalFSDriver is an FSDriver that is able to list the changes made to the file system
+// since a cursor, which is much cheaper than walking the entire file system.
+type IncrementalFSDriver interface {
+	FSDriver
+
+	// Cursor returns the current position of the file system in its stream of changes.
+	Cursor(ctx context.Context) (uint64, error)
+
+	// Changes lists the changes made to the file system since cursor.
+	// The changes may concern entries outside of the tracked path.
+	Changes(ctx context.Context, fsName db.FSName, cursor uint64) (*db.FSChanges, error)
+}
+
+// HashCachingFSDriver is an FSDriver that is able to reuse the hashes of the files that have not
+// changed since they were last hashed.
+type HashCachingFSDriver interface {
+	FSDriver
+
+	// UseHashCache sets the cache of the hashes used by Walk. A nil cache disables it.
+	UseHashCache(cache *db.HashCache)
+}
+
+// FilteringFSDriver is an FSDriver that is able to leave out of its walks the entries excluded
+// by a filter.
+type FilteringFSDriver interface {
+	FSDriver
+	filter.IgnoreFileReader
+
+	// UseFilter sets the filter used by Walk. A nil filter disables it.
+	UseFilter(f *filter.Filter)
+}
+
+// MultiRootFSDriver is an FSDriver that is able to walk a file system made of multiple roots, as
+// one virtual tree.
+type MultiRootFSDriver interface {
+	FSDriver
+
+	// UseRoots sets the roots of the file system. Walk is then passed db.VirtualRootPath and
+	// walks each root as a folder of the virtual root. Nil roots disables them.
+	UseRoots(roots []db.FSRoot)
+}
+
+// ChangesWatcher records the paths of a file system that change, in the store.
+type ChangesWatcher interface {
+	// Watching reports whether the ChangesWatcher is currently recording all the changes of the
+	// file system.
+	Watching() bool
+}
+
 // TODO: the fact that this method returns an interface indicates a problem.
 //
 //	the implementation of this method likely belongs to the sync package, not the tracker.
@@ -62,8 +125,15 @@ func (t *Tracker) GetRootPath(ctx context.Context) (string, error) {
 }
 
 // RefreshFSContents walks the specified file system and saves the new contents as VersionNew.
-// In order to proceed, RefreshFSContents first drops all VersionPrevious entries and moves the
-// current VersionNew entries as VersionPrevious.
+// The contents are first saved as VersionStaging. Once the walk completes, the current VersionNew
+// entries replace the VersionPrevious entries (unless the file system is already marked as
+// changed, in which case VersionPrevious is preserved), the VersionStaging entries become
+// VersionNew and the file system is marked as changed, all at once (see
+// db.SQLite3.CommitVersionStaging). A refresh that fails or is interrupted leaves both versions
+// intact.
+// A single refresh of the file system runs at a time, among all the processes that share the
+// store: RefreshFSContents returns db.ErrLocked when another is in progress.
+// See WithIncrementalRefresh for file systems that support listing their changes.
 func (t *Tracker) RefreshFSContents(ctx context.Context, opts ...RefreshOption) error {
 	cfg := config{
 		entriesChSize: 100,
@@ -73,68 +143,331 @@ func (t *Tracker) RefreshFSContents(ctx context.Context, opts ...RefreshOption)
 		opt(&cfg)
 	}
 
-	fmt.Println("THIS WHOLE METHOD SHOULD BE INSIDE A TRANSACTION FOR DATA CONSISTENCY")
-	err := t.rotateFileSystemVersions(ctx)
+	unlock, err := t.store.LockRefresh(ctx, t.fsName)
 	if err != nil {
 		return err
 	}
+	defer func() { _ = unlock() }()
+
+	if incFS, ok := t.fsDriver.(IncrementalFSDriver); ok && cfg.incremental {
+		return t.refreshFSContentsIncrementally(ctx, cfg, incFS)
+	}
 
-	fsEntriesCh, errCh := t.store.AddNewFileSystemEntries(ctx, db.WithEntriesChannelSize(cfg.entriesChSize))
+	return t.walkFSContents(ctx, cfg)
+}
+
+func (t *Tracker) walkFSContents(ctx context.Context, cfg config) error {
+	var (
+		incFS  IncrementalFSDriver
+		cursor uint64
+		err    error
+	)
 
-	rootPath, err := t.GetRootPath(ctx)
+	if cfg.incremental {
+		incFS, _ = t.fsDriver.(IncrementalFSDriver)
+	}
+
+	if incFS != nil {
+		// changes that take place during the walk will be listed again by the next incremental
+		// refresh, which is harmless.
+		cursor, err = incFS.Cursor(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	rootPath, multiRoot, err := t.useRoots(ctx)
 	if err != nil {
 		return err
 	}
+	if multiRoot {
+		defer t.fsDriver.(MultiRootFSDriver).UseRoots(nil)
+	}
+
+	// the watcher records the paths of the file system, which differ from the virtual paths of the
+	// entries of a file system with multiple roots.
+	hcFS, _ := t.fsDriver.(HashCachingFSDriver)
+	watched := hcFS != nil && cfg.watcher != nil && cfg.watcher.Watching() && !multiRoot
+
+	var dirtySeq int64
+
+	if watched {
+		var cache *db.HashCache
+
+		cache, dirtySeq, err = t.getHashCache(ctx)
+		if err != nil {
+			return err
+		}
+
+		hcFS.UseHashCache(cache)
+		defer hcFS.UseHashCache(nil)
+	}
+
+	fFS, _ := t.fsDriver.(FilteringFSDriver)
+
+	var entriesFilter *filter.Filter
+
+	if fFS != nil {
+		entriesFilter, err = t.newFilter(ctx, rootPath, fFS)
+		if err != nil {
+			return err
+		}
+
+		fFS.UseFilter(entriesFilter)
+		defer fFS.UseFilter(nil)
+	}
+
+	// discard the entries of a previous refresh that did not complete.
+	err = t.store.DeleteVersionStaging(ctx, t.fsName)
+	if err != nil {
+		return err
+	}
+
+	fsEntriesCh, errCh := t.store.AddNewFileSystemEntries(ctx, db.WithEntriesChannelSize(cfg.entriesChSize), db.WithStagingVersion())
 
 	err = t.fsDriver.Walk(ctx, t.fsName, rootPath, fsEntriesCh, errCh)
 	if err != nil {
 		return err
 	}
 
-	err = t.markFileSystemAsChanged(ctx)
+	commit := db.StagingCommit{}
+
+	if entriesFilter != nil {
+		excludedEntries, err := t.findExcludedPreviousEntries(ctx, entriesFilter)
+		if err != nil {
+			return err
+		}
+
+		if len(excludedEntries) > 0 {
+			t.logger.Debug("removing excluded entries from previous version of file system", zap.String("fs_name", string(t.fsName)), zap.Int("entries", len(excludedEntries)))
+			commit.ExcludedPreviousEntries = excludedEntries
+		}
+	}
+
+	if incFS != nil {
+		commit.Cursor = &cursor
+	}
+
+	err = t.commitVersionStaging(ctx, commit)
 	if err != nil {
 		return err
 	}
 
+	// if the watcher stopped during the walk, some changes may have been missed but it will have
+	// recorded that a full rescan is required.
+	// Should the dirty paths not be cleared, they would only be rehashed again.
+	if watched && cfg.watcher.Watching() {
+		err = t.store.ClearDirtyPaths(ctx, t.fsName, dirtySeq)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (t *Tracker) rotateFileSystemVersions(ctx context.Context) error {
+// useRoots sets the roots of the file system on the FSDriver when it has multiple roots, and
+// returns the path to walk.
+func (t *Tracker) useRoots(ctx context.Context) (string, bool, error) {
+	roots, err := t.store.GetFileSystemRoots(ctx, t.fsName)
+	if err != nil {
+		return "", false, err
+	}
+
+	if len(roots) == 0 {
+		rootPath, err := t.GetRootPath(ctx)
+		return rootPath, false, err
+	}
+
+	mrFS, ok := t.fsDriver.(MultiRootFSDriver)
+	if !ok {
+		return "", false, errors.Errorf("file system '%s' has multiple roots but its driver does not support them", t.fsName)
+	}
+
+	mrFS.UseRoots(roots)
+
+	return db.VirtualRootPath, true, nil
+}
+
+// newFilter creates the filter of the entries of the file system, from its filter rules and the
+// ignore files of its folders.
+func (t *Tracker) newFilter(ctx context.Context, rootPath string, reader filter.IgnoreFileReader) (*filter.Filter, error) {
+	rules, err := t.store.GetFilterRules(ctx, t.fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter.New(*rules, rootPath, filter.WithIgnoreFiles(reader))
+}
+
+// findExcludedPreviousEntries returns the entries that will be VersionPrevious once the refresh
+// is committed and that are excluded by entriesFilter, such as after the filter rules changed.
+// They are to be removed from VersionPrevious since, otherwise, being left out of VersionNew, they
+// would appear as deleted.
+func (t *Tracker) findExcludedPreviousEntries(ctx context.Context, entriesFilter *filter.Filter) ([]db.FSEntry, error) {
 	fsInfo, err := t.store.GetFileSystemInfo(ctx, t.fsName)
 	if err != nil {
-		return errors.WithMessage(err, "database error or sync has not been initialised")
+		return nil, err
 	}
 
+	// see db.SQLite3.CommitVersionStaging.
+	getEntries := t.store.GetLatestFileSystemEntries
 	if fsInfo.FSChanged {
-		// The file system is marked as changed. This indicates a sync is pending.
-		// So VersionPrevious should not be modified and we only need to refresh VersionNew
-		// to allow a more up-to-date sync when it takes place.
-		t.logger.Debug("clearing down latest version of file system while preserving previous version intact", zap.String("fs_name", string(t.fsName)))
-		return t.store.DeleteVersionNew(ctx, t.fsName)
+		getEntries = t.store.GetPreviousFileSystemEntries
+	}
+
+	fsEntries, err := getEntries(ctx, t.fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	// parent folders are shorter paths than their contents.
+	sort.Slice(fsEntries, func(i, j int) bool {
+		return len(filepath.Join(fsEntries[i].Path, fsEntries[i].Name)) < len(filepath.Join(fsEntries[j].Path, fsEntries[j].Name))
+	})
+
+	excludedFolders := map[string]struct{}{}
+	excludedEntries := []db.FSEntry{}
+
+	for _, entry := range fsEntries {
+		_, excluded := excludedFolders[entry.Path]
+		if !excluded {
+			excluded, err = entriesFilter.Excluded(ctx, entry)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if !excluded {
+			continue
+		}
+
+		if entry.IsFolder {
+			excludedFolders[filepath.Join(entry.Path, entry.Name)] = struct{}{}
+		}
+		excludedEntries = append(excludedEntries, entry)
+	}
+
+	return excludedEntries, nil
+}
+
+// getHashCache returns the hashes of the files of VersionNew that have not changed since, as
+// recorded by the watcher, along with the sequence number of the last recorded change.
+// The cache is nil when a full rescan is required.
+func (t *Tracker) getHashCache(ctx context.Context) (*db.HashCache, int64, error) {
+	dirtyPaths, err := t.store.GetDirtyPaths(ctx, t.fsName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if dirtyPaths.FullRescan {
+		t.logger.Debug("full rescan of file system required", zap.String("fs_name", string(t.fsName)))
+		return nil, dirtyPaths.Seq, nil
 	}
 
-	// The file system is not marked as changed. We can replace VersionPrevious with the
-	// current VersionNew. After this operation, VersionNew is empty.
-	t.logger.Debug("rotating versions of file system", zap.String("fs_name", string(t.fsName)))
-	return t.store.RotateFileSystemVersions(ctx, t.fsName)
+	// VersionNew is the state of the file system at the time of the last walk, from when the
+	// dirty paths are recorded.
+	fsEntries, err := t.store.GetLatestFileSystemEntries(ctx, t.fsName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	t.logger.Debug("rehashing dirty paths of file system only", zap.String("fs_name", string(t.fsName)), zap.Int("dirty_paths", len(dirtyPaths.Paths)))
+
+	return db.NewHashCache(fsEntries, dirtyPaths.Paths), dirtyPaths.Seq, nil
 }
 
-func (t *Tracker) markFileSystemAsChanged(ctx context.Context) error {
+// refreshFSContentsIncrementally applies the changes of the file system since the last refresh
+// to VersionNew. It falls back to walking the file system entirely when it has not been walked
+// before or when its changes cannot be applied incrementally.
+func (t *Tracker) refreshFSContentsIncrementally(ctx context.Context, cfg config, incFS IncrementalFSDriver) error {
 	fsInfo, err := t.store.GetFileSystemInfo(ctx, t.fsName)
+	if err != nil {
+		return errors.WithMessage(err, "database error or sync has not been initialised")
+	}
+
+	if fsInfo.FSCursor == 0 {
+		t.logger.Debug("no cursor recorded for file system, walking it entirely", zap.String("fs_name", string(t.fsName)))
+		return t.walkFSContents(ctx, cfg)
+	}
+
+	changes, err := incFS.Changes(ctx, t.fsName, fsInfo.FSCursor)
+	if err != nil {
+		return err
+	}
+
+	if changes.Reset {
+		t.logger.Info("file system requested a resync, walking it entirely", zap.String("fs_name", string(t.fsName)))
+		return t.walkFSContents(ctx, cfg)
+	}
+
+	var entryFilter db.EntryFilter
+
+	if fFS, ok := t.fsDriver.(FilteringFSDriver); ok {
+		for _, change := range changes.Changes {
+			if change.Entry.Name == filter.IgnoreFileName {
+				t.logger.Info("ignore file changed, walking file system entirely", zap.String("fs_name", string(t.fsName)))
+				return t.walkFSContents(ctx, cfg)
+			}
+		}
+
+		rootPath, err := t.GetRootPath(ctx)
+		if err != nil {
+			return err
+		}
+
+		entriesFilter, err := t.newFilter(ctx, rootPath, fFS)
+		if err != nil {
+			return err
+		}
+
+		entryFilter = entriesFilter
+	}
+
+	// As per walkFSContents, except that VersionStaging starts from the current state of the
+	// file system rather than empty.
+	err = t.store.SeedVersionStaging(ctx, t.fsName)
 	if err != nil {
 		return err
 	}
 
+	t.logger.Debug("applying changes to file system", zap.String("fs_name", string(t.fsName)), zap.Int("changes", len(changes.Changes)))
+	err = t.store.ApplyStagingFileSystemChanges(ctx, t.fsName, changes.Changes, entryFilter)
+	if err != nil {
+		if errors.Is(err, db.ErrResyncRequired) {
+			t.logger.Info("unable to apply changes incrementally, walking file system entirely", zap.String("fs_name", string(t.fsName)), zap.Error(err))
+			return t.walkFSContents(ctx, cfg)
+		}
+		return err
+	}
+
+	return t.commitVersionStaging(ctx, db.StagingCommit{Cursor: &changes.Cursor})
+}
+
+func (t *Tracker) commitVersionStaging(ctx context.Context, commit db.StagingCommit) error {
+	fsInfo, err := t.store.GetFileSystemInfo(ctx, t.fsName)
+	if err != nil {
+		return errors.WithMessage(err, "database error or sync has not been initialised")
+	}
+
 	if fsInfo.FSChanged {
-		t.logger.Warn("state of file system is already marked as 'changed'", zap.String("fs_name", string(t.fsName)))
-		return nil
+		// The file system is marked as changed. This indicates a sync is pending.
+		// So VersionPrevious is not modified and only VersionNew is replaced, to allow a more
+		// up-to-date sync when it takes place.
+		t.logger.Debug("replacing latest version of file system while preserving previous version intact", zap.String("fs_name", string(t.fsName)))
+	} else {
+		// The file system is not marked as changed. VersionPrevious is replaced with the
+		// current VersionNew.
+		t.logger.Debug("rotating versions of file system", zap.String("fs_name", string(t.fsName)))
 	}
 
-	return t.store.MarkFileSystemAsChanged(ctx, t.fsName)
+	return t.store.CommitVersionStaging(ctx, t.fsName, commit)
 }
 
 type config struct {
 	entriesChSize int
+	incremental   bool
+	watcher       ChangesWatcher
 }
 
 type RefreshOption func(*config)
@@ -147,6 +480,25 @@ func WithEntriesChannelSize(n int) RefreshOption {
 	}
 }
 
+// WithDirtyPaths is a functional parameter that makes RefreshFSContents only rehash the files
+// that have changed since the last refresh, as recorded by watcher.
+// All files are hashed when the watcher is not running or when it missed some changes.
+// It has no effect when the FSDriver is not a HashCachingFSDriver.
+func WithDirtyPaths(watcher ChangesWatcher) RefreshOption {
+	return func(obj *config) {
+		obj.watcher = watcher
+	}
+}
+
+// WithIncrementalRefresh is a functional parameter that makes RefreshFSContents apply the changes
+// of the file system since the last refresh, rather than walk it entirely.
+// It has no effect when the FSDriver is not an IncrementalFSDriver.
+func WithIncrementalRefresh() RefreshOption {
+	return func(obj *config) {
+		obj.incremental = true
+	}
+}
+
 // ListMutations finds all mutations that have taken place in the file system between
 // VersionPrevious and VersionNew.
 func (t *Tracker) ListMutations(ctx context.Context) (db.FSMutations, error) {
@@ -156,3 +508,57 @@ func (t *Tracker) ListMutations(ctx context.Context) (db.FSMutations, error) {
 	}
 	return fsMutations, nil
 }
+
+// DefaultMutationsPageSize is the number of mutations that StreamMutations reads from the
+// database at a time, unless told otherwise.
+const DefaultMutationsPageSize = 1000
+
+// StreamMutations sends the mutations of the file system on the first returned channel, in the
+// order of ListMutations, reading them from the database one page of pageSize mutations at a
+// time so that they need not all be held in memory. It starts after the mutation at the cursor
+// after, or from the first mutation when after is nil: the cursor of each mutation (see
+// db.FSMutation.Cursor) can be persisted to resume later.
+// The mutations channel is closed once all the mutations have been sent, after which the outcome
+// is sent on the error channel. Cancel ctx to stop early.
+func (t *Tracker) StreamMutations(ctx context.Context, after *db.MutationCursor, pageSize int) (<-chan db.FSMutation, <-chan error) {
+	if pageSize <= 0 {
+		pageSize = DefaultMutationsPageSize
+	}
+
+	mutationsCh := make(chan db.FSMutation, pageSize)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(errCh)
+
+		err := t.streamMutations(ctx, after, pageSize, mutationsCh)
+		close(mutationsCh)
+
+		errCh <- err
+	}()
+
+	return mutationsCh, errCh
+}
+
+func (t *Tracker) streamMutations(ctx context.Context, cursor *db.MutationCursor, pageSize int, mutationsCh chan<- db.FSMutation) error {
+	for {
+		fsMutations, next, err := t.store.GetFileSystemMutationsPage(ctx, t.fsName, cursor, pageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, fsMutation := range fsMutations {
+			select {
+			case <-ctx.Done():
+				return errors.WithStack(ctx.Err())
+			case mutationsCh <- fsMutation:
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+
+		cursor = next
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/seborama/pcloud-sdk/tracker/db"
+	"github.com/seborama/pcloud-sdk/tracker/filter"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -21,18 +22,18 @@ func (m *StorerMock) GetFileSystemMutations(ctx context.Context, fsName db.FSNam
 	return args.Get(0).(db.FSMutations), args.Error(1)
 }
 
-func (m *StorerMock) DeleteVersionNew(ctx context.Context, fsName db.FSName) error {
-	args := m.Called(ctx, fsName)
-	return args.Error(0)
+func (m *StorerMock) GetFileSystemMutationsPage(ctx context.Context, fsName db.FSName, after *db.MutationCursor, limit int) (db.FSMutations, *db.MutationCursor, error) {
+	args := m.Called(ctx, fsName, after, limit)
+	return args.Get(0).(db.FSMutations), args.Get(1).(*db.MutationCursor), args.Error(2)
 }
 
-func (m *StorerMock) RotateFileSystemVersions(ctx context.Context, fsName db.FSName) error {
+func (m *StorerMock) DeleteVersionStaging(ctx context.Context, fsName db.FSName) error {
 	args := m.Called(ctx, fsName)
 	return args.Error(0)
 }
 
-func (m *StorerMock) MarkFileSystemAsChanged(ctx context.Context, fsName db.FSName) error {
-	args := m.Called(ctx, fsName)
+func (m *StorerMock) CommitVersionStaging(ctx context.Context, fsName db.FSName, commit db.StagingCommit) error {
+	args := m.Called(ctx, fsName, commit)
 	return args.Error(0)
 }
 
@@ -45,3 +46,117 @@ func (m *StorerMock) GetSyncDetails(ctx context.Context, fsName db.FSName) (db.F
 	args := m.Called(ctx, fsName)
 	return args.Get(0).(db.FSDriver), args.String(1), args.Error(2)
 }
+
+func (m *StorerMock) SeedVersionStaging(ctx context.Context, fsName db.FSName) error {
+	args := m.Called(ctx, fsName)
+	return args.Error(0)
+}
+
+func (m *StorerMock) ApplyStagingFileSystemChanges(ctx context.Context, fsName db.FSName, changes []db.FSChange, filter db.EntryFilter) error {
+	args := m.Called(ctx, fsName, changes, filter)
+	return args.Error(0)
+}
+
+func (m *StorerMock) GetPreviousFileSystemEntries(ctx context.Context, fsName db.FSName) ([]db.FSEntry, error) {
+	args := m.Called(ctx, fsName)
+	return args.Get(0).([]db.FSEntry), args.Error(1)
+}
+
+func (m *StorerMock) GetFilterRules(ctx context.Context, fsName db.FSName) (*db.FilterRules, error) {
+	args := m.Called(ctx, fsName)
+	return args.Get(0).(*db.FilterRules), args.Error(1)
+}
+
+func (m *StorerMock) GetFileSystemRoots(ctx context.Context, fsName db.FSName) ([]db.FSRoot, error) {
+	args := m.Called(ctx, fsName)
+	return args.Get(0).([]db.FSRoot), args.Error(1)
+}
+
+func (m *StorerMock) LockRefresh(ctx context.Context, fsName db.FSName) (func() error, error) {
+	args := m.Called(ctx, fsName)
+	return args.Get(0).(func() error), args.Error(1)
+}
+
+type IncrementalFSDriverMock struct {
+	mock.Mock
+}
+
+func (m *IncrementalFSDriverMock) Walk(ctx context.Context, fsName db.FSName, path string, fsEntriesCh chan<- db.FSEntry, errCh <-chan error) error {
+	args := m.Called(ctx, fsName, path, fsEntriesCh, errCh)
+	return args.Error(0)
+}
+
+func (m *IncrementalFSDriverMock) Cursor(ctx context.Context) (uint64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(uint64), args.Error(1)
+}
+
+func (m *IncrementalFSDriverMock) Changes(ctx context.Context, fsName db.FSName, cursor uint64) (*db.FSChanges, error) {
+	args := m.Called(ctx, fsName, cursor)
+	return args.Get(0).(*db.FSChanges), args.Error(1)
+}
+
+func (m *StorerMock) GetLatestFileSystemEntries(ctx context.Context, fsName db.FSName) ([]db.FSEntry, error) {
+	args := m.Called(ctx, fsName)
+	return args.Get(0).([]db.FSEntry), args.Error(1)
+}
+
+func (m *StorerMock) GetDirtyPaths(ctx context.Context, fsName db.FSName) (*db.DirtyPaths, error) {
+	args := m.Called(ctx, fsName)
+	return args.Get(0).(*db.DirtyPaths), args.Error(1)
+}
+
+func (m *StorerMock) ClearDirtyPaths(ctx context.Context, fsName db.FSName, seq int64) error {
+	args := m.Called(ctx, fsName, seq)
+	return args.Error(0)
+}
+
+type HashCachingFSDriverMock struct {
+	mock.Mock
+}
+
+func (m *HashCachingFSDriverMock) Walk(ctx context.Context, fsName db.FSName, path string, fsEntriesCh chan<- db.FSEntry, errCh <-chan error) error {
+	args := m.Called(ctx, fsName, path, fsEntriesCh, errCh)
+	return args.Error(0)
+}
+
+func (m *HashCachingFSDriverMock) UseHashCache(cache *db.HashCache) {
+	m.Called(cache)
+}
+
+type changesWatcherStub bool
+
+func (w changesWatcherStub) Watching() bool {
+	return bool(w)
+}
+
+type FilteringFSDriverMock struct {
+	mock.Mock
+}
+
+func (m *FilteringFSDriverMock) Walk(ctx context.Context, fsName db.FSName, path string, fsEntriesCh chan<- db.FSEntry, errCh <-chan error) error {
+	args := m.Called(ctx, fsName, path, fsEntriesCh, errCh)
+	return args.Error(0)
+}
+
+func (m *FilteringFSDriverMock) ReadIgnoreFile(ctx context.Context, dir string) ([]byte, error) {
+	args := m.Called(ctx, dir)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *FilteringFSDriverMock) UseFilter(f *filter.Filter) {
+	m.Called(f)
+}
+
+type MultiRootFSDriverMock struct {
+	mock.Mock
+}
+
+func (m *MultiRootFSDriverMock) Walk(ctx context.Context, fsName db.FSName, path string, fsEntriesCh chan<- db.FSEntry, errCh <-chan error) error {
+	args := m.Called(ctx, fsName, path, fsEntriesCh, errCh)
+	return args.Error(0)
+}
+
+func (m *MultiRootFSDriverMock) UseRoots(roots []db.FSRoot) {
+	m.Called(roots)
+}
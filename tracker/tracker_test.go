@@ -2,6 +2,7 @@ package tracker_test
 
 import (
 	"context"
+	"errors"
 	"os"
 	"sort"
 	"testing"
@@ -14,6 +15,7 @@ import (
 
 	"github.com/seborama/pcloud-sdk/tracker"
 	"github.com/seborama/pcloud-sdk/tracker/db"
+	"github.com/seborama/pcloud-sdk/tracker/filter"
 )
 
 type IntegrationTestSuite struct {
@@ -510,6 +512,273 @@ func (testsuite *IntegrationTestSuite) TestFindMutations_NoChanges() {
 }
 
 // nolint: gocritic
+func (testsuite *IntegrationTestSuite) TestApplyFileSystemChanges() {
+	time1 := time.Now().Add(-24 * time.Hour)
+	time4 := time.Now().Add(-21 * time.Hour)
+	time5 := time.Now().Add(-20 * time.Hour)
+	time6 := time.Now().Add(-19 * time.Hour)
+	time7 := time.Now().Add(-18 * time.Hour)
+	time8 := time.Now().Add(-17 * time.Hour)
+
+	fse1 := fsEntrySample1(time1, time4, time5, time6, time7)
+
+	testsuite.addNewFileSystemEntries(fse1, nil)
+
+	err := testsuite.store.RotateFileSystemVersions(testsuite.ctx, "some_fs")
+	testsuite.Require().NoError(err)
+
+	err = testsuite.store.SeedVersionNew(testsuite.ctx, "some_fs")
+	testsuite.Require().NoError(err)
+
+	changes := []db.FSChange{
+		{
+			// create Folder4 in Folder2
+			Type:  db.FSChangeTypeCreated,
+			Entry: db.FSEntry{EntryID: 40001, IsFolder: true, Name: "Folder4", ParentFolderID: 20001, Created: time8, Modified: time8},
+		},
+		{
+			// create File4 in Folder4
+			Type:  db.FSChangeTypeCreated,
+			Entry: db.FSEntry{EntryID: 40002, Name: "File4", ParentFolderID: 40001, Created: time8, Modified: time8, Size: 1, Hash: "40002"},
+		},
+		{
+			// rename Folder2 to Folder2b: its contents must follow
+			Type:  db.FSChangeTypeModified,
+			Entry: db.FSEntry{EntryID: 20001, IsFolder: true, Name: "Folder2b", ParentFolderID: 0, Created: time4, Modified: time8},
+		},
+		{
+			// File2 is replaced with a new file
+			Type:            db.FSChangeTypeCreated,
+			Entry:           db.FSEntry{EntryID: 20003, Name: "File2", ParentFolderID: 20001, Created: time8, Modified: time8, Size: 2, Hash: "20003"},
+			ReplacedEntryID: 20002,
+		},
+		{
+			// move File000 to Folder4
+			Type:  db.FSChangeTypeModified,
+			Entry: db.FSEntry{EntryID: 1000003, Name: "File000", ParentFolderID: 40001, Created: time7, Modified: time7, Size: 456, Hash: "9876543210101000003"},
+		},
+		{
+			Type:  db.FSChangeTypeDeleted,
+			Entry: db.FSEntry{EntryID: 30001, IsFolder: true, Name: "Folder3", ParentFolderID: 0},
+		},
+		{
+			// outside of the file system: ignored
+			Type:  db.FSChangeTypeCreated,
+			Entry: db.FSEntry{EntryID: 90001, Name: "File9", ParentFolderID: 99999, Created: time8, Modified: time8},
+		},
+	}
+
+	err = testsuite.store.ApplyFileSystemChanges(testsuite.ctx, "some_fs", changes, 123, nil)
+	testsuite.Require().NoError(err)
+
+	expected := []db.FSEntry{
+		{FSName: "some_fs", EntryID: 0, IsFolder: true, Path: "/", Name: "/", ParentFolderID: 0, Created: time1, Modified: time1},
+		{FSName: "some_fs", EntryID: 20001, IsFolder: true, Path: "/", Name: "Folder2b", ParentFolderID: 0, Created: time4, Modified: time8},
+		{FSName: "some_fs", EntryID: 20003, Path: "/Folder2b", Name: "File2", ParentFolderID: 20001, Created: time8, Modified: time8, Size: 2, Hash: "20003"},
+		{FSName: "some_fs", EntryID: 40001, IsFolder: true, Path: "/Folder2b", Name: "Folder4", ParentFolderID: 20001, Created: time8, Modified: time8},
+		{FSName: "some_fs", EntryID: 40002, Path: "/Folder2b/Folder4", Name: "File4", ParentFolderID: 40001, Created: time8, Modified: time8, Size: 1, Hash: "40002"},
+		{FSName: "some_fs", EntryID: 1000003, Path: "/Folder2b/Folder4", Name: "File000", ParentFolderID: 40001, Created: time7, Modified: time7, Size: 456, Hash: "9876543210101000003"},
+	}
+
+	fsEntries, err := testsuite.store.GetLatestFileSystemEntries(testsuite.ctx, "some_fs")
+	testsuite.Require().NoError(err)
+
+	sort.Slice(fsEntries, func(i, j int) bool { return fsEntries[i].EntryID < fsEntries[j].EntryID })
+	if d := cmp.Diff(expected, fsEntries, cmpopts.IgnoreUnexported()); d != "" {
+		testsuite.Fail(d)
+	}
+
+	err = testsuite.store.MarkFileSystemAsChanged(testsuite.ctx, "some_fs")
+	testsuite.Require().NoError(err)
+
+	fsMutations, err := testsuite.tracker.ListMutations(testsuite.ctx)
+	testsuite.Require().NoError(err)
+
+	mutationTypes := map[uint64]db.MutationType{}
+	for _, fsm := range fsMutations {
+		mutationTypes[fsm.Details[0].EntryID] = fsm.Type
+	}
+
+	testsuite.Equal(
+		map[uint64]db.MutationType{
+			20001:   db.MutationTypeMoved, // renamed: its contents are not reported
+			20002:   db.MutationTypeDeleted,
+			20003:   db.MutationTypeCreated,
+			30001:   db.MutationTypeDeleted,
+			40001:   db.MutationTypeCreated,
+			40002:   db.MutationTypeCreated,
+			1000003: db.MutationTypeMoved,
+		},
+		mutationTypes,
+	)
+}
+
+func (testsuite *IntegrationTestSuite) TestApplyFileSystemChanges_Filter() {
+	time1 := time.Now().Add(-24 * time.Hour)
+	time4 := time.Now().Add(-21 * time.Hour)
+	time5 := time.Now().Add(-20 * time.Hour)
+	time6 := time.Now().Add(-19 * time.Hour)
+	time7 := time.Now().Add(-18 * time.Hour)
+	time8 := time.Now().Add(-17 * time.Hour)
+
+	fse1 := fsEntrySample1(time1, time4, time5, time6, time7)
+
+	testsuite.addNewFileSystemEntries(fse1, nil)
+
+	err := testsuite.store.RotateFileSystemVersions(testsuite.ctx, "some_fs")
+	testsuite.Require().NoError(err)
+
+	err = testsuite.store.SeedVersionNew(testsuite.ctx, "some_fs")
+	testsuite.Require().NoError(err)
+
+	changes := []db.FSChange{
+		{
+			// excluded by pattern
+			Type:  db.FSChangeTypeCreated,
+			Entry: db.FSEntry{EntryID: 40001, Name: "File4.tmp", ParentFolderID: 20001, Created: time8, Modified: time8, Size: 1, Hash: "40001"},
+		},
+		{
+			// excluded once it has grown too large: it is no longer tracked
+			Type:  db.FSChangeTypeModified,
+			Entry: db.FSEntry{EntryID: 1000003, Name: "File000", ParentFolderID: 0, Created: time7, Modified: time8, Size: 4_560, Hash: "1000003"},
+		},
+		{
+			Type:  db.FSChangeTypeCreated,
+			Entry: db.FSEntry{EntryID: 40002, Name: "File5", ParentFolderID: 20001, Created: time8, Modified: time8, Size: 1, Hash: "40002"},
+		},
+	}
+
+	f, err := filter.New(db.FilterRules{Patterns: []string{"*.tmp"}, MaxSize: 1_000}, "/")
+	testsuite.Require().NoError(err)
+
+	err = testsuite.store.ApplyFileSystemChanges(testsuite.ctx, "some_fs", changes, 123, f)
+	testsuite.Require().NoError(err)
+
+	fsEntries, err := testsuite.store.GetLatestFileSystemEntries(testsuite.ctx, "some_fs")
+	testsuite.Require().NoError(err)
+
+	entryIDs := []uint64{}
+	for _, fsEntry := range fsEntries {
+		entryIDs = append(entryIDs, fsEntry.EntryID)
+	}
+	testsuite.ElementsMatch([]uint64{0, 20001, 20002, 30001, 40002}, entryIDs)
+}
+
+func (testsuite *IntegrationTestSuite) TestApplyFileSystemChanges_FolderMovedIn() {
+	time1 := time.Now().Add(-24 * time.Hour)
+	time4 := time.Now().Add(-21 * time.Hour)
+	time5 := time.Now().Add(-20 * time.Hour)
+	time6 := time.Now().Add(-19 * time.Hour)
+	time7 := time.Now().Add(-18 * time.Hour)
+
+	fse1 := fsEntrySample1(time1, time4, time5, time6, time7)
+
+	testsuite.addNewFileSystemEntries(fse1, nil)
+
+	changes := []db.FSChange{
+		{
+			Type:  db.FSChangeTypeDeleted,
+			Entry: db.FSEntry{EntryID: 1000003, Name: "File000", ParentFolderID: 0},
+		},
+		{
+			// the contents of the folder are unknown
+			Type:  db.FSChangeTypeModified,
+			Entry: db.FSEntry{EntryID: 70001, IsFolder: true, Name: "Folder7", ParentFolderID: 30001, Created: time7, Modified: time7},
+		},
+	}
+
+	err := testsuite.store.ApplyFileSystemChanges(testsuite.ctx, "some_fs", changes, 123, nil)
+	testsuite.Require().ErrorIs(err, db.ErrResyncRequired)
+
+	// no change was applied
+	fsEntries, err := testsuite.store.GetLatestFileSystemEntries(testsuite.ctx, "some_fs")
+	testsuite.Require().NoError(err)
+	testsuite.Len(fsEntries, len(fse1))
+}
+
+func (testsuite *IntegrationTestSuite) TestRefreshFSContents_Interrupted() {
+	time1 := time.Now().Add(-24 * time.Hour)
+	time4 := time.Now().Add(-21 * time.Hour)
+	time5 := time.Now().Add(-20 * time.Hour)
+	time6 := time.Now().Add(-19 * time.Hour)
+	time7 := time.Now().Add(-18 * time.Hour)
+
+	fse1 := fsEntrySample1(time1, time4, time5, time6, time7)
+
+	err := testsuite.store.RegisterFileSystem(testsuite.ctx, "some_fs", db.FSDriverPCloud, "/")
+	testsuite.Require().NoError(err)
+
+	track, err := tracker.NewTracker(testsuite.ctx, zap.NewNop(), testsuite.store, walkerStub{fsEntries: fse1}, "some_fs")
+	testsuite.Require().NoError(err)
+
+	err = track.RefreshFSContents(testsuite.ctx)
+	testsuite.Require().NoError(err)
+
+	fsEntries, err := testsuite.store.GetLatestFileSystemEntries(testsuite.ctx, "some_fs")
+	testsuite.Require().NoError(err)
+	testsuite.Len(fsEntries, len(fse1))
+
+	fsMutations, err := track.ListMutations(testsuite.ctx)
+	testsuite.Require().NoError(err)
+	testsuite.Len(fsMutations, len(fse1))
+
+	// the walk fails part way through: neither version is affected.
+	track, err = tracker.NewTracker(testsuite.ctx, zap.NewNop(), testsuite.store, walkerStub{fsEntries: fse1[:2], err: errors.New("walk interrupted")}, "some_fs")
+	testsuite.Require().NoError(err)
+
+	err = track.RefreshFSContents(testsuite.ctx)
+	testsuite.Require().EqualError(err, "walk interrupted")
+
+	fsEntries, err = testsuite.store.GetLatestFileSystemEntries(testsuite.ctx, "some_fs")
+	testsuite.Require().NoError(err)
+	testsuite.Len(fsEntries, len(fse1))
+
+	fsEntries, err = testsuite.store.GetPreviousFileSystemEntries(testsuite.ctx, "some_fs")
+	testsuite.Require().NoError(err)
+	testsuite.Empty(fsEntries)
+
+	fsMutations, err = track.ListMutations(testsuite.ctx)
+	testsuite.Require().NoError(err)
+	testsuite.Len(fsMutations, len(fse1))
+
+	fsInfo, err := testsuite.store.GetFileSystemInfo(testsuite.ctx, "some_fs")
+	testsuite.Require().NoError(err)
+	testsuite.True(fsInfo.FSChanged)
+
+	// the entries of the failed walk are discarded on recovery.
+	fsNames, err := testsuite.store.RecoverIncompleteRefreshes(testsuite.ctx)
+	testsuite.Require().NoError(err)
+	testsuite.Equal([]db.FSName{"some_fs"}, fsNames)
+
+	fsNames, err = testsuite.store.RecoverIncompleteRefreshes(testsuite.ctx)
+	testsuite.Require().NoError(err)
+	testsuite.Empty(fsNames)
+}
+
+// walkerStub is an FSDriver that walks fsEntries, and then fails with err if it is not nil.
+type walkerStub struct {
+	fsEntries []db.FSEntry
+	err       error
+}
+
+func (w walkerStub) Walk(ctx context.Context, fsName db.FSName, path string, fsEntriesCh chan<- db.FSEntry, errCh <-chan error) error {
+	for _, fsEntry := range w.fsEntries {
+		select {
+		case err := <-errCh:
+			close(fsEntriesCh)
+			return err
+		case fsEntriesCh <- fsEntry:
+		}
+	}
+	close(fsEntriesCh)
+
+	if err := <-errCh; err != nil {
+		return err
+	}
+
+	return w.err
+}
+
 func (testsuite *IntegrationTestSuite) addNewFileSystemEntries(fse []db.FSEntry, fn func(e db.FSEntry) db.FSEntry) {
 	if fn == nil {
 		fn = func(e db.FSEntry) db.FSEntry { return e }
@@ -5,12 +5,147 @@ import (
 	"testing"
 
 	"github.com/seborama/pcloud-sdk/tracker/db"
+	"github.com/seborama/pcloud-sdk/tracker/filter"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
-func TestTracker_rotateFileSystemVersions_FSNotChanged(t *testing.T) {
-	t.Log("mock the 'store' and write a separate db test, if one does not already exist")
+func TestTracker_RefreshFSContents_Incremental(t *testing.T) {
+	ctx := context.Background()
+
+	const fsName db.FSName = "some_fs"
+
+	fsInfo := &db.FSInfo{
+		FSName:    fsName,
+		FSDriver:  db.FSDriverPCloud,
+		FSRoot:    "/",
+		FSChanged: false,
+		FSCursor:  100,
+	}
+
+	changes := &db.FSChanges{
+		Changes: []db.FSChange{
+			{
+				Type:  db.FSChangeTypeCreated,
+				Entry: db.FSEntry{FSName: fsName, EntryID: 40001, IsFolder: true, Name: "Folder4"},
+			},
+		},
+		Cursor: 105,
+	}
+
+	sqlDB := &StorerMock{}
+	defer sqlDB.AssertExpectations(t)
+
+	sqlDB.On("LockRefresh", ctx, fsName).
+		Return(func() error { return nil }, nil).
+		Once()
+
+	sqlDB.On("GetFileSystemInfo", ctx, fsName).
+		Return(fsInfo, nil).
+		Twice().
+		On("SeedVersionStaging", ctx, fsName).
+		Return(nil).
+		Once().
+		On("ApplyStagingFileSystemChanges", ctx, fsName, changes.Changes, nil).
+		Return(nil).
+		Once().
+		On("CommitVersionStaging", ctx, fsName, db.StagingCommit{Cursor: &changes.Cursor}).
+		Return(nil).
+		Once()
+
+	fsDriver := &IncrementalFSDriverMock{}
+	defer fsDriver.AssertExpectations(t)
+
+	fsDriver.On("Changes", ctx, fsName, uint64(100)).
+		Return(changes, nil).
+		Once()
+
+	tr := &Tracker{
+		logger:   zap.NewNop(),
+		store:    sqlDB,
+		fsDriver: fsDriver,
+		fsName:   fsName,
+	}
+
+	err := tr.RefreshFSContents(ctx, WithIncrementalRefresh())
+	require.NoError(t, err)
+}
+
+func TestTracker_RefreshFSContents_IncrementalReset(t *testing.T) {
+	ctx := context.Background()
+
+	const fsName db.FSName = "some_fs"
+
+	fsInfo := &db.FSInfo{
+		FSName:    fsName,
+		FSDriver:  db.FSDriverPCloud,
+		FSRoot:    "/",
+		FSChanged: false,
+		FSCursor:  100,
+	}
+
+	cursor := uint64(110)
+
+	entriesCh := make(chan db.FSEntry)
+	errCh := make(chan error, 1)
+	errCh <- nil
+
+	sqlDB := &StorerMock{}
+	defer sqlDB.AssertExpectations(t)
+
+	sqlDB.On("LockRefresh", ctx, fsName).
+		Return(func() error { return nil }, nil).
+		Once()
+
+	sqlDB.On("GetFileSystemInfo", ctx, fsName).
+		Return(fsInfo, nil).
+		Twice().
+		On("DeleteVersionStaging", ctx, fsName).
+		Return(nil).
+		Once().
+		On("AddNewFileSystemEntries", ctx, mock.Anything).
+		Return((chan<- db.FSEntry)(entriesCh), (<-chan error)(errCh)).
+		Once().
+		On("GetFileSystemRoots", ctx, fsName).
+		Return([]db.FSRoot{}, nil).
+		Once().
+		On("GetSyncDetails", ctx, fsName).
+		Return(db.FSDriverPCloud, "/", nil).
+		Once().
+		On("CommitVersionStaging", ctx, fsName, db.StagingCommit{Cursor: &cursor}).
+		Return(nil).
+		Once()
+
+	fsDriver := &IncrementalFSDriverMock{}
+	defer fsDriver.AssertExpectations(t)
+
+	fsDriver.On("Changes", ctx, fsName, uint64(100)).
+		Return(&db.FSChanges{Cursor: 101, Reset: true}, nil).
+		Once().
+		On("Cursor", ctx).
+		Return(cursor, nil).
+		Once().
+		On("Walk", ctx, fsName, "/", (chan<- db.FSEntry)(entriesCh), (<-chan error)(errCh)).
+		Run(func(args mock.Arguments) {
+			close(args.Get(3).(chan<- db.FSEntry))
+			<-args.Get(4).(<-chan error)
+		}).
+		Return(nil).
+		Once()
+
+	tr := &Tracker{
+		logger:   zap.NewNop(),
+		store:    sqlDB,
+		fsDriver: fsDriver,
+		fsName:   fsName,
+	}
+
+	err := tr.RefreshFSContents(ctx, WithIncrementalRefresh())
+	require.NoError(t, err)
+}
+
+func TestTracker_RefreshFSContents_DirtyPaths(t *testing.T) {
 	ctx := context.Background()
 
 	const fsName db.FSName = "some_fs"
@@ -22,29 +157,76 @@ func TestTracker_rotateFileSystemVersions_FSNotChanged(t *testing.T) {
 		FSChanged: false,
 	}
 
+	fsEntries := []db.FSEntry{
+		{FSName: fsName, Path: "/tmp", Name: "File1", Hash: "hash1"},
+	}
+
+	entriesCh := make(chan db.FSEntry)
+	errCh := make(chan error, 1)
+	errCh <- nil
+
 	sqlDB := &StorerMock{}
 	defer sqlDB.AssertExpectations(t)
 
-	sqlDB.On("GetFileSystemInfo", ctx, fsName).
+	sqlDB.On("LockRefresh", ctx, fsName).
+		Return(func() error { return nil }, nil).
+		Once()
+
+	sqlDB.On("GetDirtyPaths", ctx, fsName).
+		Return(&db.DirtyPaths{Paths: []string{"/tmp/File2"}, Seq: 7}, nil).
+		Once().
+		On("GetLatestFileSystemEntries", ctx, fsName).
+		Return(fsEntries, nil).
+		Once().
+		On("GetFileSystemInfo", ctx, fsName).
 		Return(fsInfo, nil).
 		Once().
-		On("RotateFileSystemVersions", ctx, fsName).
+		On("DeleteVersionStaging", ctx, fsName).
 		Return(nil).
+		Once().
+		On("AddNewFileSystemEntries", ctx, mock.Anything).
+		Return((chan<- db.FSEntry)(entriesCh), (<-chan error)(errCh)).
+		Once().
+		On("GetFileSystemRoots", ctx, fsName).
+		Return([]db.FSRoot{}, nil).
+		Once().
+		On("GetSyncDetails", ctx, fsName).
+		Return(db.FSDriverLocal, "/tmp", nil).
+		Once().
+		On("CommitVersionStaging", ctx, fsName, db.StagingCommit{}).
+		Return(nil).
+		Once().
+		On("ClearDirtyPaths", ctx, fsName, int64(7)).
+		Return(nil).
+		Once()
+
+	fsDriver := &HashCachingFSDriverMock{}
+	defer fsDriver.AssertExpectations(t)
+
+	fsDriver.On("UseHashCache", db.NewHashCache(fsEntries, []string{"/tmp/File2"})).
+		Once().
+		On("Walk", ctx, fsName, "/tmp", (chan<- db.FSEntry)(entriesCh), (<-chan error)(errCh)).
+		Run(func(args mock.Arguments) {
+			close(args.Get(3).(chan<- db.FSEntry))
+			<-args.Get(4).(<-chan error)
+		}).
+		Return(nil).
+		Once().
+		On("UseHashCache", (*db.HashCache)(nil)).
 		Once()
 
 	tr := &Tracker{
 		logger:   zap.NewNop(),
 		store:    sqlDB,
-		fsDriver: nil,
+		fsDriver: fsDriver,
 		fsName:   fsName,
 	}
 
-	err := tr.rotateFileSystemVersions(ctx)
+	err := tr.RefreshFSContents(ctx, WithDirtyPaths(changesWatcherStub(true)))
 	require.NoError(t, err)
 }
 
-func TestTracker_rotateFileSystemVersions_FSChanged(t *testing.T) {
-	t.Log("mock the 'store' and write a separate db test, if one does not already exist")
+func TestTracker_RefreshFSContents_Filter(t *testing.T) {
 	ctx := context.Background()
 
 	const fsName db.FSName = "some_fs"
@@ -56,23 +238,221 @@ func TestTracker_rotateFileSystemVersions_FSChanged(t *testing.T) {
 		FSChanged: true,
 	}
 
+	previousEntries := []db.FSEntry{
+		{FSName: fsName, EntryID: 1, IsFolder: true, Path: "/", Name: "tmp"},
+		{FSName: fsName, EntryID: 2, Path: "/tmp", Name: "File1"},
+		{FSName: fsName, EntryID: 3, IsFolder: true, Path: "/tmp", Name: "node_modules"},
+		{FSName: fsName, EntryID: 4, Path: "/tmp/node_modules", Name: "File2"},
+		{FSName: fsName, EntryID: 5, Path: "/tmp", Name: "File3.tmp"},
+	}
+
+	entriesCh := make(chan db.FSEntry)
+	errCh := make(chan error, 1)
+	errCh <- nil
+
 	sqlDB := &StorerMock{}
 	defer sqlDB.AssertExpectations(t)
 
-	sqlDB.On("GetFileSystemInfo", ctx, fsName).
+	sqlDB.On("LockRefresh", ctx, fsName).
+		Return(func() error { return nil }, nil).
+		Once()
+
+	sqlDB.On("GetFileSystemRoots", ctx, fsName).
+		Return([]db.FSRoot{}, nil).
+		Once().
+		On("GetSyncDetails", ctx, fsName).
+		Return(db.FSDriverLocal, "/tmp", nil).
+		Once().
+		On("GetFilterRules", ctx, fsName).
+		Return(&db.FilterRules{Patterns: []string{"node_modules/", "*.tmp"}}, nil).
+		Once().
+		On("GetFileSystemInfo", ctx, fsName).
 		Return(fsInfo, nil).
+		Twice().
+		On("DeleteVersionStaging", ctx, fsName).
+		Return(nil).
+		Once().
+		On("AddNewFileSystemEntries", ctx, mock.Anything).
+		Return((chan<- db.FSEntry)(entriesCh), (<-chan error)(errCh)).
+		Once().
+		// the file system is marked as changed: VersionPrevious is preserved by the commit.
+		On("GetPreviousFileSystemEntries", ctx, fsName).
+		Return(previousEntries, nil).
+		Once().
+		On("CommitVersionStaging", ctx, fsName, db.StagingCommit{ExcludedPreviousEntries: []db.FSEntry{previousEntries[4], previousEntries[2], previousEntries[3]}}).
+		Return(nil).
+		Once()
+
+	fsDriver := &FilteringFSDriverMock{}
+	defer fsDriver.AssertExpectations(t)
+
+	fsDriver.On("UseFilter", mock.AnythingOfType("*filter.Filter")).
 		Once().
-		On("DeleteVersionNew", ctx, fsName).
+		On("Walk", ctx, fsName, "/tmp", (chan<- db.FSEntry)(entriesCh), (<-chan error)(errCh)).
+		Run(func(args mock.Arguments) {
+			close(args.Get(3).(chan<- db.FSEntry))
+			<-args.Get(4).(<-chan error)
+		}).
 		Return(nil).
+		Once().
+		On("ReadIgnoreFile", ctx, "/tmp").
+		Return([]byte(nil), nil).
+		Once().
+		On("UseFilter", (*filter.Filter)(nil)).
 		Once()
 
 	tr := &Tracker{
 		logger:   zap.NewNop(),
 		store:    sqlDB,
-		fsDriver: nil,
+		fsDriver: fsDriver,
 		fsName:   fsName,
 	}
 
-	err := tr.rotateFileSystemVersions(ctx)
+	err := tr.RefreshFSContents(ctx)
 	require.NoError(t, err)
 }
+
+func TestTracker_RefreshFSContents_MultipleRoots(t *testing.T) {
+	ctx := context.Background()
+
+	const fsName db.FSName = "some_fs"
+
+	fsInfo := &db.FSInfo{
+		FSName:    fsName,
+		FSDriver:  db.FSDriverLocal,
+		FSChanged: false,
+	}
+
+	roots := []db.FSRoot{
+		{Name: "media", Path: "/mnt/media"},
+		{Name: "photos", Path: "/mnt/photos"},
+	}
+
+	entriesCh := make(chan db.FSEntry)
+	errCh := make(chan error, 1)
+	errCh <- nil
+
+	sqlDB := &StorerMock{}
+	defer sqlDB.AssertExpectations(t)
+
+	sqlDB.On("LockRefresh", ctx, fsName).
+		Return(func() error { return nil }, nil).
+		Once()
+
+	sqlDB.On("GetFileSystemRoots", ctx, fsName).
+		Return(roots, nil).
+		Once().
+		On("GetFileSystemInfo", ctx, fsName).
+		Return(fsInfo, nil).
+		Once().
+		On("DeleteVersionStaging", ctx, fsName).
+		Return(nil).
+		Once().
+		On("AddNewFileSystemEntries", ctx, mock.Anything).
+		Return((chan<- db.FSEntry)(entriesCh), (<-chan error)(errCh)).
+		Once().
+		On("CommitVersionStaging", ctx, fsName, db.StagingCommit{}).
+		Return(nil).
+		Once()
+
+	fsDriver := &MultiRootFSDriverMock{}
+	defer fsDriver.AssertExpectations(t)
+
+	fsDriver.On("UseRoots", roots).
+		Once().
+		On("Walk", ctx, fsName, db.VirtualRootPath, (chan<- db.FSEntry)(entriesCh), (<-chan error)(errCh)).
+		Run(func(args mock.Arguments) {
+			close(args.Get(3).(chan<- db.FSEntry))
+			<-args.Get(4).(<-chan error)
+		}).
+		Return(nil).
+		Once().
+		On("UseRoots", []db.FSRoot(nil)).
+		Once()
+
+	tr := &Tracker{
+		logger:   zap.NewNop(),
+		store:    sqlDB,
+		fsDriver: fsDriver,
+		fsName:   fsName,
+	}
+
+	err := tr.RefreshFSContents(ctx)
+	require.NoError(t, err)
+}
+
+func TestTracker_RefreshFSContents_MultipleRootsUnsupported(t *testing.T) {
+	ctx := context.Background()
+
+	const fsName db.FSName = "some_fs"
+
+	sqlDB := &StorerMock{}
+	defer sqlDB.AssertExpectations(t)
+
+	sqlDB.On("LockRefresh", ctx, fsName).
+		Return(func() error { return nil }, nil).
+		Once()
+
+	sqlDB.On("GetFileSystemRoots", ctx, fsName).
+		Return([]db.FSRoot{{Name: "media", Path: "/mnt/media"}}, nil).
+		Once()
+
+	fsDriver := &IncrementalFSDriverMock{}
+	defer fsDriver.AssertExpectations(t)
+
+	tr := &Tracker{
+		logger:   zap.NewNop(),
+		store:    sqlDB,
+		fsDriver: fsDriver,
+		fsName:   fsName,
+	}
+
+	err := tr.RefreshFSContents(ctx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "has multiple roots but its driver does not support them")
+}
+
+func TestTracker_StreamMutations(t *testing.T) {
+	ctx := context.Background()
+
+	const fsName db.FSName = "some_fs"
+
+	mutation := func(mType db.MutationType, entryID uint64) db.FSMutation {
+		return db.FSMutation{
+			Type:    mType,
+			Details: db.EntryMutations{{Version: db.VersionNew, FSEntry: db.FSEntry{FSName: fsName, DeviceID: "1", EntryID: entryID}}},
+		}
+	}
+
+	page1 := db.FSMutations{mutation(db.MutationTypeCreated, 10), mutation(db.MutationTypeCreated, 11)}
+	page2 := db.FSMutations{mutation(db.MutationTypeDeleted, 12)}
+
+	after := &db.MutationCursor{Type: db.MutationTypeCreated, DeviceID: "1", EntryID: 1}
+	cursor1 := page1[1].Cursor()
+
+	sqlDB := &StorerMock{}
+	defer sqlDB.AssertExpectations(t)
+
+	sqlDB.On("GetFileSystemMutationsPage", ctx, fsName, after, 2).
+		Return(page1, &cursor1, nil).
+		Once().
+		On("GetFileSystemMutationsPage", ctx, fsName, &cursor1, 2).
+		Return(page2, (*db.MutationCursor)(nil), nil).
+		Once()
+
+	tr := &Tracker{
+		logger: zap.NewNop(),
+		store:  sqlDB,
+		fsName: fsName,
+	}
+
+	mutationsCh, errCh := tr.StreamMutations(ctx, after, 2)
+
+	fsMutations := db.FSMutations{}
+	for fsMutation := range mutationsCh {
+		fsMutations = append(fsMutations, fsMutation)
+	}
+	require.NoError(t, <-errCh)
+
+	require.Equal(t, append(page1, page2...), fsMutations)
+}